@@ -0,0 +1,121 @@
+// Decompression of Storm's IMA ADPCM compressed audio data (compression methods 0x40 and 0x80).
+//
+// The compressed data starts with a zero byte and the bit shift (compression level - 1), followed by
+// the initial 16-bit sample of each channel. Each following byte encodes a sample of the next channel
+// (channels are interleaved), except for the special values 0x80 (repeat the previous sample and decrease
+// the step index) and 0x81 (increase the step index, no sample). Decompressed samples are 16-bit little endian.
+
+package mpq
+
+import (
+	"encoding/binary"
+)
+
+// Initial step index of the channels.
+const adpcmInitialStepIndex = 0x2c
+
+// Step index changes by the lower 5 bits of the encoded samples.
+var adpcmNextStep = [32]int{
+	-1, 0, -1, 4, -1, 2, -1, 6, -1, 1, -1, 5, -1, 3, -1, 7,
+	-1, 1, -1, 5, -1, 3, -1, 7, -1, 2, -1, 4, -1, 6, -1, 8,
+}
+
+// Step sizes by the step index.
+var adpcmStepSize = [89]int{
+	7, 8, 9, 10, 11, 12, 13, 14, 16, 17, 19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+	50, 55, 60, 66, 73, 80, 88, 97, 107, 118, 130, 143, 157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658, 724, 796, 876, 963, 1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066,
+	2272, 2499, 2749, 3024, 3327, 3660, 4026, 4428, 4871, 5358, 5894, 6484, 7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794, 32767,
+}
+
+// decompressADPCM decompresses IMA ADPCM compressed src having the given number of channels (1 or 2) into dst.
+// ErrInvalidArchive is returned if src has fewer samples than dst can hold.
+func decompressADPCM(dst, src []byte, channels int) error {
+	n, err := decompressADPCMStream(dst, src, channels)
+	if err == nil && n+2 <= len(dst) {
+		err = ErrInvalidArchive // Not enough samples
+	}
+	return err
+}
+
+// decompressADPCMStream decompresses IMA ADPCM compressed src having the given number of channels (1 or 2)
+// into dst whose size is only an upper limit of the decompressed size.
+// Returns the decompressed size.
+func decompressADPCMStream(dst, src []byte, channels int) (int, error) {
+	if len(src) < 2+2*channels {
+		return 0, ErrInvalidArchive
+	}
+	shift := uint(src[1])
+	src = src[2:]
+
+	n := 0 // Number of bytes written to dst
+	put := func(sample int) bool {
+		if n+2 > len(dst) {
+			return false
+		}
+		binary.LittleEndian.PutUint16(dst[n:], uint16(int16(sample)))
+		n += 2
+		return true
+	}
+
+	var predicted, stepIndex [2]int
+	for ch := 0; ch < channels; ch++ {
+		predicted[ch] = int(int16(binary.LittleEndian.Uint16(src)))
+		stepIndex[ch] = adpcmInitialStepIndex
+		src = src[2:]
+		if !put(predicted[ch]) {
+			return n, nil
+		}
+	}
+
+	ch := channels - 1
+	for _, b := range src {
+		ch = (ch + 1) % channels
+
+		switch b {
+		case 0x80: // Repeat the previous sample
+			if stepIndex[ch] > 0 {
+				stepIndex[ch]--
+			}
+			if !put(predicted[ch]) {
+				return n, nil
+			}
+		case 0x81: // Increase the step index, the next byte is for the same channel
+			stepIndex[ch] += 8
+			if stepIndex[ch] > len(adpcmStepSize)-1 {
+				stepIndex[ch] = len(adpcmStepSize) - 1
+			}
+			ch = (ch + 1) % channels
+		default:
+			step := adpcmStepSize[stepIndex[ch]]
+			diff := step >> shift
+			for i := uint(0); i < 6; i++ {
+				if b&(1<<i) != 0 {
+					diff += step >> i
+				}
+			}
+			if b&0x40 != 0 {
+				if predicted[ch] -= diff; predicted[ch] < -32768 {
+					predicted[ch] = -32768
+				}
+			} else {
+				if predicted[ch] += diff; predicted[ch] > 32767 {
+					predicted[ch] = 32767
+				}
+			}
+			if !put(predicted[ch]) {
+				return n, nil
+			}
+
+			stepIndex[ch] += adpcmNextStep[b&0x1f]
+			if stepIndex[ch] < 0 {
+				stepIndex[ch] = 0
+			} else if stepIndex[ch] > len(adpcmStepSize)-1 {
+				stepIndex[ch] = len(adpcmStepSize) - 1
+			}
+		}
+	}
+
+	return n, nil
+}
@@ -7,97 +7,39 @@ import (
 	"compress/bzip2"
 	"compress/zlib"
 	"io"
+	"sync"
+
+	"github.com/icza/mpq/mpqcrypt"
+	"github.com/icza/mpq/pkware"
 )
 
 // Different hash types for the hashString() function.
 const (
-	hashTypeTableOffset = iota << 8
-	hashTypeNameA
-	hashTypeNameB
-	hashTypeFileKey
+	hashTypeTableOffset = mpqcrypt.HashTableOffset
+	hashTypeNameA       = mpqcrypt.HashNameA
+	hashTypeNameB       = mpqcrypt.HashNameB
+	hashTypeFileKey     = mpqcrypt.HashFileKey
 )
 
-// Converts ASCII characters to uppercase.
-// Converts slash (0x2F) to backslash (0x5C)
-var asciiToUpperTable = []uint32{
-	0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F,
-	0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E, 0x1F,
-	0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29, 0x2A, 0x2B, 0x2C, 0x2D, 0x2E, 0x5C,
-	0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F,
-	0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0x4A, 0x4B, 0x4C, 0x4D, 0x4E, 0x4F,
-	0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5A, 0x5B, 0x5C, 0x5D, 0x5E, 0x5F,
-	0x60, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0x4A, 0x4B, 0x4C, 0x4D, 0x4E, 0x4F,
-	0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5A, 0x7B, 0x7C, 0x7D, 0x7E, 0x7F,
-	0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x8A, 0x8B, 0x8C, 0x8D, 0x8E, 0x8F,
-	0x90, 0x91, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9A, 0x9B, 0x9C, 0x9D, 0x9E, 0x9F,
-	0xA0, 0xA1, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6, 0xA7, 0xA8, 0xA9, 0xAA, 0xAB, 0xAC, 0xAD, 0xAE, 0xAF,
-	0xB0, 0xB1, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6, 0xB7, 0xB8, 0xB9, 0xBA, 0xBB, 0xBC, 0xBD, 0xBE, 0xBF,
-	0xC0, 0xC1, 0xC2, 0xC3, 0xC4, 0xC5, 0xC6, 0xC7, 0xC8, 0xC9, 0xCA, 0xCB, 0xCC, 0xCD, 0xCE, 0xCF,
-	0xD0, 0xD1, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6, 0xD7, 0xD8, 0xD9, 0xDA, 0xDB, 0xDC, 0xDD, 0xDE, 0xDF,
-	0xE0, 0xE1, 0xE2, 0xE3, 0xE4, 0xE5, 0xE6, 0xE7, 0xE8, 0xE9, 0xEA, 0xEB, 0xEC, 0xED, 0xEE, 0xEF,
-	0xF0, 0xF1, 0xF2, 0xF3, 0xF4, 0xF5, 0xF6, 0xF7, 0xF8, 0xF9, 0xFA, 0xFB, 0xFC, 0xFD, 0xFE, 0xFF,
-}
-
-// A number table used by the decryption and hashing algorithms.
-var cryptTable = make([]uint32, 0x500)
-
-func init() {
-	// Initialize/compute the cryptTable:
-	// The encryption/decryption and hashing functions use a number table in their procedures.
-	// This table must be initialized before the functions are called the first time.
-
-	var seed uint32 = 0x00100001
-	var index1, index2 uint32
-	var i int
-
-	for ; index1 < 0x100; index1++ {
-		for index2, i = index1, 0; i < 5; i, index2 = i+1, index2+0x100 {
-			seed = (seed*125 + 3) % 0x2aaaab
-			temp := (seed & 0xffff) << 0x10
-			seed = (seed*125 + 3) % 0x2aaaab
-			cryptTable[index2] = temp | (seed & 0xffff)
-		}
-	}
-}
+// Keys of the encrypted hash and block tables.
+const (
+	hashTableKey  = mpqcrypt.HashTableKey
+	blockTableKey = mpqcrypt.BlockTableKey
+)
 
-// decrypt decrypts the given encrypted data with the specified key.
-// The same byte slice is used for the result, so the decrypted data will be written back into the input data slice.
+// decrypt decrypts the given encrypted data with the specified key, see mpqcrypt.Decrypt().
 func decrypt(data []byte, key uint32) {
-	var seed1 = key
-	var seed2 = uint32(0xeeeeeeee)
-	var ch uint32
-
-	for i, size := 0, len(data); i < size; i += 4 {
-		seed2 += cryptTable[0x400+(seed1&0xff)]
-
-		// littleEndian byte order:
-		ch = uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
-		ch ^= seed1 + seed2
-
-		seed1 = ((^seed1 << 0x15) + 0x11111111) | (seed1 >> 0x0B)
-		seed2 = ch + seed2 + (seed2 << 5) + 3
-
-		data[i] = byte(ch)
-		data[i+1] = byte(ch >> 8)
-		data[i+2] = byte(ch >> 16)
-		data[i+3] = byte(ch >> 24)
-	}
+	mpqcrypt.Decrypt(data, key)
 }
 
-// hashString computes the hash of a string.
-func hashString(s string, hashType uint32) uint32 {
-	var seed1 uint32 = 0x7fed7fed
-	var seed2 uint32 = 0xeeeeeeee
-
-	for i, size := 0, len(s); i < size; i++ {
-		ch := asciiToUpperTable[s[i]]
-
-		// Value of hashType is the offset itself
-		seed1 = cryptTable[hashType+ch] ^ (seed1 + seed2)
-		seed2 = ch + seed1 + seed2 + (seed2 << 5) + 3
-	}
+// encrypt encrypts the given data with the specified key, see mpqcrypt.Encrypt().
+func encrypt(data []byte, key uint32) {
+	mpqcrypt.Encrypt(data, key)
+}
 
-	return seed1
+// hashString computes the hash of a string, see mpqcrypt.HashString().
+func hashString(s string, hashType mpqcrypt.HashType) uint32 {
+	return mpqcrypt.HashString(s, hashType)
 }
 
 // FileNameHash returns different hashes of the file name,
@@ -108,6 +50,12 @@ func FileNameHash(name string) (h1, h2, h3 uint32) {
 		hashString(name, hashTypeNameB)
 }
 
+// fileKey returns the encryption key of a file, derived from its name (without the path).
+// If flags contain beFlagFixKey, the key is adjusted by the block offset and the size of the file.
+func fileKey(name string, blockOffset, fileSize, flags uint32) uint32 {
+	return mpqcrypt.FileKey(name, blockOffset, fileSize, flags&beFlagFixKey != 0)
+}
+
 // decompressMulti decompresses a block which was compressed using the multi compression method (beFlagCompressedMulti).
 func decompressMulti(dst, src []byte) error {
 	// Check if block is really compressed, some blocks have set the compression flag, but are not compressed.
@@ -117,22 +65,167 @@ func decompressMulti(dst, src []byte) error {
 		return nil
 	}
 
-	switch src[0] { // The compression flag
-	case 0x02: // Flag to indicate zlib compression
-		zr, err := zlib.NewReader(bytes.NewReader(src[1:]))
-		if err != nil {
-			return ErrInvalidArchive
+	c := Compression(src[0]) // The compression flag
+	switch {
+	case c == CompressionLZMA: // LZMA is a method on its own, its bits are not to be interpreted separately
+		return decompressLzma(dst, src[1:])
+	case c != CompressionNone && c&^chainedMethods == 0:
+		return decompressChain(dst, c, src[1:])
+	}
+
+	if fn := registeredDecompressor(c); fn != nil {
+		return fn(dst, src[1:])
+	}
+	return ErrCompressionUnsupported
+}
+
+// Compression methods in the order they are undone when multiple methods are combined:
+// the reverse of the order they are applied when compressing (StormLib compatible).
+// Huffman coding (0x01), which is not supported, would be undone between zlib and ADPCM.
+var decompressionOrder = []Compression{
+	CompressionBzip2, CompressionPKWare, CompressionZlib,
+	CompressionADPCMStereo, CompressionADPCMMono, CompressionSparse,
+}
+
+// Mask of all the methods of decompressionOrder.
+const chainedMethods = CompressionBzip2 | CompressionPKWare | CompressionZlib |
+	CompressionADPCMStereo | CompressionADPCMMono | CompressionSparse
+
+// decompressChain decompresses src compressed with the methods of c (which must only contain methods of
+// decompressionOrder) into dst, undoing the methods one by one.
+func decompressChain(dst []byte, c Compression, src []byte) error {
+	for _, m := range decompressionOrder {
+		if c&m == 0 {
+			continue
 		}
-		if _, err := io.ReadFull(zr, dst); err != nil {
-			return ErrInvalidArchive
+		if c &^= m; c == 0 {
+			return decompressMethod(dst, m, src) // Last method: decompress to the final size
 		}
-	case 0x10: // Flag to indicate BZip2 compression
-		if _, err := io.ReadFull(bzip2.NewReader(bytes.NewReader(src[1:])), dst); err != nil {
-			return ErrInvalidArchive
+
+		// Size of the intermediate data is unknown, but it can't be bigger than the maximum sparse output
+		// (the others are compressed data, smaller than the final output).
+		tmp := make([]byte, 4+len(dst)+len(dst)/128+1)
+		n, err := decompressStream(tmp, m, src)
+		if err != nil {
+			return err
 		}
-	default: // Compression not supported!
+		src = tmp[:n]
+	}
+	return ErrInvalidArchive
+}
+
+// decompressMethod decompresses src compressed with the single method m into dst.
+func decompressMethod(dst []byte, m Compression, src []byte) error {
+	switch m {
+	case CompressionZlib:
+		return decompressZlib(dst, src)
+	case CompressionBzip2:
+		return decompressBzip2(dst, src)
+	case CompressionPKWare:
+		return explode(dst, src)
+	case CompressionADPCMMono:
+		return decompressADPCM(dst, src, 1)
+	case CompressionADPCMStereo:
+		return decompressADPCM(dst, src, 2)
+	case CompressionSparse:
+		return decompressSparse(dst, src)
+	}
+	return ErrInvalidArchive
+}
+
+// Decompressors registered with RegisterDecompressor().
+var (
+	decompressorsMu sync.RWMutex
+	decompressors   = map[Compression]func(dst, src []byte) error{}
+)
+
+// RegisterDecompressor registers a decompressor function for a compression mask (a method or combination
+// of methods) which is not supported by the package, e.g. to plug in codecs used by custom archives.
+// Masks supported by the package always use the built-in decompression.
+//
+// fn is called with the compressed data of a sector (without the compression mask byte),
+// and it must decompress it into dst whose size is the exact decompressed size.
+// Passing a nil fn removes the decompressor of the mask.
+//
+// RegisterDecompressor is safe for concurrent use, but should be called before reading archives.
+func RegisterDecompressor(c Compression, fn func(dst, src []byte) error) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+
+	if fn == nil {
+		delete(decompressors, c)
+	} else {
+		decompressors[c] = fn
+	}
+}
+
+// registeredDecompressor returns the registered decompressor function of a compression mask,
+// nil if there is none.
+func registeredDecompressor(c Compression) func(dst, src []byte) error {
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+
+	return decompressors[c]
+}
+
+// explode decompresses PKWARE DCL imploded src into dst.
+func explode(dst, src []byte) error {
+	if n, err := pkware.Explode(dst, src); err != nil || n < len(dst) {
 		return ErrInvalidArchive
 	}
+	return nil
+}
 
+// decompressZlib decompresses zlib compressed src into dst.
+func decompressZlib(dst, src []byte) error {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return ErrInvalidArchive
+	}
+	if _, err := io.ReadFull(zr, dst); err != nil {
+		return ErrInvalidArchive
+	}
+	return nil
+}
+
+// decompressBzip2 decompresses bzip2 compressed src into dst.
+func decompressBzip2(dst, src []byte) error {
+	if _, err := io.ReadFull(bzip2.NewReader(bytes.NewReader(src)), dst); err != nil {
+		return ErrInvalidArchive
+	}
 	return nil
 }
+
+// decompressStream decompresses src compressed with the single method c into dst whose size is only an upper limit
+// of the decompressed size. Returns the decompressed size.
+func decompressStream(dst []byte, c Compression, src []byte) (int, error) {
+	var r io.Reader
+	switch c {
+	case CompressionZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return 0, ErrInvalidArchive
+		}
+		r = zr
+	case CompressionBzip2:
+		r = bzip2.NewReader(bytes.NewReader(src))
+	case CompressionPKWare:
+		n, err := pkware.Explode(dst, src)
+		if err != nil {
+			return 0, ErrInvalidArchive
+		}
+		return n, nil
+	case CompressionADPCMMono:
+		return decompressADPCMStream(dst, src, 1)
+	case CompressionADPCMStereo:
+		return decompressADPCMStream(dst, src, 2)
+	default:
+		return 0, ErrInvalidArchive
+	}
+
+	n, err := io.ReadFull(r, dst)
+	if err != io.ErrUnexpectedEOF && err != io.EOF && err != nil {
+		return 0, ErrInvalidArchive
+	}
+	return n, nil
+}
@@ -0,0 +1,37 @@
+//go:build go1.23
+
+// Iterator over the files of the archives.
+
+package mpq
+
+import (
+	"errors"
+	"iter"
+)
+
+// errStopAll is used to stop Walk when the consumer of All stops iterating.
+var errStopAll = errors.New("Stop iteration")
+
+// All returns an iterator over the existing files of the archive whose names are known,
+// the same files as visited by Walk(). If listing the files fails, a single (nil, err) pair is yielded.
+//
+//	for fi, err := range m.All() {
+//		if err != nil {
+//			return err
+//		}
+//		data, err := m.FileByName(fi.Name)
+//		// ...
+//	}
+func (m *MPQ) All() iter.Seq2[*FileInfo, error] {
+	return func(yield func(*FileInfo, error) bool) {
+		err := m.Walk(func(fi *FileInfo) error {
+			if !yield(fi, nil) {
+				return errStopAll
+			}
+			return nil
+		})
+		if err != nil && err != errStopAll {
+			yield(nil, err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build go1.23
+
+package mpq
+
+import "testing"
+
+func TestAll(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "bb")
+	var names []string
+	for fi, err := range m.All() {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		names = append(names, fi.Name)
+		break
+	}
+	if len(names) != 1 || names[0] != "a.txt" {
+		t.Errorf("Got: %v, want: [a.txt]", names)
+	}
+
+	count := 0
+	for range m.All() {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Got: %d files, want: %d", count, 2)
+	}
+}
@@ -0,0 +1,264 @@
+// Parsing of the "(attributes)" file (see doc.go for its format).
+
+package mpq
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// Name of the attributes file.
+const attributesName = "(attributes)"
+
+// Version of the supported attributes files.
+const attributesVersion = 100
+
+// Flags telling what the "(attributes)" contains.
+const (
+	// AttrCRC32 indicates that the "(attributes)" contains CRC32 for each file.
+	AttrCRC32 = 0x00000001
+
+	// AttrFileTime indicates that the "(attributes)" contains file time for each file.
+	AttrFileTime = 0x00000002
+
+	// AttrMD5 indicates that the "(attributes)" contains MD5 for each file.
+	AttrMD5 = 0x00000004
+
+	// AttrPatchBit indicates that the "(attributes)" contains a patch bit for each file.
+	AttrPatchBit = 0x00000008
+)
+
+// Attributes is the content of the "(attributes)" file.
+// The slices are indexed by block table index (see FileInfo.BlockIndex),
+// and are nil if the "(attributes)" does not contain the corresponding data.
+type Attributes struct {
+	// Version of the "(attributes)".
+	Version uint32
+
+	// Flags telling what the "(attributes)" contains, a combination of AttrCRC32, AttrFileTime, AttrMD5 and AttrPatchBit.
+	Flags uint32
+
+	// CRC32 checksums of the files.
+	CRC32 []uint32
+
+	// File times of the files, as Windows FILETIME values
+	// (number of 100-nanosecond intervals since January 1, 1601 UTC).
+	FileTime []uint64
+
+	// MD5 digests of the files.
+	MD5 [][16]byte
+
+	// Patch bits of the files, telling if a file is an incremental patch file.
+	PatchBit []bool
+}
+
+// Attributes parses and returns the content of the "(attributes)" file of the archive.
+// The result is cached, subsequent calls return the same Attributes.
+//
+// ErrFileNotFound is returned if the archive has no "(attributes)".
+// ErrInvalidArchive is returned if the "(attributes)" is invalid or its version is not supported.
+func (m *MPQ) Attributes() (*Attributes, error) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if m.attributes != nil {
+		return m.attributes, nil
+	}
+
+	data, err := m.FileByName(attributesName)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := parseAttributes(data, len(m.blockTable))
+	if err != nil {
+		return nil, err
+	}
+	m.attributes = a
+	return a, nil
+}
+
+// parseAttributes parses the content of an "(attributes)" file of an archive having
+// blockTableSize entries in its block table.
+func parseAttributes(data []byte, blockTableSize int) (*Attributes, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalidArchive
+	}
+	a := &Attributes{
+		Version: binary.LittleEndian.Uint32(data),
+		Flags:   binary.LittleEndian.Uint32(data[4:]),
+	}
+	if a.Version != attributesVersion {
+		return nil, ErrInvalidArchive
+	}
+	data = data[8:]
+
+	// Some archives have one entry less (the "(attributes)" itself is not included).
+	n := blockTableSize
+	if len(data) < attributesSize(a.Flags, n) && len(data) == attributesSize(a.Flags, n-1) {
+		n--
+	}
+	if len(data) < attributesSize(a.Flags, n) {
+		return nil, ErrInvalidArchive
+	}
+
+	if a.Flags&AttrCRC32 != 0 {
+		a.CRC32 = make([]uint32, n)
+		for i := range a.CRC32 {
+			a.CRC32[i] = binary.LittleEndian.Uint32(data[i*4:])
+		}
+		data = data[n*4:]
+	}
+	if a.Flags&AttrFileTime != 0 {
+		a.FileTime = make([]uint64, n)
+		for i := range a.FileTime {
+			a.FileTime[i] = binary.LittleEndian.Uint64(data[i*8:])
+		}
+		data = data[n*8:]
+	}
+	if a.Flags&AttrMD5 != 0 {
+		a.MD5 = make([][16]byte, n)
+		for i := range a.MD5 {
+			copy(a.MD5[i][:], data[i*16:])
+		}
+		data = data[n*16:]
+	}
+	if a.Flags&AttrPatchBit != 0 {
+		// Bits are stored from the highest bit of each byte
+		a.PatchBit = make([]bool, n)
+		for i := range a.PatchBit {
+			a.PatchBit[i] = data[i/8]&(0x80>>uint(i%8)) != 0
+		}
+	}
+
+	return a, nil
+}
+
+// FileTime returns the file time of a file specified by its name, recorded in the "(attributes)".
+// Zero time is returned (with nil error) if no file time is recorded for the file.
+//
+// ErrFileNotFound is returned if the file is not in the archive.
+// Errors reading or parsing the "(attributes)" are returned as-is.
+func (m *MPQ) FileTime(name string) (time.Time, error) {
+	blockIndex := m.blockIndexByName(name)
+	if blockIndex < 0 {
+		return time.Time{}, ErrFileNotFound
+	}
+
+	a, err := m.Attributes()
+	if err == ErrFileNotFound {
+		return time.Time{}, nil // No "(attributes)"
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if blockIndex >= len(a.FileTime) {
+		return time.Time{}, nil
+	}
+	return filetimeToTime(a.FileTime[blockIndex]), nil
+}
+
+// FileVerification is the result of verifying a file against the checksums recorded in the "(attributes)".
+// Checksums of zero value are considered not recorded.
+type FileVerification struct {
+	// Tells if a CRC32 is recorded for the file.
+	HasCRC32 bool
+
+	// Tells if the CRC32 of the file content matches the recorded one.
+	CRC32OK bool
+
+	// Tells if an MD5 is recorded for the file.
+	HasMD5 bool
+
+	// Tells if the MD5 of the file content matches the recorded one.
+	MD5OK bool
+}
+
+// OK tells if all recorded checksums match. It also returns true if no checksums are recorded.
+func (fv *FileVerification) OK() bool {
+	return (!fv.HasCRC32 || fv.CRC32OK) && (!fv.HasMD5 || fv.MD5OK)
+}
+
+// VerifyFile reads a file specified by its name, and verifies its content against
+// the CRC32 and MD5 checksums recorded in the "(attributes)".
+// If the archive has no "(attributes)", no checksums are verified.
+//
+// ErrFileNotFound is returned if the file is not in the archive.
+// Errors reading the file or reading and parsing the "(attributes)" are returned as-is.
+func (m *MPQ) VerifyFile(name string) (*FileVerification, error) {
+	blockIndex := m.blockIndexByName(name)
+	if blockIndex < 0 {
+		return nil, ErrFileNotFound
+	}
+
+	fv := &FileVerification{}
+	a, err := m.Attributes()
+	if err == ErrFileNotFound {
+		return fv, nil // No "(attributes)"
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var expCRC uint32
+	var expMD5 [16]byte
+	if blockIndex < len(a.CRC32) {
+		expCRC = a.CRC32[blockIndex]
+	}
+	if blockIndex < len(a.MD5) {
+		expMD5 = a.MD5[blockIndex]
+	}
+	fv.HasCRC32, fv.HasMD5 = expCRC != 0, expMD5 != [16]byte{}
+	if !fv.HasCRC32 && !fv.HasMD5 {
+		return fv, nil
+	}
+
+	data, err := m.readFile(blockIndex, name)
+	if err != nil {
+		return nil, err
+	}
+	fv.CRC32OK = fv.HasCRC32 && crc32.ChecksumIEEE(data) == expCRC
+	fv.MD5OK = fv.HasMD5 && md5.Sum(data) == expMD5
+
+	return fv, nil
+}
+
+// patchBit returns the patch bit of the file specified by its block index, recorded in the "(attributes)".
+// false is returned if the archive has no (valid) "(attributes)" or it has no patch bits.
+func (m *MPQ) patchBit(blockIndex int) bool {
+	a, err := m.Attributes()
+	return err == nil && blockIndex < len(a.PatchBit) && a.PatchBit[blockIndex]
+}
+
+// Number of seconds between the FILETIME epoch (January 1, 1601 UTC) and the Unix epoch.
+const filetimeUnixDelta = 11644473600
+
+// filetimeToTime converts a Windows FILETIME value to time.Time. Zero time is returned for 0.
+func filetimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	secs, nsecs := int64(ft/1e7)-filetimeUnixDelta, int64(ft%1e7)*100
+	return time.Unix(secs, nsecs).UTC()
+}
+
+// attributesSize returns the size of the data following the version and flags
+// in an "(attributes)" with the given flags and number of entries.
+func attributesSize(flags uint32, n int) int {
+	size := 0
+	if flags&AttrCRC32 != 0 {
+		size += n * 4
+	}
+	if flags&AttrFileTime != 0 {
+		size += n * 8
+	}
+	if flags&AttrMD5 != 0 {
+		size += n * 16
+	}
+	if flags&AttrPatchBit != 0 {
+		size += (n + 7) / 8
+	}
+	return size
+}
@@ -0,0 +1,172 @@
+package mpq
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+func TestAttributes(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	a, err := m.Attributes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if a.Version != attributesVersion || a.Flags != AttrCRC32|AttrMD5 ||
+		len(a.CRC32) != len(m.blockTable) || len(a.MD5) != len(m.blockTable) || a.FileTime != nil || a.PatchBit != nil {
+		t.Fatalf("Unexpected attributes: %+v", a)
+	}
+
+	for _, name := range []string{"replay.details", "replay.tracker.events"} {
+		data, err := m.FileByName(name)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %v", name, err)
+		}
+		idx := m.FileInfo(name).BlockIndex
+		if crc := crc32.ChecksumIEEE(data); a.CRC32[idx] != crc {
+			t.Errorf("[%s] CRC32 got: %#x, want: %#x", name, a.CRC32[idx], crc)
+		}
+		if sum := md5.Sum(data); a.MD5[idx] != sum {
+			t.Errorf("[%s] MD5 got: %x, want: %x", name, a.MD5[idx], sum)
+		}
+	}
+
+	if a2, _ := m.Attributes(); a2 != a {
+		t.Errorf("Attributes are not cached")
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	// 10 entries with file times and patch bits, but one entry less than the block table:
+	const n = 10
+	data := make([]byte, 8+n*8+(n+7)/8)
+	binary.LittleEndian.PutUint32(data, attributesVersion)
+	binary.LittleEndian.PutUint32(data[4:], AttrFileTime|AttrPatchBit)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint64(data[8+i*8:], uint64(i)*1000)
+	}
+	data[8+n*8] = 0x81   // Entries 0 and 7
+	data[8+n*8+1] = 0x40 // Entry 9
+
+	a, err := parseAttributes(data, n+1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(a.FileTime) != n || a.FileTime[3] != 3000 || a.CRC32 != nil || a.MD5 != nil {
+		t.Errorf("Unexpected attributes: %+v", a)
+	}
+	for i, bit := range a.PatchBit {
+		if exp := i == 0 || i == 7 || i == 9; bit != exp {
+			t.Errorf("[%d] Patch bit got: %v, want: %v", i, bit, exp)
+		}
+	}
+
+	// Invalid:
+	if _, err := parseAttributes(data, n+2); err != ErrInvalidArchive {
+		t.Errorf("Expected: %v, got: %v", ErrInvalidArchive, err)
+	}
+	binary.LittleEndian.PutUint32(data, 99)
+	if _, err := parseAttributes(data, n); err != ErrInvalidArchive {
+		t.Errorf("Expected: %v, got: %v", ErrInvalidArchive, err)
+	}
+}
+
+func TestFileTime(t *testing.T) {
+	m, err := NewFromFile("reps/computer.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	// File times are recorded, but all are zeros:
+	if ft, err := m.FileTime("replay.details"); err != nil || !ft.IsZero() {
+		t.Errorf("Got: %v, %v, want: zero time, nil", ft, err)
+	}
+	if _, err := m.FileTime("missing"); err != ErrFileNotFound {
+		t.Errorf("Expected: %v, got: %v", ErrFileNotFound, err)
+	}
+
+	a, err := m.Attributes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	a.FileTime[m.FileInfo("replay.details").BlockIndex] = 132223104001234567
+	exp := time.Date(2020, 1, 1, 0, 0, 0, 123456700, time.UTC)
+	if ft, err := m.FileTime("replay.details"); err != nil || !ft.Equal(exp) {
+		t.Errorf("Got: %v, %v, want: %v, nil", ft, err, exp)
+	}
+
+	// No file times recorded:
+	m2, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m2.Close()
+	if ft, err := m2.FileTime("replay.details"); err != nil || !ft.IsZero() {
+		t.Errorf("Got: %v, %v, want: zero time, nil", ft, err)
+	}
+
+	cases := []struct {
+		ft  uint64
+		exp time.Time
+	}{
+		{0, time.Time{}},
+		{116444736000000000, time.Unix(0, 0).UTC()},
+		{132223104001234567, time.Date(2020, 1, 1, 0, 0, 0, 123456700, time.UTC)},
+	}
+	for _, c := range cases {
+		if got := filetimeToTime(c.ft); !got.Equal(c.exp) {
+			t.Errorf("[%d] Got: %v, want: %v", c.ft, got, c.exp)
+		}
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	files, err := m.Files()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, f := range files {
+		fv, err := m.VerifyFile(f.Name)
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", f.Name, err)
+			continue
+		}
+		// Empty files have no recorded checksums
+		if size, _ := m.FileSize(f.Name); (size > 0 && (!fv.HasCRC32 || !fv.HasMD5)) || !fv.OK() {
+			t.Errorf("[%s] Unexpected result: %+v", f.Name, fv)
+		}
+	}
+
+	// Corrupt the recorded checksums:
+	a, err := m.Attributes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	idx := m.FileInfo("replay.details").BlockIndex
+	a.CRC32[idx]++
+	fv, err := m.VerifyFile("replay.details")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp := (FileVerification{HasCRC32: true, HasMD5: true, MD5OK: true}); *fv != exp || fv.OK() {
+		t.Errorf("Got: %+v, want: %+v", fv, exp)
+	}
+
+	if _, err := m.VerifyFile("missing"); err != ErrFileNotFound {
+		t.Errorf("Expected: %v, got: %v", ErrFileNotFound, err)
+	}
+}
@@ -0,0 +1,116 @@
+// Reading multiple files of the archives at once.
+
+package mpq
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileByNames returns the contents of multiple files specified by their names, mapped by the names.
+// All names are looked up first, and then the files are read in the order of their data in the archive,
+// in a single sequential sweep instead of random access, e.g. to read the usual set of files of replays.
+//
+// Names that could not be read are not in contents, their errors are in errs instead
+// (errs is nil if all files were read). Errors are the same as those of FileByName().
+func (m *MPQ) FileByNames(names ...string) (contents map[string][]byte, errs map[string]error) {
+	type file struct {
+		name       string
+		blockIndex int
+		offset     int64
+	}
+
+	contents = make(map[string][]byte, len(names))
+	setErr := func(name string, err error) {
+		if errs == nil {
+			errs = map[string]error{}
+		}
+		errs[name] = err
+	}
+
+	tablesErr := m.loadTables()
+	files := make([]file, 0, len(names))
+	for _, name := range names {
+		if tablesErr != nil {
+			setErr(name, tablesErr)
+			continue
+		}
+		idx := m.blockIndexByName(name)
+		if idx < 0 {
+			setErr(name, ErrFileNotFound)
+			continue
+		}
+		files = append(files, file{name: name, blockIndex: idx, offset: m.blockOffset(idx)})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].offset < files[j].offset })
+
+	for _, f := range files {
+		if _, ok := contents[f.name]; ok {
+			continue // Duplicate name
+		}
+		data, err := m.readFile(f.blockIndex, f.name)
+		if err != nil {
+			setErr(f.name, err)
+			continue
+		}
+		contents[f.name] = data
+	}
+
+	return
+}
+
+// ExtractAll extracts the files of the archive into the directory dir, creating it and the subdirectories
+// of the files as needed. Files whose names are known are extracted (see Files()), with backslashes in their names
+// interpreted as path separators. Files are read in the order of their data in the archive (in a single
+// sequential sweep), and their content is streamed sector by sector into the created files.
+//
+// ErrFileNotFound is returned if no file names are known. A *fs.PathError with fs.ErrInvalid is returned
+// if the name of a file is not a valid relative path (e.g. it contains ".." elements), before anything is extracted.
+// Errors of reading the files and creating the files on disk are returned as-is.
+func (m *MPQ) ExtractAll(dir string) error {
+	efs, err := m.exportedFiles()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(efs))
+	for i, ef := range efs {
+		name := strings.ReplaceAll(ef.name, `\`, "/")
+		if !fs.ValidPath(name) || name == "." {
+			return &fs.PathError{Op: "extract", Path: ef.name, Err: fs.ErrInvalid}
+		}
+		paths[i] = filepath.Join(dir, filepath.FromSlash(name))
+	}
+
+	for i, ef := range efs {
+		if err := os.MkdirAll(filepath.Dir(paths[i]), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(paths[i])
+		if err != nil {
+			return err
+		}
+		err = m.exportFile(ef, f)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockOffset returns the offset of the data of the block specified by its block table index,
+// relative to the archive.
+func (m *MPQ) blockOffset(blockEntryIndex int) int64 {
+	offset := int64(m.blockTable[blockEntryIndex].blockOffset)
+	if blockEntryIndex < len(m.extBlockEntryHighOffsets) {
+		offset += int64(m.extBlockEntryHighOffsets[blockEntryIndex]) << 32
+	}
+	return offset
+}
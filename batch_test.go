@@ -0,0 +1,109 @@
+package mpq
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// seekRecorder records the offsets seeked to.
+type seekRecorder struct {
+	io.ReadSeeker
+	offsets []int64
+}
+
+func (s *seekRecorder) Seek(offset int64, whence int) (int64, error) {
+	pos, err := s.ReadSeeker.Seek(offset, whence)
+	s.offsets = append(s.offsets, pos)
+	return pos, err
+}
+
+func TestFileByNames(t *testing.T) {
+	data, err := ioutil.ReadFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to read replay: %v", err)
+	}
+	sr := &seekRecorder{ReadSeeker: bytes.NewReader(data)}
+	m, err := New(sr)
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+
+	names := []string{
+		"replay.tracker.events", "replay.details", "replay.initData", "replay.game.events",
+		"replay.message.events", "replay.attributes.events", "replay.details", "missing",
+	}
+	sr.offsets = nil
+	contents, errs := m.FileByNames(names...)
+	if len(errs) != 1 || errs["missing"] != ErrFileNotFound {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if len(contents) != 6 {
+		t.Errorf("Got: %d contents, want: %d", len(contents), 6)
+	}
+	for i := 1; i < len(sr.offsets); i++ {
+		if sr.offsets[i] < sr.offsets[i-1] {
+			t.Errorf("Non-sequential reads: %v", sr.offsets)
+			break
+		}
+	}
+
+	for _, name := range names[:len(names)-1] {
+		exp, err := m.FileByName(name)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %v", name, err)
+		}
+		if !bytes.Equal(contents[name], exp) {
+			t.Errorf("[%s] Content mismatch", name)
+		}
+	}
+}
+
+func TestExtractAll(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	// Files are extracted in the order of their data:
+	efs, err := m.exportedFiles()
+	if err != nil || len(efs) == 0 {
+		t.Fatalf("Got: %d files, %v", len(efs), err)
+	}
+	for i := 1; i < len(efs); i++ {
+		if m.blockOffset(efs[i].blockIndex) < m.blockOffset(efs[i-1].blockIndex) {
+			t.Errorf("Files are not ordered by offset")
+			break
+		}
+	}
+
+	dir := t.TempDir()
+	if err := m.ExtractAll(dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, ef := range efs {
+		exp, _ := m.FileByName(ef.name)
+		got, err := ioutil.ReadFile(filepath.Join(dir, ef.name))
+		if err != nil || !bytes.Equal(got, exp) {
+			t.Errorf("[%s] Got: %d bytes, %v, want: %d bytes", ef.name, len(got), err, len(exp))
+		}
+	}
+
+	// Subdirectories are created, names escaping the directory are rejected:
+	m = buildArchive(t, `dir\b.txt`, "b")
+	if err := m.ExtractAll(dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "dir", "b.txt")); err != nil || string(got) != "b" {
+		t.Errorf("Got: %q, %v, want: %q", got, err, "b")
+	}
+	m = buildArchive(t, `..\evil.txt`, "evil")
+	if err := m.ExtractAll(dir); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Got: %v, want: %v", err, fs.ErrInvalid)
+	}
+}
@@ -0,0 +1,151 @@
+package mpq
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// Files of replays read by the benchmarks.
+var benchNames = []string{"replay.details", "replay.initData", "replay.tracker.events", "replay.game.events"}
+
+func TestLookupAllocs(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	for _, locale := range []bool{false, true} {
+		m.hasLocale = locale
+		for _, name := range append(benchNames, "missing") {
+			h1, h2, h3 := FileNameHash(name)
+			allocs := testing.AllocsPerRun(100, func() {
+				m.blockIndexByHash(h1, h2, h3)
+				m.blockIndexByName(name)
+				m.Exists(name)
+			})
+			if allocs != 0 {
+				t.Errorf("[locale: %t, %s] Got: %v allocs, want: 0", locale, name, allocs)
+			}
+		}
+	}
+}
+
+// benchInput returns the content of the replay used by the benchmarks.
+func benchInput(b *testing.B) []byte {
+	data, err := os.ReadFile("reps/lotv.SC2Replay")
+	if err != nil {
+		b.Fatalf("Failed to read replay: %v", err)
+	}
+	return data
+}
+
+func BenchmarkNew(b *testing.B) {
+	data := benchInput(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := New(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewLazyTables(b *testing.B) {
+	data := benchInput(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := New(bytes.NewReader(data), WithLazyTables()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewFromFile(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m, err := NewFromFile("reps/lotv.SC2Replay")
+		if err != nil {
+			b.Fatal(err)
+		}
+		m.Close()
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	data := benchInput(b)
+	m, err := New(bytes.NewReader(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.Reset(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	m, err := New(bytes.NewReader(benchInput(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !m.Exists(benchNames[i%len(benchNames)]) {
+			b.Fatal("file not found")
+		}
+	}
+}
+
+func BenchmarkFileByName(b *testing.B) {
+	m, err := New(bytes.NewReader(benchInput(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range benchNames {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := m.FileByName(name); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFileByHash(b *testing.B) {
+	m, err := New(bytes.NewReader(benchInput(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	h1, h2, h3 := FileNameHash("replay.details")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.FileByHash(h1, h2, h3); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileByNameInto(b *testing.B) {
+	m, err := New(bytes.NewReader(benchInput(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	var buf []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if buf, err = m.FileByNameInto(buf, "replay.details"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
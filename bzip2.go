@@ -0,0 +1,507 @@
+// Compression in the bzip2 format.
+// The standard library only implements decompression (compress/bzip2), so this is needed to create bzip2 compressed files.
+
+package mpq
+
+// Maximum length of the Huffman codes used by the bzip2 compressor.
+const bzip2MaxCodeLen = 17
+
+// Number of symbols coded with the same Huffman table.
+const bzip2GroupSize = 50
+
+// Table for the (non-reflected) CRC-32 checksum used by bzip2.
+var bzip2CRCTable [256]uint32
+
+func init() {
+	for i := range bzip2CRCTable {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		bzip2CRCTable[i] = c
+	}
+}
+
+// bitWriter is an MSB-first bit writer.
+type bitWriter struct {
+	buf  []byte // Written bytes
+	acc  uint64 // Accumulated bits not yet written to buf
+	nacc uint   // Number of bits in acc
+}
+
+// writeBits writes the lowest n bits of v (n <= 32).
+func (bw *bitWriter) writeBits(v uint32, n uint) {
+	bw.acc = bw.acc<<n | uint64(v)&(1<<n-1)
+	bw.nacc += n
+	for bw.nacc >= 8 {
+		bw.nacc -= 8
+		bw.buf = append(bw.buf, byte(bw.acc>>bw.nacc))
+	}
+}
+
+// flush writes the remaining bits, padded with zeros to a full byte.
+func (bw *bitWriter) flush() {
+	if bw.nacc > 0 {
+		bw.writeBits(0, 8-bw.nacc)
+	}
+}
+
+// bzip2Compress compresses data in bzip2 format.
+// level is the block size in 100 KB units, must be in the range 1..9.
+func bzip2Compress(data []byte, level int) []byte {
+	bw := &bitWriter{buf: make([]byte, 0, len(data)/2+64)}
+	bw.writeBits('B'<<16|'Z'<<8|'h', 24)
+	bw.writeBits('0'+uint32(level), 8)
+
+	maxBlockSize := level*100000 - 19
+	block := make([]byte, 0, maxBlockSize)
+	var combinedCRC uint32
+
+	for len(data) > 0 {
+		// Fill the next block applying the initial run-length encoding:
+		// runs of 4-255 equal bytes are stored as 4 bytes followed by the repeat count minus 4.
+		block = block[:0]
+		crc := ^uint32(0)
+		for len(data) > 0 {
+			b, run := data[0], 1
+			for run < len(data) && run < 255 && data[run] == b {
+				run++
+			}
+			size := run
+			if run >= 4 {
+				size = 5
+			}
+			if len(block)+size > maxBlockSize {
+				break
+			}
+			if run >= 4 {
+				block = append(block, b, b, b, b, byte(run-4))
+			} else {
+				for i := 0; i < run; i++ {
+					block = append(block, b)
+				}
+			}
+			for i := 0; i < run; i++ {
+				crc = crc<<8 ^ bzip2CRCTable[byte(crc>>24)^b]
+			}
+			data = data[run:]
+		}
+		crc = ^crc
+		combinedCRC = (combinedCRC<<1 | combinedCRC>>31) ^ crc
+
+		bzip2WriteBlock(bw, block, crc)
+	}
+
+	// End of stream marker and combined CRC
+	bw.writeBits(0x177245, 24)
+	bw.writeBits(0x385090, 24)
+	bw.writeBits(combinedCRC, 32)
+	bw.flush()
+
+	return bw.buf
+}
+
+// bzip2WriteBlock compresses and writes a block.
+func bzip2WriteBlock(bw *bitWriter, block []byte, crc uint32) {
+	last, origPtr := bwt(block)
+
+	// Symbol map of the used bytes
+	var inUse [256]bool
+	for _, b := range block {
+		inUse[b] = true
+	}
+	var unseqToSeq [256]byte
+	nInUse := 0
+	for i, used := range inUse {
+		if used {
+			unseqToSeq[i] = byte(nInUse)
+			nInUse++
+		}
+	}
+
+	// Move-to-front transform combined with run-length encoding of zeros
+	alphaSize := nInUse + 2
+	eob := uint16(nInUse + 1)
+	mtfv := make([]uint16, 0, len(block)+1)
+	var freqs [258]int
+
+	var mtf [256]byte
+	for i := range mtf {
+		mtf[i] = byte(i)
+	}
+	zPend := 0
+	flushZeros := func() {
+		if zPend == 0 {
+			return
+		}
+		zPend--
+		for {
+			sym := uint16(zPend & 1) // RUNA = 0, RUNB = 1
+			mtfv = append(mtfv, sym)
+			freqs[sym]++
+			if zPend < 2 {
+				break
+			}
+			zPend = (zPend - 2) / 2
+		}
+		zPend = 0
+	}
+	for _, b := range last {
+		s := unseqToSeq[b]
+		j := 0
+		for mtf[j] != s {
+			j++
+		}
+		copy(mtf[1:j+1], mtf[:j])
+		mtf[0] = s
+		if j == 0 {
+			zPend++
+			continue
+		}
+		flushZeros()
+		mtfv = append(mtfv, uint16(j+1))
+		freqs[j+1]++
+	}
+	flushZeros()
+	mtfv = append(mtfv, eob)
+	freqs[eob]++
+
+	// Choose the number of Huffman tables, and generate the initial ones by partitioning the alphabet
+	nGroups := 6
+	switch n := len(mtfv); {
+	case n < 200:
+		nGroups = 2
+	case n < 600:
+		nGroups = 3
+	case n < 1200:
+		nGroups = 4
+	case n < 2400:
+		nGroups = 5
+	}
+	lens := make([][]uint8, nGroups)
+	for i := range lens {
+		lens[i] = make([]uint8, alphaSize)
+	}
+	remF, gs := len(mtfv), 0
+	for nPart := nGroups; nPart > 0; nPart-- {
+		tFreq := remF / nPart
+		ge, aFreq := gs-1, 0
+		for aFreq < tFreq && ge < alphaSize-1 {
+			ge++
+			aFreq += freqs[ge]
+		}
+		if ge > gs && nPart != nGroups && nPart != 1 && (nGroups-nPart)%2 == 1 {
+			aFreq -= freqs[ge]
+			ge--
+		}
+		for v := range lens[nPart-1] {
+			if v >= gs && v <= ge {
+				lens[nPart-1][v] = 0
+			} else {
+				lens[nPart-1][v] = 15
+			}
+		}
+		gs = ge + 1
+		remF -= aFreq
+	}
+
+	// Refine the tables: assign each group of symbols to the cheapest table,
+	// and recompute the tables from the frequencies of the assigned groups.
+	nSelectors := (len(mtfv) + bzip2GroupSize - 1) / bzip2GroupSize
+	selectors := make([]uint8, nSelectors)
+	rfreqs := make([][]int, nGroups)
+	for i := range rfreqs {
+		rfreqs[i] = make([]int, alphaSize)
+	}
+	for iter := 0; iter < 4; iter++ {
+		for _, rf := range rfreqs {
+			for i := range rf {
+				rf[i] = 0
+			}
+		}
+		for sel := range selectors {
+			group := mtfv[sel*bzip2GroupSize:]
+			if len(group) > bzip2GroupSize {
+				group = group[:bzip2GroupSize]
+			}
+			best, bestCost := 0, -1
+			for t, l := range lens {
+				cost := 0
+				for _, v := range group {
+					cost += int(l[v])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			selectors[sel] = uint8(best)
+			for _, v := range group {
+				rfreqs[best][v]++
+			}
+		}
+		for t := range lens {
+			huffmanCodeLengths(lens[t], rfreqs[t], bzip2MaxCodeLen)
+		}
+	}
+
+	codes := make([][]uint32, nGroups)
+	for t, l := range lens {
+		codes[t] = canonicalCodes(l)
+	}
+
+	// Block header
+	bw.writeBits(0x314159, 24)
+	bw.writeBits(0x265359, 24)
+	bw.writeBits(crc, 32)
+	bw.writeBits(0, 1) // Not randomised
+	bw.writeBits(uint32(origPtr), 24)
+
+	// Symbol map
+	var groupsInUse uint32
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				groupsInUse |= 1 << (15 - i)
+			}
+		}
+	}
+	bw.writeBits(groupsInUse, 16)
+	for i := 0; i < 16; i++ {
+		if groupsInUse&(1<<(15-i)) == 0 {
+			continue
+		}
+		var bits uint32
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				bits |= 1 << (15 - j)
+			}
+		}
+		bw.writeBits(bits, 16)
+	}
+
+	// Selectors, coded with move-to-front transform and unary coding
+	bw.writeBits(uint32(nGroups), 3)
+	bw.writeBits(uint32(nSelectors), 15)
+	pos := []uint8{0, 1, 2, 3, 4, 5}
+	for _, sel := range selectors {
+		j := 0
+		for pos[j] != sel {
+			j++
+		}
+		copy(pos[1:j+1], pos[:j])
+		pos[0] = sel
+		for ; j > 0; j-- {
+			bw.writeBits(1, 1)
+		}
+		bw.writeBits(0, 1)
+	}
+
+	// Code lengths of the tables, delta coded
+	for _, l := range lens {
+		curr := l[0]
+		bw.writeBits(uint32(curr), 5)
+		for _, n := range l {
+			for ; curr < n; curr++ {
+				bw.writeBits(2, 2)
+			}
+			for ; curr > n; curr-- {
+				bw.writeBits(3, 2)
+			}
+			bw.writeBits(0, 1)
+		}
+	}
+
+	// And finally the data
+	for i, v := range mtfv {
+		t := selectors[i/bzip2GroupSize]
+		bw.writeBits(codes[t][v], uint(lens[t][v]))
+	}
+}
+
+// bwt performs the Burrows-Wheeler transform of the block.
+// Returns the last column of the sorted rotations and the index of the original string in the sorted rotations.
+//
+// Rotations are sorted by prefix doubling, using counting sorts.
+func bwt(block []byte) (last []byte, origPtr int) {
+	n := len(block)
+	sa := make([]int32, n)
+	rank := make([]int32, n)
+	tmp := make([]int32, n)
+	cntSize := 256
+	if n > cntSize {
+		cntSize = n
+	}
+	cnt := make([]int32, cntSize)
+
+	// Initial order by the first byte
+	for i, b := range block {
+		cnt[b]++
+		rank[i] = int32(b)
+	}
+	for i, sum := 0, int32(0); i < 256; i++ {
+		cnt[i], sum = sum, sum+cnt[i]
+	}
+	for i, b := range block {
+		sa[cnt[b]] = int32(i)
+		cnt[b]++
+	}
+	classes := 256
+
+	for k := 1; k < n; k <<= 1 {
+		// Sort by the second key (rank of the rotation starting k bytes later),
+		// which is given by the current order shifted:
+		for j, s := range sa {
+			tmp[j] = (s - int32(k) + int32(n)) % int32(n)
+		}
+		// Then (stable) sort by the first key:
+		for i := 0; i < classes; i++ {
+			cnt[i] = 0
+		}
+		for _, s := range tmp {
+			cnt[rank[s]]++
+		}
+		for i, sum := 0, int32(0); i < classes; i++ {
+			cnt[i], sum = sum, sum+cnt[i]
+		}
+		for _, s := range tmp {
+			sa[cnt[rank[s]]] = s
+			cnt[rank[s]]++
+		}
+
+		// Compute new ranks
+		tmp[sa[0]] = 0
+		classes = 1
+		for j := 1; j < n; j++ {
+			a, b := sa[j-1], sa[j]
+			if rank[a] != rank[b] || rank[(int(a)+k)%n] != rank[(int(b)+k)%n] {
+				classes++
+			}
+			tmp[b] = int32(classes - 1)
+		}
+		rank, tmp = tmp, rank
+		if classes == n {
+			break
+		}
+	}
+
+	last = make([]byte, n)
+	for j, s := range sa {
+		if s == 0 {
+			origPtr = j
+			last[j] = block[n-1]
+		} else {
+			last[j] = block[s-1]
+		}
+	}
+	return
+}
+
+// huffmanCodeLengths computes Huffman code lengths for the given symbol frequencies into lens,
+// limited to maxLen. Symbols with 0 frequency also get a code.
+func huffmanCodeLengths(lens []uint8, freqs []int, maxLen uint8) {
+	n := len(lens)
+	weights := make([]int, n)
+	for i, f := range freqs[:n] {
+		if f == 0 {
+			f = 1
+		}
+		weights[i] = f
+	}
+
+	parents := make([]int, 2*n)
+	nodeWeights := make([]int, 2*n)
+	for {
+		// Build the tree with a simple 2-queue algorithm on the sorted leaves.
+		leaves := make([]int, n)
+		for i := range leaves {
+			leaves[i] = i
+			nodeWeights[i] = weights[i]
+		}
+		sortByWeight(leaves, nodeWeights)
+
+		internal := make([]int, 0, n)
+		li, ii, next := 0, 0, n
+		pick := func() int {
+			if li < len(leaves) && (ii >= len(internal) || nodeWeights[leaves[li]] <= nodeWeights[internal[ii]]) {
+				li++
+				return leaves[li-1]
+			}
+			ii++
+			return internal[ii-1]
+		}
+		for i := 0; i < n-1; i++ {
+			a, b := pick(), pick()
+			nodeWeights[next] = nodeWeights[a] + nodeWeights[b]
+			parents[a], parents[b] = next, next
+			internal = append(internal, next)
+			next++
+		}
+
+		// Depth of leaves
+		root := next - 1
+		tooLong := false
+		for i := 0; i < n; i++ {
+			depth := uint8(0)
+			for j := i; j != root; j = parents[j] {
+				depth++
+			}
+			if n == 1 {
+				depth = 1
+			}
+			lens[i] = depth
+			if depth > maxLen {
+				tooLong = true
+			}
+		}
+		if !tooLong {
+			return
+		}
+
+		// Flatten the weights and retry
+		for i := range weights {
+			weights[i] = weights[i]/2 + 1
+		}
+	}
+}
+
+// sortByWeight sorts the indices by their weights (insertion sort, stable).
+func sortByWeight(indices []int, weights []int) {
+	for i := 1; i < len(indices); i++ {
+		v := indices[i]
+		j := i
+		for ; j > 0 && weights[indices[j-1]] > weights[v]; j-- {
+			indices[j] = indices[j-1]
+		}
+		indices[j] = v
+	}
+}
+
+// canonicalCodes assigns canonical Huffman codes to the code lengths,
+// in the order used by bzip2 (by increasing length, then by symbol).
+func canonicalCodes(lens []uint8) []uint32 {
+	codes := make([]uint32, len(lens))
+	minLen, maxLen := uint8(255), uint8(0)
+	for _, l := range lens {
+		if l < minLen {
+			minLen = l
+		}
+		if l > maxLen {
+			maxLen = l
+		}
+	}
+	var code uint32
+	for n := minLen; n <= maxLen; n++ {
+		for i, l := range lens {
+			if l == n {
+				codes[i] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
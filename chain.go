@@ -0,0 +1,90 @@
+// Search chain of multiple MPQ archives.
+
+package mpq
+
+// Chain is a search chain of MPQ archives layered in priority order,
+// typically a base archive and patch archives overriding its files.
+// Files are resolved like Storm does: the archive with the highest priority containing the file wins,
+// and a deletion marker in an archive hides the file of lower-priority archives.
+type Chain struct {
+	archives []*MPQ // Archives in increasing priority
+}
+
+// NewChain returns a new Chain of the given archives in increasing priority:
+// the base archive first, followed by the patch archives (later archives override earlier ones).
+func NewChain(archives ...*MPQ) *Chain {
+	return &Chain{archives: append([]*MPQ(nil), archives...)}
+}
+
+// Add adds an archive to the chain with the highest priority.
+func (c *Chain) Add(m *MPQ) {
+	c.archives = append(c.archives, m)
+}
+
+// Archives returns the archives of the chain in increasing priority.
+func (c *Chain) Archives() []*MPQ {
+	return append([]*MPQ(nil), c.archives...)
+}
+
+// FileByName returns the content of a file specified by its name from the highest-priority archive containing it.
+// Incremental patch files (see FlagPatchFile) are applied to the file of the lower-priority archives
+// (see ApplyPatch()); patch files may be stacked in multiple archives.
+//
+// ErrFileNotFound is returned if the file cannot be found, and ErrFileDeleted is returned if it is marked
+// as deleted in an archive (in which case lower-priority archives are not searched).
+// Errors reading the file from the archive and errors of applying patches are returned as-is.
+func (c *Chain) FileByName(name string) ([]byte, error) {
+	return c.file(len(c.archives)-1,
+		func(m *MPQ) ([]byte, error) { return m.FileByName(name) },
+		func(m *MPQ) int { return m.blockIndexByName(name) },
+	)
+}
+
+// FileByHash returns the content of a file specified by hashes of its name from the highest-priority
+// archive containing it. The required hashes of a name can be acquired using the FileNameHash() function.
+//
+// Return values are the same as those of FileByName().
+func (c *Chain) FileByHash(h1, h2, h3 uint32) ([]byte, error) {
+	return c.file(len(c.archives)-1,
+		func(m *MPQ) ([]byte, error) { return m.FileByHash(h1, h2, h3) },
+		func(m *MPQ) int { return m.blockIndexByHash(h1, h2, h3) },
+	)
+}
+
+// file returns the content of a file from the highest-priority archive containing it, searching the archives
+// up to the index top. read reads the file from an archive, blockIndex returns the block index of the file.
+func (c *Chain) file(top int, read func(m *MPQ) ([]byte, error), blockIndex func(m *MPQ) int) ([]byte, error) {
+	for i := top; i >= 0; i-- {
+		m := c.archives[i]
+		data, err := read(m)
+		if err == ErrFileNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if idx := blockIndex(m); idx < 0 || m.blockTable[idx].flags&uint32(FlagPatchFile) == 0 {
+			return data, nil
+		}
+
+		// Patch file, the base is the file of the lower-priority archives (if any)
+		base, err := c.file(i-1, read, blockIndex)
+		if err != nil && err != ErrFileNotFound {
+			return nil, err
+		}
+		return ApplyPatch(base, data)
+	}
+	return nil, ErrFileNotFound
+}
+
+// Close closes all archives of the chain.
+// The first error encountered (if any) is returned.
+func (c *Chain) Close() error {
+	var firstErr error
+	for _, m := range c.archives {
+		if err := m.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,67 @@
+package mpq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	build := func(files []string, contents []string) []byte {
+		w, err := NewBufferedWriter()
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		for i, name := range files {
+			if err := w.AddFile(name, []byte(contents[i])); err != nil {
+				t.Errorf("Failed to add file: %v", err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		return buf.Bytes()
+	}
+	open := func(content []byte) *MPQ {
+		m, err := New(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Failed to open archive: %v", err)
+		}
+		return m
+	}
+
+	base := open(build([]string{"a.txt", "b.txt", "c.txt"}, []string{"base a", "base b", "base c"}))
+	content := build([]string{"b.txt", "c.txt", "d.txt"}, []string{"patch b", "", "patch d"})
+	// Turn c.txt of the patch into a deletion marker:
+	setBlockFlags(content, open(content).header, 1, beFlagFile|beFlagDeleteMarker)
+	patch := open(content)
+
+	c := NewChain(base)
+	c.Add(patch)
+	defer c.Close()
+	if n := len(c.Archives()); n != 2 {
+		t.Errorf("Unexpected number of archives: %d", n)
+	}
+
+	for name, exp := range map[string]string{"a.txt": "base a", "b.txt": "patch b", "d.txt": "patch d"} {
+		if got, err := c.FileByName(name); err != nil || string(got) != exp {
+			t.Errorf("File %s mismatch: %q (err: %v)", name, got, err)
+		}
+		if got, err := c.FileByHash(FileNameHash(name)); err != nil || string(got) != exp {
+			t.Errorf("File %s mismatch by hash: %q (err: %v)", name, got, err)
+		}
+	}
+	for name, exp := range map[string]error{"c.txt": ErrFileDeleted, "x.txt": ErrFileNotFound} {
+		if got, err := c.FileByName(name); got != nil || err != exp {
+			t.Errorf("Expected nil, %v for %s, got: %q, %v", exp, name, got, err)
+		}
+	}
+
+	// Reversed priority:
+	c = NewChain(patch, base)
+	for name, exp := range map[string]string{"b.txt": "base b", "c.txt": "base c"} {
+		if got, err := c.FileByName(name); err != nil || string(got) != exp {
+			t.Errorf("File %s mismatch: %q (err: %v)", name, got, err)
+		}
+	}
+}
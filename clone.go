@@ -0,0 +1,66 @@
+// Cloning of the archives.
+
+package mpq
+
+import "io"
+
+// Clone returns a new MPQ which shares the already parsed header and tables of m (they are not parsed again),
+// but reads the content of the files from input, e.g. from a separately opened file of the same archive,
+// so workers extracting files in parallel don't contend for the same input.
+// input must provide the same content as the input of m. If input is nil, the input of m is shared.
+//
+// The tables of m are read first if they are not yet read (see WithLazyTables); the clone reports
+// the same error of reading them. The options of m apply to the clone too.
+// Closing the clone does not close the input (nor the source file of m).
+func (m *MPQ) Clone(input io.ReadSeeker) *MPQ {
+	m.loadTables()
+	var sharedInputLock *inputLock
+	if input == nil {
+		input, sharedInputLock = m.input, m.lockOfInput()
+	}
+
+	c := &MPQ{
+		input:                    input,
+		sharedInputLock:          sharedInputLock,
+		memory:                   m.memory,
+		mapHeader:                m.mapHeader,
+		header:                   m.header,
+		hashTable:                m.hashTable,
+		blockTable:               m.blockTable,
+		het:                      m.het,
+		bet:                      m.bet,
+		extBlockEntryHighOffsets: m.extBlockEntryHighOffsets,
+		scanHeader:               m.scanHeader,
+		names:                    m.names[:len(m.names):len(m.names)], // Appending to the names of the clone must not affect m
+		archiveOffset:            m.archiveOffset,
+		blockSize:                m.blockSize,
+		blockEntryIndices:        m.blockEntryIndices,
+		fileIndices:              m.fileIndices,
+		filesCount:               m.filesCount,
+		strict:                   m.strict,
+		lazyTables:               m.lazyTables,
+		headerOnly:               m.headerOnly,
+		maxMemory:                m.maxMemory,
+		locale:                   m.locale,
+		hasLocale:                m.hasLocale,
+		noUserDataCopy:           m.noUserDataCopy,
+		logger:                   m.logger,
+		inMemoryLimit:            m.inMemoryLimit,
+		tablesErr:                m.tablesErr,
+		warnings:                 m.warnings[:len(m.warnings):len(m.warnings)],
+	}
+	c.tablesOnce.Do(func() {}) // Tables are shared, not to be read again
+	if m.ioStats != nil {
+		c.ioStats = &IOStats{}
+	}
+
+	m.cacheMu.Lock()
+	if m.userData != nil {
+		u := *m.userData
+		c.userData = &u
+	}
+	c.nameIndex, c.attributes = m.nameIndex, m.attributes
+	m.cacheMu.Unlock()
+
+	return c
+}
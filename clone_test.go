@@ -0,0 +1,60 @@
+package mpq
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithLazyTables())
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	names := []string{"replay.details", "replay.initData", "replay.tracker.events", "replay.game.events"}
+
+	// Shared input, closing the clone must not close the file of m:
+	c := m.Clone(nil)
+	if c.FilesCount() != m.FilesCount() || c.FilesCount() == 0 {
+		t.Errorf("Got: %d files, want: %d", c.FilesCount(), m.FilesCount())
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		f, err := os.Open("reps/lotv.SC2Replay")
+		if err != nil {
+			t.Fatalf("Failed to open replay: %v", err)
+		}
+		defer f.Close()
+		c := m.Clone(f)
+		c.AddNames("extra.txt")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, name := range names {
+				got, err := c.FileByName(name)
+				if err != nil {
+					t.Errorf("[%s] Unexpected error: %v", name, err)
+				}
+				exp, _ := m.FileByName(name)
+				if !bytes.Equal(got, exp) {
+					t.Errorf("[%s] Content mismatch", name)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(m.names) != 0 {
+		t.Errorf("Names registered to the clones are registered to the original: %v", m.names)
+	}
+	if !bytes.Equal(c.UserData(), m.UserData()) {
+		t.Errorf("User data mismatch")
+	}
+}
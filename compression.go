@@ -0,0 +1,110 @@
+// Compression of sectors for the Writer.
+
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+
+	"github.com/icza/mpq/pkware"
+)
+
+var (
+	// ErrCompressionUnsupported indicates a compression (method or combination of methods) that is not supported.
+	ErrCompressionUnsupported = errors.New("Unsupported MPQ compression")
+)
+
+// Compression is a bitmask of the compression methods applied to the data of a file.
+// Compressed sectors start with a byte holding this mask.
+//
+// Multiple methods may be combined, in which case they are applied in the order
+// sparse first, then zlib or bzip2 (e.g. CompressionSparse|CompressionZlib).
+type Compression byte
+
+// Compression methods.
+const (
+	// CompressionNone stores the data without compression.
+	CompressionNone Compression = 0x00
+
+	// CompressionZlib is the zlib (deflate) compression.
+	CompressionZlib Compression = 0x02
+
+	// CompressionPKWare is the PKWARE Data Compression Library (DCL) implode compression.
+	CompressionPKWare Compression = 0x08
+
+	// CompressionBzip2 is the bzip2 compression.
+	CompressionBzip2 Compression = 0x10
+
+	// CompressionLZMA is the LZMA compression. It is a method on its own, not a combination of methods.
+	// Only supported when reading.
+	CompressionLZMA Compression = 0x12
+
+	// CompressionSparse is Storm's run-length encoding of zero bytes.
+	CompressionSparse Compression = 0x20
+
+	// CompressionADPCMMono is the IMA ADPCM compression of mono (1 channel) audio data.
+	// Only supported when reading.
+	CompressionADPCMMono Compression = 0x40
+
+	// CompressionADPCMStereo is the IMA ADPCM compression of stereo (2 channels) audio data.
+	// Only supported when reading.
+	CompressionADPCMStereo Compression = 0x80
+)
+
+// valid tells if the compression (combination) is supported when writing.
+func (c Compression) valid() bool {
+	switch c {
+	case CompressionNone, CompressionZlib, CompressionBzip2, CompressionPKWare, CompressionSparse,
+		CompressionSparse | CompressionZlib, CompressionSparse | CompressionBzip2, CompressionSparse | CompressionPKWare:
+		return true
+	}
+	return false
+}
+
+// compressMulti compresses a sector with the methods specified by c, using the given compression level
+// (see WithCompressionLevel).
+// The returned data starts with the compression mask.
+// If the compressed data is not smaller than the input, src itself is returned
+// (such sectors are stored uncompressed, without the compression mask).
+func compressMulti(src []byte, c Compression, level int) ([]byte, error) {
+	data := src
+	if c&CompressionSparse != 0 {
+		data = compressSparse(data)
+	}
+
+	switch {
+	case c&CompressionZlib != 0:
+		buf := &bytes.Buffer{}
+		zw, err := zlib.NewWriterLevel(buf, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	case c&CompressionBzip2 != 0:
+		if level < 1 {
+			level = 9
+		}
+		data = bzip2Compress(data, level)
+	case c&CompressionPKWare != 0:
+		var err error
+		if data, err = pkware.Implode(data, pkware.Binary, 4096); err != nil {
+			return nil, err
+		}
+	}
+
+	if 1+len(data) >= len(src) {
+		return src, nil
+	}
+
+	out := make([]byte, 1+len(data))
+	out[0] = byte(c)
+	copy(out[1:], data)
+	return out, nil
+}
@@ -0,0 +1,86 @@
+// Concurrent reading of the archives.
+
+package mpq
+
+import (
+	"io"
+	"math"
+	"sync"
+)
+
+// inputLock serializes reading an input not implementing io.ReaderAt, and tracks the position of the input,
+// so seeking to the current position (e.g. when reading consecutive sectors) can be skipped.
+type inputLock struct {
+	mu       sync.Mutex
+	pos      int64 // Position of the input, valid if posKnown is true
+	posKnown bool  // Tells if the position of the input is known
+
+	buf inputBuffer // Buffer of the input coalescing small reads, see bufferedReaderAt
+}
+
+// lockedReaderAt implements io.ReaderAt over an io.ReadSeeker, serializing the reads with an inputLock.
+type lockedReaderAt struct {
+	lock *inputLock
+	rs   io.ReadSeeker
+}
+
+// ReadAt implements io.ReaderAt.
+func (l *lockedReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	lock := l.lock
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	if !lock.posKnown || lock.pos != off {
+		lock.posKnown = false
+		if _, err = l.rs.Seek(off, io.SeekStart); err != nil {
+			return
+		}
+	}
+	n, err = io.ReadFull(l.rs, p)
+	lock.pos, lock.posKnown = off+int64(n), err == nil
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return
+}
+
+// inputAt returns an io.ReaderAt of the input, which may be used concurrently.
+// If the input implements io.ReaderAt, it is used directly, else reads are serialized (see lockedReaderAt),
+// and small reads are served from a buffer (see bufferedReaderAt).
+// Operations on the input are counted if enabled (see WithIOStats).
+func (m *MPQ) inputAt() io.ReaderAt {
+	if ra, ok := m.input.(io.ReaderAt); ok {
+		if m.ioStats != nil {
+			return &countingReaderAt{ra: ra, stats: m.ioStats}
+		}
+		return ra
+	}
+	// Reads of the input are serialized anyway, small reads are coalesced
+	lock := m.lockOfInput()
+	return &bufferedReaderAt{buf: &lock.buf, ra: &lockedReaderAt{lock: lock, rs: m.seqInput()}}
+}
+
+// lockOfInput returns the lock of the input: the lock shared with the MPQ m was cloned from
+// if the input is shared (see Clone()), else the own lock of m.
+func (m *MPQ) lockOfInput() *inputLock {
+	if m.sharedInputLock != nil {
+		return m.sharedInputLock
+	}
+	return &m.inputLock
+}
+
+// fileInput returns a reader of the input having its own position, so multiple readers may be used concurrently.
+func (m *MPQ) fileInput() io.ReadSeeker {
+	return io.NewSectionReader(m.inputAt(), 0, math.MaxInt64)
+}
+
+// tableInput returns a reader of the input for reading the header and the tables, like fileInput().
+// Their small reads are coalesced even if the input implements io.ReaderAt, with a buffer of the returned
+// reader, so other readers of the input are not affected. Inputs held in memory are not buffered.
+func (m *MPQ) tableInput() io.ReadSeeker {
+	ra := m.inputAt()
+	if _, ok := ra.(*bufferedReaderAt); !ok && m.memory == nil {
+		ra = &bufferedReaderAt{buf: &inputBuffer{}, ra: ra}
+	}
+	return io.NewSectionReader(ra, 0, math.MaxInt64)
+}
@@ -0,0 +1,101 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// readSeeker hides all methods of the wrapped reader other than Read and Seek (e.g. ReadAt).
+type readSeeker struct {
+	io.ReadSeeker
+}
+
+func TestConcurrentReads(t *testing.T) {
+	data, err := ioutil.ReadFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to read replay: %v", err)
+	}
+	names := []string{
+		"replay.details", "replay.initData", "replay.tracker.events", "replay.game.events",
+		"replay.message.events", "replay.attributes.events",
+	}
+
+	inputs := map[string]io.ReadSeeker{
+		"ReaderAt": bytes.NewReader(data),
+		"locked":   readSeeker{bytes.NewReader(data)},
+	}
+	for inputName, input := range inputs {
+		m, err := New(input, WithLazyTables())
+		if err != nil {
+			t.Fatalf("[%s] Failed to open replay: %v", inputName, err)
+		}
+
+		var wg sync.WaitGroup
+		results := make([][][]byte, 8)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				m.UserData()
+				for _, name := range names {
+					content, err := m.FileByName(name)
+					if err != nil {
+						t.Errorf("[%s] Unexpected error: %v", inputName, err)
+					}
+					results[i] = append(results[i], content)
+					m.NameForHash(FileNameHash(name))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for i, contents := range results {
+			for j, content := range contents {
+				if !bytes.Equal(content, results[0][j]) {
+					t.Errorf("[%s] Content mismatch of %s in goroutine %d", inputName, names[j], i)
+				}
+			}
+		}
+	}
+}
+
+func TestRedundantSeeks(t *testing.T) {
+	content := bytes.Repeat([]byte("sequential sectors "), 2000) // Multiple sectors
+	data := buildArchiveBytes(t, "a.txt", string(content))
+	m, err := New(readSeeker{bytes.NewReader(data)}, WithIOStats())
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	r, err := m.Open("a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer r.Close()
+	sectors := r.sr.sectorsCount
+	if sectors < 4 {
+		t.Fatalf("Got: %d sectors, want: at least 4", sectors)
+	}
+
+	// Sectors following the sector offset table are read without seeking:
+	before := m.IOStats()
+	got, err := m.FileByName("a.txt")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(got), err, len(content))
+	}
+	if seeks := m.IOStats().Seeks - before.Seeks; seeks > 1 {
+		t.Errorf("Got: %d seeks, want: at most 1", seeks)
+	}
+
+	// Moving the input of a clone sharing it must be tracked:
+	c := m.Clone(nil)
+	for i := 0; i < 3; i++ {
+		for _, mm := range []*MPQ{m, c} {
+			if got, err := mm.FileByName("a.txt"); err != nil || !bytes.Equal(got, content) {
+				t.Errorf("Got: %d bytes, %v, want: %d bytes", len(got), err, len(content))
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Context-aware variants of the operations which read the input.
+
+package mpq
+
+import (
+	"context"
+	"io"
+)
+
+// ctxReader is an io.ReadSeeker which fails with the error of its context once the context is done.
+// It allows interrupting long reads from slow inputs.
+type ctxReader struct {
+	ctx context.Context
+	rs  io.ReadSeeker
+}
+
+// Read implements io.Reader.
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.rs.Read(p)
+}
+
+// Seek implements io.Seeker.
+func (r *ctxReader) Seek(offset int64, whence int) (int64, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.rs.Seek(offset, whence)
+}
+
+// NewContext is like New, but the error of ctx (context.Canceled or context.DeadlineExceeded)
+// is returned if ctx is done before the header and the tables of the archive are read.
+// ctx only affects the construction, not the subsequent use of the returned MPQ.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewContext(ctx context.Context, input io.ReadSeeker, opts ...Option) (*MPQ, error) {
+	m := &MPQ{input: &ctxReader{ctx: ctx, rs: input}}
+	m.applyOptions(opts)
+
+	if _, err := m.diveIn(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	m.input = input
+	return m, nil
+}
+
+// FileByNameContext is like FileByName, but the error of ctx (context.Canceled or context.DeadlineExceeded)
+// is returned if ctx is done before the file is read. Cancellation is checked before each read and seek
+// of the input, and before decompressing each sector of the file.
+func (m *MPQ) FileByNameContext(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	idx := m.blockIndexByName(name)
+	if idx < 0 {
+		return nil, ErrFileNotFound
+	}
+	return m.readFileContext(ctx, nil, idx, name)
+}
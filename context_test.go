@@ -0,0 +1,65 @@
+package mpq
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// cancelingReader is an io.ReadSeeker which cancels a context after a number of reads.
+type cancelingReader struct {
+	io.ReadSeeker
+	reads  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	if r.reads--; r.reads == 0 {
+		r.cancel()
+	}
+	return r.ReadSeeker.Read(p)
+}
+
+func TestNewContext(t *testing.T) {
+	data := buildArchiveBytes(t, "a.txt", "a")
+
+	m, err := NewContext(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, err := m.FileByName("a.txt"); err != nil || string(got) != "a" {
+		t.Errorf("Got: %q, %v, want: %q, nil", got, err, "a")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewContext(ctx, bytes.NewReader(data)); err != context.Canceled {
+		t.Errorf("Got: %v, want: %v", err, context.Canceled)
+	}
+}
+
+func TestFileByNameContext(t *testing.T) {
+	content := strings.Repeat("context ", 10000) // Multiple sectors
+	data := buildArchiveBytes(t, "a.txt", content)
+
+	m, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if got, err := m.FileByNameContext(context.Background(), "a.txt"); err != nil || string(got) != content {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes, nil", len(got), err, len(content))
+	}
+	if got, err := m.FileByNameContext(context.Background(), "missing.txt"); err != ErrFileNotFound || got != nil {
+		t.Errorf("Got: %v, %v, want: nil, %v", got, err, ErrFileNotFound)
+	}
+
+	// Canceled while reading:
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.input = &cancelingReader{ReadSeeker: m.input, reads: 2, cancel: cancel}
+	if _, err := m.FileByNameContext(ctx, "a.txt"); err != context.Canceled {
+		t.Errorf("Got: %v, want: %v", err, context.Canceled)
+	}
+}
@@ -32,6 +32,20 @@ If you already have the MPQ data in memory:
 	mpqdata := []byte{} // MPQ data in memory
 	m, err := mpq.New(bytes.NewReader(mpqdata)))
 
+Creating a new MPQ archive:
+
+	w, err := mpq.NewFileWriter("myarchive.mpq")
+	if err != nil {
+		// Handle error
+		return
+	}
+	if err := w.AddFile("readme.txt", []byte("Hello, MPQ!")); err != nil {
+		// Handle error
+	}
+	if err := w.Close(); err != nil {
+		// Handle error
+	}
+
 
 Information sources
 
@@ -0,0 +1,649 @@
+// In-place modification of existing MPQ archives.
+
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrHashTableFull indicates that there is no free entry in the hash table of the archive for a new file.
+	ErrHashTableFull = errors.New("MPQ hash table is full")
+
+	// ErrUnknownFileName indicates that an operation requires the name of a file which is not known
+	// (e.g. it is not listed in the "(listfile)").
+	ErrUnknownFileName = errors.New("Unknown name of MPQ file")
+)
+
+// deletedHashEntry is a hash table entry of a deleted file.
+var deletedHashEntry = hashEntry{
+	filePathHashA: 0xffffffff, filePathHashB: 0xffffffff,
+	language: 0xffff, platform: 0xffff,
+	fileBlockIndex: hashEntryDeleted,
+}
+
+// Editor modifies an existing MPQ archive in place.
+//
+// Added files are written after the existing file data (overwriting the old tables), or into free space
+// (e.g. the space of replaced files) if they fit, so the archive is never rewritten as a whole.
+// The hash table, the block table and the header are rewritten when the Editor is closed,
+// so the Editor must be closed with the Close method!
+//
+// The size of the hash table is not changed, which limits the number of files that can be added.
+// If the archive has a "(listfile)", it is kept up-to-date with the added and deleted files.
+// The HET and BET tables (of format version 3 and later) are dropped (the hash and block tables are sufficient),
+// and the digital signatures and the "(attributes)" file are not updated.
+type Editor struct {
+	file *os.File // The archive file
+
+	w *Writer // Writes the content of the files, and the tables
+
+	hashTable  []hashEntry  // The hash table
+	blockTable []blockEntry // The block table (block offsets are in offsets)
+	offsets    []int64      // Offsets of the blocks, relative to the beginning of the archive
+
+	extended bool // Tells if the archive has an extended block table
+
+	emptyEntries int // Number of empty hash table entries (that have always been empty)
+
+	free []region // Free regions of the archive that may hold new files
+
+	listfile      []string // Names in the "(listfile)", nil if the archive has no (readable) "(listfile)"
+	listfileDirty bool     // Tells if the "(listfile)" has to be rewritten
+
+	names map[[2]uint32]string // Known file names (listed or added), mapped from their name hashes
+}
+
+// region is a region of the archive.
+type region struct {
+	offset, size int64 // Offset (relative to the beginning of the archive) and size of the region
+	block        int   // Index of the free block table entry describing the region (see Delete), -1 if none
+}
+
+// OpenEditor opens the named MPQ archive for editing.
+// The returned Editor must be closed with the Close method!
+func OpenEditor(name string) (*Editor, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := newEditor(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// newEditor creates a new Editor, reading the archive from f.
+func newEditor(f *os.File) (*Editor, error) {
+	m, err := New(f)
+	if err != nil {
+		return nil, err
+	}
+	if m.hashTable == nil {
+		// Only HET and BET tables are usable, editing such archives is not supported
+		return nil, ErrInvalidArchive
+	}
+
+	base := m.archiveOffset
+
+	e := &Editor{
+		file: f,
+		w: &Writer{
+			out:              f,
+			base:             base,
+			header:           m.header,
+			blockSize:        m.blockSize,
+			compressionLevel: zlib.DefaultCompression,
+		},
+		hashTable:  m.hashTable,
+		blockTable: m.blockTable,
+		offsets:    make([]int64, len(m.blockTable)),
+		extended:   m.extBlockEntryHighOffsets != nil,
+		names:      map[[2]uint32]string{},
+	}
+
+	for _, he := range e.hashTable {
+		if he.fileBlockIndex == hashEntryEmpty {
+			e.emptyEntries++
+		}
+	}
+
+	// New content is written after the header and the data of all blocks:
+	end := int64(m.header.size)
+	for i, be := range e.blockTable {
+		e.offsets[i] = int64(be.blockOffset)
+		if e.extended {
+			e.offsets[i] |= int64(m.extBlockEntryHighOffsets[i]) << 32
+		}
+		if blockEnd := e.offsets[i] + int64(be.blockSize); blockEnd > end {
+			end = blockEnd
+		}
+	}
+
+	if data, err := m.FileByName(listfileName); err == nil && data != nil {
+		e.listfile = parseListfile(data)
+		for _, name := range e.listfile {
+			e.addName(name)
+		}
+	}
+
+	// Reading the archive is done, position the output
+	e.w.pos = end
+	if _, err = f.Seek(base+end, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// AddFile adds a file with the specified name and content to the archive.
+// If a file with the same name already exists, it is replaced.
+//
+// See Writer.AddFile for the available options and the possible errors.
+// ErrHashTableFull is returned if there is no room for a new file in the hash table.
+func (e *Editor) AddFile(name string, data []byte, opts ...FileOption) error {
+	return e.AddFileReader(name, bytes.NewReader(data), opts...)
+}
+
+// AddFileReader adds a file with the specified name to the archive whose content is read from r until EOF.
+// If a file with the same name already exists, it is replaced.
+//
+// See Writer.AddFileReader for the available options and the possible errors.
+// ErrHashTableFull is returned if there is no room for a new file in the hash table.
+func (e *Editor) AddFileReader(name string, r io.Reader, opts ...FileOption) error {
+	w := e.w
+	if w.err != nil {
+		return w.err
+	}
+
+	fc, err := w.fileConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	h1, h2, h3 := FileNameHash(name)
+	idx, free := e.lookup(h1, h2, h3)
+	if idx < 0 && free < 0 {
+		return ErrHashTableFull
+	}
+	e.addName(name)
+
+	f := &writerFile{
+		name: name,
+		h1:   h1, h2: h2, h3: h3,
+	}
+	if err := w.writeFile(f, r, fc); err != nil {
+		return err
+	}
+
+	var blockIndex uint32
+	if idx >= 0 {
+		// Replace: the space of the old content becomes free
+		blockIndex = e.hashTable[idx].fileBlockIndex
+		if old := e.blockTable[blockIndex]; old.blockSize > 0 {
+			e.free = append(e.free, region{offset: e.offsets[blockIndex], size: int64(old.blockSize), block: -1})
+		}
+	} else {
+		blockIndex = uint32(len(e.blockTable))
+		e.blockTable = append(e.blockTable, blockEntry{})
+		e.offsets = append(e.offsets, 0)
+
+		if e.hashTable[free].fileBlockIndex == hashEntryEmpty {
+			e.emptyEntries--
+		}
+		e.hashTable[free] = hashEntry{filePathHashA: h2, filePathHashB: h3, fileBlockIndex: blockIndex}
+
+		if e.listfile != nil && name != listfileName {
+			e.listfile = append(e.listfile, name)
+			e.listfileDirty = true
+		}
+	}
+
+	if err := e.relocate(f); err != nil {
+		w.err = err
+		return err
+	}
+
+	e.blockTable[blockIndex] = f.block
+	e.offsets[blockIndex] = f.offset
+
+	return nil
+}
+
+// lookup returns the index of the hash table entry of the file (-1 if the file is not in the archive),
+// and the index of the first free entry on its search path (-1 if there is no free entry that may be used).
+func (e *Editor) lookup(h1, h2, h3 uint32) (idx, free int) {
+	idx, free = -1, -1
+
+	n := uint32(len(e.hashTable))
+	for i, j := uint32(0), h1&(n-1); i < n; i, j = i+1, (j+1)&(n-1) {
+		he := &e.hashTable[j]
+		switch {
+		case he.fileBlockIndex == hashEntryEmpty:
+			// At least 1 empty entry must remain to terminate searches
+			if free < 0 && e.emptyEntries > 1 {
+				free = int(j)
+			}
+			return
+		case he.fileBlockIndex == hashEntryDeleted:
+			if free < 0 {
+				free = int(j)
+			}
+		case he.filePathHashA == h2 && he.filePathHashB == h3 && he.fileBlockIndex < uint32(len(e.blockTable)):
+			return int(j), free
+		}
+	}
+
+	return
+}
+
+// relocate moves the content of the file (just written after the file data) into the smallest free region
+// that can hold it. Files encrypted with the FIX_KEY variant are not moved, as their key depends on their position.
+func (e *Editor) relocate(f *writerFile) error {
+	size := int64(f.block.blockSize)
+	if size == 0 || f.block.flags&beFlagFixKey != 0 {
+		return nil
+	}
+
+	best := -1
+	for i, r := range e.free {
+		if r.size >= size && (best < 0 || r.size < e.free[best].size) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	r := &e.free[best]
+
+	if err := e.move(f.offset, r.offset, size); err != nil {
+		return err
+	}
+	if err := e.w.discard(f.offset, nil); err != nil {
+		return err
+	}
+	f.offset = r.offset
+	r.offset, r.size = r.offset+size, r.size-size
+
+	// The free block table entry must only describe the rest of the region
+	if r.block >= 0 {
+		if r.size > 0 {
+			e.offsets[r.block], e.blockTable[r.block].blockSize = r.offset, uint32(r.size)
+		} else {
+			e.offsets[r.block], e.blockTable[r.block] = 0, blockEntry{}
+		}
+	}
+
+	return nil
+}
+
+// move copies size bytes of the archive from offset from to offset to.
+// The regions may only overlap if to < from.
+func (e *Editor) move(from, to, size int64) error {
+	w := e.w
+	buf := make([]byte, 64*1024)
+	for copied := int64(0); copied < size; {
+		chunk := buf
+		if remaining := size - copied; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		if _, err := e.file.ReadAt(chunk, w.base+from+copied); err != nil {
+			return err
+		}
+		if err := w.writeAt(chunk, to+copied); err != nil {
+			return err
+		}
+		copied += int64(len(chunk))
+	}
+	return nil
+}
+
+// addName records a known file name.
+func (e *Editor) addName(name string) {
+	_, h2, h3 := FileNameHash(name)
+	e.names[[2]uint32{h2, h3}] = name
+}
+
+// Delete deletes the named file from the archive.
+//
+// The hash table entry of the file is marked deleted, and its block is marked free
+// (its space may be reused by files added later). Use Compact to reclaim the space of deleted files.
+//
+// ErrFileNotFound is returned if the file is not in the archive.
+func (e *Editor) Delete(name string) error {
+	w := e.w
+	if w.err != nil {
+		return w.err
+	}
+
+	h1, h2, h3 := FileNameHash(name)
+	idx, _ := e.lookup(h1, h2, h3)
+	if idx < 0 {
+		return ErrFileNotFound
+	}
+
+	blockIndex := e.hashTable[idx].fileBlockIndex
+	e.hashTable[idx] = deletedHashEntry
+
+	// Free the block unless it is shared with other entries (of other locales)
+	shared := false
+	for _, he := range e.hashTable {
+		if he.fileBlockIndex == blockIndex {
+			shared = true
+			break
+		}
+	}
+	if be := &e.blockTable[blockIndex]; !shared {
+		be.flags = 0 // Not a file: free block
+		if be.blockSize > 0 {
+			e.free = append(e.free, region{offset: e.offsets[blockIndex], size: int64(be.blockSize), block: int(blockIndex)})
+		}
+	}
+
+	if name == listfileName {
+		// No more listfile to maintain
+		e.listfile, e.listfileDirty = nil, false
+	} else if e.listfile != nil {
+		names := e.listfile[:0]
+		for _, n := range e.listfile {
+			if _, nh2, nh3 := FileNameHash(n); nh2 != h2 || nh3 != h3 {
+				names = append(names, n)
+			}
+		}
+		e.listfile, e.listfileDirty = names, true
+	}
+
+	return nil
+}
+
+// Rename renames a file of the archive, moving its hash table entry to the search path of the new name.
+// Encrypted files are re-encrypted, as their key is derived from their name.
+//
+// ErrFileNotFound is returned if the file is not in the archive,
+// ErrDuplicateFile is returned if a file with the new name already exists,
+// and ErrHashTableFull is returned if there is no free hash table entry for the new name.
+func (e *Editor) Rename(oldName, newName string) error {
+	w := e.w
+	if w.err != nil {
+		return w.err
+	}
+
+	h1, h2, h3 := FileNameHash(oldName)
+	idx, _ := e.lookup(h1, h2, h3)
+	if idx < 0 {
+		return ErrFileNotFound
+	}
+
+	// The old entry is deleted first, so it may be reused by the new name
+	he := e.hashTable[idx]
+	e.hashTable[idx] = deletedHashEntry
+
+	nh1, nh2, nh3 := FileNameHash(newName)
+	newIdx, free := e.lookup(nh1, nh2, nh3)
+	if newIdx >= 0 || free < 0 {
+		e.hashTable[idx] = he
+		if newIdx >= 0 {
+			return ErrDuplicateFile
+		}
+		return ErrHashTableFull
+	}
+
+	bi := he.fileBlockIndex
+	if be := e.blockTable[bi]; be.flags&beFlagEncrypted != 0 && be.blockSize > 0 {
+		if err := e.moveRekey(be, e.offsets[bi], e.offsets[bi], oldName, newName); err != nil {
+			w.err = err
+			return err
+		}
+	}
+
+	if e.hashTable[free].fileBlockIndex == hashEntryEmpty {
+		e.emptyEntries--
+	}
+	he.filePathHashA, he.filePathHashB = nh2, nh3
+	e.hashTable[free] = he
+
+	e.addName(newName)
+	if e.listfile != nil {
+		for i, n := range e.listfile {
+			if _, lh2, lh3 := FileNameHash(n); lh2 == h2 && lh3 == h3 {
+				e.listfile[i] = newName
+				e.listfileDirty = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// Compact rewrites the file data of the archive without gaps, reclaiming the space of deleted and replaced files
+// and dropping the blocks not referenced by the hash table. The tables are written on Close.
+//
+// Files encrypted with the FIX_KEY variant are re-encrypted, as their key depends on their position.
+// ErrUnknownFileName is returned (before anything is changed) if the name of such a file is not known.
+func (e *Editor) Compact() error {
+	w := e.w
+	if w.err != nil {
+		return w.err
+	}
+
+	// Live blocks (referenced by the hash table) in the order of their position
+	var live []uint32
+	referenced := make([]bool, len(e.blockTable))
+	keyNames := map[uint32]string{} // Names of the files whose key depends on their position
+	for _, he := range e.hashTable {
+		bi := he.fileBlockIndex
+		if bi >= uint32(len(e.blockTable)) || referenced[bi] {
+			continue
+		}
+		referenced[bi] = true
+		live = append(live, bi)
+
+		if flags := e.blockTable[bi].flags; flags&beFlagEncrypted != 0 && flags&beFlagFixKey != 0 {
+			name, ok := e.names[[2]uint32{he.filePathHashA, he.filePathHashB}]
+			if !ok {
+				return ErrUnknownFileName
+			}
+			keyNames[bi] = name
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return e.offsets[live[i]] < e.offsets[live[j]] })
+
+	// Move the blocks
+	blockTable := make([]blockEntry, 0, len(live))
+	offsets := make([]int64, 0, len(live))
+	newIndices := make([]uint32, len(e.blockTable))
+	pos := int64(w.header.size)
+	for _, bi := range live {
+		be := e.blockTable[bi]
+		if offset := e.offsets[bi]; offset != pos && be.blockSize > 0 {
+			var err error
+			if name, ok := keyNames[bi]; ok {
+				err = e.moveRekey(be, offset, pos, name, name)
+			} else {
+				err = e.move(offset, pos, int64(be.blockSize))
+			}
+			if err != nil {
+				w.err = err
+				return err
+			}
+		}
+
+		newIndices[bi] = uint32(len(blockTable))
+		blockTable = append(blockTable, be)
+		offsets = append(offsets, pos)
+		pos += int64(be.blockSize)
+	}
+
+	for i := range e.hashTable {
+		if bi := e.hashTable[i].fileBlockIndex; bi < uint32(len(e.blockTable)) {
+			e.hashTable[i].fileBlockIndex = newIndices[bi]
+		}
+	}
+	e.blockTable, e.offsets = blockTable, offsets
+	e.free = nil
+
+	// New content (and the tables) go after the compacted data
+	w.pos = pos
+	if _, w.err = w.out.Seek(w.base+pos, io.SeekStart); w.err != nil {
+		return w.err
+	}
+
+	return nil
+}
+
+// moveRekey moves the content of an encrypted file from offset from to offset to (which may be the same),
+// re-encrypting it with the key belonging to the new position and name.
+func (e *Editor) moveRekey(be blockEntry, from, to int64, oldName, newName string) error {
+	data := make([]byte, be.blockSize)
+	if _, err := e.file.ReadAt(data, e.w.base+from); err != nil {
+		return err
+	}
+
+	oldKey := fileKey(oldName, uint32(from), be.fileSize, be.flags)
+	newKey := fileKey(newName, uint32(to), be.fileSize, be.flags)
+	if err := rekey(data, be, e.w.blockSize, oldKey, newKey); err != nil {
+		return err
+	}
+
+	return e.w.writeAt(data, to)
+}
+
+// rekey re-encrypts the content of a file, changing its key from oldKey to newKey.
+func rekey(data []byte, be blockEntry, sectorSize, oldKey, newKey uint32) error {
+	if be.flags&beFlagSingle != 0 {
+		decrypt(data, oldKey)
+		encrypt(data, newKey)
+		return nil
+	}
+
+	// Sector boundaries
+	sectors := (be.fileSize + sectorSize - 1) / sectorSize
+	var offsets []uint32
+	if be.flags&beFlagCompressed != 0 {
+		n := sectors + 1
+		if be.flags&beFlagExtra != 0 {
+			n++ // Sector checksums
+		}
+		if uint32(len(data)) < n*4 {
+			return ErrInvalidArchive
+		}
+		table := data[:n*4]
+		decrypt(table, oldKey-1)
+		offsets = make([]uint32, n)
+		for i := range offsets {
+			offsets[i] = binary.LittleEndian.Uint32(table[i*4:])
+		}
+		encrypt(table, newKey-1)
+	} else {
+		offsets = make([]uint32, sectors+1)
+		for i := range offsets {
+			offsets[i] = uint32(i) * sectorSize
+		}
+		offsets[sectors] = uint32(len(data))
+	}
+
+	for i := 0; i+1 < len(offsets); i++ {
+		if offsets[i] > offsets[i+1] || offsets[i+1] > uint32(len(data)) {
+			return ErrInvalidArchive
+		}
+		sector := data[offsets[i]:offsets[i+1]]
+		decrypt(sector, oldKey+uint32(i))
+		encrypt(sector, newKey+uint32(i))
+	}
+
+	return nil
+}
+
+// Close writes the "(listfile)" (if it changed), the hash table, the block table and the header of the archive,
+// and closes the archive file.
+func (e *Editor) Close() error {
+	w := e.w
+	if w.err == ErrWriterClosed {
+		return w.err
+	}
+
+	err := e.finish()
+	if err == nil {
+		// The archive may have shrunk (the tables may have been moved), cut the rest
+		var end int64
+		if end, err = e.file.Seek(0, io.SeekCurrent); err == nil {
+			err = e.file.Truncate(end)
+		}
+	}
+	if err2 := e.file.Close(); err == nil {
+		err = err2
+	}
+
+	w.err = ErrWriterClosed
+
+	return err
+}
+
+// finish writes the data that can only be written after all modifications.
+func (e *Editor) finish() error {
+	w := e.w
+	if w.err != nil {
+		return w.err
+	}
+
+	if e.listfileDirty {
+		sb := strings.Builder{}
+		for _, name := range e.listfile {
+			sb.WriteString(name)
+			sb.WriteString("\r\n")
+		}
+		if err := e.AddFile(listfileName, []byte(sb.String())); err != nil {
+			return err
+		}
+	}
+
+	// Tables are written after the file data, the extended block table is needed for archives exceeding 4 GB:
+	tablesSize := int64(len(e.hashTable)+len(e.blockTable)) * 16
+	if w.pos+tablesSize > math.MaxUint32 {
+		e.extended = true
+	}
+	if e.extended {
+		if w.header.formatVersion == uint16(FormatVersion1) {
+			return ErrArchiveTooLarge
+		}
+		tablesSize += int64(len(e.blockTable)) * 2
+	}
+	if w.pos+tablesSize > 1<<48 {
+		return ErrArchiveTooLarge
+	}
+
+	var highOffsets []uint16
+	if e.extended {
+		highOffsets = make([]uint16, len(e.blockTable))
+	}
+	for i, offset := range e.offsets {
+		e.blockTable[i].blockOffset = uint32(offset)
+		if e.extended {
+			highOffsets[i] = uint16(offset >> 32)
+		}
+	}
+
+	// The HET and BET tables are dropped
+	h := &w.header
+	h.hetTableOffset, h.hetTableSize64, h.hetTableMD5 = 0, 0, [16]byte{}
+	h.betTableOffset, h.betTableSize64, h.betTableMD5 = 0, 0, [16]byte{}
+
+	if err := w.writeClassicTables(e.hashTable, e.blockTable, highOffsets); err != nil {
+		return err
+	}
+
+	// Writing the header restores the position to the end of the archive
+	return w.writeAt(w.headerBytes(), 0)
+}
@@ -0,0 +1,357 @@
+package mpq
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyFile copies the src file into the dir folder, and returns the name of the copy.
+func copyFile(t *testing.T, src, dir string) string {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	name := filepath.Join(dir, filepath.Base(src))
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return name
+}
+
+func TestEditor(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	w, err := NewFileWriter(name)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := map[string][]byte{
+		"a.txt":    testContent(10000),
+		"b.txt":    bytes.Repeat([]byte("Lorem ipsum. "), 1000),
+		"keep.bin": testContent(3000),
+	}
+	for fname, data := range files {
+		if err := w.AddFile(fname, data, FileCompression(CompressionZlib)); err != nil {
+			t.Errorf("Failed to add file %s: %v", fname, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	origSize := fi.Size()
+
+	e, err := OpenEditor(name)
+	if err != nil {
+		t.Fatalf("Failed to open editor: %v", err)
+	}
+	files["a.txt"] = testContent(100) // Smaller, fits into the space of the old content
+	files["b.txt"] = testContent(20000)
+	files["new.txt"] = []byte("new file")
+	for _, fname := range []string{"a.txt", "b.txt", "new.txt"} {
+		if err := e.AddFile(fname, files[fname]); err != nil {
+			t.Errorf("Failed to add file %s: %v", fname, err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if err := e.AddFile(strings.Repeat("x", i+1), nil); err != nil && err != ErrHashTableFull {
+			t.Errorf("Failed to add file: %v", err)
+		}
+	}
+	if err := e.AddFile("full.txt", nil); err != ErrHashTableFull {
+		t.Errorf("Expected ErrHashTableFull, got: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Failed to close editor: %v", err)
+	}
+	if err := e.AddFile("late.txt", nil); err != ErrWriterClosed {
+		t.Errorf("Expected ErrWriterClosed, got: %v", err)
+	}
+
+	fi, err = os.Stat(name)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	if max := origSize + 20000 + 1000; fi.Size() > max {
+		t.Errorf("Archive too large: %d > %d", fi.Size(), max)
+	}
+
+	m, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open edited archive: %v", err)
+	}
+	defer m.Close()
+
+	for fname, data := range files {
+		if got, err := m.FileByName(fname); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("File %s mismatch (err: %v)", fname, err)
+		}
+	}
+	listfile, err := m.FileByName(listfileName)
+	if err != nil || !strings.Contains(string(listfile), "new.txt\r\n") {
+		t.Errorf("(listfile) does not contain the new file: %q (err: %v)", listfile, err)
+	}
+}
+
+func TestEditorReplay(t *testing.T) {
+	name := copyFile(t, "reps/lotv.SC2Replay", t.TempDir())
+
+	orig, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	listfile, err := orig.FileByName(listfileName)
+	if err != nil {
+		t.Fatalf("Failed to read (listfile): %v", err)
+	}
+	contents := map[string][]byte{}
+	for _, fname := range strings.Fields(string(listfile)) {
+		if contents[fname], err = orig.FileByName(fname); err != nil {
+			t.Errorf("Failed to read file %s: %v", fname, err)
+		}
+	}
+	userData := orig.UserData()
+	orig.Close()
+
+	e, err := OpenEditor(name)
+	if err != nil {
+		t.Fatalf("Failed to open editor: %v", err)
+	}
+	contents["replay.details"] = []byte("replaced details")
+	contents["extra.txt"] = testContent(5000)
+	for _, fname := range []string{"replay.details", "extra.txt"} {
+		if err := e.AddFile(fname, contents[fname], FileCompression(CompressionBzip2)); err != nil {
+			t.Errorf("Failed to add file %s: %v", fname, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Failed to close editor: %v", err)
+	}
+
+	m, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open edited replay: %v", err)
+	}
+	defer m.Close()
+
+	if !bytes.Equal(m.UserData(), userData) {
+		t.Errorf("User data mismatch")
+	}
+	for fname, data := range contents {
+		if got, err := m.FileByName(fname); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("File %s mismatch (err: %v)", fname, err)
+		}
+	}
+}
+
+func TestEditorDeleteCompact(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	w, err := NewFileWriter(name)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		opts []FileOption
+	}{
+		{"first.bin", testContent(50000), nil},
+		{"fixkey.bin", testContent(10001), []FileOption{FileEncrypted(true)}},
+		{"fixkey.txt", bytes.Repeat([]byte("Lorem ipsum. "), 1000),
+			[]FileOption{FileEncrypted(true), FileCompression(CompressionZlib)}},
+		{"plain.txt", []byte("plain"), nil},
+	}
+	for _, f := range files {
+		if err := w.AddFile(f.name, f.data, f.opts...); err != nil {
+			t.Errorf("Failed to add file %s: %v", f.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	e, err := OpenEditor(name)
+	if err != nil {
+		t.Fatalf("Failed to open editor: %v", err)
+	}
+	if err := e.Delete("first.bin"); err != nil {
+		t.Errorf("Failed to delete file: %v", err)
+	}
+	if err := e.Delete("first.bin"); err != ErrFileNotFound {
+		t.Errorf("Expected ErrFileNotFound, got: %v", err)
+	}
+	if err := e.Compact(); err != nil {
+		t.Errorf("Failed to compact: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Failed to close editor: %v", err)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if len(content) > 50000 {
+		t.Errorf("Archive is not compacted, size: %d", len(content))
+	}
+
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open edited archive: %v", err)
+	}
+	if m.header.blockTableEntries != 4 { // 3 remaining files + (listfile)
+		t.Errorf("Unexpected block table entries: %d", m.header.blockTableEntries)
+	}
+	if got, err := m.FileByName("first.bin"); got != nil || err != ErrFileNotFound {
+		t.Errorf("Deleted file is still present: %v", err)
+	}
+	if got, err := m.FileByName("plain.txt"); err != nil || string(got) != "plain" {
+		t.Errorf("File plain.txt mismatch: %q (err: %v)", got, err)
+	}
+	for _, f := range files[1:3] {
+		_, h2, h3 := FileNameHash(f.name)
+		for _, he := range m.hashTable {
+			if he.filePathHashA == h2 && he.filePathHashB == h3 {
+				be := m.blockTable[he.fileBlockIndex]
+				if got, err := decodeEncrypted(content, f.name, be, m.blockSize); err != nil || !bytes.Equal(got, f.data) {
+					t.Errorf("File %s mismatch (err: %v)", f.name, err)
+				}
+			}
+		}
+	}
+	if listfile, err := m.FileByName(listfileName); err != nil || strings.Contains(string(listfile), "first.bin") {
+		t.Errorf("(listfile) still contains the deleted file: %q (err: %v)", listfile, err)
+	}
+
+	// Names of FIX_KEY files are required for compacting:
+	e, err = OpenEditor(name)
+	if err != nil {
+		t.Fatalf("Failed to open editor: %v", err)
+	}
+	if err := e.Delete(listfileName); err != nil {
+		t.Errorf("Failed to delete file: %v", err)
+	}
+	e.names = map[[2]uint32]string{}
+	if err := e.Compact(); err != ErrUnknownFileName {
+		t.Errorf("Expected ErrUnknownFileName, got: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Failed to close editor: %v", err)
+	}
+
+	// Space of deleted files is reused, their free block table entries must not cover the new content:
+	if e, err = OpenEditor(name); err != nil {
+		t.Fatalf("Failed to open editor: %v", err)
+	}
+	if err := e.Delete("fixkey.bin"); err != nil {
+		t.Errorf("Failed to delete file: %v", err)
+	}
+	if err := e.AddFile("small.txt", testContent(100)); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Failed to close editor: %v", err)
+	}
+	if m, err = NewFromFile(name); err != nil {
+		t.Fatalf("Failed to open edited archive: %v", err)
+	}
+	defer m.Close()
+	if got, err := m.FileByName("small.txt"); err != nil || !bytes.Equal(got, testContent(100)) {
+		t.Errorf("File small.txt mismatch (err: %v)", err)
+	}
+	for i, free := range m.blockTable {
+		if free.flags != 0 || free.blockSize == 0 {
+			continue
+		}
+		for j, be := range m.blockTable {
+			if be.flags&beFlagFile != 0 && be.blockOffset < free.blockOffset+free.blockSize &&
+				free.blockOffset < be.blockOffset+be.blockSize {
+				t.Errorf("Free block %d overlaps block %d", i, j)
+			}
+		}
+	}
+}
+
+func TestEditorRename(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	w, err := NewFileWriter(name)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	encData := bytes.Repeat([]byte("Lorem ipsum. "), 1000)
+	for _, fname := range []string{"a", "b", "c"} { // Some more files for a larger hash table
+		if err := w.AddFile(fname, nil); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+	}
+	if err := w.AddFile("old.txt", []byte("plain")); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.AddFile("dir\\enc.txt", encData, FileEncrypted(true), FileCompression(CompressionZlib)); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	e, err := OpenEditor(name)
+	if err != nil {
+		t.Fatalf("Failed to open editor: %v", err)
+	}
+	if err := e.Rename("old.txt", "new\\name.txt"); err != nil {
+		t.Errorf("Failed to rename: %v", err)
+	}
+	if err := e.Rename("dir\\enc.txt", "other\\renamed.txt"); err != nil {
+		t.Errorf("Failed to rename: %v", err)
+	}
+	if err := e.Rename("old.txt", "x.txt"); err != ErrFileNotFound {
+		t.Errorf("Expected ErrFileNotFound, got: %v", err)
+	}
+	if err := e.Rename("new\\name.txt", "other\\renamed.txt"); err != ErrDuplicateFile {
+		t.Errorf("Expected ErrDuplicateFile, got: %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Failed to close editor: %v", err)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open edited archive: %v", err)
+	}
+	if got, err := m.FileByName("old.txt"); got != nil || err != ErrFileNotFound {
+		t.Errorf("Old name is still present: %v", err)
+	}
+	if got, err := m.FileByName("new\\name.txt"); err != nil || string(got) != "plain" {
+		t.Errorf("Renamed file mismatch: %q (err: %v)", got, err)
+	}
+	found := false
+	_, h2, h3 := FileNameHash("other\\renamed.txt")
+	for _, he := range m.hashTable {
+		if he.filePathHashA == h2 && he.filePathHashB == h3 {
+			found = true
+			be := m.blockTable[he.fileBlockIndex]
+			if got, err := decodeEncrypted(content, "renamed.txt", be, m.blockSize); err != nil || !bytes.Equal(got, encData) {
+				t.Errorf("Renamed encrypted file mismatch (err: %v)", err)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Renamed encrypted file not found")
+	}
+	listfile, err := m.FileByName(listfileName)
+	if err != nil || !strings.Contains(string(listfile), "new\\name.txt\r\n") || strings.Contains(string(listfile), "old.txt") {
+		t.Errorf("(listfile) is not updated: %q (err: %v)", listfile, err)
+	}
+}
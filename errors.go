@@ -0,0 +1,48 @@
+// Detailed errors of parsing archives.
+
+package mpq
+
+import (
+	"context"
+	"strconv"
+)
+
+// ParseError describes a failure of parsing a structure of an archive.
+// It wraps the underlying error (e.g. an I/O error or ErrCorruptSectorTable),
+// and it also matches ErrInvalidArchive (with errors.Is()).
+type ParseError struct {
+	// Structure being parsed, e.g. "header", "hash table" or "sector offset table".
+	Struct string
+
+	// Offset of the structure in the input.
+	Offset int64
+
+	// Underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *ParseError) Error() string {
+	return "Failed to parse MPQ " + e.Struct + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Is tells if the error matches target: a ParseError matches ErrInvalidArchive.
+func (e *ParseError) Is(target error) bool {
+	return target == ErrInvalidArchive
+}
+
+// parseErr returns the error to report for a failure of parsing a structure at the given offset.
+// ErrDataMissing (data not available in a partial archive), ErrMemoryLimit and the errors of a context
+// (reading was canceled, see FileByNameContext()) are returned as-is, others are wrapped in a *ParseError.
+func parseErr(structure string, offset int64, err error) error {
+	switch err {
+	case ErrDataMissing, ErrMemoryLimit, context.Canceled, context.DeadlineExceeded:
+		return err
+	}
+	return &ParseError{Struct: structure, Offset: offset, Err: err}
+}
@@ -0,0 +1,120 @@
+// Conversion of archives to other archive formats.
+
+package mpq
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportedFile is a file to be exported to another archive format.
+type exportedFile struct {
+	name       string    // Name of the file in the archive
+	blockIndex int       // Block table index of the file
+	size       uint32    // Uncompressed size of the file
+	modTime    time.Time // Modification time of the file, zero if unknown
+}
+
+// exportedFiles returns the files to be exported: the existing files whose names are known (see Files()),
+// in the order of their data in the archive, so they are read in a single sequential sweep.
+func (m *MPQ) exportedFiles() ([]exportedFile, error) {
+	files, err := m.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	var efs []exportedFile
+	for _, lf := range files {
+		if !lf.Found {
+			continue
+		}
+		blockIndex := m.blockIndexByName(lf.Name)
+		if !m.exists(blockIndex) {
+			continue
+		}
+		modTime, _ := m.FileTime(lf.Name)
+		efs = append(efs, exportedFile{
+			name:       lf.Name,
+			blockIndex: blockIndex,
+			size:       m.blockTable[blockIndex].fileSize,
+			modTime:    modTime,
+		})
+	}
+	sort.SliceStable(efs, func(i, j int) bool { return m.blockOffset(efs[i].blockIndex) < m.blockOffset(efs[j].blockIndex) })
+	return efs, nil
+}
+
+// WriteZip writes the files of the archive to w in ZIP format, streaming their content sector by sector.
+// Files whose names are known are exported (see Files()), with backslashes in their names replaced
+// by slashes. File modification times are taken from the "(attributes)" if present (see FileTime()).
+//
+// ErrFileNotFound is returned if no file names are known.
+// Errors of reading the files are returned as-is, just like the errors of w.
+func (m *MPQ) WriteZip(w io.Writer) error {
+	efs, err := m.exportedFiles()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, ef := range efs {
+		fh := &zip.FileHeader{
+			Name:     strings.ReplaceAll(ef.name, `\`, "/"),
+			Method:   zip.Deflate,
+			Modified: ef.modTime,
+		}
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if err := m.exportFile(ef, fw); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// WriteTar writes the files of the archive to w as a tar stream, streaming their content sector by sector.
+// The exported files, their names and modification times are the same as with WriteZip().
+//
+// ErrFileNotFound is returned if no file names are known.
+// Errors of reading the files are returned as-is, just like the errors of w.
+func (m *MPQ) WriteTar(w io.Writer) error {
+	efs, err := m.exportedFiles()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, ef := range efs {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.ReplaceAll(ef.name, `\`, "/"),
+			Size:     int64(ef.size),
+			Mode:     0644,
+			ModTime:  ef.modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := m.exportFile(ef, tw); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// exportFile writes the content of an exported file to w.
+func (m *MPQ) exportFile(ef exportedFile, w io.Writer) error {
+	sr, err := m.newSectorReader(m.fileInput(), ef.blockIndex, ef.name)
+	if err != nil {
+		return err
+	}
+	defer sr.release()
+	_, err = sr.writeTo(w, 0)
+	return err
+}
@@ -0,0 +1,79 @@
+package mpq
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriteZip(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "bb", "empty.txt", "")
+	// File times are taken from the "(attributes)":
+	modTime := time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	m.attributes = &Attributes{FileTime: make([]uint64, len(m.blockTable))}
+	m.attributes.FileTime[m.FileInfo("a.txt").BlockIndex] = uint64(modTime.Unix()+filetimeUnixDelta) * 1e7
+
+	var buf bytes.Buffer
+	if err := m.WriteZip(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("[%s] Failed to open: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("[%s] Failed to read: %v", f.Name, err)
+		}
+		got[f.Name] = string(data)
+		if f.Name == "a.txt" && !f.Modified.Equal(modTime) {
+			t.Errorf("Got: %v, want: %v", f.Modified, modTime)
+		}
+	}
+	for name, exp := range map[string]string{"a.txt": "a", "dir/b.txt": "bb", "empty.txt": ""} {
+		if data, ok := got[name]; !ok || data != exp {
+			t.Errorf("[%s] Got: %q, %v, want: %q", name, data, ok, exp)
+		}
+	}
+}
+
+func TestWriteTar(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "bb", "empty.txt", "")
+
+	var buf bytes.Buffer
+	if err := m.WriteTar(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid tar: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("[%s] Failed to read: %v", hdr.Name, err)
+		}
+		got[hdr.Name] = string(data)
+	}
+	for name, exp := range map[string]string{"a.txt": "a", "dir/b.txt": "bb", "empty.txt": ""} {
+		if data, ok := got[name]; !ok || data != exp {
+			t.Errorf("[%s] Got: %q, %v, want: %q", name, data, ok, exp)
+		}
+	}
+}
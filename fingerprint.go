@@ -0,0 +1,38 @@
+// Fingerprints of the archives.
+
+package mpq
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Fingerprint returns a SHA-256 hash of the header, the tables and the user data of the archive,
+// which identifies identical archives regardless of their file names, e.g. to deduplicate
+// replay collections without hashing the whole archives. The content of the files is not hashed:
+// the tables (which record the offsets and the sizes of the files) practically identify it.
+//
+// The fingerprint does not depend on where the archive is in the input (e.g. a Warcraft III map header
+// or an executable preceding it). Errors of reading the tables and the user data are returned as-is.
+func (m *MPQ) Fingerprint() (fp [sha256.Size]byte, err error) {
+	if err = m.loadTables(); err != nil {
+		return
+	}
+
+	h := sha256.New()
+	// Writing to a hash never fails, omitting error checks
+	binary.Write(h, binary.LittleEndian, m.header)
+	binary.Write(h, binary.LittleEndian, m.hashTable)
+	binary.Write(h, binary.LittleEndian, m.blockTable)
+	binary.Write(h, binary.LittleEndian, m.extBlockEntryHighOffsets)
+
+	if r := m.UserDataReader(); r != nil {
+		if _, err = io.Copy(h, r); err != nil {
+			return
+		}
+	}
+
+	h.Sum(fp[:0])
+	return
+}
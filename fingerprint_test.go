@@ -0,0 +1,48 @@
+package mpq
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	data, err := ioutil.ReadFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to read replay: %v", err)
+	}
+	fingerprint := func(data []byte, opts ...Option) [32]byte {
+		m, err := New(bytes.NewReader(data), opts...)
+		if err != nil {
+			t.Fatalf("Failed to open replay: %v", err)
+		}
+		fp, err := m.Fingerprint()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return fp
+	}
+
+	fp := fingerprint(data)
+	if fp2 := fingerprint(data, WithLazyTables(), WithoutUserDataCopy()); fp2 != fp {
+		t.Errorf("Fingerprints of the same archive differ")
+	}
+
+	// Embedded in an "executable":
+	embedded := append(make([]byte, 1024), data...)
+	m, err := NewEmbedded(bytes.NewReader(embedded))
+	if err != nil {
+		t.Fatalf("Failed to open embedded replay: %v", err)
+	}
+	if fp2, err := m.Fingerprint(); err != nil || fp2 != fp {
+		t.Errorf("Fingerprints of the same archive differ (err: %v)", err)
+	}
+
+	other, err := ioutil.ReadFile("reps/wol.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to read replay: %v", err)
+	}
+	if fingerprint(other) == fp {
+		t.Errorf("Fingerprints of different archives are equal")
+	}
+}
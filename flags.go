@@ -0,0 +1,112 @@
+// Flags of the blocks (files) of the archives.
+
+package mpq
+
+import (
+	"strconv"
+	"strings"
+)
+
+// BlockFlags is the bitmask of the flags of a block (file) in the block table.
+type BlockFlags uint32
+
+// Block flags.
+const (
+	// FlagImplode indicates that the file is imploded (PKWARE DCL compressed).
+	FlagImplode BlockFlags = 0x00000100
+
+	// FlagCompress indicates that the file is compressed (with one or more compression methods).
+	FlagCompress BlockFlags = 0x00000200
+
+	// FlagEncrypted indicates that the file is encrypted.
+	FlagEncrypted BlockFlags = 0x00010000
+
+	// FlagFixKey indicates that the decryption key of the file is adjusted by the block offset and the file size.
+	FlagFixKey BlockFlags = 0x00020000
+
+	// FlagPatchFile indicates that the file is an incremental patch file.
+	FlagPatchFile BlockFlags = 0x00100000
+
+	// FlagSingleUnit indicates that the file is stored as a single unit, rather than split into sectors.
+	FlagSingleUnit BlockFlags = 0x01000000
+
+	// FlagDeleteMarker indicates that the file is a deletion marker: the file no longer exists.
+	FlagDeleteMarker BlockFlags = 0x02000000
+
+	// FlagSectorCRC indicates that the file has checksums for each sector.
+	FlagSectorCRC BlockFlags = 0x04000000
+
+	// FlagExists indicates that the block is a file; otherwise the block is free space or unused.
+	FlagExists BlockFlags = 0x80000000
+)
+
+// Names of the flags in the order of their values, used by BlockFlags.String().
+var blockFlagNames = []struct {
+	flag BlockFlags
+	name string
+}{
+	{FlagImplode, "Implode"},
+	{FlagCompress, "Compress"},
+	{FlagEncrypted, "Encrypted"},
+	{FlagFixKey, "FixKey"},
+	{FlagPatchFile, "PatchFile"},
+	{FlagSingleUnit, "SingleUnit"},
+	{FlagDeleteMarker, "DeleteMarker"},
+	{FlagSectorCRC, "SectorCRC"},
+	{FlagExists, "Exists"},
+}
+
+// IsFile tells if the block is a file (and not free space or an unused entry).
+func (f BlockFlags) IsFile() bool {
+	return f&FlagExists != 0
+}
+
+// IsCompressed tells if the file is compressed or imploded.
+func (f BlockFlags) IsCompressed() bool {
+	return f&(FlagCompress|FlagImplode) != 0
+}
+
+// IsEncrypted tells if the file is encrypted.
+func (f BlockFlags) IsEncrypted() bool {
+	return f&FlagEncrypted != 0
+}
+
+// IsSingleUnit tells if the file is stored as a single unit.
+func (f BlockFlags) IsSingleUnit() bool {
+	return f&FlagSingleUnit != 0
+}
+
+// HasSectorCRC tells if the file has checksums for each sector.
+func (f BlockFlags) HasSectorCRC() bool {
+	return f&FlagSectorCRC != 0
+}
+
+// IsDeleted tells if the file is a deletion marker.
+func (f BlockFlags) IsDeleted() bool {
+	return f&FlagDeleteMarker != 0
+}
+
+// IsPatchFile tells if the file is an incremental patch file.
+func (f BlockFlags) IsPatchFile() bool {
+	return f&FlagPatchFile != 0
+}
+
+// String returns the names of the set flags separated by '|', e.g. "Compress|Encrypted|Exists".
+// Unknown bits are appended as a hexadecimal number. "0" is returned if no flags are set.
+func (f BlockFlags) String() string {
+	if f == 0 {
+		return "0"
+	}
+
+	var names []string
+	for _, fn := range blockFlagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+			f &^= fn.flag
+		}
+	}
+	if f != 0 {
+		names = append(names, "0x"+strconv.FormatUint(uint64(f), 16))
+	}
+	return strings.Join(names, "|")
+}
@@ -0,0 +1,48 @@
+package mpq
+
+import (
+	"testing"
+)
+
+func TestBlockFlags(t *testing.T) {
+	cases := []struct {
+		f   BlockFlags
+		exp string
+	}{
+		{0, "0"},
+		{FlagExists, "Exists"},
+		{FlagExists | FlagCompress | FlagEncrypted | FlagFixKey, "Compress|Encrypted|FixKey|Exists"},
+		{FlagExists | FlagSectorCRC | 0x08, "SectorCRC|Exists|0x8"},
+	}
+	for _, c := range cases {
+		if s := c.f.String(); s != c.exp {
+			t.Errorf("[%#x] Got: %q, want: %q", uint32(c.f), s, c.exp)
+		}
+	}
+
+	// Exported flags must match the internal ones:
+	pairs := []struct {
+		f        BlockFlags
+		internal uint32
+	}{
+		{FlagImplode, beFlagPKWare},
+		{FlagCompress, beFlagCompressedMulti},
+		{FlagEncrypted, beFlagEncrypted},
+		{FlagFixKey, beFlagFixKey},
+		{FlagSingleUnit, beFlagSingle},
+		{FlagDeleteMarker, beFlagDeleteMarker},
+		{FlagSectorCRC, beFlagExtra},
+		{FlagExists, beFlagFile},
+	}
+	for _, p := range pairs {
+		if uint32(p.f) != p.internal {
+			t.Errorf("Flag %v mismatch: %#x", p.f, p.internal)
+		}
+	}
+
+	f := FlagExists | FlagImplode | FlagSingleUnit | FlagDeleteMarker | FlagSectorCRC | FlagPatchFile
+	if !f.IsFile() || !f.IsCompressed() || f.IsEncrypted() || !f.IsSingleUnit() ||
+		!f.HasSectorCRC() || !f.IsDeleted() || !f.IsPatchFile() {
+		t.Errorf("Unexpected flag queries for %v", f)
+	}
+}
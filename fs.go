@@ -0,0 +1,329 @@
+// File system (io/fs) view of the archives.
+
+package mpq
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errIsDir is the error of reading the content of a directory.
+var errIsDir = errors.New("Is a directory")
+
+// FS is a read-only file system view of an archive. It implements io/fs.FS, fs.ReadFileFS and fs.StatFS,
+// so archives can be used with anything that accepts an fs.FS.
+//
+// Paths are slash-separated (as required by io/fs): the path "dir/file.txt" denotes the file
+// "dir\file.txt" of the archive. The directory structure is built from the known file names
+// (see Files()) on first use; names registered later are not reflected in directory listings.
+// Files whose names are not known can still be opened by their name.
+//
+// Files are read into memory when opened. The modification times of files
+// are taken from the "(attributes)", if present (see FileTime()).
+type FS struct {
+	m *MPQ
+
+	// Entries of the directories by path ("." is the root), built lazily
+	dirs map[string][]fs.DirEntry
+}
+
+// NewFromFS returns a new MPQ using a file specified by its name in a file system as the input,
+// e.g. an embedded file system (embed.FS) or test fixtures (fstest.MapFS).
+// If the opened file does not implement io.Seeker, its content is read into memory.
+// The returned MPQ must be closed with the Close method!
+// ErrInvalidArchive is returned if the file is not a valid MPQ archive, see ParseError for details.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewFromFS(fsys fs.FS, name string, opts ...Option) (*MPQ, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MPQ{}
+	switch ff := f.(type) {
+	case *os.File:
+		m.file, m.input = ff, ff
+	case io.ReadSeeker:
+		m.closer, m.input = f, ff
+	default:
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		m.input = bytes.NewReader(data)
+	}
+	m.applyOptions(opts)
+
+	if _, err := m.diveIn(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// FS returns a file system view of the archive.
+func (m *MPQ) FS() *FS {
+	return &FS{m: m}
+}
+
+// Open opens the named file or directory, implementing fs.FS.
+// The returned file implements io.ReaderAt and io.Seeker, directories implement fs.ReadDirFile.
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.dir {
+		return &fsDir{info: info, path: name, entries: f.dirs[name]}, nil
+	}
+
+	data, err := f.m.readFile(info.fi.BlockIndex, info.fi.Name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{info: info, Reader: bytes.NewReader(data)}, nil
+}
+
+// ReadFile reads and returns the content of the named file, implementing fs.ReadFileFS.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	info, err := f.stat("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.dir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+
+	data, err := f.m.readFile(info.fi.BlockIndex, info.fi.Name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// Stat returns the fs.FileInfo describing the named file or directory, implementing fs.StatFS.
+// The Sys() method of the info of files returns the *FileInfo of the file.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// stat returns the info of the named file or directory.
+// op is the operation reported in the returned *fs.PathError.
+func (f *FS) stat(op, name string) (*fsFileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	f.buildDirs()
+
+	if _, ok := f.dirs[name]; ok {
+		return &fsFileInfo{name: path.Base(name), dir: true}, nil
+	}
+	// Backslashes must not be interpreted as separators, so they are not allowed in file names.
+	if !strings.Contains(name, `\`) {
+		if info := f.fileInfo(name); info != nil {
+			return info, nil
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// fileInfo returns the info of the file specified by its (slash-separated) path, nil if it does not exist.
+func (f *FS) fileInfo(name string) *fsFileInfo {
+	mpqName := strings.ReplaceAll(name, "/", `\`)
+	fi := f.m.FileInfo(mpqName)
+	if fi == nil || fi.Flags.IsDeleted() {
+		return nil
+	}
+	modTime, _ := f.m.FileTime(mpqName)
+	return &fsFileInfo{name: path.Base(name), fi: fi, modTime: modTime}
+}
+
+// buildDirs builds the directory structure from the known file names, if not yet built.
+func (f *FS) buildDirs() {
+	if f.dirs != nil {
+		return
+	}
+	f.dirs = map[string][]fs.DirEntry{".": nil}
+
+	files, _ := f.m.Files() // Error means there are no known names
+	var infos []*fsFileInfo
+	var names []string
+	for _, lf := range files {
+		name := strings.ReplaceAll(lf.Name, `\`, "/")
+		if !lf.Found || name == "." || !fs.ValidPath(name) {
+			continue
+		}
+		if info := f.fileInfo(name); info != nil {
+			infos, names = append(infos, info), append(names, name)
+			f.addDir(path.Dir(name))
+		}
+	}
+
+	for i, info := range infos {
+		if _, ok := f.dirs[names[i]]; ok {
+			continue // Name of a file is also a directory: directory wins
+		}
+		dir := path.Dir(names[i])
+		f.dirs[dir] = append(f.dirs[dir], fs.FileInfoToDirEntry(info))
+	}
+
+	for _, entries := range f.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+}
+
+// addDir adds a directory and its parent directories to the directory structure.
+func (f *FS) addDir(dir string) {
+	if _, ok := f.dirs[dir]; ok {
+		return
+	}
+	f.dirs[dir] = nil
+
+	parent := path.Dir(dir)
+	f.addDir(parent)
+	f.dirs[parent] = append(f.dirs[parent], fs.FileInfoToDirEntry(&fsFileInfo{name: path.Base(dir), dir: true}))
+}
+
+// fsFileInfo implements fs.FileInfo.
+type fsFileInfo struct {
+	name    string    // Base name
+	dir     bool      // Tells if this is a directory
+	fi      *FileInfo // Info of the file, nil for directories
+	modTime time.Time // Modification time of the file
+}
+
+func (i *fsFileInfo) Name() string { return i.name }
+
+func (i *fsFileInfo) Size() int64 {
+	if i.dir {
+		return 0
+	}
+	return int64(i.fi.Size)
+}
+
+func (i *fsFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *fsFileInfo) ModTime() time.Time { return i.modTime }
+
+func (i *fsFileInfo) IsDir() bool { return i.dir }
+
+func (i *fsFileInfo) Sys() interface{} {
+	if i.dir {
+		return nil
+	}
+	return i.fi
+}
+
+// fsFile is an opened file of FS.
+type fsFile struct {
+	info *fsFileInfo
+	*bytes.Reader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *fsFile) Close() error { return nil }
+
+// fsDir is an opened directory of FS.
+type fsDir struct {
+	info    *fsFileInfo
+	path    string
+	entries []fs.DirEntry
+	offset  int // Number of entries already returned by ReadDir
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errIsDir}
+}
+
+func (d *fsDir) Close() error { return nil }
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// ReadDir returns the entries of a directory of the archive specified by its backslash-separated path
+// ("" denotes the root), sorted by name. The directory structure is built from the known file names,
+// see Files() and FS. The Info() method of the entries of files returns an fs.FileInfo whose Sys()
+// method returns the *FileInfo of the file.
+//
+// An *fs.PathError wrapping fs.ErrNotExist is returned if the directory does not exist.
+func (m *MPQ) ReadDir(dir string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(m.fsDirs(), toFSPath(dir))
+	if err != nil {
+		if pe, ok := err.(*fs.PathError); ok {
+			err = &fs.PathError{Op: pe.Op, Path: dir, Err: pe.Err}
+		}
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Glob returns the backslash-separated names of the known files and directories matching a pattern,
+// e.g. "*.dds" or `units\*\*.mdx`. The pattern syntax is that of path.Match(), with backslash
+// as the separator (so escaping is not supported). Matching is case-sensitive (using the names
+// as listed), and * does not match across directories: "*.dds" only matches files of the root.
+//
+// The only possible returned error is path.ErrBadPattern, reporting that the pattern is malformed.
+func (m *MPQ) Glob(pattern string) ([]string, error) {
+	matches, err := fs.Glob(m.fsDirs(), strings.ReplaceAll(pattern, `\`, "/"))
+	if err != nil {
+		return nil, err
+	}
+	for i, match := range matches {
+		matches[i] = strings.ReplaceAll(match, "/", `\`)
+	}
+	return matches, nil
+}
+
+// fsDirs returns the cached file system view used for directory listings.
+func (m *MPQ) fsDirs() *FS {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if m.dirFS == nil {
+		m.dirFS = m.FS()
+	}
+	return m.dirFS
+}
+
+// toFSPath converts a backslash-separated directory path of the archive to an fs path.
+func toFSPath(dir string) string {
+	dir = strings.Trim(dir, `\`)
+	if dir == "" {
+		return "."
+	}
+	return strings.ReplaceAll(dir, `\`, "/")
+}
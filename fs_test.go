@@ -0,0 +1,152 @@
+package mpq
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "b", `dir\sub\c.txt`, "c")
+	fsys := m.FS()
+
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt"); err != nil {
+		t.Error(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "dir/sub/c.txt")
+	if err != nil || string(data) != "c" {
+		t.Errorf("Got: %q, %v, want: %q, nil", data, err, "c")
+	}
+
+	entries, err := fs.ReadDir(fsys, "dir")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "b.txt" || !entries[1].IsDir() || entries[1].Name() != "sub" {
+		t.Errorf("Unexpected entries: %v", entries)
+	}
+
+	info, err := fs.Stat(fsys, "dir/b.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fi, ok := info.Sys().(*FileInfo); !ok || fi.Name != `dir\b.txt` || info.Size() != 1 {
+		t.Errorf("Unexpected info: %v", info.Sys())
+	}
+
+	for _, name := range []string{"missing.txt", `dir\b.txt`, "dir/missing"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("[%s] Got: %v, want: %v", name, err, fs.ErrNotExist)
+		}
+	}
+	if _, err := fsys.Open("/a.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Got: %v, want: %v", err, fs.ErrInvalid)
+	}
+}
+
+func TestReadDirGlob(t *testing.T) {
+	m := buildArchive(t, "a.dds", "a", `dir\b.dds`, "b", `dir\sub\c.dds`, "c", `dir\d.txt`, "d")
+
+	for _, dir := range []string{"dir", `dir\`} {
+		entries, err := m.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %v", dir, err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if exp := []string{"b.dds", "d.txt", "sub"}; !reflect.DeepEqual(names, exp) {
+			t.Errorf("[%s] Got: %v, want: %v", dir, names, exp)
+		}
+	}
+	if entries, err := m.ReadDir(""); err != nil || len(entries) != 2 {
+		t.Errorf("Got: %v, %v, want: 2 entries", entries, err)
+	}
+	if _, err := m.ReadDir("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Got: %v, want: %v", err, fs.ErrNotExist)
+	}
+
+	cases := []struct {
+		pattern string
+		exp     []string
+	}{
+		{"*.dds", []string{"a.dds"}},
+		{`dir\*.dds`, []string{`dir\b.dds`}},
+		{`*\*\*.dds`, []string{`dir\sub\c.dds`}},
+		{`dir\*`, []string{`dir\b.dds`, `dir\d.txt`, `dir\sub`}},
+		{"*.mdx", nil},
+	}
+	for _, c := range cases {
+		matches, err := m.Glob(c.pattern)
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", c.pattern, err)
+		}
+		if !reflect.DeepEqual(matches, c.exp) {
+			t.Errorf("[%s] Got: %v, want: %v", c.pattern, matches, c.exp)
+		}
+	}
+	if _, err := m.Glob("[a"); err != path.ErrBadPattern {
+		t.Errorf("Got: %v, want: %v", err, path.ErrBadPattern)
+	}
+}
+
+func TestNewFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.mpq":    &fstest.MapFile{Data: buildArchiveBytes(t, "a.txt", "a")},
+		"invalid.mpq": &fstest.MapFile{Data: []byte("invalid")},
+	}
+
+	check := func(fsys fs.FS) {
+		m, err := NewFromFS(fsys, "test.mpq")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer m.Close()
+		if data, err := m.FileByName("a.txt"); err != nil || string(data) != "a" {
+			t.Errorf("Got: %q, %v, want: %q, nil", data, err, "a")
+		}
+	}
+	check(fsys)
+	check(readerFS{fsys}) // Non-seekable files
+
+	if _, err := NewFromFS(fsys, "missing.mpq"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Got: %v, want: %v", err, fs.ErrNotExist)
+	}
+	if _, err := NewFromFS(fsys, "invalid.mpq"); err == nil {
+		t.Errorf("Expected error for invalid archive")
+	}
+}
+
+// readerFS is an fs.FS whose files only implement fs.File (they are not seekable).
+type readerFS struct {
+	fsys fs.FS
+}
+
+func (r readerFS) Open(name string) (fs.File, error) {
+	data, err := fs.ReadFile(r.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(r.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return &readerFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// readerFile is an fs.File which is not seekable.
+type readerFile struct {
+	io.Reader
+	info fs.FileInfo
+}
+
+func (f *readerFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *readerFile) Close() error { return nil }
@@ -0,0 +1,47 @@
+// Precomputed hashes of file names.
+
+package mpq
+
+// Hash holds the hashes of a file name which are needed to look up the file, see FileNameHash().
+type Hash struct {
+	// TableOffset is the hash used to locate the entry of the file in the hash table (h1).
+	TableOffset uint32
+
+	// NameA and NameB are the hashes identifying the name (h2 and h3).
+	NameA, NameB uint32
+}
+
+// NameHash returns the Hash of a file name.
+func NameHash(name string) Hash {
+	h1, h2, h3 := FileNameHash(name)
+	return Hash{TableOffset: h1, NameA: h2, NameB: h3}
+}
+
+// HashIndex holds precomputed hashes of file names, mapped by the names.
+// It is profitable to use a HashIndex if the same files are looked up frequently
+// (e.g. the same files of many replays). A HashIndex may be used from multiple goroutines
+// concurrently as long as it is not modified.
+type HashIndex map[string]Hash
+
+// NewHashIndex returns a new HashIndex holding the hashes of the given names.
+func NewHashIndex(names ...string) HashIndex {
+	x := make(HashIndex, len(names))
+	for _, name := range names {
+		x[name] = NameHash(name)
+	}
+	return x
+}
+
+// Hash returns the Hash of a file name, the precomputed one if present, else it is computed.
+func (x HashIndex) Hash(name string) Hash {
+	if h, ok := x[name]; ok {
+		return h
+	}
+	return NameHash(name)
+}
+
+// FileByHashStruct returns the content of a file specified by the Hash of its name from the archive.
+// It is the equivalent of FileByHash(h.TableOffset, h.NameA, h.NameB), see FileByHash() for details.
+func (m *MPQ) FileByHashStruct(h Hash) ([]byte, error) {
+	return m.FileByHash(h.TableOffset, h.NameA, h.NameB)
+}
@@ -0,0 +1,48 @@
+package mpq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHashIndex(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "bb")
+
+	x := NewHashIndex("a.txt", `dir\b.txt`)
+	if h1, h2, h3 := FileNameHash("a.txt"); x["a.txt"] != (Hash{h1, h2, h3}) {
+		t.Errorf("Got: %+v, want: %+v", x["a.txt"], Hash{h1, h2, h3})
+	}
+	if x.Hash("c.txt") != NameHash("c.txt") {
+		t.Errorf("Got: %+v, want: %+v", x.Hash("c.txt"), NameHash("c.txt"))
+	}
+
+	for name, exp := range map[string]string{"a.txt": "a", `dir\b.txt`: "bb"} {
+		if data, err := m.FileByHashStruct(x.Hash(name)); err != nil || !bytes.Equal(data, []byte(exp)) {
+			t.Errorf("[%s] Got: %q, %v, want: %q, nil", name, data, err, exp)
+		}
+	}
+	if _, err := m.FileByHashStruct(x.Hash("c.txt")); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+}
+
+func TestProbeFullHashTable(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a")
+	idx := m.blockIndexByName("a.txt")
+
+	// No empty entries: every entry is deleted, except the entry of a.txt which is the last one probed
+	h1, h2, h3 := FileNameHash("a.txt")
+	n := uint32(len(m.hashTable))
+	for i := range m.hashTable {
+		m.hashTable[i] = hashEntry{filePathHashA: 1, filePathHashB: 2, fileBlockIndex: 0xfffffffe}
+	}
+	last := (h1&(n-1) + n - 1) % n
+	m.hashTable[last] = hashEntry{filePathHashA: h2, filePathHashB: h3, fileBlockIndex: uint32(idx)}
+
+	if got := m.blockIndexByName("a.txt"); got != idx {
+		t.Errorf("Got: %d, want: %d", got, idx)
+	}
+	if _, err := m.FileByName("missing.txt"); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+}
@@ -0,0 +1,469 @@
+// HET and BET tables of format version 3 and later.
+//
+// The HET table (hash extended table) is an open addressing hash table of 8-bit name hashes,
+// mapping file names to BET table indices. The BET table (block extended table) is a bit packed
+// version of the block table, also holding the rest of the name hashes to verify the lookups.
+//
+// Both tables start with a 12-byte extended table header (signature, version, data size),
+// and the data following it is encrypted (with the key of the hash table and block table respectively).
+// Name hashes are computed with Bob Jenkins' lookup3 hash of the lower-cased file name.
+// Bit arrays store values with their least significant bit first.
+
+package mpq
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Signatures of the HET and BET tables.
+const (
+	hetTableSignature = 0x1a544548 // "HET\x1a"
+	betTableSignature = 0x1a544542 // "BET\x1a"
+)
+
+// Sizes of the table headers (including the extended table header).
+const (
+	extTableHeaderSize = 12
+	hetHeaderSize      = extTableHeaderSize + 8*4
+	betHeaderSize      = extTableHeaderSize + 19*4
+)
+
+// Size of the name hashes in bits (the HET table holds the highest 8 bits, the BET table holds the rest).
+const hetNameHashBitSize = 64
+
+// hetHash returns the name hash of a file used in the HET and BET tables.
+// The highest bit is always set, so the 8-bit name hashes in the HET table are never 0 (which marks empty slots).
+func hetHash(name string) uint64 {
+	// Normalize: convert to lower case and slash to backslash
+	key := []byte(name)
+	for i, c := range key {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			key[i] = c + 'a' - 'A'
+		case c == '/':
+			key[i] = '\\'
+		}
+	}
+
+	c, b := hashLittle2(key, 2, 1)
+	return (uint64(b)<<32 | uint64(c)) | 1<<(hetNameHashBitSize-1)
+}
+
+// hashLittle2 is Bob Jenkins' lookup3 hashlittle2() function, returning 2 32-bit hashes of key.
+// pc and pb are the initial values (seeds), and the returned values are the resulting (c, b).
+func hashLittle2(key []byte, pc, pb uint32) (uint32, uint32) {
+	rot := func(x uint32, k uint) uint32 { return x<<k | x>>(32-k) }
+
+	a := 0xdeadbeef + uint32(len(key)) + pc
+	b, c := a, a+pb
+
+	for len(key) > 12 {
+		a += binary.LittleEndian.Uint32(key)
+		b += binary.LittleEndian.Uint32(key[4:])
+		c += binary.LittleEndian.Uint32(key[8:])
+
+		// mix
+		a -= c
+		a ^= rot(c, 4)
+		c += b
+		b -= a
+		b ^= rot(a, 6)
+		a += c
+		c -= b
+		c ^= rot(b, 8)
+		b += a
+		a -= c
+		a ^= rot(c, 16)
+		c += b
+		b -= a
+		b ^= rot(a, 19)
+		a += c
+		c -= b
+		c ^= rot(b, 4)
+		b += a
+
+		key = key[12:]
+	}
+
+	if len(key) == 0 {
+		return c, b
+	}
+
+	// Last (possibly partial) block, zero padded
+	var last [12]byte
+	copy(last[:], key)
+	a += binary.LittleEndian.Uint32(last[:])
+	b += binary.LittleEndian.Uint32(last[4:])
+	c += binary.LittleEndian.Uint32(last[8:])
+
+	// final
+	c ^= b
+	c -= rot(b, 14)
+	a ^= c
+	a -= rot(c, 11)
+	b ^= a
+	b -= rot(a, 25)
+	c ^= b
+	c -= rot(b, 16)
+	a ^= c
+	a -= rot(c, 4)
+	b ^= a
+	b -= rot(a, 14)
+	c ^= b
+	c -= rot(b, 24)
+
+	return c, b
+}
+
+// bitCount returns the number of bits needed to represent v.
+func bitCount(v uint64) uint32 {
+	var n uint32
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// setBits stores the lowest count bits of v in the bit array at bit position pos.
+func setBits(bits []byte, pos uint64, count uint32, v uint64) {
+	for i := uint64(0); i < uint64(count); i, pos = i+1, pos+1 {
+		if v&(1<<i) != 0 {
+			bits[pos/8] |= 1 << (pos % 8)
+		} else {
+			bits[pos/8] &^= 1 << (pos % 8)
+		}
+	}
+}
+
+// newExtTable allocates an extended table (HET or BET) with the given data size,
+// and fills its extended table header.
+func newExtTable(signature uint32, dataSize int) []byte {
+	buf := make([]byte, extTableHeaderSize+dataSize)
+	binary.LittleEndian.PutUint32(buf, signature)
+	binary.LittleEndian.PutUint32(buf[4:], 1) // version
+	binary.LittleEndian.PutUint32(buf[8:], uint32(dataSize))
+	return buf
+}
+
+// buildHetTable builds the encrypted HET table of files whose name hashes (see hetHash) are given
+// in the order of their BET table indices.
+func buildHetTable(hashes []uint64) []byte {
+	entryCount := uint32(len(hashes))
+	totalCount := entryCount * 4 / 3
+	if totalCount <= entryCount {
+		totalCount = entryCount + 1 // Keep at least 1 empty slot to terminate searches
+	}
+	indexSize := bitCount(uint64(entryCount))
+	indexTableSize := (uint64(indexSize)*uint64(totalCount) + 7) / 8
+
+	dataSize := hetHeaderSize - extTableHeaderSize + int(totalCount) + int(indexTableSize)
+	buf := newExtTable(hetTableSignature, dataSize)
+
+	h := buf[extTableHeaderSize:]
+	binary.LittleEndian.PutUint32(h, uint32(dataSize)) // table size
+	binary.LittleEndian.PutUint32(h[4:], entryCount)
+	binary.LittleEndian.PutUint32(h[8:], totalCount)
+	binary.LittleEndian.PutUint32(h[12:], hetNameHashBitSize)
+	binary.LittleEndian.PutUint32(h[16:], indexSize) // index size total
+	binary.LittleEndian.PutUint32(h[20:], 0)         // index size extra
+	binary.LittleEndian.PutUint32(h[24:], indexSize) // index size
+	binary.LittleEndian.PutUint32(h[28:], uint32(indexTableSize))
+
+	nameHashes := buf[hetHeaderSize : hetHeaderSize+int(totalCount)]
+	indices := buf[hetHeaderSize+int(totalCount):]
+	for i := range indices {
+		indices[i] = 0xff // Empty slots have an index of all 1 bits
+	}
+
+	for i, hash := range hashes {
+		j := uint32(hash % uint64(totalCount))
+		for nameHashes[j] != 0 {
+			j = (j + 1) % totalCount
+		}
+		nameHashes[j] = byte(hash >> (hetNameHashBitSize - 8))
+		setBits(indices, uint64(j)*uint64(indexSize), indexSize, uint64(i))
+	}
+
+	encrypt(buf[extTableHeaderSize:], hashTableKey)
+	return buf
+}
+
+// buildBetTable builds the encrypted BET table of the files.
+// hashes are the name hashes of the files (see hetHash).
+func buildBetTable(files []*writerFile, hashes []uint64) []byte {
+	var flags []uint32
+	flagIndices := make([]uint32, len(files))
+	var maxOffset, maxFileSize, maxBlockSize uint64
+	for i, f := range files {
+		if uint64(f.offset) > maxOffset {
+			maxOffset = uint64(f.offset)
+		}
+		if uint64(f.block.fileSize) > maxFileSize {
+			maxFileSize = uint64(f.block.fileSize)
+		}
+		if uint64(f.block.blockSize) > maxBlockSize {
+			maxBlockSize = uint64(f.block.blockSize)
+		}
+
+		idx := -1
+		for j, fl := range flags {
+			if fl == f.block.flags {
+				idx = j
+				break
+			}
+		}
+		if idx < 0 {
+			idx = len(flags)
+			flags = append(flags, f.block.flags)
+		}
+		flagIndices[i] = uint32(idx)
+	}
+
+	entryCount := uint64(len(files))
+	offsetBits, fileSizeBits, blockSizeBits := bitCount(maxOffset), bitCount(maxFileSize), bitCount(maxBlockSize)
+	flagIndexBits := bitCount(uint64(len(flags)))
+	entrySize := offsetBits + fileSizeBits + blockSizeBits + flagIndexBits
+	tableSize := (uint64(entrySize)*entryCount + 7) / 8
+	nameHashBits := uint32(hetNameHashBitSize - 8)
+	nameHashArraySize := (uint64(nameHashBits)*entryCount + 7) / 8
+
+	dataSize := betHeaderSize - extTableHeaderSize + len(flags)*4 + int(tableSize) + int(nameHashArraySize)
+	buf := newExtTable(betTableSignature, dataSize)
+
+	h := buf[extTableHeaderSize:]
+	for i, v := range []uint32{
+		uint32(dataSize), // table size
+		uint32(entryCount),
+		0x10, // unknown
+		entrySize,
+		0,                                         // bit index of file position
+		offsetBits,                                // bit index of file size
+		offsetBits + fileSizeBits,                 // bit index of compressed size
+		offsetBits + fileSizeBits + blockSizeBits, // bit index of flag index
+		entrySize,                                 // bit index of unknown
+		offsetBits, fileSizeBits, blockSizeBits, flagIndexBits,
+		0,            // bit count of unknown
+		nameHashBits, // total bits of name hash 2
+		0,            // extra bits of name hash 2
+		nameHashBits, // bit count of name hash 2
+		uint32(nameHashArraySize),
+		uint32(len(flags)),
+	} {
+		binary.LittleEndian.PutUint32(h[i*4:], v)
+	}
+
+	p := buf[betHeaderSize:]
+	for i, fl := range flags {
+		binary.LittleEndian.PutUint32(p[i*4:], fl)
+	}
+
+	table := p[len(flags)*4 : len(flags)*4+int(tableSize)]
+	nameHashes := p[len(flags)*4+int(tableSize):]
+	for i, f := range files {
+		pos := uint64(i) * uint64(entrySize)
+		setBits(table, pos, offsetBits, uint64(f.offset))
+		setBits(table, pos+uint64(offsetBits), fileSizeBits, uint64(f.block.fileSize))
+		setBits(table, pos+uint64(offsetBits+fileSizeBits), blockSizeBits, uint64(f.block.blockSize))
+		setBits(table, pos+uint64(offsetBits+fileSizeBits+blockSizeBits), flagIndexBits, uint64(flagIndices[i]))
+
+		setBits(nameHashes, uint64(i)*uint64(nameHashBits), nameHashBits, hashes[i])
+	}
+
+	encrypt(buf[extTableHeaderSize:], blockTableKey)
+	return buf
+}
+
+// getBits returns count bits from the bit array at bit position pos.
+func getBits(bits []byte, pos uint64, count uint32) (v uint64) {
+	for i := uint64(0); i < uint64(count); i, pos = i+1, pos+1 {
+		if bits[pos/8]&(1<<(pos%8)) != 0 {
+			v |= 1 << i
+		}
+	}
+	return
+}
+
+// hetTable is a HET table read from an archive.
+type hetTable struct {
+	nameHashBitSize uint32 // Size of the name hashes in bits
+	totalCount      uint32 // Number of slots in the table
+
+	indexSizeTotal uint32 // Size of the index entries in bits (including extra bits)
+	indexSize      uint32 // Effective size of the index entries in bits
+
+	nameHashes []byte // The 8-bit name hashes (0 marks empty slots)
+	indices    []byte // Bit array of the BET table indices
+}
+
+// betTable is a BET table read from an archive.
+// Block entries are converted to blockEntry; only data needed for lookups is retained here.
+type betTable struct {
+	entryCount uint32 // Number of entries in the table
+
+	nameHashSizeTotal uint32 // Size of the name hash entries in bits (including extra bits)
+	nameHashSize      uint32 // Effective size of the name hash entries in bits
+
+	nameHashes []byte // Bit array of the name hashes, without the bits stored in the HET table
+
+	blocks      []blockEntry // Block entries
+	highOffsets []uint16     // Upper bits of the block offsets, only present if there's an offset > 4 GB
+}
+
+// readExtTable reads an extended table (HET or BET) from the input at the given offset,
+// and returns its decrypted data following the extended table header.
+// maxSize is the maximum size of the table including its header.
+func (m *MPQ) readExtTable(in io.ReadSeeker, offset int64, signature, key uint32, maxSize uint64) ([]byte, error) {
+	if _, err := in.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+	var hdr [extTableHeaderSize]byte
+	if _, err := io.ReadFull(in, hdr[:]); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[:]) != signature || binary.LittleEndian.Uint32(hdr[4:]) != 1 {
+		return nil, ErrInvalidArchive
+	}
+	size := uint64(binary.LittleEndian.Uint32(hdr[8:]))
+	if extTableHeaderSize+size > maxSize {
+		return nil, ErrInvalidArchive
+	}
+	if err := m.checkAlloc(size); err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(in, data); err != nil {
+		return nil, err
+	}
+	decrypt(data, key)
+	return data, nil
+}
+
+// parseHetTable parses the (decrypted) data of a HET table.
+func parseHetTable(data []byte) (*hetTable, error) {
+	if len(data) < hetHeaderSize-extTableHeaderSize {
+		return nil, ErrInvalidArchive
+	}
+	u32 := func(i int) uint32 { return binary.LittleEndian.Uint32(data[i*4:]) }
+
+	t := &hetTable{
+		totalCount:      u32(2),
+		nameHashBitSize: u32(3),
+		indexSizeTotal:  u32(4),
+		indexSize:       u32(6),
+	}
+	indexTableSize := uint64(u32(7))
+	if t.totalCount == 0 || t.nameHashBitSize < 8 || t.nameHashBitSize > 64 || t.indexSize > 32 || t.indexSize > t.indexSizeTotal ||
+		indexTableSize < (uint64(t.indexSizeTotal)*uint64(t.totalCount)+7)/8 {
+		return nil, ErrInvalidArchive
+	}
+
+	data = data[hetHeaderSize-extTableHeaderSize:]
+	if uint64(len(data)) < uint64(t.totalCount)+indexTableSize {
+		return nil, ErrInvalidArchive
+	}
+	t.nameHashes = data[:t.totalCount]
+	t.indices = data[t.totalCount : uint64(t.totalCount)+indexTableSize]
+
+	return t, nil
+}
+
+// parseBetTable parses the (decrypted) data of a BET table.
+func (m *MPQ) parseBetTable(data []byte) (*betTable, error) {
+	if len(data) < betHeaderSize-extTableHeaderSize {
+		return nil, ErrInvalidArchive
+	}
+	var f [19]uint32
+	for i := range f {
+		f[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	entryCount, entrySize := f[1], f[3]
+	posIndex, fileSizeIndex, cmpSizeIndex, flagIndexIndex := f[4], f[5], f[6], f[7]
+	posBits, fileSizeBits, cmpSizeBits, flagIndexBits := f[9], f[10], f[11], f[12]
+	flagCount := f[18]
+
+	t := &betTable{
+		entryCount:        entryCount,
+		nameHashSizeTotal: f[14],
+		nameHashSize:      f[16],
+	}
+	if posBits > 64 || fileSizeBits > 32 || cmpSizeBits > 32 || flagIndexBits > 32 || t.nameHashSize > 64 ||
+		t.nameHashSize > t.nameHashSizeTotal || (entrySize == 0 && entryCount > 0) {
+		return nil, ErrInvalidArchive
+	}
+	for _, v := range []uint32{posIndex + posBits, fileSizeIndex + fileSizeBits, cmpSizeIndex + cmpSizeBits, flagIndexIndex + flagIndexBits} {
+		if v > entrySize {
+			return nil, ErrInvalidArchive
+		}
+	}
+
+	data = data[betHeaderSize-extTableHeaderSize:]
+	tableSize := (uint64(entrySize)*uint64(entryCount) + 7) / 8
+	nameHashArraySize := (uint64(t.nameHashSizeTotal)*uint64(entryCount) + 7) / 8
+	if uint64(len(data)) < uint64(flagCount)*4+tableSize+nameHashArraySize {
+		return nil, ErrInvalidArchive
+	}
+	flags := data[:flagCount*4]
+	table := data[flagCount*4 : uint64(flagCount)*4+tableSize]
+	t.nameHashes = data[uint64(flagCount)*4+tableSize : uint64(flagCount)*4+tableSize+nameHashArraySize]
+
+	// Block entries take 16 bytes, plus 2 bytes for the upper bits of their offsets
+	if err := m.checkAlloc(uint64(entryCount) * 18); err != nil {
+		return nil, err
+	}
+	t.blocks = make([]blockEntry, entryCount)
+	for i := range t.blocks {
+		pos := uint64(i) * uint64(entrySize)
+		offset := getBits(table, pos+uint64(posIndex), posBits)
+		flagIndex := getBits(table, pos+uint64(flagIndexIndex), flagIndexBits)
+		if flagCount > 0 && flagIndex >= uint64(flagCount) {
+			return nil, ErrInvalidArchive
+		}
+
+		be := &t.blocks[i]
+		be.blockOffset = uint32(offset)
+		be.fileSize = uint32(getBits(table, pos+uint64(fileSizeIndex), fileSizeBits))
+		be.blockSize = uint32(getBits(table, pos+uint64(cmpSizeIndex), cmpSizeBits))
+		if flagCount > 0 {
+			be.flags = binary.LittleEndian.Uint32(flags[flagIndex*4:])
+		}
+
+		if offset>>32 != 0 {
+			if t.highOffsets == nil {
+				t.highOffsets = make([]uint16, entryCount)
+			}
+			t.highOffsets[i] = uint16(offset >> 32)
+		}
+	}
+
+	return t, nil
+}
+
+// lookup returns the BET table index of the file with the given name, or -1 if it is not found.
+func (t *hetTable) lookup(bet *betTable, name string) int {
+	// hetHash() sets the highest bit of the 64-bit hash, adjust it to the hash size of the table:
+	hash := hetHash(name)
+	if t.nameHashBitSize < 64 {
+		hash = hash&(1<<t.nameHashBitSize-1) | 1<<(t.nameHashBitSize-1)
+	}
+	nameHash1 := byte(hash >> (t.nameHashBitSize - 8))
+	nameHash2 := hash & (1<<(t.nameHashBitSize-8) - 1)
+
+	start := uint32(hash % uint64(t.totalCount))
+	for i := start; t.nameHashes[i] != 0; {
+		if t.nameHashes[i] == nameHash1 {
+			idx := getBits(t.indices, uint64(i)*uint64(t.indexSizeTotal), t.indexSize)
+			if idx < uint64(bet.entryCount) &&
+				getBits(bet.nameHashes, idx*uint64(bet.nameHashSizeTotal), bet.nameHashSize) == nameHash2 {
+				return int(idx)
+			}
+		}
+		if i++; i == t.totalCount {
+			i = 0
+		}
+		if i == start {
+			break
+		}
+	}
+	return -1
+}
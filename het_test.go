@@ -0,0 +1,258 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestHashLittle2(t *testing.T) {
+	cases := []struct {
+		key          string
+		pc, pb, c, b uint32
+	}{
+		{"", 0, 0, 0xdeadbeef, 0xdeadbeef},
+		{"", 0, 0xdeadbeef, 0xbd5b7dde, 0xdeadbeef},
+		{"", 0xdeadbeef, 0xdeadbeef, 0x9c093ccd, 0xbd5b7dde},
+		{"Four score and seven years ago", 0, 0, 0x17770551, 0xce7226e6},
+		{"Four score and seven years ago", 0, 1, 0xe3607cae, 0xbd371de4},
+		{"Four score and seven years ago", 1, 0, 0xcd628161, 0x6cbea4b3},
+	}
+	for _, c := range cases {
+		if gc, gb := hashLittle2([]byte(c.key), c.pc, c.pb); gc != c.c || gb != c.b {
+			t.Errorf("hashLittle2(%q, %x, %x) = %x, %x, expected: %x, %x", c.key, c.pc, c.pb, gc, gb, c.c, c.b)
+		}
+	}
+
+	if hetHash("Dir/File.TXT") != hetHash("dir\\file.txt") {
+		t.Errorf("hetHash must be case insensitive and treat slash as backslash")
+	}
+}
+
+func TestWriterHetBet(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	w, err := NewFileWriter(name, WithFormatVersion(FormatVersion4))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []string{"a.txt", "dir\\b.bin", "c.dat"}
+	for i, fname := range files {
+		if err := w.AddFile(fname, testContent(1000*i), FileCompression(CompressionZlib)); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+
+	u32 := func(b []byte, i int) uint32 { return binary.LittleEndian.Uint32(b[i*4:]) }
+
+	hetOffset := binary.LittleEndian.Uint64(content[0x3c:])
+	hetSize := binary.LittleEndian.Uint64(content[0x5c:])
+	het := append([]byte{}, content[hetOffset:hetOffset+hetSize]...)
+	if u32(het, 0) != hetTableSignature || u32(het, 1) != 1 || uint64(u32(het, 2)) != hetSize-extTableHeaderSize {
+		t.Fatalf("Invalid HET table header")
+	}
+	decrypt(het[extTableHeaderSize:], hashTableKey)
+	totalCount, indexSize := u32(het, 5), u32(het, 7)
+
+	betOffset := binary.LittleEndian.Uint64(content[0x34:])
+	betSize := binary.LittleEndian.Uint64(content[0x64:])
+	bet := append([]byte{}, content[betOffset:betOffset+betSize]...)
+	if u32(bet, 0) != betTableSignature || uint64(u32(bet, 2)) != betSize-extTableHeaderSize {
+		t.Fatalf("Invalid BET table header")
+	}
+	decrypt(bet[extTableHeaderSize:], blockTableKey)
+	entrySize, flagCount := u32(bet, 6), u32(bet, 21)
+	if u32(bet, 4) != m.header.blockTableEntries {
+		t.Errorf("BET entry count mismatch: %d", u32(bet, 4))
+	}
+	flags := bet[betHeaderSize : betHeaderSize+4*flagCount]
+	table := bet[betHeaderSize+4*flagCount:]
+	nameHashes := table[(uint64(entrySize)*uint64(u32(bet, 4))+7)/8:]
+
+	for _, fname := range append(files, listfileName) {
+		hash := hetHash(fname)
+		j := uint32(hash % uint64(totalCount))
+		for het[hetHeaderSize+j] != byte(hash>>56) {
+			j = (j + 1) % totalCount
+		}
+		idx := getBits(het[hetHeaderSize+int(totalCount):], uint64(j)*uint64(indexSize), indexSize)
+
+		if getBits(nameHashes, idx*56, 56) != hash&(1<<56-1) {
+			t.Errorf("Name hash mismatch for %s", fname)
+		}
+
+		pos := idx * uint64(entrySize)
+		offset := getBits(table, pos+uint64(u32(bet, 7)), u32(bet, 12))
+		fileSize := getBits(table, pos+uint64(u32(bet, 8)), u32(bet, 13))
+		blockSize := getBits(table, pos+uint64(u32(bet, 9)), u32(bet, 14))
+		flagIndex := getBits(table, pos+uint64(u32(bet, 10)), u32(bet, 15))
+
+		be := m.blockTable[idx]
+		if uint32(offset) != be.blockOffset || uint32(fileSize) != be.fileSize || uint32(blockSize) != be.blockSize ||
+			u32(flags, int(flagIndex)) != be.flags {
+			t.Errorf("BET entry mismatch for %s", fname)
+		}
+	}
+}
+
+func TestHetBetRead(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	if m.het == nil || m.bet == nil {
+		t.Fatalf("HET and BET tables are not read")
+	}
+	for i, be := range m.bet.blocks {
+		if i < len(m.blockTable) && be != m.blockTable[i] {
+			t.Errorf("BET entry %d mismatch: %+v, block table entry: %+v", i, be, m.blockTable[i])
+		}
+	}
+	listfile, err := m.FileByName(listfileName)
+	if err != nil {
+		t.Fatalf("Failed to read (listfile): %v", err)
+	}
+	for _, fname := range append(strings.Fields(string(listfile)), listfileName) {
+		_, h2, h3 := FileNameHash(fname)
+		idx := -1
+		for _, he := range m.hashTable {
+			if he.filePathHashA == h2 && he.filePathHashB == h3 {
+				idx = int(he.fileBlockIndex)
+			}
+		}
+		if got := m.het.lookup(m.bet, fname); got != idx {
+			t.Errorf("HET lookup of %s: %d, expected: %d", fname, got, idx)
+		}
+	}
+	if got := m.het.lookup(m.bet, "not-present"); got != -1 {
+		t.Errorf("HET lookup of missing file: %d", got)
+	}
+
+	// Archives without usable classic tables:
+	w, err := NewBufferedWriter(WithFormatVersion(FormatVersion3))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []string{"a.txt", "dir\\b.bin", "c.dat"}
+	for i, fname := range files {
+		if err := w.AddFile(fname, testContent(1000*i), FileCompression(CompressionZlib)); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		modify func(content []byte)
+	}{
+		{"absent", func(content []byte) {
+			binary.LittleEndian.PutUint32(content[0x18:], 0) // hash table entries
+			binary.LittleEndian.PutUint32(content[0x1c:], 0) // block table entries
+		}},
+		{"truncated", func(content []byte) {
+			binary.LittleEndian.PutUint32(content[0x10:], uint32(len(content))) // hash table offset
+		}},
+	} {
+		content := append([]byte{}, buf.Bytes()...)
+		c.modify(content)
+		m, err := New(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("[%s] Failed to open archive: %v", c.name, err)
+		}
+		if m.hashTable != nil {
+			t.Errorf("[%s] Classic hash table is used", c.name)
+		}
+		for i, fname := range files {
+			if got, err := m.FileByName(fname); err != nil || !bytes.Equal(got, testContent(1000*i)) {
+				t.Errorf("[%s] File %s mismatch (err: %v)", c.name, fname, err)
+			}
+		}
+		if got, err := m.FileByName("not-present"); got != nil || err != ErrFileNotFound {
+			t.Errorf("[%s] Expected nil, ErrFileNotFound for missing file, got: %v, %v", c.name, got, err)
+		}
+		if got, err := m.FileByHash(FileNameHash("a.txt")); got != nil || err != ErrFileNotFound {
+			t.Errorf("[%s] Expected nil, ErrFileNotFound from FileByHash, got: %v, %v", c.name, got, err)
+		}
+	}
+}
+
+func TestHetBetBounds(t *testing.T) {
+	w, err := NewBufferedWriter(WithFormatVersion(FormatVersion3))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("a.txt", []byte("a")); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	hetOffset := binary.LittleEndian.Uint64(buf.Bytes()[0x3c:])
+	betOffset := binary.LittleEndian.Uint64(buf.Bytes()[0x34:])
+
+	for _, c := range []struct {
+		name   string
+		modify func(content []byte)
+	}{
+		{"huge HET", func(content []byte) {
+			binary.LittleEndian.PutUint32(content[hetOffset+8:], 0xffffffff) // data size
+		}},
+		{"huge BET", func(content []byte) {
+			binary.LittleEndian.PutUint32(content[betOffset+8:], 0xffffffff) // data size
+		}},
+		{"zero BET entry size", func(content []byte) {
+			size := uint64(binary.LittleEndian.Uint32(content[betOffset+8:]))
+			data := content[betOffset+extTableHeaderSize : betOffset+extTableHeaderSize+size]
+			decrypt(data, blockTableKey)
+			binary.LittleEndian.PutUint32(data[4:], 0xffffffff) // entry count
+			binary.LittleEndian.PutUint32(data[12:], 0)         // entry size
+			binary.LittleEndian.PutUint32(data[14*4:], 0)       // total bits of name hash 2
+			binary.LittleEndian.PutUint32(data[16*4:], 0)       // bits of name hash 2
+			encrypt(data, blockTableKey)
+		}},
+	} {
+		content := append([]byte{}, buf.Bytes()...)
+		c.modify(content)
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		allocated := ms.TotalAlloc
+		m, err := New(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("[%s] Failed to open archive: %v", c.name, err)
+		}
+		runtime.ReadMemStats(&ms)
+		if allocated = ms.TotalAlloc - allocated; allocated > 1<<20 {
+			t.Errorf("[%s] Got: %d bytes allocated, want: at most %d", c.name, allocated, 1<<20)
+		}
+		if m.het != nil && m.bet != nil {
+			t.Errorf("[%s] HET and BET tables are read", c.name)
+		}
+		if len(m.Warnings()) == 0 {
+			t.Errorf("[%s] No warnings", c.name)
+		}
+		if got, err := m.FileByName("a.txt"); err != nil || string(got) != "a" {
+			t.Errorf("[%s] Got: %q, %v, want: %q", c.name, got, err, "a")
+		}
+	}
+}
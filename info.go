@@ -0,0 +1,193 @@
+// Metadata of the files of the archives.
+
+package mpq
+
+// FileInfo describes a file of the archive without its content.
+type FileInfo struct {
+	// Name of the file, if known (the file was looked up by name, or the name could be resolved
+	// from the "(listfile)" or the registered names).
+	Name string
+
+	// Size is the uncompressed size of the file.
+	Size uint32
+
+	// CompressedSize is the size of the stored data of the file in the archive,
+	// including the sector offset table and sector checksums if present.
+	CompressedSize uint32
+
+	// Flags of the block of the file, e.g. Flags.IsCompressed() tells if the file is compressed.
+	Flags BlockFlags
+
+	// PatchFile tells if the file is an incremental patch file (to be applied to the file of a
+	// lower-priority archive) rather than a full file. It is set if the block is flagged as a patch file,
+	// or if the patch bit of the file is set in the "(attributes)".
+	PatchFile bool
+
+	// Locale of the file (Windows LANGID), 0 is the default (language-neutral) locale.
+	// Always 0 in archives without a usable hash table (only HET and BET tables).
+	Locale uint16
+
+	// Platform of the file, 0 is the default platform.
+	Platform uint16
+
+	// BlockIndex is the index of the file's entry in the block table.
+	BlockIndex int
+}
+
+// FileInfo returns metadata of a file specified by its name, without reading its content.
+// nil is returned if the file cannot be found.
+func (m *MPQ) FileInfo(name string) *FileInfo {
+	hashIndex, blockIndex := m.lookupName(name)
+	if blockIndex < 0 {
+		return nil
+	}
+	return m.fileInfo(name, hashIndex, blockIndex)
+}
+
+// FileInfoByHash returns metadata of a file specified by hashes of its name, without reading its content.
+// The required hashes of a name can be acquired using the FileNameHash() function.
+// The name of the file is filled if it can be resolved (see NameForHash()).
+// nil is returned if the file cannot be found.
+func (m *MPQ) FileInfoByHash(h1, h2, h3 uint32) *FileInfo {
+	hashIndex, blockIndex := m.lookupHash(h1, h2, h3)
+	if blockIndex < 0 {
+		return nil
+	}
+	return m.fileInfo(m.NameForHash(h1, h2, h3), hashIndex, blockIndex)
+}
+
+// fileInfo assembles the FileInfo of a file specified by its hash table and block table indices.
+// hashIndex may be -1 if the file has no hash table entry.
+func (m *MPQ) fileInfo(name string, hashIndex, blockIndex int) *FileInfo {
+	be := m.blockTable[blockIndex]
+	fi := &FileInfo{
+		Name:           name,
+		Size:           be.fileSize,
+		CompressedSize: be.blockSize,
+		Flags:          BlockFlags(be.flags),
+		BlockIndex:     blockIndex,
+	}
+	if hashIndex >= 0 {
+		he := m.hashTable[hashIndex]
+		fi.Locale, fi.Platform = he.language, he.platform
+	}
+	fi.PatchFile = fi.Flags.IsPatchFile() || m.patchBit(blockIndex)
+	return fi
+}
+
+// Exists tells if a file specified by its name is in the archive. Only the hash table (or HET table)
+// is searched, no file data is read. Files marked as deleted (by a deletion marker) do not exist.
+func (m *MPQ) Exists(name string) bool {
+	return m.exists(m.blockIndexByName(name))
+}
+
+// ExistsByHash tells if a file specified by hashes of its name is in the archive.
+// The required hashes of a name can be acquired using the FileNameHash() function.
+// See Exists() for details.
+func (m *MPQ) ExistsByHash(h1, h2, h3 uint32) bool {
+	return m.exists(m.blockIndexByHash(h1, h2, h3))
+}
+
+// exists tells if the block specified by its index (-1 if not found) holds an existing file.
+func (m *MPQ) exists(blockIndex int) bool {
+	return blockIndex >= 0 && m.blockTable[blockIndex].flags&beFlagDeleteMarker == 0
+}
+
+// FileSize returns the uncompressed size of a file specified by its name, without reading its content.
+// found is false if the file cannot be found.
+func (m *MPQ) FileSize(name string) (size uint32, found bool) {
+	return m.blockSizes(m.blockIndexByName(name), false)
+}
+
+// FileSizeByHash returns the uncompressed size of a file specified by hashes of its name,
+// without reading its content. The required hashes of a name can be acquired using the FileNameHash() function.
+// found is false if the file cannot be found.
+func (m *MPQ) FileSizeByHash(h1, h2, h3 uint32) (size uint32, found bool) {
+	return m.blockSizes(m.blockIndexByHash(h1, h2, h3), false)
+}
+
+// CompressedSize returns the size of the stored data of a file specified by its name.
+// found is false if the file cannot be found.
+func (m *MPQ) CompressedSize(name string) (size uint32, found bool) {
+	return m.blockSizes(m.blockIndexByName(name), true)
+}
+
+// CompressedSizeByHash returns the size of the stored data of a file specified by hashes of its name.
+// The required hashes of a name can be acquired using the FileNameHash() function.
+// found is false if the file cannot be found.
+func (m *MPQ) CompressedSizeByHash(h1, h2, h3 uint32) (size uint32, found bool) {
+	return m.blockSizes(m.blockIndexByHash(h1, h2, h3), true)
+}
+
+// blockSizes returns the uncompressed or the stored size of the block specified by its index (-1 if not found).
+func (m *MPQ) blockSizes(blockIndex int, stored bool) (size uint32, found bool) {
+	if blockIndex < 0 {
+		return 0, false
+	}
+	if stored {
+		return m.blockTable[blockIndex].blockSize, true
+	}
+	return m.blockTable[blockIndex].fileSize, true
+}
+
+// UnnamedFiles returns the metadata of the files whose names are unknown: names that are neither listed
+// in the "(listfile)" nor registered (see AddNames()). Useful to audit archives with a missing or
+// incomplete "(listfile)".
+func (m *MPQ) UnnamedFiles() []*FileInfo {
+	m.loadTables()
+	named := make([]bool, len(m.blockTable))
+	for _, name := range m.namesIndex() {
+		if idx := m.blockIndexByName(name); idx >= 0 {
+			named[idx] = true
+		}
+	}
+
+	// Hash table entries of the blocks (for the locale and platform)
+	hashIndices := make([]int, len(m.blockTable))
+	for i := range hashIndices {
+		hashIndices[i] = -1
+	}
+	for i, he := range m.hashTable {
+		if idx := m.hashEntryBlockIndex(he); idx >= 0 && hashIndices[idx] < 0 {
+			hashIndices[idx] = i
+		}
+	}
+
+	var files []*FileInfo
+	for i, be := range m.blockTable {
+		if be.flags&beFlagFile == 0 || named[i] {
+			continue
+		}
+		files = append(files, m.fileInfo("", hashIndices[i], i))
+	}
+	return files
+}
+
+// FileByBlockIndex returns the content of the file stored in the block specified by its block table index
+// (see FileInfo.BlockIndex). Useful to read files whose names are unknown (see UnnamedFiles()).
+//
+// ErrFileNotFound is returned if the index is out of range or the block is not a file.
+// Encrypted files can only be read if their names can be resolved (see NameForHash()),
+// as the decryption key is derived from the file name (else ErrEncryptionUnsupported is returned).
+// Other return values are the same as those of FileByName().
+func (m *MPQ) FileByBlockIndex(blockIndex int) ([]byte, error) {
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	if blockIndex < 0 || blockIndex >= len(m.blockTable) || m.blockTable[blockIndex].flags&beFlagFile == 0 {
+		return nil, ErrFileNotFound
+	}
+
+	var name string
+	if m.blockTable[blockIndex].flags&beFlagEncrypted != 0 {
+		for _, he := range m.hashTable {
+			if m.hashEntryBlockIndex(he) == blockIndex {
+				if name = m.NameForHash(0, he.filePathHashA, he.filePathHashB); name != "" {
+					break
+				}
+			}
+		}
+	}
+
+	return m.readFile(blockIndex, name)
+}
@@ -0,0 +1,234 @@
+package mpq
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileInfo(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	content := []byte(strings.Repeat("compressible ", 1000))
+	if err := w.AddFile("plain.txt", content, FileCompression(CompressionZlib)); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if err := w.AddFile(`dir\secret.txt`, content, FileEncrypted(true)); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	fi := m.FileInfo("plain.txt")
+	if fi == nil {
+		t.Fatalf("File info not found")
+	}
+	if fi.Name != "plain.txt" || fi.Size != uint32(len(content)) || fi.CompressedSize >= fi.Size ||
+		!fi.Flags.IsCompressed() || fi.Flags.IsEncrypted() || fi.Flags.IsSingleUnit() || fi.Locale != 0 || !fi.Flags.IsFile() {
+		t.Errorf("Unexpected file info: %+v", fi)
+	}
+	if be := m.blockTable[fi.BlockIndex]; be.fileSize != fi.Size || be.blockSize != fi.CompressedSize {
+		t.Errorf("Block index mismatch: %+v", fi)
+	}
+
+	// By hash, name resolved from the listfile:
+	fi = m.FileInfoByHash(FileNameHash(`DIR/SECRET.TXT`))
+	if fi == nil {
+		t.Fatalf("File info not found")
+	}
+	if fi.Name != `dir\secret.txt` || fi.Size != uint32(len(content)) || !fi.Flags.IsEncrypted() {
+		t.Errorf("Unexpected file info: %+v", fi)
+	}
+
+	if fi = m.FileInfo("missing.txt"); fi != nil {
+		t.Errorf("Got: %+v, want: nil", fi)
+	}
+}
+
+func TestExists(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	cases := []struct {
+		name   string
+		exists bool
+	}{
+		{"replay.tracker.events", true},
+		{"REPLAY.DETAILS", true},
+		{"replay.details.backup", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if exists := m.Exists(c.name); exists != c.exists {
+			t.Errorf("[%q] Got: %v, want: %v", c.name, exists, c.exists)
+		}
+		if exists := m.ExistsByHash(FileNameHash(c.name)); exists != c.exists {
+			t.Errorf("[%q] Got: %v, want: %v (by hash)", c.name, exists, c.exists)
+		}
+	}
+
+	// Deletion marker:
+	content := buildArchiveBytes(t, "a.txt", "a", "b.txt", "")
+	m2, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	setBlockFlags(content, m2.header, 1, beFlagFile|beFlagDeleteMarker)
+	if m2, err = New(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if !m2.Exists("a.txt") || m2.Exists("b.txt") {
+		t.Errorf("Got: %v, %v, want: true, false", m2.Exists("a.txt"), m2.Exists("b.txt"))
+	}
+}
+
+func TestFileSizes(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	for _, name := range []string{"replay.details", "replay.game.events", "replay.initData"} {
+		data, err := m.FileByName(name)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %v", name, err)
+		}
+		size, found := m.FileSize(name)
+		if !found || size != uint32(len(data)) {
+			t.Errorf("[%s] Got: %d, %v, want: %d, true", name, size, found, len(data))
+		}
+		if size2, _ := m.FileSizeByHash(FileNameHash(name)); size2 != size {
+			t.Errorf("[%s] Got: %d, want: %d (by hash)", name, size2, size)
+		}
+		csize, found := m.CompressedSize(name)
+		if !found || csize == 0 || csize != m.FileInfo(name).CompressedSize {
+			t.Errorf("[%s] Got: %d, %v, want: %d, true", name, csize, found, m.FileInfo(name).CompressedSize)
+		}
+		if csize2, _ := m.CompressedSizeByHash(FileNameHash(name)); csize2 != csize {
+			t.Errorf("[%s] Got: %d, want: %d (by hash)", name, csize2, csize)
+		}
+	}
+
+	if _, found := m.FileSize("missing"); found {
+		t.Errorf("Missing file found")
+	}
+	if _, found := m.CompressedSize("missing"); found {
+		t.Errorf("Missing file found")
+	}
+}
+
+func TestFileInfoPatchFile(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	if fi := m.FileInfo("replay.details"); fi.PatchFile {
+		t.Errorf("Unexpected patch file: %+v", fi)
+	}
+
+	// Set the patch bit in the (attributes):
+	a, err := m.Attributes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	idx := m.FileInfo("replay.details").BlockIndex
+	a.PatchBit = make([]bool, len(m.blockTable))
+	a.PatchBit[idx] = true
+	if fi := m.FileInfo("replay.details"); !fi.PatchFile {
+		t.Errorf("Expected patch file: %+v", fi)
+	}
+	if fi := m.FileInfo("replay.initData"); fi.PatchFile {
+		t.Errorf("Unexpected patch file: %+v", fi)
+	}
+
+	// Patch file flag of the block:
+	m.blockTable[idx].flags |= uint32(FlagPatchFile)
+	a.PatchBit = nil
+	if fi := m.FileInfo("replay.details"); !fi.PatchFile {
+		t.Errorf("Expected patch file: %+v", fi)
+	}
+}
+
+func TestUnnamedFiles(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", "b.txt", "bb", "c.txt", "ccc", listfileName, "a.txt")
+
+	sizes := func(files []*FileInfo) (s []uint32) {
+		for _, fi := range files {
+			if fi.Name != "" || !fi.Flags.IsFile() {
+				t.Errorf("Unexpected file info: %+v", fi)
+			}
+			s = append(s, fi.Size)
+		}
+		return
+	}
+
+	if got, exp := sizes(m.UnnamedFiles()), []uint32{2, 3}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Got: %v, want: %v", got, exp)
+	}
+	m.AddNames("B.TXT")
+	if got, exp := sizes(m.UnnamedFiles()), []uint32{3}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Got: %v, want: %v", got, exp)
+	}
+	m.AddNames("c.txt")
+	if files := m.UnnamedFiles(); len(files) != 0 {
+		t.Errorf("Got: %v, want: none", files)
+	}
+}
+
+func TestFileByBlockIndex(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("plain.txt", []byte("plain")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if err := w.AddFile("secret.txt", []byte("secret"), FileEncrypted(true)); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if err := w.AddFile(listfileName, []byte("secret.txt")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	unnamed := m.UnnamedFiles()
+	if len(unnamed) != 1 {
+		t.Fatalf("Got: %d unnamed files, want: 1", len(unnamed))
+	}
+	if data, err := m.FileByBlockIndex(unnamed[0].BlockIndex); err != nil || string(data) != "plain" {
+		t.Errorf("Got: %q, %v, want: %q, nil", data, err, "plain")
+	}
+
+	// Encrypted, name resolved from the listfile:
+	if data, err := m.FileByBlockIndex(m.FileInfo("secret.txt").BlockIndex); err != nil || string(data) != "secret" {
+		t.Errorf("Got: %q, %v, want: %q, nil", data, err, "secret")
+	}
+
+	for _, idx := range []int{-1, len(m.blockTable)} {
+		if data, err := m.FileByBlockIndex(idx); data != nil || err != ErrFileNotFound {
+			t.Errorf("[%d] Got: %q, %v, want: nil, %v", idx, data, err, ErrFileNotFound)
+		}
+	}
+}
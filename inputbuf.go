@@ -0,0 +1,50 @@
+// Coalescing small reads of the input if it does not implement io.ReaderAt (its reads are serialized).
+
+package mpq
+
+import (
+	"io"
+	"sync"
+)
+
+// Size of the buffer of the input. Reads smaller than this are served from the buffer,
+// which is filled with a single read of this size (e.g. the header, the user data and small sectors).
+const inputBufferSize = 16 << 10
+
+// inputBuffer holds the buffered data of an input.
+type inputBuffer struct {
+	mu   sync.Mutex
+	data []byte // Buffered data, allocated on first use
+	off  int64  // Offset of the buffered data in the input
+	n    int    // Number of valid bytes in data
+}
+
+// bufferedReaderAt is an io.ReaderAt which serves small reads from an inputBuffer.
+type bufferedReaderAt struct {
+	buf *inputBuffer
+	ra  io.ReaderAt
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *bufferedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) >= inputBufferSize || off < 0 {
+		return b.ra.ReadAt(p, off)
+	}
+
+	buf := b.buf
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	if off < buf.off || off+int64(len(p)) > buf.off+int64(buf.n) {
+		if buf.data == nil {
+			buf.data = make([]byte, inputBufferSize)
+		}
+		// Errors of filling are ignored (e.g. the input ends): p is read directly if the buffer does not cover it
+		buf.off = off
+		buf.n, _ = b.ra.ReadAt(buf.data, off)
+		if buf.n < len(p) {
+			return b.ra.ReadAt(p, off)
+		}
+	}
+	return copy(p, buf.data[off-buf.off:]), nil
+}
@@ -0,0 +1,90 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBufferedReaderAt(t *testing.T) {
+	data := make([]byte, inputBufferSize*3+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	stats := &IOStats{}
+	br := &bufferedReaderAt{buf: &inputBuffer{}, ra: &countingReaderAt{ra: bytes.NewReader(data), stats: stats}}
+
+	cases := []struct {
+		off, size int64
+	}{
+		{0, 4}, {4, 8}, {100, 1000}, // Served by the same fill
+		{inputBufferSize - 2, 4},           // Crossing the buffer
+		{inputBufferSize, inputBufferSize}, // Big read, not buffered
+		{int64(len(data)) - 10, 10},        // End of the input
+		{int64(len(data)) - 5, 10},         // Beyond the end of the input
+		{int64(len(data)) + 5, 10},         // After the end of the input
+	}
+	for i, c := range cases {
+		exp := make([]byte, c.size)
+		expN, expErr := bytes.NewReader(data).ReadAt(exp, c.off)
+		got := make([]byte, c.size)
+		n, err := br.ReadAt(got, c.off)
+		if n != expN || (err == nil) != (expErr == nil) || !bytes.Equal(got[:n], exp[:expN]) {
+			t.Errorf("[%d] Got: %d, %v, want: %d, %v", i, n, err, expN, expErr)
+		}
+		if i == 2 && stats.Reads != 1 {
+			t.Errorf("Got: %d reads, want: 1", stats.Reads)
+		}
+	}
+
+	// Sequential reading through the buffer:
+	sr := io.NewSectionReader(br, 0, int64(len(data)))
+	if got, err := io.ReadAll(sr); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(got), err, len(data))
+	}
+}
+
+func TestInputBuffered(t *testing.T) {
+	content := buildArchiveBytes(t, "a.txt", "a")
+
+	// Inputs implementing io.ReaderAt (including inputs held in memory) can be read concurrently, they are not buffered:
+	m1, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	m2, err := NewFromReader(&seekRecorder{ReadSeeker: bytes.NewReader(content)})
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	for _, m := range []*MPQ{m1, m2} {
+		if _, ok := m.inputAt().(*bufferedReaderAt); ok {
+			t.Errorf("Input is buffered")
+		}
+	}
+
+	m, err := New(&seekRecorder{ReadSeeker: bytes.NewReader(content)})
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if _, ok := m.inputAt().(*bufferedReaderAt); !ok {
+		t.Errorf("Input is not buffered")
+	}
+}
+
+func TestTableInputBuffered(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithIOStats())
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer m.Close()
+
+	// The header (with the user data) and the tables at the end of the file:
+	if stats := m.IOStats(); stats.Reads > 3 {
+		t.Errorf("Too many reads: %+v", stats)
+	}
+
+	// Reading files is not affected:
+	if _, ok := m.inputAt().(*bufferedReaderAt); ok {
+		t.Errorf("Input is buffered")
+	}
+}
@@ -0,0 +1,77 @@
+// Instrumentation of the input operations.
+
+package mpq
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// IOStats holds the number of operations performed on the input of an archive, see WithIOStats.
+type IOStats struct {
+	// Number of read operations (calls of Read, or ReadAt if the input implements io.ReaderAt).
+	// If the input is network-backed, this is the number of round trips.
+	Reads int64
+
+	// Number of seek operations.
+	Seeks int64
+
+	// Number of bytes read.
+	BytesRead int64
+}
+
+// IOStats returns the number of operations performed on the input so far. Taking the difference of the
+// stats before and after a call (e.g. FileByName()) tells the cost of the call.
+// Zero stats are returned if counting is not enabled, see WithIOStats.
+func (m *MPQ) IOStats() IOStats {
+	if m.ioStats == nil {
+		return IOStats{}
+	}
+	return IOStats{
+		Reads:     atomic.LoadInt64(&m.ioStats.Reads),
+		Seeks:     atomic.LoadInt64(&m.ioStats.Seeks),
+		BytesRead: atomic.LoadInt64(&m.ioStats.BytesRead),
+	}
+}
+
+// countingReader counts the operations performed on an io.ReadSeeker.
+type countingReader struct {
+	rs    io.ReadSeeker
+	stats *IOStats
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.rs.Read(p)
+	atomic.AddInt64(&c.stats.Reads, 1)
+	atomic.AddInt64(&c.stats.BytesRead, int64(n))
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	atomic.AddInt64(&c.stats.Seeks, 1)
+	return c.rs.Seek(offset, whence)
+}
+
+// countingReaderAt counts the operations performed on an io.ReaderAt.
+type countingReaderAt struct {
+	ra    io.ReaderAt
+	stats *IOStats
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.ra.ReadAt(p, off)
+	atomic.AddInt64(&c.stats.Reads, 1)
+	atomic.AddInt64(&c.stats.BytesRead, int64(n))
+	return n, err
+}
+
+// seqInput returns the input to be read sequentially (with Read and Seek), counting the operations if enabled.
+func (m *MPQ) seqInput() io.ReadSeeker {
+	if m.ioStats == nil {
+		return m.input
+	}
+	return &countingReader{rs: m.input, stats: m.ioStats}
+}
@@ -0,0 +1,55 @@
+package mpq
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestIOStats(t *testing.T) {
+	for _, hideReaderAt := range []bool{false, true} {
+		f, err := os.Open("reps/lotv.SC2Replay")
+		if err != nil {
+			t.Fatalf("Failed to open replay: %v", err)
+		}
+		defer f.Close()
+		var in io.ReadSeeker = f
+		if hideReaderAt {
+			in = readSeeker{f}
+		}
+
+		m, err := New(in, WithIOStats())
+		if err != nil {
+			t.Fatalf("Failed to parse replay: %v", err)
+		}
+		opened := m.IOStats()
+		if opened.Reads == 0 || opened.BytesRead == 0 {
+			t.Errorf("[hideReaderAt: %t] No reads counted when opening: %+v", hideReaderAt, opened)
+		}
+
+		data, err := m.FileByName("replay.details")
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		read := m.IOStats()
+		if read.Reads <= opened.Reads || read.BytesRead-opened.BytesRead < int64(len(data))/2 {
+			t.Errorf("[hideReaderAt: %t] Reading file not counted, before: %+v, after: %+v", hideReaderAt, opened, read)
+		}
+
+		if c := m.Clone(nil); c.IOStats() != (IOStats{}) {
+			t.Errorf("[hideReaderAt: %t] Clone has non-zero stats: %+v", hideReaderAt, c.IOStats())
+		}
+	}
+
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	if _, err := m.FileByName("replay.details"); err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if got := m.IOStats(); got != (IOStats{}) {
+		t.Errorf("Got: %+v, want zero stats when not enabled", got)
+	}
+}
@@ -0,0 +1,91 @@
+// Classification of the archives.
+
+package mpq
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Kind is the kind of an archive: the game and the purpose it is used for, see MPQ.Kind().
+type Kind int
+
+// Kinds of archives.
+const (
+	// KindUnknown is an archive of unknown kind.
+	KindUnknown Kind = iota
+
+	// KindSC2Replay is a StarCraft II replay (*.SC2Replay).
+	KindSC2Replay
+
+	// KindHeroesReplay is a Heroes of the Storm replay (*.StormReplay).
+	KindHeroesReplay
+
+	// KindSC2Map is a StarCraft II map (*.SC2Map) or other StarCraft II (or Heroes of the Storm) document.
+	KindSC2Map
+
+	// KindWC3Map is a Warcraft III map (*.w3m, *.w3x).
+	KindWC3Map
+
+	// KindWoWData is a World of Warcraft data archive.
+	KindWoWData
+)
+
+// Names of the kinds.
+var kindNames = []string{
+	KindUnknown:      "Unknown",
+	KindSC2Replay:    "StarCraft II replay",
+	KindHeroesReplay: "Heroes of the Storm replay",
+	KindSC2Map:       "StarCraft II map",
+	KindWC3Map:       "Warcraft III map",
+	KindWoWData:      "World of Warcraft data",
+}
+
+// String returns the name of the kind.
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return kindNames[KindUnknown]
+}
+
+// Top-level directories of the files of World of Warcraft data archives.
+var wowDirs = []string{`DBFilesClient\`, `Interface\`, `World\`, `Character\`, `Creature\`, `Item\`, `Spells\`}
+
+// Kind heuristically identifies the kind of the archive from the signature of its user data,
+// the Warcraft III map header and the well-known names of its files. No file data is read,
+// except for the "(listfile)" which is consulted if the well-known names are not found.
+// KindUnknown is returned if the kind cannot be identified.
+func (m *MPQ) Kind() Kind {
+	// Replays have their signature in the user data:
+	if data := m.UserData(); len(data) > 4 {
+		switch {
+		case bytes.Contains(data, []byte("StarCraft II replay")):
+			return KindSC2Replay
+		case bytes.Contains(data, []byte("Heroes of the Storm replay")):
+			return KindHeroesReplay
+		}
+	}
+
+	if m.mapHeader != nil || m.Exists("war3map.w3i") || m.Exists("war3map.j") ||
+		m.Exists(`scripts\war3map.j`) || m.Exists("war3map.lua") {
+		return KindWC3Map
+	}
+	if m.Exists("DocumentHeader") || (m.Exists("MapInfo") && m.Exists("t3Terrain.xml")) {
+		return KindSC2Map
+	}
+	if m.Exists("replay.details") && m.Exists("replay.initData") {
+		return KindSC2Replay // User data is missing, but has the files of replays
+	}
+
+	files, _ := m.Files()
+	for _, f := range files {
+		for _, dir := range wowDirs {
+			if len(f.Name) > len(dir) && strings.EqualFold(f.Name[:len(dir)], dir) {
+				return KindWoWData
+			}
+		}
+	}
+
+	return KindUnknown
+}
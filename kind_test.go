@@ -0,0 +1,60 @@
+package mpq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKind(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	if k := m.Kind(); k != KindSC2Replay {
+		t.Errorf("Got: %v, want: %v", k, KindSC2Replay)
+	}
+
+	build := func(opts []WriterOption, names ...string) *MPQ {
+		w, err := NewBufferedWriter(opts...)
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		for _, name := range names {
+			if err := w.AddFile(name, []byte("x")); err != nil {
+				t.Fatalf("Failed to add file: %v", err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		m, err := New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to open archive: %v", err)
+		}
+		return m
+	}
+
+	heroes := append([]byte{0x70, 0, 0, 0, 5, 0x10, 0, 2, 0x3a}, "Heroes of the Storm replay\x1b11"...)
+	cases := []struct {
+		m   *MPQ
+		exp Kind
+	}{
+		{build([]WriterOption{WithUserData(heroes)}, "replay.details"), KindHeroesReplay},
+		{build(nil, "replay.details", "replay.initData"), KindSC2Replay},
+		{build(nil, "war3map.w3i", "war3map.j"), KindWC3Map},
+		{build(nil, "DocumentHeader", "MapInfo"), KindSC2Map},
+		{build(nil, `DBFilesClient\Spell.dbc`), KindWoWData},
+		{build(nil, "a.txt"), KindUnknown},
+	}
+	for _, c := range cases {
+		if k := c.m.Kind(); k != c.exp {
+			t.Errorf("Got: %v, want: %v", k, c.exp)
+		}
+	}
+
+	if s := Kind(100).String(); s != "Unknown" {
+		t.Errorf("Got: %s, want: Unknown", s)
+	}
+}
@@ -0,0 +1,124 @@
+// File names listed in the "(listfile)" of the archives.
+
+package mpq
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ListedFile is a file name listed in the "(listfile)" of an archive.
+type ListedFile struct {
+	// Name of the file.
+	Name string
+
+	// Tells if the name resolves to a file in the archive.
+	// Listfiles may contain names of files that are not (or no longer) in the archive.
+	Found bool
+}
+
+// Files returns the names listed in the "(listfile)" of the archive in the order they are listed,
+// followed by the registered names (see AddNames and AddListfile) that resolve to a file in the archive.
+// Duplicate names (names only differing in letter case or slashes) are listed once.
+//
+// ErrFileNotFound is returned if the archive has no "(listfile)" and no names are registered.
+// Errors reading the "(listfile)" are returned as-is.
+func (m *MPQ) Files() ([]ListedFile, error) {
+	data, err := m.FileByName(listfileName)
+	if err == ErrFileNotFound {
+		if m.names == nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	names := parseListfile(data)
+	files := make([]ListedFile, 0, len(names))
+	seen := make(map[[2]uint32]bool, len(names))
+	add := func(name string, registered bool) {
+		_, h2, h3 := FileNameHash(name)
+		if seen[[2]uint32{h2, h3}] {
+			return
+		}
+		found := m.blockIndexByName(name) >= 0
+		if registered && !found {
+			return
+		}
+		seen[[2]uint32{h2, h3}] = true
+		files = append(files, ListedFile{Name: name, Found: found})
+	}
+	for _, name := range names {
+		add(name, false)
+	}
+	for _, name := range m.names {
+		add(name, true)
+	}
+
+	return files, nil
+}
+
+// AddNames registers additional candidate file names, e.g. from a community listfile.
+// Registered names are used for enumeration and reverse lookups, useful if the "(listfile)"
+// of the archive is missing or incomplete. Names need not be in the archive.
+func (m *MPQ) AddNames(names ...string) {
+	m.names = append(m.names, names...)
+	m.nameIndex, m.dirFS = nil, nil // Must be rebuilt
+}
+
+// AddListfile registers the names listed in an external listfile read from r.
+// Names are separated by line breaks or semicolons, like in the "(listfile)".
+// See AddNames for details.
+func (m *MPQ) AddListfile(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.AddNames(parseListfile(data)...)
+	return nil
+}
+
+// NameForHash returns the name of the file specified by hashes of its name (as returned by FileNameHash()).
+// Names are resolved using the names of the "(listfile)" and the registered names (see AddNames).
+// Empty string is returned if the name is unknown.
+func (m *MPQ) NameForHash(h1, h2, h3 uint32) string {
+	// h1 is only used to locate the hash table entry, h2 and h3 identify the name.
+	return m.namesIndex()[[2]uint32{h2, h3}]
+}
+
+// namesIndex returns the index of the known names by their hashes, built on first use.
+func (m *MPQ) namesIndex() map[[2]uint32]string {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if m.nameIndex == nil {
+		m.buildNameIndex()
+	}
+	return m.nameIndex
+}
+
+// buildNameIndex builds the index of the known names by their hashes,
+// from the names of the "(listfile)" and the registered names.
+func (m *MPQ) buildNameIndex() {
+	data, _ := m.FileByName(listfileName)
+	names := parseListfile(data)
+
+	m.nameIndex = make(map[[2]uint32]string, len(names)+len(m.names)+1)
+	for _, list := range [][]string{{listfileName}, names, m.names} {
+		for _, name := range list {
+			_, h2, h3 := FileNameHash(name)
+			if _, ok := m.nameIndex[[2]uint32{h2, h3}]; !ok {
+				m.nameIndex[[2]uint32{h2, h3}] = name
+			}
+		}
+	}
+}
+
+// parseListfile returns the names listed in the content of a listfile.
+// Names are separated by line breaks or semicolons.
+func parseListfile(data []byte) []string {
+	return strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == '\r' || r == '\n' || r == ';'
+	})
+}
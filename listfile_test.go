@@ -0,0 +1,136 @@
+package mpq
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildArchiveBytes builds an archive in memory with the given files (name-content pairs).
+func buildArchiveBytes(t *testing.T, files ...string) []byte {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for i := 0; i+1 < len(files); i += 2 {
+		if err := w.AddFile(files[i], []byte(files[i+1])); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// buildArchive builds an archive in memory with the given files (name-content pairs) and opens it.
+func buildArchive(t *testing.T, files ...string) *MPQ {
+	m, err := New(bytes.NewReader(buildArchiveBytes(t, files...)))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	return m
+}
+
+func TestFiles(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "b")
+	files, err := m.Files()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := []ListedFile{{"a.txt", true}, {`dir\b.txt`, true}}
+	if !reflect.DeepEqual(files, exp) {
+		t.Errorf("Got: %v, want: %v", files, exp)
+	}
+
+	// Explicit listfile with stale and duplicate names:
+	m = buildArchive(t, "a.txt", "a", listfileName, "a.txt;gone.txt\r\nA.TXT\n\nreplay.details")
+	if files, err = m.Files(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp = []ListedFile{{"a.txt", true}, {"gone.txt", false}, {"replay.details", false}}
+	if !reflect.DeepEqual(files, exp) {
+		t.Errorf("Got: %v, want: %v", files, exp)
+	}
+
+	// All names listed in a replay resolve:
+	m, err = NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	if files, err = m.Files(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, f := range files {
+		if !f.Found {
+			t.Errorf("Listed file not found: %s", f.Name)
+		}
+	}
+}
+
+func TestAddNames(t *testing.T) {
+	// Incomplete listfile:
+	m := buildArchive(t, "a.txt", "a", "b.txt", "b", "c.txt", "c", listfileName, "a.txt")
+	m.AddNames("c.txt", "A.txt")
+	if err := m.AddListfile(strings.NewReader("b.txt\r\nmissing.txt;c.txt")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	files, err := m.Files()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := []ListedFile{{"a.txt", true}, {"c.txt", true}, {"b.txt", true}}
+	if !reflect.DeepEqual(files, exp) {
+		t.Errorf("Got: %v, want: %v", files, exp)
+	}
+}
+
+func TestWellKnownNames(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	m.AddNames(SC2ReplayFileNames...)
+	m.AddNames(InternalFileNames...)
+
+	listfile, err := m.FileByName(listfileName)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	listed := len(parseListfile(listfile))
+
+	files, err := m.Files()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// All listed files plus the "(listfile)" and the "(attributes)":
+	if exp := listed + 2; len(files) != exp {
+		t.Errorf("Got: %d files, want: %d (%v)", len(files), exp, files)
+	}
+}
+
+func TestNameForHash(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "b", listfileName, `a.txt;dir\b.txt`)
+
+	if name := m.NameForHash(FileNameHash("DIR/B.TXT")); name != `dir\b.txt` {
+		t.Errorf("Got: %q, want: %q", name, `dir\b.txt`)
+	}
+	if name := m.NameForHash(FileNameHash(listfileName)); name != listfileName {
+		t.Errorf("Got: %q, want: %q", name, listfileName)
+	}
+	if name := m.NameForHash(FileNameHash("c.txt")); name != "" {
+		t.Errorf("Got: %q, want: %q", name, "")
+	}
+
+	// Registering names after the index is built:
+	m.AddNames("c.txt")
+	if name := m.NameForHash(FileNameHash("c.txt")); name != "c.txt" {
+		t.Errorf("Got: %q, want: %q", name, "c.txt")
+	}
+}
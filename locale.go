@@ -0,0 +1,96 @@
+// Locale variants of the files: the hash table may hold multiple entries
+// of the same name with different locales.
+
+package mpq
+
+// LocaleNeutral is the default locale, used by language-neutral files (and files in American English).
+const LocaleNeutral = 0
+
+// Locales returns the locales (Windows LANGIDs) of the variants of a file specified by its name,
+// in hash table order. nil is returned if the file cannot be found.
+//
+// Archives without a usable hash table (only HET and BET tables) do not record locales,
+// LocaleNeutral is reported for files found in them.
+func (m *MPQ) Locales(name string) []uint16 {
+	m.loadTables()
+	if m.hashTable == nil && m.het != nil {
+		if m.blockIndexByName(name) < 0 {
+			return nil
+		}
+		return []uint16{LocaleNeutral}
+	}
+
+	var locales []uint16
+	h1, h2, h3 := FileNameHash(name)
+	m.probeHash(h1, h2, h3, func(i int) bool {
+		if he := m.hashTable[i]; m.hashEntryBlockIndex(he) >= 0 {
+			locales = append(locales, he.language)
+		}
+		return true
+	})
+	return locales
+}
+
+// FileByNameLocale returns the content of the variant of a file specified by its name and locale
+// (Windows LANGID, see Locales()). Unlike FileByName(), which returns the first variant found,
+// only the variant with the given locale is considered (regardless of its platform).
+//
+// ErrFileNotFound is returned if the file has no variant with the given locale.
+// Other return values are the same as those of FileByName().
+func (m *MPQ) FileByNameLocale(name string, locale uint16) ([]byte, error) {
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	if m.hashTable == nil && m.het != nil {
+		if locale != LocaleNeutral {
+			return nil, ErrFileNotFound
+		}
+		return m.FileByName(name)
+	}
+
+	h1, h2, h3 := FileNameHash(name)
+	_, idx := m.lookupHashFunc(h1, h2, h3, func(he hashEntry) bool {
+		return he.language == locale
+	})
+	if idx < 0 {
+		return nil, ErrFileNotFound
+	}
+	return m.readFile(idx, name)
+}
+
+// FileByHashLocale returns the content of the variant of a file specified by hashes of its name,
+// its locale (Windows LANGID, see Locales()) and its platform (0 is the default platform).
+// Unlike FileByHash(), which returns the first variant found, only the hash table entry
+// matching both the locale and the platform is considered.
+//
+// ErrFileNotFound is returned if the file has no variant with the given locale and platform.
+// Other return values are the same as those of FileByHash().
+func (m *MPQ) FileByHashLocale(h1, h2, h3 uint32, locale, platform uint16) ([]byte, error) {
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	_, idx := m.lookupHashFunc(h1, h2, h3, func(he hashEntry) bool {
+		return he.language == locale && he.platform == platform
+	})
+	if idx < 0 {
+		return nil, ErrFileNotFound
+	}
+	return m.readFile(idx, "")
+}
+
+// lookupHashFunc looks up the file specified by hashes of its name like lookupHash(),
+// but only hash table entries for which match returns true are considered.
+func (m *MPQ) lookupHashFunc(h1, h2, h3 uint32, match func(he hashEntry) bool) (hashIndex, blockIndex int) {
+	hashIndex, blockIndex = -1, -1
+	m.probeHash(h1, h2, h3, func(i int) bool {
+		he := m.hashTable[i]
+		if !match(he) {
+			return true
+		}
+		if idx := m.hashEntryBlockIndex(he); idx >= 0 {
+			hashIndex, blockIndex = i, idx
+		}
+		return false
+	})
+	return
+}
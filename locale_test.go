@@ -0,0 +1,89 @@
+package mpq
+
+import (
+	"reflect"
+	"testing"
+)
+
+// addLocaleVariant turns the file named variant into a variant of the file named name with the given locale
+// by moving its hash table entry into the probing chain of name.
+// The hash table must have at least 2 empty entries.
+func addLocaleVariant(t *testing.T, m *MPQ, name, variant string, locale uint16) {
+	hashIndex, _ := m.lookupName(name)
+	variantIndex, _ := m.lookupName(variant)
+	if hashIndex < 0 || variantIndex < 0 {
+		t.Fatalf("Files not found")
+	}
+	n := len(m.hashTable)
+	j := (hashIndex + 1) % n
+	for m.hashTable[j].fileBlockIndex != hashEntryEmpty {
+		j = (j + 1) % n
+	}
+
+	he := m.hashTable[variantIndex]
+	m.hashTable[variantIndex].fileBlockIndex = hashEntryDeleted
+	_, he.filePathHashA, he.filePathHashB = FileNameHash(name)
+	he.language = locale
+	m.hashTable[j] = he
+}
+
+func TestLocales(t *testing.T) {
+	// The extra file makes the hash table grow, leaving enough empty entries
+	m := buildArchive(t, "a.txt", "neutral", "b.txt", "german", "c.txt", "c")
+	addLocaleVariant(t, m, "a.txt", "b.txt", 0x407)
+
+	if got, exp := m.Locales("a.txt"), []uint16{LocaleNeutral, 0x407}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Got: %v, want: %v", got, exp)
+	}
+	if got := m.Locales("missing.txt"); got != nil {
+		t.Errorf("Got: %v, want: nil", got)
+	}
+
+	cases := []struct {
+		locale uint16
+		exp    string
+	}{
+		{LocaleNeutral, "neutral"},
+		{0x407, "german"},
+		{0x409, ""},
+	}
+	for _, c := range cases {
+		data, err := m.FileByNameLocale("a.txt", c.locale)
+		if (err == ErrFileNotFound) != (c.exp == "") || (err != nil && err != ErrFileNotFound) {
+			t.Errorf("[locale: %x] Unexpected error: %v", c.locale, err)
+		}
+		if string(data) != c.exp {
+			t.Errorf("[locale: %x] Got: %q, want: %q", c.locale, data, c.exp)
+		}
+	}
+}
+
+func TestFileByHashLocale(t *testing.T) {
+	m := buildArchive(t, "a.txt", "neutral", "b.txt", "german", "c.txt", "c")
+	addLocaleVariant(t, m, "a.txt", "b.txt", 0x407)
+	for i := range m.hashTable {
+		if m.hashTable[i].language == 0x407 {
+			m.hashTable[i].platform = 1
+		}
+	}
+
+	h1, h2, h3 := FileNameHash("a.txt")
+	cases := []struct {
+		locale, platform uint16
+		exp              string
+	}{
+		{LocaleNeutral, 0, "neutral"},
+		{0x407, 1, "german"},
+		{0x407, 0, ""},
+		{LocaleNeutral, 1, ""},
+	}
+	for _, c := range cases {
+		data, err := m.FileByHashLocale(h1, h2, h3, c.locale, c.platform)
+		if (err == ErrFileNotFound) != (c.exp == "") || (err != nil && err != ErrFileNotFound) {
+			t.Errorf("[locale: %x, platform: %d] Unexpected error: %v", c.locale, c.platform, err)
+		}
+		if string(data) != c.exp {
+			t.Errorf("[locale: %x, platform: %d] Got: %q, want: %q", c.locale, c.platform, data, c.exp)
+		}
+	}
+}
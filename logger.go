@@ -0,0 +1,18 @@
+// Debug logging of the parsing and reading steps.
+
+package mpq
+
+// Logger receives debug messages about the steps of parsing archives and reading files, see WithLogger.
+// *log.Logger implements Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// debugf emits a debug message if a logger is set.
+// In frequently called code the call should be guarded by a nil check of m.logger,
+// so the arguments are not evaluated (and allocated) in vain.
+func (m *MPQ) debugf(format string, v ...interface{}) {
+	if m.logger != nil {
+		m.logger.Printf("mpq: "+format, v...)
+	}
+}
@@ -0,0 +1,36 @@
+package mpq
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestWithLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithLogger(log.New(buf, "", 0)))
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	if _, err := m.FileByName("replay.details"); err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	for _, want := range []string{"mpq: user data at offset 0", "mpq: header at offset 1024", "mpq: hash table at offset",
+		`mpq: file "replay.details"`, "mpq: block ", "mpq: sector 0 at offset"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Log does not contain %q:\n%s", want, buf)
+		}
+	}
+
+	// Invalid archives are also logged:
+	buf.Reset()
+	if _, err := New(bytes.NewReader([]byte("MPQ\x1b\x00\x00\x00\x00\x10\x00\x00\x00garbagegarbage")), WithLogger(log.New(buf, "", 0))); err == nil {
+		t.Errorf("Expected error")
+	}
+	if want := "mpq: no header magic at offset 16"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Log does not contain %q:\n%s", want, buf)
+	}
+}
@@ -0,0 +1,345 @@
+// Decompression of LZMA compressed data (compression method 0x12).
+//
+// LZMA compressed data in MPQ archives starts with a filter byte (must be 0), followed by the
+// 5-byte LZMA properties (lc/lp/pb byte and dictionary size), the 8-byte uncompressed size
+// and the LZMA stream. The layout after the filter byte is the same as that of ".lzma" files.
+//
+// The implementation follows the LZMA specification (LzmaSpec.cpp of the LZMA SDK).
+
+package mpq
+
+import (
+	"encoding/binary"
+)
+
+// Sizes of the header parts of LZMA compressed data.
+const (
+	lzmaPropsSize  = 5
+	lzmaHeaderSize = 1 + lzmaPropsSize + 8 // Filter byte, properties, uncompressed size
+)
+
+// LZMA model constants.
+const (
+	lzmaNumBitModelTotalBits = 11
+	lzmaBitModelTotal        = 1 << lzmaNumBitModelTotalBits
+	lzmaNumMoveBits          = 5
+	lzmaProbInit             = lzmaBitModelTotal / 2
+
+	lzmaNumStates         = 12
+	lzmaNumPosBitsMax     = 4
+	lzmaNumLenToPosStates = 4
+	lzmaNumAlignBits      = 4
+	lzmaEndPosModelIndex  = 14
+	lzmaNumFullDistances  = 1 << (lzmaEndPosModelIndex >> 1)
+	lzmaMatchMinLen       = 2
+)
+
+// rangeDecoder is the range decoder of LZMA.
+type rangeDecoder struct {
+	src    []byte
+	pos    int
+	rng    uint32
+	code   uint32
+	failed bool // Set if the input is exhausted or corrupt
+}
+
+// next returns the next input byte.
+func (rd *rangeDecoder) next() uint32 {
+	if rd.pos >= len(rd.src) {
+		rd.failed = true
+		return 0
+	}
+	b := rd.src[rd.pos]
+	rd.pos++
+	return uint32(b)
+}
+
+// init initializes the range decoder, reading the first 5 bytes of the stream.
+func (rd *rangeDecoder) init() bool {
+	rd.rng = 0xffffffff
+	if rd.next() != 0 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		rd.code = rd.code<<8 | rd.next()
+	}
+	return !rd.failed && rd.code != rd.rng
+}
+
+// normalize refills the range if needed.
+func (rd *rangeDecoder) normalize() {
+	if rd.rng < 1<<24 {
+		rd.rng <<= 8
+		rd.code = rd.code<<8 | rd.next()
+	}
+}
+
+// directBits decodes numBits bits with fixed probabilities.
+func (rd *rangeDecoder) directBits(numBits uint) uint32 {
+	var res uint32
+	for ; numBits > 0; numBits-- {
+		rd.rng >>= 1
+		rd.code -= rd.rng
+		t := 0 - (rd.code >> 31)
+		rd.code += rd.rng & t
+		if rd.code == rd.rng {
+			rd.failed = true
+		}
+		rd.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+// bit decodes a bit using the adaptive probability prob.
+func (rd *rangeDecoder) bit(prob *uint16) uint32 {
+	v := uint32(*prob)
+	bound := (rd.rng >> lzmaNumBitModelTotalBits) * v
+	var symbol uint32
+	if rd.code < bound {
+		v += (lzmaBitModelTotal - v) >> lzmaNumMoveBits
+		rd.rng = bound
+	} else {
+		v -= v >> lzmaNumMoveBits
+		rd.code -= bound
+		rd.rng -= bound
+		symbol = 1
+	}
+	*prob = uint16(v)
+	rd.normalize()
+	return symbol
+}
+
+// bitTree decodes numBits bits (most significant bit first) using the probabilities of a bit tree.
+func (rd *rangeDecoder) bitTree(probs []uint16, numBits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		m = m<<1 + rd.bit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+// bitTreeReverse decodes numBits bits (least significant bit first) using the probabilities of a bit tree.
+func (rd *rangeDecoder) bitTreeReverse(probs []uint16, numBits uint) uint32 {
+	m, symbol := uint32(1), uint32(0)
+	for i := uint(0); i < numBits; i++ {
+		bit := rd.bit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+	return symbol
+}
+
+// newProbs returns n initialized probabilities.
+func newProbs(n int) []uint16 {
+	probs := make([]uint16, n)
+	for i := range probs {
+		probs[i] = lzmaProbInit
+	}
+	return probs
+}
+
+// lzmaLenDecoder decodes match lengths.
+type lzmaLenDecoder struct {
+	choice, choice2 uint16
+	low, mid        [1 << lzmaNumPosBitsMax][]uint16
+	high            []uint16
+}
+
+// newLzmaLenDecoder returns a new, initialized lzmaLenDecoder.
+func newLzmaLenDecoder() *lzmaLenDecoder {
+	ld := &lzmaLenDecoder{choice: lzmaProbInit, choice2: lzmaProbInit, high: newProbs(1 << 8)}
+	for i := range ld.low {
+		ld.low[i] = newProbs(1 << 3)
+		ld.mid[i] = newProbs(1 << 3)
+	}
+	return ld
+}
+
+// decode decodes a match length (without the minimum match length).
+func (ld *lzmaLenDecoder) decode(rd *rangeDecoder, posState uint32) uint32 {
+	if rd.bit(&ld.choice) == 0 {
+		return rd.bitTree(ld.low[posState], 3)
+	}
+	if rd.bit(&ld.choice2) == 0 {
+		return 8 + rd.bitTree(ld.mid[posState], 3)
+	}
+	return 16 + rd.bitTree(ld.high, 8)
+}
+
+// decompressLzma decompresses LZMA compressed src (starting with the filter byte) into dst.
+func decompressLzma(dst, src []byte) error {
+	if len(src) < lzmaHeaderSize || src[0] != 0 {
+		return ErrInvalidArchive
+	}
+
+	d := uint32(src[1])
+	if d >= 9*5*5 {
+		return ErrInvalidArchive
+	}
+	lc, lp, pb := uint(d%9), uint(d/9%5), uint(d/45)
+	dictSize := binary.LittleEndian.Uint32(src[2:])
+	if dictSize < 1<<12 {
+		dictSize = 1 << 12
+	}
+
+	rd := &rangeDecoder{src: src[lzmaHeaderSize:]}
+	if !rd.init() {
+		return ErrInvalidArchive
+	}
+
+	literalProbs := newProbs(0x300 << (lc + lp))
+	posSlotProbs := make([][]uint16, lzmaNumLenToPosStates)
+	for i := range posSlotProbs {
+		posSlotProbs[i] = newProbs(1 << 6)
+	}
+	posProbs := newProbs(1 + lzmaNumFullDistances - lzmaEndPosModelIndex)
+	alignProbs := newProbs(1 << lzmaNumAlignBits)
+	lenDecoder, repLenDecoder := newLzmaLenDecoder(), newLzmaLenDecoder()
+
+	isMatch := newProbs(lzmaNumStates << lzmaNumPosBitsMax)
+	isRep := newProbs(lzmaNumStates)
+	isRepG0 := newProbs(lzmaNumStates)
+	isRepG1 := newProbs(lzmaNumStates)
+	isRepG2 := newProbs(lzmaNumStates)
+	isRep0Long := newProbs(lzmaNumStates << lzmaNumPosBitsMax)
+
+	decodeDistance := func(length uint32) uint32 {
+		lenState := length
+		if lenState > lzmaNumLenToPosStates-1 {
+			lenState = lzmaNumLenToPosStates - 1
+		}
+		posSlot := rd.bitTree(posSlotProbs[lenState], 6)
+		if posSlot < 4 {
+			return posSlot
+		}
+		numDirectBits := uint(posSlot>>1) - 1
+		dist := (2 | posSlot&1) << numDirectBits
+		if posSlot < lzmaEndPosModelIndex {
+			return dist + rd.bitTreeReverse(posProbs[dist-posSlot:], numDirectBits)
+		}
+		dist += rd.directBits(numDirectBits-lzmaNumAlignBits) << lzmaNumAlignBits
+		return dist + rd.bitTreeReverse(alignProbs, lzmaNumAlignBits)
+	}
+
+	var state, rep0, rep1, rep2, rep3 uint32
+	pbMask, lpMask := uint32(1)<<pb-1, uint32(1)<<lp-1
+	n := 0 // Number of decoded bytes
+
+	for n < len(dst) {
+		if rd.failed {
+			return ErrInvalidArchive
+		}
+		posState := uint32(n) & pbMask
+
+		if rd.bit(&isMatch[state<<lzmaNumPosBitsMax+posState]) == 0 {
+			// Literal
+			var prevByte uint32
+			if n > 0 {
+				prevByte = uint32(dst[n-1])
+			}
+			litState := (uint32(n)&lpMask)<<lc + prevByte>>(8-lc)
+			probs := literalProbs[0x300*litState:]
+
+			symbol := uint32(1)
+			if state >= 7 {
+				matchByte := uint32(dst[n-int(rep0)-1])
+				for symbol < 0x100 {
+					matchBit := matchByte >> 7 & 1
+					matchByte <<= 1
+					bit := rd.bit(&probs[(1+matchBit)<<8+symbol])
+					symbol = symbol<<1 | bit
+					if matchBit != bit {
+						break
+					}
+				}
+			}
+			for symbol < 0x100 {
+				symbol = symbol<<1 | rd.bit(&probs[symbol])
+			}
+			dst[n] = byte(symbol)
+			n++
+
+			switch {
+			case state < 4:
+				state = 0
+			case state < 10:
+				state -= 3
+			default:
+				state -= 6
+			}
+			continue
+		}
+
+		var length uint32
+		if rd.bit(&isRep[state]) != 0 {
+			if n == 0 {
+				return ErrInvalidArchive
+			}
+			if rd.bit(&isRepG0[state]) == 0 {
+				if rd.bit(&isRep0Long[state<<lzmaNumPosBitsMax+posState]) == 0 {
+					// Short rep: a single byte at distance rep0
+					if state < 7 {
+						state = 9
+					} else {
+						state = 11
+					}
+					dst[n] = dst[n-int(rep0)-1]
+					n++
+					continue
+				}
+			} else {
+				var dist uint32
+				if rd.bit(&isRepG1[state]) == 0 {
+					dist = rep1
+				} else {
+					if rd.bit(&isRepG2[state]) == 0 {
+						dist = rep2
+					} else {
+						dist = rep3
+						rep3 = rep2
+					}
+					rep2 = rep1
+				}
+				rep1 = rep0
+				rep0 = dist
+			}
+			length = repLenDecoder.decode(rd, posState)
+			if state < 7 {
+				state = 8
+			} else {
+				state = 11
+			}
+		} else {
+			rep3, rep2, rep1 = rep2, rep1, rep0
+			length = lenDecoder.decode(rd, posState)
+			if state < 7 {
+				state = 7
+			} else {
+				state = 10
+			}
+			rep0 = decodeDistance(length)
+			if rep0 == 0xffffffff {
+				break // End marker
+			}
+			if rep0 >= dictSize || int(rep0) >= n {
+				return ErrInvalidArchive
+			}
+		}
+
+		// Copy match (source and destination may overlap)
+		length += lzmaMatchMinLen
+		if int(length) > len(dst)-n {
+			return ErrInvalidArchive
+		}
+		for ; length > 0; length-- {
+			dst[n] = dst[n-int(rep0)-1]
+			n++
+		}
+	}
+
+	if n < len(dst) || rd.failed {
+		return ErrInvalidArchive
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// In-memory output of buffered Writers.
+
+package mpq
+
+import (
+	"errors"
+	"io"
+)
+
+// memBuffer is an in-memory io.WriteSeeker and io.ReaderAt, the output of buffered Writers.
+type memBuffer struct {
+	data []byte // Written data
+	pos  int64  // Current position
+}
+
+// Write implements io.Writer.
+func (b *memBuffer) Write(p []byte) (int, error) {
+	if n := int(b.pos) + len(p) - len(b.data); n > 0 {
+		b.data = append(b.data, make([]byte, n)...)
+	}
+	copy(b.data[b.pos:], p)
+	b.pos += int64(len(p))
+	return len(p), nil
+}
+
+// Seek implements io.Seeker.
+func (b *memBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+	b.pos = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *memBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -1,21 +1,50 @@
 // Implementation note:
-// In this implementation I read structs from the MPQ source field-by-field for efficiency
-// because fields are primitive types and binary.Read() is optimized for them
-// and hence no reflection will be applied.
+// In this implementation I read structs from the MPQ source into a buffer with a single read,
+// and decode them field-by-field for efficiency because fields are primitive types
+// and binary.Read() is optimized for them and hence no reflection will be applied.
+// Tables having many entries are decoded directly from the buffer (with binary.LittleEndian)
+// to avoid the cost of a call per field.
 
 package mpq
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
 	"encoding/binary"
 	"errors"
 	"io"
 	"os"
+	"sync"
 )
 
 var (
 	// ErrInvalidArchive indicates an invalid MPQ archive
 	ErrInvalidArchive = errors.New("Invalid MPQ Archive")
+
+	// ErrFileNotFound indicates that a file is not in the MPQ archive
+	ErrFileNotFound = errors.New("File not found in MPQ Archive")
+
+	// ErrEncryptionUnsupported indicates an encrypted file whose decryption key cannot be derived
+	// because its name is not known (e.g. it is read by hash or by block index)
+	ErrEncryptionUnsupported = errors.New("Unsupported encryption of MPQ file with unknown name")
+
+	// ErrCorruptSectorTable indicates a corrupt sector offset table of a file in the MPQ archive
+	ErrCorruptSectorTable = errors.New("Corrupt sector offset table in MPQ Archive")
+
+	// ErrFileDeleted indicates that a file is marked as deleted in the MPQ archive (by a deletion marker).
+	// This is used by patch archives to delete files present in lower-priority archives.
+	ErrFileDeleted = errors.New("File deleted in MPQ Archive")
+
+	// ErrMD5Mismatch indicates that the MD5 digest of the header or a table does not match the one stored in the header
+	ErrMD5Mismatch = errors.New("MD5 mismatch in MPQ Archive")
+
+	// ErrMemoryLimit indicates that an allocation required by the archive data exceeds the limit set by WithMaxMemory
+	ErrMemoryLimit = errors.New("MPQ memory limit exceeded")
+
+	// ErrHeaderOnly indicates that the tables of the MPQ archive are not available because it was opened
+	// with WithHeaderOnly
+	ErrHeaderOnly = errors.New("MPQ Archive opened with header only")
 )
 
 // blockEntry.flag bitmask constants.
@@ -23,6 +52,9 @@ const (
 	// Flag indicating that block is a file, and follows the file data format; otherwise, block is free space or unused.
 	beFlagFile = 0x80000000
 
+	// Flag indicating that the file is a deletion marker, indicating that the file no longer exists.
+	beFlagDeleteMarker = 0x02000000
+
 	// Flag indicating that file is stored as a single unit, rather than split into sectors.
 	beFlagSingle = 0x01000000
 
@@ -40,6 +72,9 @@ const (
 
 	// Flag indicating that the file is encrypted.
 	beFlagEncrypted = 0x00010000
+
+	// Flag indicating that the decryption key of the file is adjusted by the block offset and the file size.
+	beFlagFixKey = 0x00020000
 )
 
 // The User Data before the header of the MPQ archives.
@@ -66,8 +101,15 @@ type userData struct {
 
 	// The block to store user data in. It has a length of Size.
 	data []byte // User data
+
+	// Offset of the user data block in the input, used to read it on demand (see WithoutUserDataCopy).
+	dataOffset int64
 }
 
+// userDataCopyLimit is the maximum size of the user data which is read when the archive is opened,
+// bigger user data is read on demand.
+const userDataCopyLimit = 64 << 10
+
 // The header of the MPQ archives.
 //
 // The archive header is the first structure in the archive, at archive offset 0;
@@ -92,6 +134,8 @@ type header struct {
 	// MoPaQ format version. MPQAPI will not open archives where this is negative. Known versions:
 	//     0x0000 Original format. HeaderSize should be 20h, and large archives are not supported.
 	//     0x0001 Burning Crusade format. Header size should be 2Ch, and large archives are supported.
+	//     0x0002 Format version 3 (Cataclysm beta). Header size should be 44h.
+	//     0x0003 Format version 4 (Cataclysm). Header size should be D0h.
 	formatVersion uint16
 
 	// Power of two exponent specifying the number of 512-byte disk sectors in each logical sector
@@ -123,7 +167,27 @@ type header struct {
 	// High 16 bits of the block table offset for large archives.
 	blockTableOffsetHigh uint16
 
-	// Note: in FormatVersion > 1 there are further fields which I do not implement/use.
+	// Fields only present in format version 3 and later (FormatVersion > 1):
+
+	// 64-bit version of the archive size.
+	archiveSize64 uint64
+
+	// Offset to the beginning of the BET table, relative to the beginning of the archive.
+	betTableOffset uint64
+
+	// Offset to the beginning of the HET table, relative to the beginning of the archive.
+	hetTableOffset uint64
+
+	// Fields only present in format version 4 (FormatVersion > 2):
+
+	// Stored (compressed) sizes of the hash table, block table, extended block table, HET and BET tables.
+	hashTableSize64, blockTableSize64, extendedBlockTableSize64, hetTableSize64, betTableSize64 uint64
+
+	// Size of the chunks of raw data whose MD5 follows each file's data (0 if there are no such MD5s).
+	rawChunkSize uint32
+
+	// MD5 digests of the stored tables, and of the header itself (excluding this field).
+	blockTableMD5, hashTableMD5, extendedBlockTableMD5, betTableMD5, hetTableMD5, headerMD5 [16]byte
 }
 
 // Entries of the Hash table section of the MPQ archives.
@@ -213,27 +277,77 @@ type blockEntry struct {
 }
 
 // MPQ describes an MPQ archive and provides access to its content.
+//
+// Reading files (e.g. FileByName(), FileByHash(), Open()) and querying the archive is safe for concurrent use
+// by multiple goroutines: files are read through their own readers with independent positions
+// (inputs not implementing io.ReaderAt are read under a lock). Methods modifying the MPQ (e.g. AddNames()
+// and Close()) must not be called concurrently with other methods.
 type MPQ struct {
-	file  *os.File      // Optional source file
-	input io.ReadSeeker // Input data of the MPQ content
+	file   *os.File      // Optional source file
+	closer io.Closer     // Optional source to close other than file (e.g. a file of an fs.FS)
+	input  io.ReadSeeker // Input data of the MPQ content
+	memory []byte        // Content of the input if it is read into memory (see WithInMemory and NewFromReader), nil otherwise
 
-	userData *userData // Optional UserData
-	header   header    // MPQ Header
+	mapHeader *MapHeader // Optional Warcraft III map header
+	userData  *userData  // Optional UserData
+	header    header     // MPQ Header
 
 	hashTable  []hashEntry  // The Hash table
 	blockTable []blockEntry // The Block table
 
+	het *hetTable // Optional HET table
+	bet *betTable // Optional BET table
+
 	// The upper bits of the archive offsets for each block in the block table.
 	// Only present if the archive is > 4GB.
 	extBlockEntryHighOffsets []uint16
 
+	scanHeader bool // Tells if the header is to be searched for at 512-byte boundaries
+
+	names     []string             // Registered candidate file names
+	nameIndex map[[2]uint32]string // Known names by their hashes (2nd and 3rd), built on demand
+	dirFS     *FS                  // File system view used for directory listings, built on demand
+
+	attributes *Attributes // Parsed "(attributes)", parsed on demand
+
 	// Derived data
 
+	archiveOffset int64 // Offset of the archive (its header) in the input.
+
 	blockSize uint32 // Size of the blocks.
 
 	blockEntryIndices []int // Block table entry indices of the files.
 
+	fileIndices []uint32 // Number of files preceding the block table entries, indexed by the block table indices.
+
 	filesCount uint32 // Number of files in the archive.
+
+	// Options, see Option
+
+	strict         bool   // Tells if the archive is validated strictly
+	lazyTables     bool   // Tells if the tables are read on first use
+	headerOnly     bool   // Tells if the tables are never read
+	maxMemory      int64  // Maximum size of allocations driven by the archive data, 0 means no limit
+	locale         uint16 // Preferred locale of the files
+	hasLocale      bool   // Tells if a preferred locale is set
+	noUserDataCopy bool   // Tells if the user data is read on demand
+	logger         Logger // Receives debug messages, nil if not set
+	inMemoryLimit  int64  // Maximum size of files read into memory when opened, 0 means files are not read into memory
+
+	prefetch *prefetcher // State of prefetching, nil if not enabled
+
+	tablesOnce sync.Once // Used to read the tables once
+	tablesErr  error     // Error of reading the tables
+
+	inputLock       inputLock  // Serializes reading inputs not implementing io.ReaderAt, see fileInput()
+	sharedInputLock *inputLock // Lock of the input shared with the MPQ this was cloned from, see Clone()
+	cacheMu         sync.Mutex // Guards the data parsed on demand: the user data, the names index, the attributes and dirFS
+
+	warnings []Warning // Recoverable anomalies encountered while parsing
+
+	ioStats *IOStats // Counters of the input operations, nil if not enabled
+
+	spare tableBuffers // Buffers of the tables to be reused, see Reset()
 }
 
 // Magic bytes of the first optional MPQ section: UserData
@@ -245,7 +359,10 @@ var headerMagic = [4]byte{'M', 'P', 'Q', 0x1a}
 // NewFromFile returns a new MPQ using a file specified by its name as the input.
 // The returned MPQ must be closed with the Close method!
 // ErrInvalidArchive is returned if file exists and can be read, but is not a valid MPQ archive.
-func NewFromFile(name string) (*MPQ, error) {
+// Errors of parsing the archive are *ParseError values (matching ErrInvalidArchive with errors.Is()),
+// describing the structure and offset where parsing failed.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewFromFile(name string, opts ...Option) (*MPQ, error) {
 	var f *os.File
 	var err error
 	if f, err = os.Open(name); err != nil {
@@ -253,71 +370,164 @@ func NewFromFile(name string) (*MPQ, error) {
 	}
 
 	m := &MPQ{file: f, input: f}
+	m.applyOptions(opts)
+	if err = m.loadIntoMemory(); err != nil {
+		f.Close()
+		return nil, err
+	}
 
-	return m.diveIn()
+	if _, err = m.diveIn(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
 }
 
 // New returns a new MPQ using the specified io.ReadSeeker as the input source.
 // This can be used to create an MPQ out of a []byte with the help of bytes.NewReader(b []byte).
 // The returned MPQ must be closed with the Close method!
-// ErrInvalidArchive is returned if input is not a valid MPQ archive.
-func New(input io.ReadSeeker) (*MPQ, error) {
+// ErrInvalidArchive is returned if input is not a valid MPQ archive, see ParseError for details.
+// Options may be specified to tune how the archive is opened, see Option.
+func New(input io.ReadSeeker, opts ...Option) (*MPQ, error) {
 	m := &MPQ{input: input}
+	m.applyOptions(opts)
+
+	return m.diveIn()
+}
+
+// NewFromFileEmbedded returns a new MPQ using a file specified by its name as the input,
+// in which the archive may be embedded (e.g. in an installer executable).
+// If the input does not start with an archive, the archive is searched for at 512-byte boundaries like Storm does.
+// The returned MPQ must be closed with the Close method!
+// ErrInvalidArchive is returned if no valid MPQ archive is found in the file.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewFromFileEmbedded(name string, opts ...Option) (*MPQ, error) {
+	var f *os.File
+	var err error
+	if f, err = os.Open(name); err != nil {
+		return nil, err
+	}
+
+	m := &MPQ{file: f, input: f, scanHeader: true}
+	m.applyOptions(opts)
+
+	if _, err = m.diveIn(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewEmbedded returns a new MPQ using the specified io.ReadSeeker as the input source,
+// in which the archive may be embedded (e.g. in an installer executable).
+// If the input does not start with an archive, the archive is searched for at 512-byte boundaries like Storm does.
+// The returned MPQ must be closed with the Close method!
+// ErrInvalidArchive is returned if no valid MPQ archive is found in input.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewEmbedded(input io.ReadSeeker, opts ...Option) (*MPQ, error) {
+	m := &MPQ{input: input, scanHeader: true}
+	m.applyOptions(opts)
 
 	return m.diveIn()
 }
 
 // diveIn dives in into the archive data by parsing its header.
 func (m *MPQ) diveIn() (*MPQ, error) {
-	in := m.input
+	in := m.tableInput()
 
 	var err error
 
 	var magic [4]byte
 	if _, err = io.ReadFull(in, magic[:]); err != nil {
-		return nil, err
+		return nil, parseErr("header", 0, err)
 	}
 
+	// Structures are read into buffers with a single read each (so opening an archive takes only a few reads),
+	// and their fields are decoded from the buffers.
+	var buf *bytes.Reader
+	fill := func(size int) error {
+		data := make([]byte, size)
+		if _, err = io.ReadFull(in, data); err == nil {
+			buf = bytes.NewReader(data)
+		}
+		return err
+	}
 	read := func(data interface{}) error {
 		if err != nil {
 			return err // No-op if we already have an error
 		}
-		err = binary.Read(in, binary.LittleEndian, data)
+		err = binary.Read(buf, binary.LittleEndian, data)
 		return err
 	}
 
-	// Optionally the MPQ starts with a User Data section
+	// Warcraft III maps start with a map header, followed by the archive
 	var headerOffset int64
+	if magic == mapHeaderMagic {
+		if m.mapHeader, err = readMapHeader(in); err != nil {
+			return nil, parseErr("map header", 0, err)
+		}
+		headerOffset = mapHeaderSize
+		m.debugf("map header found, archive expected at offset %d", headerOffset)
+
+		// Read the Header's magic:
+		if _, err = io.ReadFull(in, magic[:]); err != nil {
+			return nil, parseErr("header", headerOffset, err)
+		}
+	}
+
+	// Optionally search for the archive at 512-byte boundaries (e.g. archives embedded in executables)
+	if m.scanHeader {
+		for magic != userDataMagic && magic != headerMagic {
+			headerOffset += 512
+			if _, err = in.Seek(headerOffset, 0); err != nil {
+				return nil, parseErr("header", headerOffset, err)
+			}
+			if _, err = io.ReadFull(in, magic[:]); err != nil {
+				return nil, parseErr("header", headerOffset, err)
+			}
+		}
+	}
+
+	// Optionally the MPQ starts with a User Data section
 	if magic == userDataMagic {
 		u := userData{}
+		fill(8)
 		read(&u.size)
 		read(&u.headerOffset)
-		if err == nil {
+		u.dataOffset = headerOffset + 12
+		if err == nil && !m.noUserDataCopy && u.size <= userDataCopyLimit {
+			if err = m.checkAlloc(uint64(u.size)); err != nil {
+				return nil, err
+			}
 			u.data = make([]byte, u.size)
 			_, err = io.ReadFull(in, u.data)
 		}
 		if err != nil {
-			return nil, ErrInvalidArchive
+			return nil, parseErr("user data", headerOffset, err)
 		}
 		m.userData = &u
+		m.debugf("user data at offset %d: size %d, header offset %d", headerOffset, u.size, u.headerOffset)
 
-		headerOffset = int64(u.headerOffset)
+		// The header offset is relative to the User Data section
+		headerOffset += int64(u.headerOffset)
 		if _, err = in.Seek(headerOffset, 0); err != nil { // Seek from start of the file
-			return nil, ErrInvalidArchive
+			return nil, parseErr("header", headerOffset, err)
 		}
 
 		// Magic was UserData magic, so read the Header's magic:
 		if _, err = io.ReadFull(in, magic[:]); err != nil {
-			return nil, err
+			return nil, parseErr("header", headerOffset, err)
 		}
 	}
 
 	// Check Header
 	if magic != headerMagic {
-		return nil, ErrInvalidArchive
+		m.debugf("no header magic at offset %d: %q", headerOffset, magic[:])
+		return nil, parseErr("header", headerOffset, ErrInvalidArchive)
 	}
 	h := header{}
 
+	fill(headerSizeV1 - 4)
 	read(&h.size)
 	read(&h.archiveSize)
 	read(&h.formatVersion)
@@ -328,7 +538,22 @@ func (m *MPQ) diveIn() (*MPQ, error) {
 	read(&h.blockTableEntries)
 
 	if err != nil {
-		return nil, ErrInvalidArchive
+		return nil, parseErr("header", headerOffset, err)
+	}
+
+	// Size of the fields of the format version
+	var size int
+	if h.formatVersion > 0 {
+		size += headerSizeV2 - headerSizeV1
+	}
+	if h.formatVersion > 1 && h.size >= headerSizeV3 {
+		size += headerSizeV3 - headerSizeV2
+	}
+	if h.formatVersion > 2 && h.size >= headerSizeV4 {
+		size += headerSizeV4 - headerSizeV3
+	}
+	if size > 0 {
+		fill(size)
 	}
 
 	if h.formatVersion > 0 {
@@ -337,94 +562,331 @@ func (m *MPQ) diveIn() (*MPQ, error) {
 		read(&h.blockTableOffsetHigh)
 	}
 
-	if err != nil {
-		return nil, ErrInvalidArchive
+	if h.formatVersion > 1 && h.size >= headerSizeV3 {
+		read(&h.archiveSize64)
+		read(&h.betTableOffset)
+		read(&h.hetTableOffset)
+	}
+
+	if h.formatVersion > 2 && h.size >= headerSizeV4 {
+		read(&h.hashTableSize64)
+		read(&h.blockTableSize64)
+		read(&h.extendedBlockTableSize64)
+		read(&h.hetTableSize64)
+		read(&h.betTableSize64)
+		read(&h.rawChunkSize)
+		read(&h.blockTableMD5)
+		read(&h.hashTableMD5)
+		read(&h.extendedBlockTableMD5)
+		read(&h.betTableMD5)
+		read(&h.hetTableMD5)
+		read(&h.headerMD5)
 	}
 
-	// Note: in FormatVersion > 1 there are further fields which I do not implement/use.
+	if err != nil {
+		return nil, parseErr("header", headerOffset, err)
+	}
 
 	m.header = h
+	m.archiveOffset = headerOffset
+	m.debugf("header at offset %d: size %d, format version %d, sector size shift %d, hash table at %d (%d entries), block table at %d (%d entries)",
+		headerOffset, h.size, h.formatVersion, h.sectorSizeShift, h.hashTableOffset, h.hashTableEntries, h.blockTableOffset, h.blockTableEntries)
 
 	m.blockSize = 512 << h.sectorSizeShift
+	if m.blockSize == 0 {
+		return nil, parseErr("header", headerOffset, ErrInvalidArchive) // Sector size shift is too large (overflow)
+	}
+	m.checkHeader()
+
+	if !m.lazyTables && !m.headerOnly {
+		if err = m.loadTables(); err != nil {
+			return nil, err
+		}
+	}
+	m.startPrefetch()
+
+	return m, nil
+}
+
+// loadTables reads the tables of the archive, if reading them has not yet been attempted.
+// The error of reading the tables is returned (also by subsequent calls).
+func (m *MPQ) loadTables() error {
+	m.tablesOnce.Do(func() {
+		if m.headerOnly {
+			m.tablesErr = ErrHeaderOnly
+			return
+		}
+		if m.tablesErr = m.readTables(); m.tablesErr != nil {
+			m.debugf("reading tables failed: %v", m.tablesErr)
+			m.hashTable, m.blockTable, m.extBlockEntryHighOffsets = nil, nil, nil
+		}
+	})
+	return m.tablesErr
+}
+
+// readTables reads the hash and block tables (or the HET and BET tables) of the archive,
+// and validates them if strict validation is enabled.
+func (m *MPQ) readTables() error {
+	h, headerOffset := &m.header, m.archiveOffset
+
+	// Read the HET and BET tables (format version 3 and later)
+	if h.hetTableOffset > 0 && h.betTableOffset > 0 {
+		m.readHetBetTables(headerOffset)
+	}
+
+	// Read the classic hash and block tables, or fall back to the HET and BET tables if they are absent or truncated
+	if err := m.readClassicTables(headerOffset); err != nil || h.hashTableEntries == 0 {
+		if err == ErrMemoryLimit {
+			return err
+		}
+		if m.het == nil {
+			if err == nil {
+				err = parseErr("hash table", headerOffset+int64(h.hashTableOffset), ErrInvalidArchive) // No entries
+			}
+			return err
+		}
+		m.debugf("classic tables unusable (%v), using the HET and BET tables", err)
+		m.hashTable = nil
+		m.blockTable = m.bet.blocks
+		m.extBlockEntryHighOffsets = m.bet.highOffsets
+	}
 
-	// Create a big-enough buffer that is enough to read further hash and block tables to avoid reallocation:
-	// Size of both hash and block entries is 16 bytes
-	var buf []byte
-	if h.hashTableEntries > h.blockTableEntries {
-		buf = make([]byte, h.hashTableEntries*16)
+	// Count valid files in the archive
+	if n := len(m.blockTable); cap(m.spare.blockEntryIndices) >= n && cap(m.spare.fileIndices) >= n {
+		m.blockEntryIndices, m.fileIndices = m.spare.blockEntryIndices[:n], m.spare.fileIndices[:n]
+		m.spare.blockEntryIndices, m.spare.fileIndices = nil, nil
 	} else {
-		buf = make([]byte, h.blockTableEntries*16)
+		m.blockEntryIndices, m.fileIndices = make([]int, n), make([]uint32, n)
+	}
+	for i := range m.blockEntryIndices {
+		m.fileIndices[i] = m.filesCount
+		if (m.blockTable[i].flags & beFlagFile) != 0 {
+			m.blockEntryIndices[m.filesCount] = i
+			m.filesCount++
+		}
+	}
+	m.checkTables()
+
+	if m.strict {
+		return m.validate()
+	}
+	return nil
+}
+
+// readClassicTables reads the hash table, the block table and the optional extended block table.
+// headerOffset is the offset of the archive in the input.
+func (m *MPQ) readClassicTables(headerOffset int64) error {
+	in, h := m.tableInput(), &m.header
+
+	hashTableOffset := int64(h.hashTableOffsetHigh)<<32 + int64(h.hashTableOffset)
+	blockTableOffset := int64(h.blockTableOffsetHigh)<<32 + int64(h.blockTableOffset)
+
+	// Stored sizes of the tables: only format version 4 records them.
+	// In format version 3, if the block table immediately follows the hash table (and the table data
+	// ends with the archive), a smaller distance means the table is compressed (StarCraft II).
+	// Older versions are not guessed: the archive size is often wrong in them (e.g. in protected maps).
+	hashTableSize, blockTableSize := h.hashTableSize64, h.blockTableSize64
+	guess := h.formatVersion >= uint16(FormatVersion3)
+	if guess && hashTableSize == 0 && blockTableOffset > hashTableOffset {
+		hashTableSize = uint64(blockTableOffset - hashTableOffset)
+	}
+	if guess && blockTableSize == 0 {
+		end := int64(h.archiveSize)
+		if h.archiveSize64 > 0 {
+			end = int64(h.archiveSize64)
+		}
+		if h.extendedBlockTableOffset > 0 {
+			end = int64(h.extendedBlockTableOffset)
+		}
+		if end > blockTableOffset {
+			blockTableSize = uint64(end - blockTableOffset)
+		}
+	}
+
+	if err := m.checkAlloc(uint64(h.hashTableEntries)*16 + uint64(h.blockTableEntries)*16); err != nil {
+		return err
 	}
 
+	m.debugf("hash table at offset %d: %d entries, stored size %d", hashTableOffset+headerOffset, h.hashTableEntries, hashTableSize)
+	m.debugf("block table at offset %d: %d entries, stored size %d", blockTableOffset+headerOffset, h.blockTableEntries, blockTableSize)
+
 	// Read Hash table
-	if _, err = in.Seek(int64(h.hashTableOffsetHigh)<<32+int64(h.hashTableOffset)+headerOffset, 0); err != nil {
-		return nil, ErrInvalidArchive
+	buf, err := readTable(in, hashTableOffset+headerOffset, h.hashTableEntries, hashTableSize, hashTableKey)
+	if err != nil {
+		return parseErr("hash table", hashTableOffset+headerOffset, err)
 	}
-	buf = buf[:h.hashTableEntries*16]
-	if _, err = io.ReadFull(in, buf); err != nil {
-		return nil, ErrInvalidArchive
+	if uint32(cap(m.spare.hashTable)) >= h.hashTableEntries {
+		m.hashTable, m.spare.hashTable = m.spare.hashTable[:h.hashTableEntries], nil
+	} else {
+		m.hashTable = make([]hashEntry, h.hashTableEntries)
 	}
-	// Decryption key of the hash table is the value of hashString("(hash table)", hashTypeFileKey)
-	decrypt(buf, 0xc3af3770)
-	m.hashTable = make([]hashEntry, h.hashTableEntries)
-	r := bytes.NewReader(buf)
 	for i := range m.hashTable {
-		he := &m.hashTable[i]
-		// Reading from a byte slice whose length is "confirmed", omitting error check
-		binary.Read(r, binary.LittleEndian, &he.filePathHashA)
-		binary.Read(r, binary.LittleEndian, &he.filePathHashB)
-		binary.Read(r, binary.LittleEndian, &he.language)
-		binary.Read(r, binary.LittleEndian, &he.platform)
-		binary.Read(r, binary.LittleEndian, &he.fileBlockIndex)
+		// The length of buf is "confirmed", entries are 16 bytes
+		b := buf[i*16 : i*16+16]
+		m.hashTable[i] = hashEntry{
+			filePathHashA:  binary.LittleEndian.Uint32(b),
+			filePathHashB:  binary.LittleEndian.Uint32(b[4:]),
+			language:       binary.LittleEndian.Uint16(b[8:]),
+			platform:       binary.LittleEndian.Uint16(b[10:]),
+			fileBlockIndex: binary.LittleEndian.Uint32(b[12:]),
+		}
 	}
 
 	// Read Block table
-	if _, err = in.Seek(int64(h.blockTableOffsetHigh)<<32+int64(h.blockTableOffset)+headerOffset, 0); err != nil {
-		return nil, ErrInvalidArchive
+	if buf, err = readTable(in, blockTableOffset+headerOffset, h.blockTableEntries, blockTableSize, blockTableKey); err != nil {
+		return parseErr("block table", blockTableOffset+headerOffset, err)
 	}
-	buf = buf[:h.blockTableEntries*16]
-	if _, err = io.ReadFull(in, buf); err != nil {
-		return nil, ErrInvalidArchive
+	if uint32(cap(m.spare.blockTable)) >= h.blockTableEntries {
+		m.blockTable, m.spare.blockTable = m.spare.blockTable[:h.blockTableEntries], nil
+	} else {
+		m.blockTable = make([]blockEntry, h.blockTableEntries)
 	}
-	// Decryption key of the block table is the value of hashString("(block table)", hashTypeFileKey)
-	decrypt(buf, 0xec83b3a3)
-	m.blockTable = make([]blockEntry, h.blockTableEntries)
-	r = bytes.NewReader(buf)
 	for i := range m.blockTable {
-		be := &m.blockTable[i]
-		// Reading from a byte slice whose length is "confirmed", omitting error check
-		binary.Read(r, binary.LittleEndian, &be.blockOffset)
-		binary.Read(r, binary.LittleEndian, &be.blockSize)
-		binary.Read(r, binary.LittleEndian, &be.fileSize)
-		binary.Read(r, binary.LittleEndian, &be.flags)
+		// The length of buf is "confirmed", entries are 16 bytes
+		b := buf[i*16 : i*16+16]
+		m.blockTable[i] = blockEntry{
+			blockOffset: binary.LittleEndian.Uint32(b),
+			blockSize:   binary.LittleEndian.Uint32(b[4:]),
+			fileSize:    binary.LittleEndian.Uint32(b[8:]),
+			flags:       binary.LittleEndian.Uint32(b[12:]),
+		}
 	}
 
 	// Regardless of the version the extended block is only present in archives > 4 GB
 	if h.extendedBlockTableOffset > 0 {
 		// Reads the extended block table entries from the input.
 		// We will probably not ever end up here in case of SC2Replay files.
-		if _, err = in.Seek(int64(h.extendedBlockTableOffset)+headerOffset, 0); err != nil {
-			return nil, ErrInvalidArchive
+		offset := int64(h.extendedBlockTableOffset) + headerOffset
+		if _, err = in.Seek(offset, 0); err != nil {
+			return parseErr("extended block table", offset, err)
+		}
+		buf = make([]byte, uint64(h.blockTableEntries)*2)
+		if _, err = io.ReadFull(in, buf); err != nil {
+			return parseErr("extended block table", offset, err)
 		}
 		m.extBlockEntryHighOffsets = make([]uint16, h.blockTableEntries)
 		for i := range m.extBlockEntryHighOffsets {
-			err = binary.Read(r, binary.LittleEndian, &m.extBlockEntryHighOffsets[i])
+			m.extBlockEntryHighOffsets[i] = binary.LittleEndian.Uint16(buf[i*2:])
 		}
-		if err != nil {
-			return nil, ErrInvalidArchive
+	}
+
+	return nil
+}
+
+// readTable reads an encrypted table of 16-byte entries (hash or block table) from the input at the given offset,
+// and returns the decrypted table data.
+// storedSize is the size of the table in the input; if it is less than the size of the entries,
+// the table is compressed (after decryption it is decompressed like a compressed sector).
+func readTable(in io.ReadSeeker, offset int64, entries uint32, storedSize uint64, key uint32) ([]byte, error) {
+	size := uint64(entries) * 16
+	if storedSize == 0 || storedSize > size {
+		storedSize = size
+	}
+
+	if _, err := in.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, storedSize)
+	if _, err := io.ReadFull(in, buf); err != nil {
+		return nil, err
+	}
+	decrypt(buf, key)
+
+	if storedSize == size {
+		return buf, nil
+	}
+	data := make([]byte, size)
+	if err := decompressMulti(data, buf); err != nil {
+		// The stored size may be guessed wrong, try the table uncompressed
+		return readTable(in, offset, entries, 0, key)
+	}
+	return data, nil
+}
+
+// readHetBetTables reads the HET and BET tables.
+// Errors are not reported (the tables are left nil, and a warning is recorded), the tables are only used
+// if the classic hash and block tables are unusable.
+// headerOffset is the offset of the archive in the input.
+func (m *MPQ) readHetBetTables(headerOffset int64) {
+	h, in := &m.header, m.tableInput()
+	hetOffset, betOffset := int64(h.hetTableOffset)+headerOffset, int64(h.betTableOffset)+headerOffset
+
+	// Sizes of the tables are only recorded in format version 4, else they are bound by the archive
+	maxSize := func(storedSize, offset uint64) uint64 {
+		if storedSize > 0 {
+			return storedSize
+		}
+		if size := m.ArchiveSize(); size > offset {
+			return size - offset
 		}
+		return 0
 	}
 
-	// Count valid files in the archive
-	m.blockEntryIndices = make([]int, h.blockTableEntries)
-	for i := range m.blockEntryIndices {
-		if (m.blockTable[i].flags & beFlagFile) != 0 {
-			m.blockEntryIndices[m.filesCount] = i
-			m.filesCount++
+	data, err := m.readExtTable(in, hetOffset, hetTableSignature, hashTableKey, maxSize(h.hetTableSize64, h.hetTableOffset))
+	if err != nil {
+		m.warn("HET table", hetOffset, "unreadable: %v", err)
+		return
+	}
+	het, err := parseHetTable(data)
+	if err != nil {
+		m.warn("HET table", hetOffset, "unreadable: %v", err)
+		return
+	}
+	if data, err = m.readExtTable(in, betOffset, betTableSignature, blockTableKey, maxSize(h.betTableSize64, h.betTableOffset)); err != nil {
+		m.warn("BET table", betOffset, "unreadable: %v", err)
+		return
+	}
+	bet, err := m.parseBetTable(data)
+	if err != nil {
+		m.warn("BET table", betOffset, "unreadable: %v", err)
+		return
+	}
+	m.het, m.bet = het, bet
+	m.debugf("HET table at offset %d and BET table at offset %d read", hetOffset, betOffset)
+}
+
+// VerifyMD5 verifies the MD5 digests of the header and the tables stored in the header
+// of format version 4 archives against the data read from the input.
+// ErrMD5Mismatch is returned if a digest does not match.
+// Archives of older format versions have no such digests, in which case nil is returned.
+func (m *MPQ) VerifyMD5() error {
+	h := &m.header
+	if h.formatVersion < 3 || h.size < headerSizeV4 {
+		return nil
+	}
+
+	base := m.archiveOffset
+
+	checks := []struct {
+		offset int64
+		size   uint64
+		digest [16]byte
+	}{
+		{0, headerSizeV4 - md5.Size, h.headerMD5},
+		{int64(h.hashTableOffsetHigh)<<32 + int64(h.hashTableOffset), h.hashTableSize64, h.hashTableMD5},
+		{int64(h.blockTableOffsetHigh)<<32 + int64(h.blockTableOffset), h.blockTableSize64, h.blockTableMD5},
+		{int64(h.extendedBlockTableOffset), h.extendedBlockTableSize64, h.extendedBlockTableMD5},
+		{int64(h.hetTableOffset), h.hetTableSize64, h.hetTableMD5},
+		{int64(h.betTableOffset), h.betTableSize64, h.betTableMD5},
+	}
+	in := m.tableInput()
+	for _, c := range checks {
+		if c.size == 0 || c.digest == [16]byte{} {
+			continue // Table not present or digest not stored
+		}
+		if _, err := in.Seek(base+c.offset, 0); err != nil {
+			return ErrInvalidArchive
+		}
+		hash := md5.New()
+		if _, err := io.CopyN(hash, in, int64(c.size)); err != nil {
+			return ErrInvalidArchive
+		}
+		if !bytes.Equal(hash.Sum(nil), c.digest[:]) {
+			return ErrMD5Mismatch
 		}
 	}
 
-	return m, nil
+	return nil
 }
 
 // SrcFile returns the optional source file of the MPQ.
@@ -435,50 +897,281 @@ func (m *MPQ) SrcFile() *os.File {
 
 // Input returns the input source of the MPQ content.
 // A non-nil result is returned even if the MPQ is constructed from a file.
+// If the input does not implement io.ReaderAt, its position is tracked by the MPQ (redundant seeks are skipped),
+// so it must not be read or seeked while the MPQ is in use. Parts of the input are buffered,
+// so its content must not change either.
 func (m *MPQ) Input() io.ReadSeeker {
 	return m.input
 }
 
 // UserData returns the optional data that precedes the MPQ header.
+//
+// If the archive was opened with WithoutUserDataCopy, or the user data is bigger than 64 KB, the user data
+// is read from the input on the first call (and nil is returned if reading it fails).
+// Use UserDataReader() to read parts of big user data without reading it whole.
 func (m *MPQ) UserData() []byte {
 	if m.userData == nil {
 		return nil
 	}
-	return m.userData.data
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	u := m.userData
+	if u.data == nil && u.size > 0 {
+		if m.checkAlloc(uint64(u.size)) != nil {
+			return nil
+		}
+		in := m.fileInput()
+		if _, err := in.Seek(u.dataOffset, 0); err != nil {
+			return nil
+		}
+		data := make([]byte, u.size)
+		if _, err := io.ReadFull(in, data); err != nil {
+			return nil
+		}
+		u.data = data
+	}
+	return u.data
+}
+
+// UserDataSize returns the size of the optional data that precedes the MPQ header, 0 if there is none.
+// The user data is not read.
+func (m *MPQ) UserDataSize() uint32 {
+	if m.userData == nil {
+		return 0
+	}
+	return m.userData.size
+}
+
+// UserDataReader returns a reader of the optional data that precedes the MPQ header,
+// nil if there is none. The user data is read on demand (unless it was read already),
+// and the reader also implements io.ReaderAt to read parts of it at arbitrary offsets.
+// Multiple readers may be used concurrently.
+func (m *MPQ) UserDataReader() *io.SectionReader {
+	if m.userData == nil {
+		return nil
+	}
+	m.cacheMu.Lock()
+	data := m.userData.data
+	m.cacheMu.Unlock()
+
+	if data != nil {
+		return io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))
+	}
+	return io.NewSectionReader(m.inputAt(), m.userData.dataOffset, int64(m.userData.size))
 }
 
 // FilesCount returns the number of files in the archive.
 func (m *MPQ) FilesCount() uint32 {
+	m.loadTables()
 	return m.filesCount
 }
 
+// FormatVersion returns the format version of the archive.
+func (m *MPQ) FormatVersion() FormatVersion {
+	return FormatVersion(m.header.formatVersion)
+}
+
+// HeaderSize returns the size of the archive header.
+func (m *MPQ) HeaderSize() uint32 {
+	return m.header.size
+}
+
+// SectorSize returns the size of the sectors (blocks) the files are split into.
+func (m *MPQ) SectorSize() uint32 {
+	return m.blockSize
+}
+
+// ArchiveSize returns the size of the archive as recorded in the header
+// (the 64-bit size of format version 3 and later if present).
+func (m *MPQ) ArchiveSize() uint64 {
+	if m.header.archiveSize64 != 0 {
+		return m.header.archiveSize64
+	}
+	return uint64(m.header.archiveSize)
+}
+
+// HashTableEntries returns the number of entries in the hash table as recorded in the header.
+func (m *MPQ) HashTableEntries() uint32 {
+	return m.header.hashTableEntries
+}
+
+// BlockTableEntries returns the number of entries in the block table as recorded in the header.
+func (m *MPQ) BlockTableEntries() uint32 {
+	return m.header.blockTableEntries
+}
+
+// ArchiveOffset returns the offset of the archive (its header) in the input,
+// e.g. non-zero if the archive is preceded by User Data.
+func (m *MPQ) ArchiveOffset() int64 {
+	return m.archiveOffset
+}
+
 // FileByName returns the content of a file specified by its name from the archive.
+// The content of incremental patch files (see FlagPatchFile) is the PTCH file, see ApplyPatch().
 //
-// nil slice and nil error is returned if the file cannot be found.
-// ErrInvalidArchive is returned if the file exists but the storing method of the file
-// is not supported/implemented or some error occurs.
+// ErrFileNotFound is returned if the file cannot be found.
+// ErrFileDeleted is returned if the file is marked as deleted (by a deletion marker).
+// ErrEncryptionUnsupported is returned if the file is encrypted and its name is not known.
+// ErrCompressionUnsupported is returned if the compression method of the file is not supported.
+// ErrCorruptSectorTable is returned if the sector offset table of the file is corrupt.
+// ErrInvalidArchive is returned if some other error occurs.
+// The errors may be checked with errors.Is(): errors of parsing the sector offset table or the sectors
+// are *ParseError values wrapping the underlying error (e.g. ErrCorruptSectorTable or an I/O error).
 //
 // Implementation note: this method returns:
 //
 //     MPQ.FileByHash(FileNameHash(name))
 //
 // If you need to call this frequently, it's profitable to store the hashes returned by
-// FileNameHash(), and call MPQ.FileByHash() directly passing the stored hashes.
+// FileNameHash(), and call MPQ.FileByHash() directly passing the stored hashes
+// (or store them in a HashIndex, and call MPQ.FileByHashStruct()).
+//
+// If the archive has no usable hash table (only HET and BET tables), the file is looked up
+// in the HET table (which requires the name).
 func (m *MPQ) FileByName(name string) ([]byte, error) {
-	return m.FileByHash(FileNameHash(name))
+	if data := m.takePrefetched(name); data != nil {
+		return data, nil
+	}
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	idx := m.blockIndexByName(name)
+	if idx < 0 {
+		return nil, ErrFileNotFound
+	}
+	return m.readFile(idx, name)
 }
 
 // FileByHash returns the content of a file specified by hashes of its name from the archive.
 // The required hashes of a name can be acquired using the FileNameHash() function.
 //
-// nil slice and nil error is returned if the file cannot be found.
-// ErrInvalidArchive is returned if the file exists but the storing method of the file
-// is not supported/implemented or some error occurs.
+// Returned errors are the same as those of FileByName().
+//
+// Archives without a usable hash table (only HET and BET tables) can only be accessed with FileByName().
+// Encrypted files can also only be read with FileByName(), as the decryption key is derived from the file name.
+//
+// If the archive holds multiple locale variants of the file, the first one found is returned;
+// use FileByHashLocale() to select a specific variant.
+//
+// Looking up the file (probing the hash table and resolving its block) does not allocate,
+// allocations are only made to read the content (use FileByNameInto() to read into a reused buffer).
 func (m *MPQ) FileByHash(h1, h2, h3 uint32) ([]byte, error) {
-	hashTableEntries := m.header.hashTableEntries
-	var counter uint32
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	idx := m.blockIndexByHash(h1, h2, h3)
+	if idx < 0 {
+		return nil, ErrFileNotFound
+	}
+	return m.readFile(idx, "")
+}
+
+// FileByNameInto is like FileByName, but the content of the file is read into dst (reslicing it)
+// if its capacity is sufficient, else into a newly allocated slice. Returns the slice holding the content,
+// which may be passed as dst to subsequent calls, avoiding allocations when reading many files.
+//
+// Returned errors are the same as those of FileByName(). If an error is returned, the content of dst
+// may be overwritten partially.
+func (m *MPQ) FileByNameInto(dst []byte, name string) ([]byte, error) {
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	idx := m.blockIndexByName(name)
+	if idx < 0 {
+		return nil, ErrFileNotFound
+	}
+	return m.readFileContext(context.Background(), dst, idx, name)
+}
+
+// blockIndexByName returns the block table index of the file specified by its name, -1 if it cannot be found.
+// If the archive has no usable hash table, the file is looked up in the HET table.
+func (m *MPQ) blockIndexByName(name string) int {
+	_, blockIndex := m.lookupName(name)
+	return blockIndex
+}
+
+// blockIndexByHash returns the block table index of the file specified by hashes of its name,
+// -1 if it cannot be found.
+func (m *MPQ) blockIndexByHash(h1, h2, h3 uint32) int {
+	_, blockIndex := m.lookupHash(h1, h2, h3)
+	return blockIndex
+}
+
+// lookupName looks up the file specified by its name, and returns the indices of its hash table
+// and block table entries, -1 if the file cannot be found.
+// If the archive has no usable hash table, the file is looked up in the HET table,
+// in which case the returned hash table index is always -1.
+func (m *MPQ) lookupName(name string) (hashIndex, blockIndex int) {
+	m.loadTables()
+	if m.hashTable == nil && m.het != nil {
+		idx := m.het.lookup(m.bet, name)
+		if idx < 0 || m.blockTable[idx].flags&beFlagFile == 0 {
+			return -1, -1
+		}
+		return -1, idx
+	}
+	h1, h2, h3 := FileNameHash(name)
+	hashIndex, blockIndex = m.lookupHash(h1, h2, h3)
+	if m.logger != nil {
+		m.debugf("file %q: hashes %08x %08x %08x, hash table index %d, block table index %d", name, h1, h2, h3, hashIndex, blockIndex)
+	}
+	return
+}
 
-	for i := h1 & (hashTableEntries - 1); ; i++ {
+// lookupHash looks up the file specified by hashes of its name in the hash table,
+// and returns the indices of its hash table and block table entries, -1 if the file cannot be found.
+//
+// If a preferred locale is set (see WithLocale), the variant with that locale is looked up first,
+// then the language-neutral variant, then the first variant found.
+func (m *MPQ) lookupHash(h1, h2, h3 uint32) (hashIndex, blockIndex int) {
+	hashIndex, blockIndex = -1, -1
+	if m.hasLocale {
+		neutralHashIndex, neutralBlockIndex := -1, -1
+		m.probeHash(h1, h2, h3, func(i int) bool {
+			he := m.hashTable[i]
+			idx := m.hashEntryBlockIndex(he)
+			if idx < 0 {
+				return true
+			}
+			switch {
+			case he.language == m.locale:
+				hashIndex, blockIndex = i, idx
+				return false
+			case he.language == LocaleNeutral && neutralBlockIndex < 0:
+				neutralHashIndex, neutralBlockIndex = i, idx
+			}
+			return true
+		})
+		if blockIndex < 0 && neutralBlockIndex >= 0 {
+			return neutralHashIndex, neutralBlockIndex
+		}
+		if blockIndex >= 0 {
+			return
+		}
+	}
+
+	m.probeHash(h1, h2, h3, func(i int) bool {
+		if idx := m.hashEntryBlockIndex(m.hashTable[i]); idx >= 0 {
+			hashIndex, blockIndex = i, idx
+		}
+		return false
+	})
+	return
+}
+
+// probeHash calls fn with the index of each hash table entry matching the hashes of a file name,
+// in probing order, until fn returns false or the search terminates.
+// The search terminates at an empty entry, or after all entries are probed (the hash table may be full
+// or crafted to have no empty entries).
+func (m *MPQ) probeHash(h1, h2, h3 uint32, fn func(hashIndex int) bool) {
+	m.loadTables()
+	hashTableEntries := uint32(len(m.hashTable))
+	if hashTableEntries == 0 {
+		return
+	}
+
+	for i, probes := h1&(hashTableEntries-1), uint32(0); probes < hashTableEntries; i, probes = i+1, probes+1 {
 		if i == hashTableEntries {
 			i = 0
 		}
@@ -486,7 +1179,7 @@ func (m *MPQ) FileByHash(h1, h2, h3 uint32) ([]byte, error) {
 		hashEntry := m.hashTable[i]
 		if hashEntry.fileBlockIndex == 0xffffffff {
 			// Indicates that the hash table entry is empty, and has always been empty. Terminates search for a given file.
-			break
+			return
 		}
 
 		if hashEntry.filePathHashA != h2 || hashEntry.filePathHashB != h3 {
@@ -495,137 +1188,298 @@ func (m *MPQ) FileByHash(h1, h2, h3 uint32) ([]byte, error) {
 
 		// FOUND!
 
-		for j := uint32(0); j < hashEntry.fileBlockIndex; j++ {
-			if m.blockTable[j].flags&beFlagFile == 0 {
-				counter++
-			}
+		if !fn(int(i)) {
+			return
 		}
+	}
+}
 
-		// File index:
-		fileIndex := hashEntry.fileBlockIndex - counter
-		if fileIndex < 0 || fileIndex >= m.filesCount {
-			return nil, nil
-		}
+// hashEntryBlockIndex returns the block table index of the file referenced by a hash table entry,
+// -1 if the entry does not reference a file.
+func (m *MPQ) hashEntryBlockIndex(hashEntry hashEntry) int {
+	if hashEntry.fileBlockIndex >= uint32(len(m.blockTable)) {
+		return -1 // Empty or deleted entry, or invalid block index
+	}
+
+	// File index:
+	fileIndex := m.fileIndices[hashEntry.fileBlockIndex]
+	if fileIndex >= m.filesCount {
+		return -1
+	}
+
+	return m.blockEntryIndices[fileIndex]
+}
 
-		blockEntryIndex := m.blockEntryIndices[fileIndex]
-		// The block containing the file
-		blockEntry := m.blockTable[blockEntryIndex]
+// readFile reads and returns the content of the file stored in the block specified by its block table index.
+// name is the file name, used to decrypt encrypted files; it may be empty if unknown.
+func (m *MPQ) readFile(blockEntryIndex int, name string) ([]byte, error) {
+	return m.readFileContext(context.Background(), nil, blockEntryIndex, name)
+}
 
-		var blockOffsetBase = int64(blockEntry.blockOffset)
-		if m.extBlockEntryHighOffsets != nil {
-			blockOffsetBase += int64(m.extBlockEntryHighOffsets[blockEntryIndex]) << 32
+// readFileContext reads and returns the content of the file stored in the block specified by its block table index.
+// The content is read into dst if its capacity is sufficient, else into a newly allocated slice.
+// name is the file name, used to decrypt encrypted files; it may be empty if unknown.
+// The error of ctx is returned if ctx is done before the file is read.
+func (m *MPQ) readFileContext(ctx context.Context, dst []byte, blockEntryIndex int, name string) ([]byte, error) {
+	in := m.fileInput()
+	if ctx.Done() != nil {
+		in = &ctxReader{ctx: ctx, rs: in}
+	}
+	sr, err := m.newSectorReader(in, blockEntryIndex, name)
+	if err != nil {
+		return nil, err
+	}
+	defer sr.release()
+
+	var content []byte
+	if dst != nil && uint64(cap(dst)) >= uint64(sr.blockEntry.fileSize) {
+		content = dst[:sr.blockEntry.fileSize]
+	} else {
+		if err = m.checkAlloc(uint64(sr.blockEntry.fileSize)); err != nil {
+			return nil, err
 		}
-		if m.userData != nil {
-			blockOffsetBase += int64(m.userData.headerOffset)
+		content = make([]byte, sr.blockEntry.fileSize)
+	}
+	var contentIndex uint32
+	for k := uint32(0); k < sr.sectorsCount; k++ {
+		if err = ctx.Err(); err != nil {
+			return nil, err
 		}
+		unpackedSize := sr.sectorSize(k)
+		if err = sr.readSector(k, content[contentIndex:contentIndex+unpackedSize]); err != nil {
+			return nil, err
+		}
+		contentIndex += unpackedSize
+	}
 
-		var blocksCount uint32
-		if blockEntry.flags&beFlagSingle != 0 {
-			blocksCount = 1
-		} else {
-			blocksCount = (blockEntry.fileSize + m.blockSize - 1) / m.blockSize
+	return content, nil
+}
+
+// sectorReader reads the sectors of a file.
+type sectorReader struct {
+	m  *MPQ
+	in io.ReadSeeker // Input to read the sectors from
+
+	blockEntry blockEntry // Block entry of the file
+
+	encrypted bool   // Tells if the file is encrypted
+	key       uint32 // Decryption key of the file
+
+	blockOffsetBase int64 // Offset of the file's block in the input
+
+	sectorsCount       uint32   // Number of sectors of the file (0 for empty files)
+	packedBlockOffsets []uint32 // Offsets of the sectors, relative to the file's block
+	inBuffer           []byte   // Buffer of the stored data of a sector, reused between sectors
+
+	pooledOffsets *[]uint32 // Pooled slice of packedBlockOffsets, see release()
+	pooledIn      *[]byte   // Pooled slice of inBuffer, see release()
+}
+
+// release puts the buffers of the sector reader into the pools. The sector reader must not be used afterwards.
+func (sr *sectorReader) release() {
+	putUint32s(sr.pooledOffsets)
+	putBytes(sr.pooledIn)
+	sr.pooledOffsets, sr.pooledIn = nil, nil
+	sr.packedBlockOffsets, sr.inBuffer = nil, nil
+}
+
+// newSectorReader returns a sectorReader for the file stored in the block specified by its block table index.
+// name is the file name, used to decrypt encrypted files; it may be empty if unknown.
+// The sector offset table of the file is read from in (which is m.input or a wrapper of it).
+func (m *MPQ) newSectorReader(in io.ReadSeeker, blockEntryIndex int, name string) (*sectorReader, error) {
+	// The block containing the file
+	blockEntry := m.blockTable[blockEntryIndex]
+
+	if blockEntry.flags&beFlagDeleteMarker != 0 {
+		return nil, ErrFileDeleted
+	}
+	sr := &sectorReader{m: m, in: in, blockEntry: blockEntry}
+	if blockEntry.fileSize == 0 {
+		return sr, nil // Empty file: there's nothing to read, not even a sector offset table
+	}
+	if blockEntry.blockSize == 0 {
+		return nil, ErrInvalidArchive // No stored data for a non-empty file
+	}
+
+	sr.encrypted = blockEntry.flags&beFlagEncrypted != 0
+	if sr.encrypted {
+		if name == "" {
+			return nil, ErrEncryptionUnsupported // Decryption key cannot be derived without the file name
 		}
-		// Create a packed block offset table
-		// 1 entry for each block + 1 extra + 1 extra if FLAG_EXTRA is 1
-		temp := blocksCount + 1
-		if blockEntry.flags&beFlagExtra != 0 {
-			temp++
+		// If beFlagFixKey is set, the key is adjusted by the block offset which is relative to the archive
+		// (not to the input, so it's not affected by the user data), and only its lower 32 bits are used.
+		sr.key = fileKey(name, blockEntry.blockOffset, blockEntry.fileSize, blockEntry.flags)
+	}
+
+	sr.blockOffsetBase = m.archiveOffset + int64(blockEntry.blockOffset)
+	if m.extBlockEntryHighOffsets != nil {
+		sr.blockOffsetBase += int64(m.extBlockEntryHighOffsets[blockEntryIndex]) << 32
+	}
+
+	if blockEntry.flags&uint32(FlagPatchFile) != 0 {
+		// The patch info precedes the data of patch files, the content is the PTCH file of the size recorded in it
+		length, dataSize, err := readPatchInfo(in, sr.blockOffsetBase, blockEntry.blockSize)
+		if err != nil {
+			return nil, parseErr("patch info", sr.blockOffsetBase, err)
 		}
-		packedBlockOffsets := make([]uint32, temp)
+		sr.blockOffsetBase += int64(length)
+		blockEntry.blockSize -= length
+		blockEntry.fileSize = dataSize
+		sr.blockEntry = blockEntry
+	}
 
-		var err error
-		in := m.input
+	// Data of uncompressed files is stored as-is
+	if blockEntry.flags&beFlagCompressed == 0 && blockEntry.blockSize < blockEntry.fileSize {
+		return nil, parseErr("sector offset table", sr.blockOffsetBase, ErrCorruptSectorTable)
+	}
 
-		if blockEntry.flags&beFlagCompressed != 0 && blockEntry.flags&beFlagSingle == 0 {
-			// We need to load the packed block offset table, we will maintain this table for unpacked files too.
-			if _, err = in.Seek(blockOffsetBase, 0); err != nil {
-				return nil, ErrInvalidArchive
-			}
-			for k := range packedBlockOffsets {
-				err = binary.Read(in, binary.LittleEndian, &packedBlockOffsets[k])
-			}
-			if err != nil {
-				return nil, ErrInvalidArchive
-			}
+	var blocksCount uint32
+	if blockEntry.flags&beFlagSingle != 0 {
+		blocksCount = 1
+		// The single sector is as big as the file
+		if err := m.checkAlloc(uint64(blockEntry.fileSize)); err != nil {
+			return nil, err
+		}
+	} else {
+		blocksCount = (blockEntry.fileSize + m.blockSize - 1) / m.blockSize
+	}
+	sr.sectorsCount = blocksCount
+	// Create a packed block offset table
+	// 1 entry for each block + 1 extra + 1 extra if FLAG_EXTRA is 1
+	temp := blocksCount + 1
+	if blockEntry.flags&beFlagExtra != 0 {
+		temp++
+	}
+	if err := m.checkAlloc(uint64(temp) * 4); err != nil {
+		return nil, err
+	}
+	sr.pooledOffsets = getUint32s(int(temp))
+	packedBlockOffsets := *sr.pooledOffsets
+	sr.packedBlockOffsets = packedBlockOffsets
+	if m.logger != nil {
+		m.debugf("block %d at offset %d: stored size %d, file size %d, flags %#08x, %d sectors",
+			blockEntryIndex, sr.blockOffsetBase, blockEntry.blockSize, blockEntry.fileSize, blockEntry.flags, blocksCount)
+	}
 
-			// Decryption would take place here
-			if blockEntry.flags&beFlagEncrypted != 0 {
-				return nil, ErrInvalidArchive // Decryption of packed block offset table is not yet implemented!
+	if blockEntry.flags&beFlagCompressed != 0 && blockEntry.flags&beFlagSingle == 0 {
+		// We need to load the packed block offset table, we will maintain this table for unpacked files too.
+		if _, err := in.Seek(sr.blockOffsetBase, 0); err != nil {
+			return nil, parseErr("sector offset table", sr.blockOffsetBase, err)
+		}
+		pbuf := getBytes(len(packedBlockOffsets) * 4)
+		defer putBytes(pbuf)
+		buf := *pbuf
+		if _, err := io.ReadFull(in, buf); err != nil {
+			return nil, parseErr("sector offset table", sr.blockOffsetBase, err)
+		}
+
+		// The packed block offset table is encrypted with the file key - 1
+		if sr.encrypted {
+			decrypt(buf, sr.key-1)
+		}
+		for k := range packedBlockOffsets {
+			packedBlockOffsets[k] = binary.LittleEndian.Uint32(buf[k*4:])
+		}
+	} else {
+		if blockEntry.flags&beFlagSingle == 0 {
+			for k := uint32(0); k < blocksCount; k++ {
+				packedBlockOffsets[k] = k * m.blockSize
 			}
+			packedBlockOffsets[blocksCount] = blockEntry.blockSize
 		} else {
-			if blockEntry.flags&beFlagSingle == 0 {
-				for k := uint32(0); k < blocksCount; k++ {
-					packedBlockOffsets[k] = k * m.blockSize
-				}
-				packedBlockOffsets[blocksCount] = blockEntry.blockSize
-			} else {
-				packedBlockOffsets[0] = 0
-				packedBlockOffsets[1] = blockEntry.blockSize
-			}
+			packedBlockOffsets[0] = 0
+			packedBlockOffsets[1] = blockEntry.blockSize
 		}
+	}
 
-		content := make([]byte, blockEntry.fileSize)
-		var contentIndex uint32
+	// Sectors must follow each other within the block
+	for k := uint32(0); k < blocksCount; k++ {
+		if packedBlockOffsets[k] > packedBlockOffsets[k+1] {
+			return nil, parseErr("sector offset table", sr.blockOffsetBase, ErrCorruptSectorTable)
+		}
+	}
+	if packedBlockOffsets[blocksCount] > blockEntry.blockSize {
+		return nil, parseErr("sector offset table", sr.blockOffsetBase, ErrCorruptSectorTable)
+	}
 
-		var inBuffer []byte
-		for k := uint32(0); k < blocksCount; k++ {
-			// Unpacked size of the block
-			var unpackedSize uint32
-			if blockEntry.flags&beFlagSingle != 0 {
-				unpackedSize = blockEntry.fileSize
-			} else if k < blocksCount-1 {
-				unpackedSize = m.blockSize
-			} else {
-				unpackedSize = blockEntry.fileSize - m.blockSize*k
-			}
+	return sr, nil
+}
 
-			// Read block
-			inSize := int(packedBlockOffsets[k+1] - packedBlockOffsets[k])
-			if _, err = in.Seek(blockOffsetBase+int64(packedBlockOffsets[k]), 0); err != nil {
-				return nil, ErrInvalidArchive
-			}
+// sectorSize returns the unpacked size of the sector specified by its index.
+func (sr *sectorReader) sectorSize(k uint32) uint32 {
+	if sr.blockEntry.flags&beFlagSingle != 0 {
+		return sr.blockEntry.fileSize
+	} else if k < sr.sectorsCount-1 {
+		return sr.m.blockSize
+	}
+	return sr.blockEntry.fileSize - sr.m.blockSize*k
+}
 
-			// Reuse previous inBuffer if big enough:
-			if cap(inBuffer) >= inSize {
-				inBuffer = inBuffer[:inSize]
-			} else {
-				inBuffer = make([]byte, inSize)
-			}
-			if _, err = io.ReadFull(in, inBuffer); err != nil {
-				return nil, ErrInvalidArchive
-			}
+// readSector reads, decrypts and decompresses the sector specified by its index into dst,
+// whose length must be the unpacked size of the sector (see sectorSize()).
+func (sr *sectorReader) readSector(k uint32, dst []byte) error {
+	blockEntry, in := sr.blockEntry, sr.in
 
-			// Check encryption (decryption would take place here)
-			if blockEntry.flags&beFlagEncrypted != 0 {
-				return nil, ErrInvalidArchive // Decryption of packed data block is not yet implemented!
-			}
-			// Check compression
-			if blockEntry.flags&beFlagCompressedMulti != 0 {
-				// Decompress block
-				if err = decompressMulti(content[contentIndex:contentIndex+unpackedSize], inBuffer); err != nil {
-					return nil, err
-				}
-			} else if blockEntry.flags&beFlagPKWare != 0 { // Check implosion
-				// Explode block
-				return nil, ErrInvalidArchive // Explosion of data block is not yet implemented!
-			} else {
-				// Copy block
-				copy(content[contentIndex:], inBuffer)
-			}
+	// Read block
+	inSize := int(sr.packedBlockOffsets[k+1] - sr.packedBlockOffsets[k])
+	offset := sr.blockOffsetBase + int64(sr.packedBlockOffsets[k])
+	if _, err := in.Seek(offset, 0); err != nil {
+		return parseErr("sector", offset, err)
+	}
 
-			contentIndex += unpackedSize
+	// Reuse previous inBuffer if big enough:
+	if cap(sr.inBuffer) >= inSize {
+		sr.inBuffer = sr.inBuffer[:inSize]
+	} else {
+		if err := sr.m.checkAlloc(uint64(inSize)); err != nil {
+			return err
 		}
-
-		return content, nil
+		putBytes(sr.pooledIn)
+		sr.pooledIn = getBytes(inSize)
+		sr.inBuffer = *sr.pooledIn
+	}
+	inBuffer := sr.inBuffer
+	if _, err := io.ReadFull(in, inBuffer); err != nil {
+		return parseErr("sector", offset, err)
 	}
 
-	return nil, nil
+	// Check encryption, blocks are encrypted with the file key + block index
+	if sr.encrypted {
+		decrypt(inBuffer, sr.key+k)
+	}
+	// Check compression
+	if blockEntry.flags&beFlagCompressedMulti != 0 {
+		// Decompress block
+		err := decompressMulti(dst, inBuffer)
+		if sr.m.logger != nil {
+			var c Compression
+			if inSize < len(dst) {
+				c = Compression(inBuffer[0])
+			}
+			sr.m.debugf("sector %d at offset %d: %d bytes decompressed to %d bytes, compression %#02x, error: %v", k, offset, inSize, len(dst), c, err)
+		}
+		return err
+	} else if blockEntry.flags&beFlagPKWare != 0 && inSize < len(dst) { // Check implosion
+		// Explode block
+		err := explode(dst, inBuffer)
+		if sr.m.logger != nil {
+			sr.m.debugf("sector %d at offset %d: %d bytes exploded to %d bytes, error: %v", k, offset, inSize, len(dst), err)
+		}
+		return err
+	}
+	// Copy block
+	copy(dst, inBuffer)
+	return nil
 }
 
 // Close closes the MPQ and its resources.
 func (m *MPQ) Close() error {
+	m.stopPrefetch()
 	if m.file != nil {
 		return m.file.Close()
 	}
+	if m.closer != nil {
+		return m.closer.Close()
+	}
 	return nil
 }
@@ -2,12 +2,19 @@ package mpq
 
 import (
 	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"path"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/icza/mpq/pkware"
 )
 
 func TestSpeed(t *testing.T) {
@@ -106,10 +113,10 @@ func testMpq(t *testing.T, name string, m *MPQ) {
 		"(listfile)",
 	}
 	for _, file := range files {
-		if data, err := m.FileByName(file); err != nil {
-			t.Errorf("Error getting file '%s' from archive: %s, error: %v", name, file, err)
-		} else if data == nil {
+		if _, err := m.FileByName(file); err == ErrFileNotFound {
 			fmt.Println("\tFile not present in archive:", file)
+		} else if err != nil {
+			t.Errorf("Error getting file '%s' from archive: %s, error: %v", name, file, err)
 		}
 	}
 }
@@ -131,3 +138,850 @@ func TestInvalid(t *testing.T) {
 		t.Errorf("Parse should have failed but it succeeded: error: %v", err)
 	}
 }
+
+func TestHeaderV3(t *testing.T) {
+	content := buildArchiveBytesVersion(t, FormatVersion3)
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	h := m.header
+	if h.formatVersion != uint16(FormatVersion3) || h.size != headerSizeV3 {
+		t.Errorf("Unexpected format version: %d, header size: %d", h.formatVersion, h.size)
+	}
+	if h.archiveSize64 != uint64(len(content)) || h.archiveSize64 != uint64(h.archiveSize) {
+		t.Errorf("Got: %d archive size, want: %d", h.archiveSize64, len(content))
+	}
+	le := binary.LittleEndian
+	if h.hetTableOffset == 0 || le.Uint32(content[h.hetTableOffset:]) != hetTableSignature ||
+		h.betTableOffset == 0 || le.Uint32(content[h.betTableOffset:]) != betTableSignature {
+		t.Errorf("Unexpected HET and BET table offsets: %d, %d", h.hetTableOffset, h.betTableOffset)
+	}
+	if h.hashTableSize64 != 0 || h.hetTableSize64 != 0 {
+		t.Errorf("Fields of format version 4 are read: %+v", h)
+	}
+	if m.het == nil || m.bet == nil {
+		t.Errorf("HET and BET tables are not read")
+	}
+	if got, err := m.FileByName("a.txt"); err != nil || string(got) != "some content" {
+		t.Errorf("Got: %q, %v, want: %q", got, err, "some content")
+	}
+
+	// Format version 4 header (the format version field is 3):
+	if m, err = NewFromFile("reps/lotv.SC2Replay"); err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	h = m.header
+	if h.formatVersion != uint16(FormatVersion4) || h.size != headerSizeV4 {
+		t.Errorf("Unexpected format version: %d, header size: %d", h.formatVersion, h.size)
+	}
+	if h.archiveSize64 != uint64(h.archiveSize) || h.hetTableOffset != 98106 || h.betTableOffset != 98193 {
+		t.Errorf("Unexpected header fields: %+v", h)
+	}
+}
+
+// buildArchiveBytesVersion builds an archive of the given format version in memory with a single file "a.txt".
+func buildArchiveBytesVersion(t *testing.T, v FormatVersion) []byte {
+	w, err := NewBufferedWriter(WithFormatVersion(v))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("a.txt", []byte("some content")); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyMD5(t *testing.T) {
+	content := buildArchiveBytesVersion(t, FormatVersion4)
+
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if m.header.hashTableSize64 != uint64(m.header.hashTableEntries)*16 || m.header.hetTableSize64 == 0 {
+		t.Errorf("Unexpected v4 header fields: %+v", m.header)
+	}
+	if err := m.VerifyMD5(); err != nil {
+		t.Errorf("Failed to verify MD5: %v", err)
+	}
+
+	content[m.header.hashTableOffset] ^= 0xff // Corrupt the hash table
+	if m, err = New(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if err := m.VerifyMD5(); err != ErrMD5Mismatch {
+		t.Errorf("Expected ErrMD5Mismatch, got: %v", err)
+	}
+
+	// Format version 3 archives have no MD5 digests:
+	if m, err = New(bytes.NewReader(buildArchiveBytesVersion(t, FormatVersion3))); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if err := m.VerifyMD5(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Format version 4 archive whose MD5 digests are zero (not recorded):
+	if m, err = NewFromFile("reps/lotv.SC2Replay"); err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	if err := m.VerifyMD5(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+}
+
+func TestCompressedTables(t *testing.T) {
+	w, err := NewBufferedWriter(WithFormatVersion(FormatVersion3))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := w.AddFile(fmt.Sprint("file", i), []byte("content")); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	content := buf.Bytes()
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	// Rebuild the archive with compressed tables following the file data:
+	h := m.header
+	out := append([]byte{}, content[:h.hashTableOffset]...)
+	for _, table := range []struct {
+		offset, entries, key uint32
+		headerPos            int
+	}{
+		{h.hashTableOffset, h.hashTableEntries, hashTableKey, 0x10},
+		{h.blockTableOffset, h.blockTableEntries, blockTableKey, 0x14},
+	} {
+		data := append([]byte{}, content[table.offset:table.offset+table.entries*16]...)
+		decrypt(data, table.key)
+		compressed, err := compressMulti(data, CompressionZlib, 9)
+		if err != nil || len(compressed) >= len(data) {
+			t.Fatalf("Failed to compress table (err: %v)", err)
+		}
+		encrypt(compressed, table.key)
+		binary.LittleEndian.PutUint32(out[table.headerPos:], uint32(len(out)))
+		out = append(out, compressed...)
+	}
+	binary.LittleEndian.PutUint32(out[0x08:], uint32(len(out))) // archive size
+	binary.LittleEndian.PutUint64(out[0x2c:], uint64(len(out))) // 64-bit archive size
+
+	cm, err := New(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Failed to open archive with compressed tables: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if got, err := cm.FileByName(fmt.Sprint("file", i)); err != nil || string(got) != "content" {
+			t.Errorf("File %d mismatch: %q (err: %v)", i, got, err)
+		}
+	}
+
+	// A wrong archive size makes the block table look compressed, it is read uncompressed then
+	// (the HET and BET tables are dropped, so they can't stand in for the classic tables):
+	short := append([]byte{}, content...)
+	binary.LittleEndian.PutUint64(short[0x2c:], uint64(h.blockTableOffset+16))
+	binary.LittleEndian.PutUint64(short[0x34:], 0)
+	binary.LittleEndian.PutUint64(short[0x3c:], 0)
+	if sm, err := New(bytes.NewReader(short)); err != nil {
+		t.Errorf("Failed to open archive with short archive size: %v", err)
+	} else if got, err := sm.FileByName("file0"); err != nil || string(got) != "content" {
+		t.Errorf("Got: %q, %v, want: %q", got, err, "content")
+	}
+}
+
+func TestShortArchiveSizeV1(t *testing.T) {
+	// Archive sizes of format version 1 are often wrong (e.g. in protected maps), they must not affect the tables
+	content := buildArchiveBytes(t, "a.txt", "a")
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if m.FormatVersion() != FormatVersion1 {
+		t.Fatalf("Got: %v, want: %v", m.FormatVersion(), FormatVersion1)
+	}
+	binary.LittleEndian.PutUint32(content[0x08:], m.header.blockTableOffset+16)
+
+	if m, err = New(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to open archive with short archive size: %v", err)
+	}
+	if got, err := m.FileByName("a.txt"); err != nil || string(got) != "a" {
+		t.Errorf("Got: %q, %v, want: %q", got, err, "a")
+	}
+}
+
+func TestEncryptedFiles(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		opts []FileOption
+	}{
+		{"dir\\stored.bin", testContent(10001), []FileOption{FileEncrypted(false)}},
+		{"compressed.txt", bytes.Repeat([]byte("Lorem ipsum. "), 1000),
+			[]FileOption{FileEncrypted(false), FileCompression(CompressionZlib)}},
+		{"dir/small.txt", []byte("abc"), []FileOption{FileEncrypted(false)}},
+	}
+	for _, f := range files {
+		if err := w.AddFile(f.name, f.data, f.opts...); err != nil {
+			t.Errorf("Failed to add file %s: %v", f.name, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	for _, f := range files {
+		if got, err := m.FileByName(f.name); err != nil || !bytes.Equal(got, f.data) {
+			t.Errorf("File %s mismatch (err: %v)", f.name, err)
+		}
+	}
+	if _, err := m.FileByHash(FileNameHash(files[0].name)); err != ErrEncryptionUnsupported {
+		t.Errorf("Expected ErrEncryptionUnsupported without file name, got: %v", err)
+	}
+}
+
+// Key of files named "(hash table)": the well-known key of the hash table (as published with StormLib),
+// so the stored data can be checked against the format instead of the code which encrypted it.
+const knownFileKey = 0xc3af3770
+
+func TestEncryptedKnownKey(t *testing.T) {
+	name, data := `dir\(hash table)`, testContent(10001)
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile(name, data, FileEncrypted(false)); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	if key := fileKey(name, 0, 0, beFlagEncrypted); key != knownFileKey {
+		t.Errorf("Got: %#x, want: %#x", key, knownFileKey)
+	}
+
+	// Sectors are encrypted with the key of the file name (without the path) incremented by the sector index:
+	be := m.blockTable[m.blockIndexByName(name)]
+	stored := append([]byte{}, buf.Bytes()[be.blockOffset:be.blockOffset+be.blockSize]...)
+	for k := uint32(0); k*m.blockSize < uint32(len(stored)); k++ {
+		end := (k + 1) * m.blockSize
+		if end > uint32(len(stored)) {
+			end = uint32(len(stored))
+		}
+		decrypt(stored[k*m.blockSize:end], knownFileKey+k)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("Stored data does not decrypt with the known key")
+	}
+	if got, err := m.FileByName(name); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(got), err, len(data))
+	}
+}
+
+func TestEncryptedFixKey(t *testing.T) {
+	// User data shifts the archive in the input, which must not affect the adjusted keys:
+	w, err := NewBufferedWriter(WithUserData(testContent(100)))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		opts []FileOption
+	}{
+		{"(attributes)", testContent(10001), []FileOption{FileEncrypted(true)}},
+		{"dir\\compressed.txt", bytes.Repeat([]byte("Lorem ipsum. "), 1000),
+			[]FileOption{FileEncrypted(true), FileCompression(CompressionBzip2)}},
+		{"small.txt", []byte("abc"), []FileOption{FileEncrypted(true)}},
+	}
+	for _, f := range files {
+		if err := w.AddFile(f.name, f.data, f.opts...); err != nil {
+			t.Errorf("Failed to add file %s: %v", f.name, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	for i, f := range files {
+		if m.blockTable[i].flags&beFlagFixKey == 0 {
+			t.Errorf("File %s is not FIX_KEY encrypted", f.name)
+		}
+		if got, err := m.FileByName(f.name); err != nil || !bytes.Equal(got, f.data) {
+			t.Errorf("File %s mismatch (err: %v)", f.name, err)
+		}
+	}
+}
+
+func TestEncryptedFixKeyKnownKey(t *testing.T) {
+	// User data shifts the archive in the input, the key is adjusted by the offset relative to the archive:
+	name, data := "(hash table)", bytes.Repeat([]byte("Lorem ipsum. "), 1000)
+	w, err := NewBufferedWriter(WithUserData(testContent(100)))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile(name, data, FileEncrypted(true), FileCompression(CompressionZlib)); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	// FIX_KEY: (key + block offset) ^ file size
+	if got, want := fileKey(name, 0x1000, 0x20, beFlagEncrypted|beFlagFixKey), uint32(0xc3af4750); got != want {
+		t.Errorf("Got: %#x, want: %#x", got, want)
+	}
+
+	be := m.blockTable[m.blockIndexByName(name)]
+	key := (knownFileKey + be.blockOffset) ^ be.fileSize
+	stored := append([]byte{}, buf.Bytes()[m.archiveOffset+int64(be.blockOffset):m.archiveOffset+int64(be.blockOffset+be.blockSize)]...)
+
+	// The sector offset table is encrypted with the key decremented by 1, the sectors with the key incremented by their index:
+	sectors := (be.fileSize + m.blockSize - 1) / m.blockSize
+	offsets := stored[:(sectors+1)*4]
+	decrypt(offsets, key-1)
+	if first := binary.LittleEndian.Uint32(offsets); first != uint32(len(offsets)) {
+		t.Fatalf("Got: %d first sector offset, want: %d", first, len(offsets))
+	}
+	var got []byte
+	for k := uint32(0); k < sectors; k++ {
+		sector := stored[binary.LittleEndian.Uint32(offsets[k*4:]):binary.LittleEndian.Uint32(offsets[k*4+4:])]
+		decrypt(sector, key+k)
+		dst := make([]byte, m.blockSize)
+		if rest := be.fileSize - k*m.blockSize; rest < m.blockSize {
+			dst = dst[:rest]
+		}
+		if err := decompressMulti(dst, sector); err != nil {
+			t.Fatalf("Failed to decompress sector %d: %v", k, err)
+		}
+		got = append(got, dst...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Stored data does not decrypt with the known key")
+	}
+}
+
+func TestDecompressBzip2(t *testing.T) {
+	// "Hello, MPQ! " repeated 10 times, compressed by the bzip2 tool
+	src := []byte{byte(CompressionBzip2),
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x33, 0x5d, 0x72, 0x8b, 0x00, 0x00, 0x18, 0x97, 0x00, 0x60,
+		0x04, 0x00, 0x42, 0x60, 0x00, 0x02, 0x04, 0xa0, 0x00, 0x31, 0x00, 0x30, 0x0a, 0x55, 0x1a, 0x60, 0x9e, 0x27, 0xa9, 0xa4,
+		0xea, 0x7c, 0x4d, 0x26, 0xd3, 0x69, 0xc4, 0xe9, 0x3f, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0x33, 0x5d, 0x72, 0x8b}
+	exp := strings.Repeat("Hello, MPQ! ", 10)
+
+	dst := make([]byte, len(exp))
+	if err := decompressMulti(dst, src); err != nil || string(dst) != exp {
+		t.Errorf("decompressMulti() = %q (err: %v), expected: %q", dst, err, exp)
+	}
+
+	src[20] ^= 0xff // Corrupt the stream
+	if err := decompressMulti(dst, src); err != ErrInvalidArchive {
+		t.Errorf("Expected ErrInvalidArchive, got: %v", err)
+	}
+}
+
+func TestDecompressLzma(t *testing.T) {
+	var exp []byte
+	for i := 0; i < 200; i++ {
+		exp = append(exp, fmt.Sprintf("Lorem ipsum dolor sit amet, %d. ", i*i%97)...)
+	}
+
+	// Produced by Python's lzma module (".lzma" format, with end marker)
+	for _, src := range []string{
+		// Default properties (lc=3, lp=0, pb=2)
+		"5d00008000ffffffffffffffff00261bca46675af277b87d86d841db0535cd83a57c12a505db90bd2f14d350729567921e81" +
+			"f939562f1111cdb44e8bc5b8ea40415fc09b1be44df3008f85c6fe75f15b60022f86b43835a039f8810368fb8f0a299a13f7" +
+			"e06a5cc4e258d6d705d1a7135170a37ba954e3d82de18ab5317c93925c93e0eb4f48fa70021caba1fae92368d92eac03844c" +
+			"46453461c41dee38e29ba3b3830ef0d01d482d8b8014f9007c4352a841aa482c35a8510fcd1c9b008e8d55c1b4a3b873a0db" +
+			"a360365c119fde5d8bf383ed4c41bd2173a6753ce19c7880d6042bbbc3b5af6f924f76e64f1576a342cde5debab4939c623d" +
+			"d0c5d3a8b200319f89d5f9269ee71971c3cd854b4b1b956006b9c2d75e73f70313b2451fffc880b5fb",
+		// lc=0, lp=2, pb=0
+		"1200000100ffffffffffffffff00261ca9a1003713b6d357ccf97618b7f8d09d9a47392b913e2ad39b372046b03a75de321c" +
+			"907fd47c5815a7521c3fe5265fd98691349010a71d318a8b3d2f17912ae291abab72a86e89f2943a65e91b1e798a0fc70e6b" +
+			"e835683178f1d2bb35cf6247a22b1e75c14408010b20b0ec2be204cf8acbf441561205b10de370b337bcb495012d4d0d1828" +
+			"f8d62f225501c746f02fafcdcada987f124aca7ffdf5b13ee255e5a76e3607d80490278e475c210425715c36dd4329d49004" +
+			"c523408622667cc15cce54d79f0c6c7165c14c7f0e9de1075e9383beec4b54c59927e5a78c77f00ce7f8d6c4bf1cf6b2e11a" +
+			"ce1a6f8d2f8977006282b0340cf948de3fb1cf034a16204f269d93361bd1dd4af65bb5a46d790dc6b0e312837cd5dbe376a7" +
+			"5803f1ba5817ebffc4835dae",
+	} {
+		data, err := hex.DecodeString(src)
+		if err != nil {
+			t.Fatalf("Invalid test data: %v", err)
+		}
+		data = append([]byte{byte(CompressionLZMA), 0}, data...) // Compression mask and filter byte
+
+		dst := make([]byte, len(exp))
+		if err := decompressMulti(dst, data); err != nil || !bytes.Equal(dst, exp) {
+			t.Errorf("decompressMulti() mismatch (err: %v)", err)
+		}
+
+		// Corrupt and truncated input
+		if err := decompressMulti(make([]byte, len(exp)), data[:len(data)/2]); err != ErrInvalidArchive {
+			t.Errorf("Expected ErrInvalidArchive for truncated input, got: %v", err)
+		}
+		data[1] = 1 // Filter byte
+		if err := decompressMulti(dst, data); err != ErrInvalidArchive {
+			t.Errorf("Expected ErrInvalidArchive for filter byte, got: %v", err)
+		}
+	}
+}
+
+func TestDecompressADPCM(t *testing.T) {
+	cases := []struct {
+		c   Compression
+		src []byte
+		exp []int16
+	}{
+		{CompressionADPCMMono,
+			[]byte{0, 2, 0x10, 0x00, 0x00, 0x01, 0x41, 0x80, 0x81, 0x05, 0x3f, 0x7f, 0x7f, 0x7f, 0x3f},
+			[]int16{16, 139, 700, 139, 139, 1453, 3804, -1237, -12041, -32768, 16897}},
+		{CompressionADPCMStereo,
+			[]byte{0, 3, 0xe8, 0x03, 0x18, 0xfc, 0x01, 0x41, 0x81, 0x22, 0x62, 0x80, 0x80, 0x7f},
+			[]int16{1000, -1000, 1555, -1555, 2250, -1878, 2250, -1878, 418}},
+	}
+	for _, c := range cases {
+		dst := make([]byte, 2*len(c.exp))
+		if err := decompressMulti(dst, append([]byte{byte(c.c)}, c.src...)); err != nil {
+			t.Errorf("[%x] Failed to decompress: %v", c.c, err)
+			continue
+		}
+		for i, exp := range c.exp {
+			if got := int16(binary.LittleEndian.Uint16(dst[2*i:])); got != exp {
+				t.Errorf("[%x] Sample %d: got %d, expected: %d", c.c, i, got, exp)
+			}
+		}
+
+		// Not enough samples
+		if err := decompressMulti(make([]byte, len(dst)+2), append([]byte{byte(c.c)}, c.src...)); err != ErrInvalidArchive {
+			t.Errorf("[%x] Expected ErrInvalidArchive, got: %v", c.c, err)
+		}
+	}
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	const custom Compression = 0x04 // Not supported by the package
+	src := []byte{byte(custom), 'a', 3}
+
+	dst := make([]byte, 5)
+	if err := decompressMulti(dst, src); err != ErrCompressionUnsupported {
+		t.Errorf("Expected ErrCompressionUnsupported, got: %v", err)
+	}
+
+	RegisterDecompressor(custom, func(dst, src []byte) error { // Fills dst with src[0]
+		if len(src) == 0 {
+			return ErrInvalidArchive
+		}
+		for i := range dst {
+			dst[i] = src[0]
+		}
+		return nil
+	})
+	defer RegisterDecompressor(custom, nil)
+
+	if err := decompressMulti(dst, src); err != nil || string(dst) != "aaaaa" {
+		t.Errorf("decompressMulti() = %q (err: %v)", dst, err)
+	}
+
+	RegisterDecompressor(custom, nil)
+	if err := decompressMulti(dst, src); err != ErrCompressionUnsupported {
+		t.Errorf("Expected ErrCompressionUnsupported after removal, got: %v", err)
+	}
+}
+
+func TestDecompressChain(t *testing.T) {
+	// ADPCM (stereo) compressed samples, then zlib compressed:
+	adpcm := append([]byte{0, 3, 0xe8, 0x03, 0x18, 0xfc}, bytes.Repeat([]byte{0x01, 0x41, 0x80, 0x62}, 100)...)
+	samples := make([]byte, 2*(2+400))
+	if err := decompressADPCM(samples, adpcm, 2); err != nil {
+		t.Fatalf("Failed to decompress ADPCM: %v", err)
+	}
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write(adpcm)
+	zw.Close()
+
+	// Sparse compressed, then imploded:
+	data := append(make([]byte, 300), "some data"...)
+	imploded, err := pkware.Implode(compressSparse(data), pkware.ASCII, 1024)
+	if err != nil {
+		t.Fatalf("Failed to implode: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		c    Compression
+		src  []byte
+		exp  []byte
+	}{
+		{"zlib+adpcm", CompressionZlib | CompressionADPCMStereo, zbuf.Bytes(), samples},
+		{"pkware+sparse", CompressionPKWare | CompressionSparse, imploded, data},
+	}
+	for _, c := range cases {
+		dst := make([]byte, len(c.exp))
+		if err := decompressMulti(dst, append([]byte{byte(c.c)}, c.src...)); err != nil || !bytes.Equal(dst, c.exp) {
+			t.Errorf("[%s] Mismatch (err: %v)", c.name, err)
+		}
+	}
+
+	// Huffman coding is not supported, but the combination can be registered:
+	const huffmanADPCM Compression = 0x01 | CompressionADPCMMono
+	if err := decompressMulti(make([]byte, 100), []byte{byte(huffmanADPCM), 0}); err != ErrCompressionUnsupported {
+		t.Errorf("Expected ErrCompressionUnsupported, got: %v", err)
+	}
+	RegisterDecompressor(huffmanADPCM, func(dst, src []byte) error { return nil })
+	defer RegisterDecompressor(huffmanADPCM, nil)
+	if err := decompressMulti(make([]byte, 100), []byte{byte(huffmanADPCM), 0}); err != nil {
+		t.Errorf("Expected registered decompressor to be used, got: %v", err)
+	}
+}
+
+// setBlockFlags sets the flags of a block table entry of the archive content.
+func setBlockFlags(content []byte, h header, index int, flags uint32) {
+	table := content[h.blockTableOffset : h.blockTableOffset+h.blockTableEntries*16]
+	decrypt(table, blockTableKey)
+	binary.LittleEndian.PutUint32(table[index*16+12:], flags)
+	encrypt(table, blockTableKey)
+}
+
+// setBlockSize sets the block size of a block table entry of the archive content.
+func setBlockSize(content []byte, h header, index int, size uint32) {
+	table := content[h.blockTableOffset : h.blockTableOffset+h.blockTableEntries*16]
+	decrypt(table, blockTableKey)
+	binary.LittleEndian.PutUint32(table[index*16+4:], size)
+	encrypt(table, blockTableKey)
+}
+
+func TestDeletionMarker(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for _, name := range []string{"deleted.txt", "kept.txt"} {
+		if err := w.AddFile(name, []byte("content")); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	content := buf.Bytes()
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	setBlockFlags(content, m.header, 0, beFlagFile|beFlagDeleteMarker)
+
+	if m, err = New(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if got, err := m.FileByName("deleted.txt"); got != nil || err != ErrFileDeleted {
+		t.Errorf("Expected ErrFileDeleted, got: %q, %v", got, err)
+	}
+	if got, err := m.FileByName("kept.txt"); err != nil || string(got) != "content" {
+		t.Errorf("File mismatch: %q (err: %v)", got, err)
+	}
+}
+
+func TestEmptyFiles(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []string{"empty", "empty-compressed", "empty-block", "nonempty"}
+	for _, name := range files {
+		if err := w.AddFile(name, nil, FileCompression(CompressionZlib)); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	content := buf.Bytes()
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	// Placeholder entries without stored data:
+	table := content[m.header.blockTableOffset : m.header.blockTableOffset+m.header.blockTableEntries*16]
+	decrypt(table, blockTableKey)
+	for i, name := range files {
+		be := table[i*16:]
+		switch name {
+		case "empty-compressed":
+			binary.LittleEndian.PutUint32(be[12:], beFlagFile|beFlagCompressedMulti)
+		case "empty-block":
+			binary.LittleEndian.PutUint32(be[4:], 0) // Block size
+			binary.LittleEndian.PutUint32(be[12:], beFlagFile|beFlagCompressedMulti)
+		case "nonempty":
+			binary.LittleEndian.PutUint32(be[4:], 0)  // Block size
+			binary.LittleEndian.PutUint32(be[8:], 10) // File size
+		}
+	}
+	encrypt(table, blockTableKey)
+
+	if m, err = New(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	for _, name := range files[:3] {
+		if got, err := m.FileByName(name); got == nil || len(got) != 0 || err != nil {
+			t.Errorf("Expected empty slice for %s, got: %v (err: %v)", name, got, err)
+		}
+	}
+	if _, err := m.FileByName("nonempty"); err != ErrInvalidArchive {
+		t.Errorf("Expected ErrInvalidArchive, got: %v", err)
+	}
+
+	content[0x0e] = 30 // Sector size shift
+	if _, err := New(bytes.NewReader(content)); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("Expected ErrInvalidArchive for invalid sector size shift, got: %v", err)
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	for _, opts := range [][]WriterOption{nil, {WithUserData([]byte("user data"))}} {
+		w, err := NewBufferedWriter(opts...)
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		if err := w.AddFile("readme.txt", []byte("embedded")); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+		var archive bytes.Buffer
+		if _, err := w.WriteTo(&archive); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+
+		// Archive embedded in an "executable":
+		content := append([]byte("MZ"), make([]byte, 3*512-2)...)
+		content = append(content, archive.Bytes()...)
+
+		if _, err := New(bytes.NewReader(content)); !errors.Is(err, ErrInvalidArchive) {
+			t.Errorf("Expected: %v, got: %v", ErrInvalidArchive, err)
+		}
+		m, err := NewEmbedded(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Failed to open embedded archive: %v", err)
+		}
+		if data, err := m.FileByName("readme.txt"); err != nil || string(data) != "embedded" {
+			t.Errorf("Got: %q, %v, want: %q, nil", data, err, "embedded")
+		}
+	}
+
+	// No archive:
+	if _, err := NewEmbedded(bytes.NewReader(make([]byte, 2000))); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("Expected: %v, got: %v", ErrInvalidArchive, err)
+	}
+}
+
+func TestHeaderAccessors(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	h := m.header
+	if m.FormatVersion() != FormatVersion4 || m.HeaderSize() != h.size || m.SectorSize() != 512<<h.sectorSizeShift ||
+		m.ArchiveSize() != uint64(h.archiveSize) || m.HashTableEntries() != uint32(len(m.hashTable)) ||
+		m.BlockTableEntries() != uint32(len(m.blockTable)) || m.ArchiveOffset() != int64(m.userData.headerOffset) {
+		t.Errorf("Unexpected header values: %d %d %d %d %d %d %d", m.FormatVersion(), m.HeaderSize(), m.SectorSize(),
+			m.ArchiveSize(), m.HashTableEntries(), m.BlockTableEntries(), m.ArchiveOffset())
+	}
+}
+
+func TestCorruptSectorTable(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("a.txt", bytes.Repeat([]byte("corrupt "), 10000), FileCompression(CompressionZlib)); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	data := buf.Bytes()
+
+	m, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	// Swap the first 2 sector offsets
+	pos := m.archiveOffset + int64(m.blockTable[m.FileInfo("a.txt").BlockIndex].blockOffset)
+	first, second := binary.LittleEndian.Uint32(data[pos:]), binary.LittleEndian.Uint32(data[pos+4:])
+	binary.LittleEndian.PutUint32(data[pos:], second)
+	binary.LittleEndian.PutUint32(data[pos+4:], first)
+
+	// Reopen, the input might be buffered
+	if m, err = New(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	_, err = m.FileByName("a.txt")
+	if !errors.Is(err, ErrCorruptSectorTable) || !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("Got: %v, want: %v", err, ErrCorruptSectorTable)
+	}
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Struct != "sector offset table" || pe.Offset != pos {
+		t.Errorf("Got: %#v, want: sector offset table at offset %d", pe, pos)
+	}
+
+	// Truncated hash table:
+	m.header.hashTableOffset = uint32(len(data))
+	err = m.readTables()
+	if !errors.As(err, &pe) || pe.Struct != "hash table" || pe.Offset != int64(len(data)) || !errors.Is(err, io.EOF) {
+		t.Errorf("Got: %v, want: hash table at offset %d: EOF", err, len(data))
+	}
+}
+
+func TestCorruptStoredSectorTable(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for _, name := range []string{"stored.txt", "single.txt"} {
+		if err := w.AddFile(name, testContent(10000), FileCompression(CompressionNone)); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	data := buf.Bytes()
+
+	m, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	// Stored data smaller than the file would make the last sector wrap around
+	for _, name := range []string{"stored.txt", "single.txt"} {
+		setBlockSize(data, m.header, m.FileInfo(name).BlockIndex, 100)
+	}
+	setBlockFlags(data, m.header, m.FileInfo("single.txt").BlockIndex, beFlagFile|beFlagSingle)
+
+	m, err = New(bytes.NewReader(data), WithMaxMemory(1<<20))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	for _, name := range []string{"stored.txt", "single.txt"} {
+		if _, err := m.FileByName(name); !errors.Is(err, ErrCorruptSectorTable) {
+			t.Errorf("[%s] Got: %v, want: %v", name, err, ErrCorruptSectorTable)
+		}
+		if _, err := m.Open(name); !errors.Is(err, ErrCorruptSectorTable) {
+			t.Errorf("[%s] Got: %v, want: %v", name, err, ErrCorruptSectorTable)
+		}
+	}
+}
+
+func TestFileByNameInto(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	var buf []byte
+	for _, name := range []string{"replay.tracker.events", "replay.details", "replay.initData"} {
+		exp, err := m.FileByName(name)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %v", name, err)
+		}
+		prevCap := cap(buf)
+		got, err := m.FileByNameInto(buf, name)
+		if err != nil || !bytes.Equal(got, exp) {
+			t.Errorf("[%s] Content mismatch (err: %v)", name, err)
+		}
+		if len(exp) <= prevCap && &got[0] != &buf[:1][0] {
+			t.Errorf("[%s] Buffer is not reused", name)
+		}
+		buf = got
+	}
+
+	if _, err := m.FileByNameInto(buf, "missing"); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+}
+
+func TestUserDataReader(t *testing.T) {
+	for _, size := range []int{100, 100 << 10} {
+		userData := testContent(size)
+		w, err := NewBufferedWriter(WithUserData(userData))
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		m, err := New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to open archive: %v", err)
+		}
+
+		if copied := m.userData.data != nil; copied != (size <= userDataCopyLimit) {
+			t.Errorf("[%d] Got: copied: %v", size, copied)
+		}
+		if m.UserDataSize() < uint32(size) {
+			t.Errorf("[%d] Got: %d size, want: at least %d", size, m.UserDataSize(), size)
+		}
+		r := m.UserDataReader()
+		if r.Size() != int64(m.UserDataSize()) {
+			t.Errorf("[%d] Got: %d, want: %d", size, r.Size(), m.UserDataSize())
+		}
+		part := make([]byte, 10)
+		if _, err := r.ReadAt(part, 50); err != nil || !bytes.Equal(part, userData[50:60]) {
+			t.Errorf("[%d] Got: %v, %v, want: %v", size, part, err, userData[50:60])
+		}
+		if data := m.UserData(); !bytes.Equal(data[:size], userData) {
+			t.Errorf("[%d] User data mismatch", size)
+		}
+	}
+
+	m := buildArchive(t, "a.txt", "a")
+	if m.UserDataSize() != 0 || m.UserDataReader() != nil {
+		t.Errorf("Unexpected user data")
+	}
+}
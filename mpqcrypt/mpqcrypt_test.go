@@ -0,0 +1,56 @@
+package mpqcrypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHashString(t *testing.T) {
+	cases := []struct {
+		s        string
+		hashType HashType
+		exp      uint32
+	}{
+		{"(hash table)", HashFileKey, HashTableKey},
+		{"(block table)", HashFileKey, BlockTableKey},
+		// Published in "Inside MoPaQ" (by Justin Olbrantz)
+		{`arr\units.dat`, HashTableOffset, 0xf4e6c69d},
+		{`unit\neutral\acritter.grp`, HashTableOffset, 0xa26067f3},
+	}
+	for _, c := range cases {
+		if got := HashString(c.s, c.hashType); got != c.exp {
+			t.Errorf("[%s] Got: %#x, want: %#x", c.s, got, c.exp)
+		}
+	}
+
+	if HashString(`dir/a.txt`, HashNameA) != HashString(`DIR\A.TXT`, HashNameA) {
+		t.Errorf("Hashes differ by letter case or slashes")
+	}
+	if HashString("a.txt", HashNameA) == HashString("a.txt", HashNameB) {
+		t.Errorf("Hashes of different types are equal")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	orig := []byte("0123456789abcdefXYZ") // Trailing 3 bytes are not encrypted
+	data := append([]byte(nil), orig...)
+
+	Encrypt(data, HashTableKey)
+	if bytes.Equal(data[:16], orig[:16]) || !bytes.Equal(data[16:], orig[16:]) {
+		t.Errorf("Unexpected encrypted data: % x", data)
+	}
+	Decrypt(data, HashTableKey)
+	if !bytes.Equal(data, orig) {
+		t.Errorf("Got: %q, want: %q", data, orig)
+	}
+}
+
+func TestFileKey(t *testing.T) {
+	key := HashString("a.txt", HashFileKey)
+	if got := FileKey(`dir\a.txt`, 100, 10, false); got != key {
+		t.Errorf("Got: %#x, want: %#x", got, key)
+	}
+	if got, exp := FileKey("dir/a.txt", 100, 10, true), (key+100)^10; got != exp {
+		t.Errorf("Got: %#x, want: %#x", got, exp)
+	}
+}
@@ -0,0 +1,73 @@
+// Well-known file names.
+
+package mpq
+
+// Well-known file names which can be registered with MPQ.AddNames() for enumeration
+// and reverse lookups if an archive has no (complete) "(listfile)", e.g.:
+//
+//	m.AddNames(mpq.SC2ReplayFileNames...)
+var (
+	// InternalFileNames are the names of the special files used by Storm.
+	InternalFileNames = []string{
+		"(listfile)",
+		"(attributes)",
+		"(signature)",
+		"(user data)",
+		"(patch_metadata)",
+	}
+
+	// SC2ReplayFileNames are the names of the files of StarCraft II replays.
+	SC2ReplayFileNames = []string{
+		"replay.attributes.events",
+		"replay.details",
+		"replay.details.backup",
+		"replay.game.events",
+		"replay.gamemetadata.json",
+		"replay.initData",
+		"replay.initData.backup",
+		"replay.load.info",
+		"replay.message.events",
+		"replay.resumable.events",
+		"replay.server.battlelobby",
+		"replay.smartcam.events",
+		"replay.sync.events",
+		"replay.sync.history",
+		"replay.tracker.events",
+	}
+
+	// WC3MapFileNames are the names of the common files of Warcraft III maps.
+	WC3MapFileNames = []string{
+		"war3map.j",
+		"war3map.lua",
+		`scripts\war3map.j`,
+		`scripts\war3map.lua`,
+		"war3map.w3e",
+		"war3map.w3i",
+		"war3map.wtg",
+		"war3map.wct",
+		"war3map.wts",
+		"war3map.w3r",
+		"war3map.w3c",
+		"war3map.w3s",
+		"war3map.w3u",
+		"war3map.w3t",
+		"war3map.w3a",
+		"war3map.w3b",
+		"war3map.w3d",
+		"war3map.w3q",
+		"war3map.wpm",
+		"war3map.doo",
+		"war3mapUnits.doo",
+		"war3map.mmp",
+		"war3map.shd",
+		"war3map.imp",
+		"war3mapMap.blp",
+		"war3mapMap.b00",
+		"war3mapMap.tga",
+		"war3mapPreview.tga",
+		"war3mapPath.tga",
+		"war3mapMisc.txt",
+		"war3mapSkin.txt",
+		"war3mapExtra.txt",
+	}
+)
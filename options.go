@@ -0,0 +1,173 @@
+// Options of opening archives.
+
+package mpq
+
+import (
+	"bytes"
+	"io"
+)
+
+// Option configures how an archive is opened. All constructors of MPQ accept options, e.g. New and NewFromFile.
+type Option func(*MPQ)
+
+// applyOptions applies the options to m.
+func (m *MPQ) applyOptions(opts []Option) {
+	for _, opt := range opts {
+		opt(m)
+	}
+}
+
+// WithStrictValidation returns an Option which enables strict validation of the archive when its tables are read.
+// Besides the checks always performed, it is verified that
+//   - the hash table size is a power of 2,
+//   - hash table entries reference existing block table entries,
+//   - the data of the files lies within the archive,
+//   - the MD5 digests of the header and the tables match (format version 4, see VerifyMD5()).
+//
+// ErrInvalidArchive (or ErrMD5Mismatch) is returned if a check fails.
+func WithStrictValidation() Option {
+	return func(m *MPQ) {
+		m.strict = true
+	}
+}
+
+// WithLazyTables returns an Option which defers reading the hash and block tables until they are first needed,
+// so workflows that only need the header or the user data (e.g. to check the version of a replay
+// with SC2ReplayHeader()) read less: opening the archive only takes a few small reads at its beginning.
+//
+// Errors of reading the tables are returned by the first methods that need them and return an error
+// (e.g. FileByName() or FileByHash()); other methods treat the archive as if it had no files.
+func WithLazyTables() Option {
+	return func(m *MPQ) {
+		m.lazyTables = true
+	}
+}
+
+// WithHeaderOnly returns an Option which only parses the user data and the header of the archive: the tables
+// (the hash and block tables, the extended block table and the HET and BET tables) are never read.
+// Useful for mass triage of archives which only needs metadata such as the format version (see FormatVersion())
+// or the header of replays (see SC2ReplayHeader()), with a guarantee that no more data is read.
+//
+// Methods needing the tables and returning an error (e.g. FileByName() or FileByHash()) return ErrHeaderOnly;
+// other methods treat the archive as if it had no files (e.g. FilesCount() returns 0).
+func WithHeaderOnly() Option {
+	return func(m *MPQ) {
+		m.headerOnly = true
+	}
+}
+
+// WithMaxMemory returns an Option which limits the size of the allocations whose size comes from the
+// archive data: the user data, the tables and the content of files. Useful to process untrusted archives.
+// ErrMemoryLimit is returned if an allocation would exceed the limit. 0 means no limit (the default).
+func WithMaxMemory(bytes int64) Option {
+	return func(m *MPQ) {
+		m.maxMemory = bytes
+	}
+}
+
+// WithListfile returns an Option which registers the names listed in an external listfile
+// (names separated by line breaks or semicolons, like in the "(listfile)"). See AddNames() for details.
+func WithListfile(data []byte) Option {
+	return func(m *MPQ) {
+		m.AddNames(parseListfile(data)...)
+	}
+}
+
+// WithLocale returns an Option which sets the preferred locale (Windows LANGID) of the files.
+// When looking up a file having multiple locale variants (see Locales()), the variant with the preferred locale
+// is chosen, or else the language-neutral variant, or else the first variant found (like Storm does).
+func WithLocale(locale uint16) Option {
+	return func(m *MPQ) {
+		m.locale, m.hasLocale = locale, true
+	}
+}
+
+// WithoutUserDataCopy returns an Option which skips reading the user data when the archive is opened.
+// The user data is read from the input when UserData() is first called.
+func WithoutUserDataCopy() Option {
+	return func(m *MPQ) {
+		m.noUserDataCopy = true
+	}
+}
+
+// WithIOStats returns an Option which enables counting the operations performed on the input
+// while parsing the archive and reading its files, see MPQ.IOStats().
+func WithIOStats() Option {
+	return func(m *MPQ) {
+		m.ioStats = &IOStats{}
+	}
+}
+
+// WithInMemory returns an Option which makes NewFromFile() read the whole file into memory when the archive
+// is opened if the size of the file is not more than maxSize, and serve all subsequent access from memory
+// (the file is closed right away, SrcFile() returns nil). Small archives (e.g. replays of a few hundred KB)
+// are read faster with one sequential read than with many seeks and small reads.
+// Bigger files are read from the file as usual. The option has no effect on other constructors.
+func WithInMemory(maxSize int64) Option {
+	return func(m *MPQ) {
+		m.inMemoryLimit = maxSize
+	}
+}
+
+// loadIntoMemory reads the source file into memory if its size does not exceed the limit set by WithInMemory,
+// and closes it.
+func (m *MPQ) loadIntoMemory() error {
+	if m.inMemoryLimit <= 0 || m.file == nil {
+		return nil
+	}
+	fi, err := m.file.Stat()
+	if err != nil || fi.Size() > m.inMemoryLimit {
+		return nil // Read from the file as usual
+	}
+
+	data := make([]byte, fi.Size())
+	if _, err = io.ReadFull(m.file, data); err != nil {
+		return err
+	}
+	m.file.Close()
+	m.file, m.input, m.memory = nil, bytes.NewReader(data), data
+	return nil
+}
+
+// WithLogger returns an Option which sets a logger to receive debug messages about parsing the header,
+// reading and decrypting the tables, resolving the blocks of the files and decompressing their sectors.
+// Useful to diagnose why an archive or file is reported invalid.
+func WithLogger(l Logger) Option {
+	return func(m *MPQ) {
+		m.logger = l
+	}
+}
+
+// checkAlloc returns ErrMemoryLimit if an allocation of the given size driven by the archive data
+// would exceed the limit set by WithMaxMemory.
+func (m *MPQ) checkAlloc(size uint64) error {
+	if m.maxMemory > 0 && size > uint64(m.maxMemory) {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+// validate performs the checks of strict validation (see WithStrictValidation) on the tables.
+func (m *MPQ) validate() error {
+	if n := len(m.hashTable); n&(n-1) != 0 {
+		return ErrInvalidArchive
+	}
+	for _, he := range m.hashTable {
+		if he.fileBlockIndex < hashEntryDeleted && he.fileBlockIndex >= uint32(len(m.blockTable)) {
+			return ErrInvalidArchive
+		}
+	}
+
+	if archiveSize := m.ArchiveSize(); archiveSize > 0 {
+		for i, be := range m.blockTable {
+			if be.flags&beFlagFile == 0 {
+				continue
+			}
+			if uint64(m.blockOffset(i))+uint64(be.blockSize) > archiveSize {
+				return ErrInvalidArchive
+			}
+		}
+	}
+
+	return m.VerifyMD5()
+}
@@ -0,0 +1,249 @@
+package mpq
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWithStrictValidation(t *testing.T) {
+	for _, name := range []string{"reps/automm.SC2Replay", "reps/lotv.SC2Replay", "reps/wol.SC2Replay"} {
+		m, err := NewFromFile(name, WithStrictValidation())
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", name, err)
+			continue
+		}
+		m.Close()
+	}
+
+	m := buildArchive(t, "a.txt", "a")
+	if err := m.validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	m.blockTable[0].blockSize = 1 << 30
+	if err := m.validate(); err != ErrInvalidArchive {
+		t.Errorf("Got: %v, want: %v", err, ErrInvalidArchive)
+	}
+
+	m = buildArchive(t, "a.txt", "a")
+	hashIndex, _ := m.lookupName("a.txt")
+	m.hashTable[hashIndex].fileBlockIndex = 100
+	if err := m.validate(); err != ErrInvalidArchive {
+		t.Errorf("Got: %v, want: %v", err, ErrInvalidArchive)
+	}
+}
+
+func TestWithLazyTables(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithLazyTables(), WithIOStats())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	if m.hashTable != nil || m.blockTable != nil {
+		t.Errorf("Tables are loaded")
+	}
+	if _, err := m.SC2ReplayHeader(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// The user data and the header are read with a single read:
+	if stats := m.IOStats(); stats.Reads != 1 || stats.BytesRead > inputBufferSize {
+		t.Errorf("Too many reads: %+v", stats)
+	}
+
+	data, err := m.FileByName("replay.details")
+	if err != nil || len(data) == 0 {
+		t.Errorf("Got: %d bytes, %v, want: non-empty, nil", len(data), err)
+	}
+	if m.blockTable == nil || m.FilesCount() == 0 {
+		t.Errorf("Tables are not loaded")
+	}
+
+	// Inputs without ReadAt are buffered too:
+	content, err := ioutil.ReadFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to read replay: %v", err)
+	}
+	if m, err = New(&seekRecorder{ReadSeeker: bytes.NewReader(content)}, WithLazyTables(), WithIOStats()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats := m.IOStats(); stats.Reads != 1 || stats.BytesRead > inputBufferSize {
+		t.Errorf("Too many reads: %+v", stats)
+	}
+}
+
+func TestWithInMemory(t *testing.T) {
+	exp, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer exp.Close()
+	expDetails, _ := exp.FileByName("replay.details")
+
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithInMemory(1<<20))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m.Close()
+	if m.SrcFile() != nil {
+		t.Errorf("File is not read into memory")
+	}
+	if details, err := m.FileByName("replay.details"); err != nil || !bytes.Equal(details, expDetails) {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(details), err, len(expDetails))
+	}
+
+	// Too big to be read into memory:
+	m2, err := NewFromFile("reps/lotv.SC2Replay", WithInMemory(1000))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m2.Close()
+	if m2.SrcFile() == nil {
+		t.Errorf("File is read into memory")
+	}
+}
+
+func TestWithHeaderOnly(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithHeaderOnly(), WithIOStats())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	if h, err := m.SC2ReplayHeader(); err != nil || h.Version.BaseBuild != 42253 {
+		t.Errorf("Got: %v, %v", h, err)
+	}
+	if m.FormatVersion() != 3 {
+		t.Errorf("Got: %v, want: 3", m.FormatVersion())
+	}
+	before := m.IOStats()
+	if _, err := m.FileByName("replay.details"); err != ErrHeaderOnly {
+		t.Errorf("Got: %v, want: %v", err, ErrHeaderOnly)
+	}
+	if _, err := m.Open("replay.details"); err != ErrHeaderOnly {
+		t.Errorf("Got: %v, want: %v", err, ErrHeaderOnly)
+	}
+	if _, err := m.ExtractTo("replay.details", io.Discard); err != ErrHeaderOnly {
+		t.Errorf("Got: %v, want: %v", err, ErrHeaderOnly)
+	}
+	if _, err := m.FileByNameContext(context.Background(), "replay.details"); err != ErrHeaderOnly {
+		t.Errorf("Got: %v, want: %v", err, ErrHeaderOnly)
+	}
+	if m.FilesCount() != 0 || m.Exists("replay.details") {
+		t.Errorf("Expected no files")
+	}
+	if after := m.IOStats(); after != before {
+		t.Errorf("Tables are read, before: %+v, after: %+v", before, after)
+	}
+}
+
+func TestWithMaxMemory(t *testing.T) {
+	// Not enough for the user data:
+	if _, err := NewFromFile("reps/lotv.SC2Replay", WithMaxMemory(10)); err != ErrMemoryLimit {
+		t.Errorf("Got: %v, want: %v", err, ErrMemoryLimit)
+	}
+
+	m := buildArchive(t, "small.txt", "small", "large.txt", string(make([]byte, 10000)))
+	m.maxMemory = 1000
+	if data, err := m.FileByName("small.txt"); err != nil || string(data) != "small" {
+		t.Errorf("Got: %q, %v, want: %q, nil", data, err, "small")
+	}
+	if _, err := m.FileByName("large.txt"); err != ErrMemoryLimit {
+		t.Errorf("Got: %v, want: %v", err, ErrMemoryLimit)
+	}
+}
+
+func TestWithListfile(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithListfile([]byte("replay.details\r\nunknown.txt")))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m.Close()
+
+	if name := m.NameForHash(FileNameHash("unknown.txt")); name != "unknown.txt" {
+		t.Errorf("Got: %q, want: %q", name, "unknown.txt")
+	}
+}
+
+func TestWithLocale(t *testing.T) {
+	data := buildArchiveBytes(t, "a.txt", "neutral", "b.txt", "german", "c.txt", "c")
+
+	cases := []struct {
+		opts []Option
+		exp  string
+	}{
+		{nil, "neutral"},
+		{[]Option{WithLocale(0x407)}, "german"},
+		{[]Option{WithLocale(0x409)}, "neutral"},
+	}
+	for i, c := range cases {
+		m, err := New(bytes.NewReader(data), c.opts...)
+		if err != nil {
+			t.Fatalf("[%d] Unexpected error: %v", i, err)
+		}
+		addLocaleVariant(t, m, "a.txt", "b.txt", 0x407)
+		if got, err := m.FileByName("a.txt"); err != nil || string(got) != c.exp {
+			t.Errorf("[%d] Got: %q, %v, want: %q, nil", i, got, err, c.exp)
+		}
+	}
+}
+
+func TestWithoutUserDataCopy(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m.Close()
+	m2, err := NewFromFile("reps/lotv.SC2Replay", WithoutUserDataCopy())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m2.Close()
+
+	if m2.userData.data != nil {
+		t.Errorf("User data is copied")
+	}
+	if !bytes.Equal(m.UserData(), m2.UserData()) {
+		t.Errorf("User data mismatch")
+	}
+}
+
+func TestConstructorOptions(t *testing.T) {
+	content := buildArchiveBytes(t, "a.txt", "a")
+	part := buildPart(content, 64, func(int) bool { return false })
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"a.mpq": content, "a.mpq.part": part} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	opt := WithHeaderOnly()
+	constructors := map[string]func() (*MPQ, error){
+		"New":                 func() (*MPQ, error) { return New(bytes.NewReader(content), opt) },
+		"NewFromFile":         func() (*MPQ, error) { return NewFromFile(filepath.Join(dir, "a.mpq"), opt) },
+		"NewEmbedded":         func() (*MPQ, error) { return NewEmbedded(bytes.NewReader(content), opt) },
+		"NewFromFileEmbedded": func() (*MPQ, error) { return NewFromFileEmbedded(filepath.Join(dir, "a.mpq"), opt) },
+		"NewFromFS":           func() (*MPQ, error) { return NewFromFS(fstest.MapFS{"a.mpq": {Data: content}}, "a.mpq", opt) },
+		"NewContext":          func() (*MPQ, error) { return NewContext(context.Background(), bytes.NewReader(content), opt) },
+		"NewPart":             func() (*MPQ, error) { return NewPart(bytes.NewReader(part), opt) },
+		"NewFromPartFile":     func() (*MPQ, error) { return NewFromPartFile(filepath.Join(dir, "a.mpq.part"), opt) },
+		"NewFromReader":       func() (*MPQ, error) { return NewFromReader(bytes.NewReader(content), opt) },
+	}
+	for name, newMPQ := range constructors {
+		m, err := newMPQ()
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", name, err)
+			continue
+		}
+		if _, err := m.FileByName("a.txt"); err != ErrHeaderOnly {
+			t.Errorf("[%s] Got: %v, want: %v", name, err, ErrHeaderOnly)
+		}
+		m.Close()
+	}
+}
@@ -0,0 +1,185 @@
+// Partial MPQ archives (.MPQ.part files) of the Blizzard Downloader.
+//
+// A part file starts with a header (version, game build number, flags, size of the contained file
+// and size of the blocks), followed by a map holding an entry for each block of the contained file:
+// flags (a block is available if any of the lower 2 bits are set), the offset of the block in the part file
+// and a 64-bit value of unknown meaning. Available blocks are stored at their offsets in the part file.
+
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	// ErrDataMissing indicates that data is not (yet) available in a partial MPQ archive
+	ErrDataMissing = errors.New("Data not available in partial MPQ Archive")
+)
+
+// Version of the supported part files.
+const partVersion = 2
+
+// Sizes of the part file structures.
+const (
+	partHeaderSize   = 4 + 0x20 + 4*4
+	partMapEntrySize = 4 * 5
+)
+
+// Flag bits of available blocks in the part file map.
+const partFlagAvailable = 0x03
+
+// partReader is an io.ReadSeeker presenting the MPQ archive contained in a part file.
+type partReader struct {
+	input     io.ReadSeeker // Input of the part file
+	size      int64         // Size of the contained file
+	blockSize int64         // Size of the blocks
+	available []bool        // Tells if blocks are available
+	blockOffs []int64       // Offsets of the blocks in the part file
+	pos       int64         // Current position in the contained file
+}
+
+// newPartReader parses the header and the block map of the part file.
+func newPartReader(input io.ReadSeeker) (*partReader, error) {
+	inputSize, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := input.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, partHeaderSize)
+	if _, err := io.ReadFull(input, buf); err != nil {
+		return nil, ErrInvalidArchive
+	}
+	u32 := func(i int) uint32 { return binary.LittleEndian.Uint32(buf[i:]) }
+
+	if u32(0) != partVersion {
+		return nil, ErrInvalidArchive
+	}
+	size := int64(u32(0x2c))<<32 + int64(u32(0x28))
+	blockSize := int64(u32(0x30))
+	if blockSize == 0 || size < 0 {
+		return nil, ErrInvalidArchive
+	}
+
+	// The map must fit in the part file
+	count := (size + blockSize - 1) / blockSize
+	if count > (inputSize-partHeaderSize)/partMapEntrySize {
+		return nil, ErrInvalidArchive
+	}
+	buf = make([]byte, count*partMapEntrySize)
+	if _, err := io.ReadFull(input, buf); err != nil {
+		return nil, ErrInvalidArchive
+	}
+
+	pr := &partReader{
+		input:     input,
+		size:      size,
+		blockSize: blockSize,
+		available: make([]bool, count),
+		blockOffs: make([]int64, count),
+	}
+	for i := range pr.available {
+		pr.available[i] = u32(i*partMapEntrySize)&partFlagAvailable != 0
+		pr.blockOffs[i] = int64(u32(i*partMapEntrySize+8))<<32 + int64(u32(i*partMapEntrySize+4))
+	}
+
+	return pr, nil
+}
+
+// Read implements io.Reader.
+// ErrDataMissing is returned if the data at the current position is not available.
+func (pr *partReader) Read(p []byte) (n int, err error) {
+	if pr.pos >= pr.size {
+		return 0, io.EOF
+	}
+	block := pr.pos / pr.blockSize
+	if !pr.available[block] {
+		return 0, ErrDataMissing
+	}
+
+	inBlock := pr.pos - block*pr.blockSize
+	if remaining := pr.blockSize - inBlock; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	if remaining := pr.size - pr.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	if _, err = pr.input.Seek(pr.blockOffs[block]+inBlock, 0); err != nil {
+		return 0, err
+	}
+	n, err = io.ReadFull(pr.input, p)
+	pr.pos += int64(n)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = ErrInvalidArchive // Map refers to data beyond the end of the part file
+	}
+	return
+}
+
+// Seek implements io.Seeker.
+func (pr *partReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += pr.pos
+	case io.SeekEnd:
+		offset += pr.size
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+	pr.pos = offset
+	return offset, nil
+}
+
+// NewFromPartFile returns a new MPQ using a partial archive file (.MPQ.part file
+// of the Blizzard Downloader) specified by its name as the input.
+// The returned MPQ must be closed with the Close method!
+//
+// The archive can be opened if its header and tables are available.
+// Reading files whose data is not available results in ErrDataMissing.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewFromPartFile(name string, opts ...Option) (*MPQ, error) {
+	var f *os.File
+	var err error
+	if f, err = os.Open(name); err != nil {
+		return nil, err
+	}
+
+	pr, err := newPartReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	m := &MPQ{file: f, input: pr}
+	m.applyOptions(opts)
+
+	if _, err = m.diveIn(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewPart returns a new MPQ using the specified io.ReadSeeker of a partial archive
+// (.MPQ.part file of the Blizzard Downloader) as the input source.
+// The returned MPQ must be closed with the Close method!
+//
+// The archive can be opened if its header and tables are available.
+// Reading files whose data is not available results in ErrDataMissing.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewPart(input io.ReadSeeker, opts ...Option) (*MPQ, error) {
+	pr, err := newPartReader(input)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MPQ{input: pr}
+	m.applyOptions(opts)
+
+	return m.diveIn()
+}
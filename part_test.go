@@ -0,0 +1,116 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// buildPart wraps content into a part file, storing blocks in reverse order.
+// Blocks for which missing returns true are marked as not available.
+func buildPart(content []byte, blockSize int, missing func(block int) bool) []byte {
+	count := (len(content) + blockSize - 1) / blockSize
+
+	var buf bytes.Buffer
+	w := func(v uint32) { binary.Write(&buf, binary.LittleEndian, v) }
+	w(partVersion)
+	buf.WriteString("12345")
+	buf.Write(make([]byte, 0x20-5))
+	w(0)
+	w(uint32(len(content)))
+	w(0)
+	w(uint32(blockSize))
+
+	dataOffset := partHeaderSize + count*partMapEntrySize
+	var data []byte
+	offsets := make([]int, count)
+	for i := count - 1; i >= 0; i-- {
+		end := (i + 1) * blockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		offsets[i] = dataOffset + len(data)
+		data = append(data, content[i*blockSize:end]...)
+	}
+	for i := 0; i < count; i++ {
+		if missing(i) {
+			w(0)
+		} else {
+			w(partFlagAvailable)
+		}
+		w(uint32(offsets[i]))
+		w(0)
+		w(0)
+		w(0)
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestPart(t *testing.T) {
+	big := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("small.txt", []byte("small")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if err := w.AddFile("big.bin", big); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	content := buf.Bytes()
+
+	const blockSize = 64
+
+	// All blocks available:
+	m, err := NewPart(bytes.NewReader(buildPart(content, blockSize, func(int) bool { return false })))
+	if err != nil {
+		t.Fatalf("Failed to open part: %v", err)
+	}
+	if data, err := m.FileByName("big.bin"); err != nil || !bytes.Equal(data, big) {
+		t.Errorf("Failed to read big.bin: %v", err)
+	}
+	if data, err := m.FileByName("small.txt"); err != nil || string(data) != "small" {
+		t.Errorf("Got: %q, %v, want: %q, nil", data, err, "small")
+	}
+
+	// Block in the middle of big.bin missing:
+	var bigOffset int
+	for _, be := range m.blockTable {
+		if be.fileSize == uint32(len(big)) {
+			bigOffset = int(be.blockOffset)
+		}
+	}
+	missingBlock := (bigOffset + 2000) / blockSize
+	m, err = NewPart(bytes.NewReader(buildPart(content, blockSize, func(b int) bool { return b == missingBlock })))
+	if err != nil {
+		t.Fatalf("Failed to open part: %v", err)
+	}
+	if _, err := m.FileByName("big.bin"); err != ErrDataMissing {
+		t.Errorf("Expected: %v, got: %v", ErrDataMissing, err)
+	}
+	if data, err := m.FileByName("small.txt"); err != nil || string(data) != "small" {
+		t.Errorf("Got: %q, %v, want: %q, nil", data, err, "small")
+	}
+
+	// Not a part file:
+	if _, err := NewPart(bytes.NewReader(content)); err != ErrInvalidArchive {
+		t.Errorf("Expected: %v, got: %v", ErrInvalidArchive, err)
+	}
+
+	// Map larger than the part file:
+	part := buildPart(content, blockSize, func(int) bool { return false })
+	binary.LittleEndian.PutUint32(part[0x28:], 1<<28-1) // Size of the contained file
+	binary.LittleEndian.PutUint32(part[0x30:], 1)       // Block size
+	if _, err := NewPart(bytes.NewReader(part)); err != ErrInvalidArchive {
+		t.Errorf("Expected: %v, got: %v", ErrInvalidArchive, err)
+	}
+}
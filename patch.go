@@ -0,0 +1,241 @@
+// Incremental patches (PTCH files) of patch archives.
+//
+// The stored data of patch files (see FlagPatchFile) starts with a patch info (length of the patch info, flags,
+// size of the patch data and MD5 of the patch data), followed by the (optionally compressed) PTCH file
+// the same way as the data of other files (the sector offsets are relative to the end of the patch info).
+//
+// A PTCH file starts with a header holding the sizes of the file before and after patching,
+// an MD5 block (with the MD5 digests of the file before and after patching) and an XFRM block
+// holding the patch type and the patch data:
+//   - COPY: the patch data is the patched file itself,
+//   - BSD0: the patch data is a (Blizzard flavored) BSDIFF40 patch, optionally compressed with a simple RLE.
+//
+// All numbers are little endian, including the ones in the BSDIFF40 data.
+
+package mpq
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrInvalidPatch indicates an invalid or unsupported PTCH patch
+	ErrInvalidPatch = errors.New("Invalid MPQ patch")
+
+	// ErrPatchBaseMismatch indicates that the base file does not match the one the patch was made for
+	ErrPatchBaseMismatch = errors.New("Patch base MD5 mismatch")
+)
+
+// Signatures of the PTCH file and its blocks.
+const (
+	patchSignature     = 0x48435450 // "PTCH"
+	patchMD5Signature  = 0x5f35444d // "MD5_"
+	patchXfrmSignature = 0x4d524658 // "XFRM"
+)
+
+// Patch types.
+const (
+	patchTypeCopy = 0x59504f43 // "COPY"
+	patchTypeBSD0 = 0x30445342 // "BSD0"
+)
+
+// Signature of BSDIFF40 patch data.
+const bsdiffSignature = 0x3034464649445342 // "BSDIFF40"
+
+// Sizes of the headers.
+const (
+	patchHeaderSize  = 4*4 + 4*2 + 16*2 + 4*3 // PTCH header, MD5 block, XFRM block header
+	patchXfrmSize    = 4 * 3                  // XFRM block header
+	bsdiffHeaderSize = 8 * 4
+)
+
+// Size of the patch info preceding the data of patch files.
+const patchInfoSize = 4*3 + 16
+
+// readPatchInfo reads the patch info of a patch file from in at the given offset,
+// and returns its length and the size of the patch data (the PTCH file).
+// The patch info must fit in blockSize, the stored size of the patch file.
+func readPatchInfo(in io.ReadSeeker, offset int64, blockSize uint32) (length, dataSize uint32, err error) {
+	if _, err = in.Seek(offset, 0); err != nil {
+		return
+	}
+	var buf [patchInfoSize]byte
+	if _, err = io.ReadFull(in, buf[:]); err != nil {
+		return
+	}
+	length, dataSize = binary.LittleEndian.Uint32(buf[:]), binary.LittleEndian.Uint32(buf[8:])
+	if length < patchInfoSize || length > blockSize {
+		return 0, 0, ErrInvalidPatch
+	}
+	return
+}
+
+// patchHeader is the header of a PTCH file.
+type patchHeader struct {
+	patchDataSize uint32   // Size of the whole patch (decompressed)
+	sizeBefore    uint32   // Size of the file before patching
+	sizeAfter     uint32   // Size of the file after patching
+	md5Before     [16]byte // MD5 of the file before patching
+	md5After      [16]byte // MD5 of the file after patching
+	xfrmBlockSize uint32   // Size of the XFRM block, including its header
+	patchType     uint32   // Patch type
+}
+
+// parsePatchHeader parses the header of a PTCH file.
+func parsePatchHeader(patch []byte) (*patchHeader, error) {
+	if len(patch) < patchHeaderSize {
+		return nil, ErrInvalidPatch
+	}
+	u32 := func(i int) uint32 { return binary.LittleEndian.Uint32(patch[i:]) }
+
+	if u32(0) != patchSignature || u32(16) != patchMD5Signature || u32(56) != patchXfrmSignature {
+		return nil, ErrInvalidPatch
+	}
+	h := &patchHeader{
+		patchDataSize: u32(4),
+		sizeBefore:    u32(8),
+		sizeAfter:     u32(12),
+		xfrmBlockSize: u32(60),
+		patchType:     u32(64),
+	}
+	copy(h.md5Before[:], patch[24:])
+	copy(h.md5After[:], patch[40:])
+
+	if h.xfrmBlockSize < patchXfrmSize || uint64(len(patch)) < uint64(patchHeaderSize-patchXfrmSize)+uint64(h.xfrmBlockSize) {
+		return nil, ErrInvalidPatch
+	}
+	return h, nil
+}
+
+// ApplyPatch applies a patch (the content of a PTCH file of a patch archive, as returned by FileByName())
+// to the content of the base file, and returns the patched content.
+// ErrInvalidPatch is returned if the patch is invalid or its type is not supported.
+//
+// Before applying a patch that depends on the base content, the MD5 of the base is verified
+// against the one recorded in the patch (if recorded), and ErrPatchBaseMismatch is returned on mismatch.
+func ApplyPatch(base, patch []byte) ([]byte, error) {
+	h, err := parsePatchHeader(patch)
+	if err != nil {
+		return nil, err
+	}
+	data := patch[patchHeaderSize : patchHeaderSize-patchXfrmSize+int(h.xfrmBlockSize)]
+
+	switch h.patchType {
+	case patchTypeCopy:
+		return append([]byte{}, data...), nil
+	case patchTypeBSD0:
+		if h.patchDataSize < patchHeaderSize {
+			return nil, ErrInvalidPatch
+		}
+		if h.md5Before != [16]byte{} && md5.Sum(base) != h.md5Before {
+			return nil, ErrPatchBaseMismatch
+		}
+		// Patch data is compressed if it's smaller than its decompressed size
+		if size := int(h.patchDataSize - patchHeaderSize); len(data) < size {
+			decompressed := make([]byte, size)
+			if err := decompressPatchRLE(decompressed, data); err != nil {
+				return nil, err
+			}
+			data = decompressed
+		}
+		return applyBsdiff(base, data)
+	}
+
+	return nil, ErrInvalidPatch
+}
+
+// decompressPatchRLE decompresses the RLE compressed patch data src into dst.
+// The compressed data starts with a 32-bit value (ignored), followed by control bytes:
+// if the highest bit is set, the lower 7 bits + 1 bytes are copied from src, else control byte + 1 zeros follow.
+func decompressPatchRLE(dst, src []byte) error {
+	if len(src) < 4 {
+		return ErrInvalidPatch
+	}
+	src = src[4:]
+
+	var i int
+	for len(src) > 0 && i < len(dst) {
+		ctrl := src[0]
+		src = src[1:]
+		if ctrl&0x80 != 0 {
+			n := int(ctrl&0x7f) + 1
+			if n > len(src) {
+				n = len(src)
+			}
+			i += copy(dst[i:], src[:n])
+			src = src[n:]
+		} else {
+			for end := i + int(ctrl) + 1; i < end && i < len(dst); i++ {
+				dst[i] = 0
+			}
+		}
+	}
+	// Unspecified bytes are zeros:
+	for ; i < len(dst); i++ {
+		dst[i] = 0
+	}
+
+	return nil
+}
+
+// applyBsdiff applies the BSDIFF40 patch data to old, and returns the new data.
+//
+// The patch data consists of a header (signature, control block size, data block size, new size)
+// followed by the control block, the data (diff) block and the extra block.
+// The control block has 3 32-bit values for each step: number of bytes to add from the data block to
+// the bytes of old, number of bytes to copy from the extra block, and the (sign-magnitude) move in old.
+func applyBsdiff(old, patch []byte) ([]byte, error) {
+	if len(patch) < bsdiffHeaderSize || binary.LittleEndian.Uint64(patch) != bsdiffSignature {
+		return nil, ErrInvalidPatch
+	}
+	ctrlSize, dataSize, newSize := binary.LittleEndian.Uint64(patch[8:]), binary.LittleEndian.Uint64(patch[16:]), binary.LittleEndian.Uint64(patch[24:])
+	patch = patch[bsdiffHeaderSize:]
+	if ctrlSize > uint64(len(patch)) || dataSize > uint64(len(patch))-ctrlSize || newSize > 1<<31 {
+		return nil, ErrInvalidPatch
+	}
+	ctrl, data, extra := patch[:ctrlSize], patch[ctrlSize:ctrlSize+dataSize], patch[ctrlSize+dataSize:]
+
+	out := make([]byte, newSize)
+	var newPos, oldPos int64
+	for newPos < int64(newSize) {
+		if len(ctrl) < 12 {
+			return nil, ErrInvalidPatch
+		}
+		addLen := int64(binary.LittleEndian.Uint32(ctrl))
+		copyLen := int64(binary.LittleEndian.Uint32(ctrl[4:]))
+		move := binary.LittleEndian.Uint32(ctrl[8:])
+		ctrl = ctrl[12:]
+
+		// Add the diff bytes to old
+		if newPos+addLen > int64(newSize) || addLen > int64(len(data)) {
+			return nil, ErrInvalidPatch
+		}
+		copy(out[newPos:], data[:addLen])
+		data = data[addLen:]
+		for i := int64(0); i < addLen; i, newPos, oldPos = i+1, newPos+1, oldPos+1 {
+			if oldPos >= 0 && oldPos < int64(len(old)) {
+				out[newPos] += old[oldPos]
+			}
+		}
+
+		// Copy the extra bytes
+		if newPos+copyLen > int64(newSize) || copyLen > int64(len(extra)) {
+			return nil, ErrInvalidPatch
+		}
+		copy(out[newPos:], extra[:copyLen])
+		extra = extra[copyLen:]
+		newPos += copyLen
+
+		// Move in old
+		if move&0x80000000 != 0 {
+			oldPos -= int64(move & 0x7fffffff)
+		} else {
+			oldPos += int64(move)
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,237 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// buildPatch builds a PTCH file of the given type with the given (stored) patch data.
+// dataSize is the decompressed size of the patch data.
+func buildPatch(patchType uint32, sizeBefore, sizeAfter, dataSize int, data []byte) []byte {
+	var buf bytes.Buffer
+	w := func(v uint32) { binary.Write(&buf, binary.LittleEndian, v) }
+	w(patchSignature)
+	w(uint32(patchHeaderSize + dataSize))
+	w(uint32(sizeBefore))
+	w(uint32(sizeAfter))
+	w(patchMD5Signature)
+	w(8 + 16*2)
+	buf.Write(make([]byte, 16*2))
+	w(patchXfrmSignature)
+	w(uint32(patchXfrmSize + len(data)))
+	w(patchType)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// buildBsdiff builds BSDIFF40 patch data from control triplets, diff and extra data.
+func buildBsdiff(ctrl []uint32, diff, extra []byte, newSize int) []byte {
+	var buf bytes.Buffer
+	for _, v := range []uint64{bsdiffSignature, uint64(len(ctrl) * 4), uint64(len(diff)), uint64(newSize)} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	binary.Write(&buf, binary.LittleEndian, ctrl)
+	buf.Write(diff)
+	buf.Write(extra)
+	return buf.Bytes()
+}
+
+func TestApplyPatch(t *testing.T) {
+	base := []byte("Hello, World!")
+	want := []byte("Hello, Gophers! World!")
+
+	// Keep "Hello, ", insert "Gophers! ", then keep "World!".
+	ctrl := []uint32{
+		7, 9, 0, // "Hello, " from base, "Gophers! " from extra
+		6, 0, 0, // "World!" from base
+	}
+	bsdiff := buildBsdiff(ctrl, make([]byte, 13), []byte("Gophers! "), len(want))
+
+	// RLE compressed variant: zeros of the diff block are compressed.
+	var rle []byte
+	rle = append(rle, 0, 0, 0, 0) // Ignored
+	head := bsdiff[:len(bsdiff)-9-13]
+	for len(head) > 0 {
+		n := len(head)
+		if n > 128 {
+			n = 128
+		}
+		rle = append(rle, 0x80|byte(n-1))
+		rle = append(rle, head[:n]...)
+		head = head[n:]
+	}
+	rle = append(rle, 12) // 13 zeros
+	rle = append(rle, 0x80|8)
+	rle = append(rle, "Gophers! "...)
+
+	cases := []struct {
+		name  string
+		patch []byte
+	}{
+		{"COPY", buildPatch(patchTypeCopy, len(base), len(want), len(want), want)},
+		{"BSD0", buildPatch(patchTypeBSD0, len(base), len(want), len(bsdiff), bsdiff)},
+		{"BSD0-RLE", buildPatch(patchTypeBSD0, len(base), len(want), len(bsdiff), rle)},
+	}
+	for _, c := range cases {
+		got, err := ApplyPatch(base, c.patch)
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", c.name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("[%s] Got: %q, want: %q", c.name, got, want)
+		}
+	}
+
+	// Invalid patches:
+	invalids := [][]byte{
+		nil,
+		[]byte("PTCH"),
+		buildPatch(0x12345678, len(base), len(want), len(want), want),
+		buildPatch(patchTypeBSD0, len(base), len(want), len(bsdiff), bsdiff[:40]),
+	}
+	for i, patch := range invalids {
+		if _, err := ApplyPatch(base, patch); err != ErrInvalidPatch {
+			t.Errorf("[%d] Expected: %v, got: %v", i, ErrInvalidPatch, err)
+		}
+	}
+}
+
+func TestApplyPatchBaseMD5(t *testing.T) {
+	base := []byte("Hello, World!")
+	ctrl := []uint32{13, 0, 0}
+	bsdiff := buildBsdiff(ctrl, make([]byte, 13), nil, len(base))
+
+	patch := buildPatch(patchTypeBSD0, len(base), len(base), len(bsdiff), bsdiff)
+	sum := md5.Sum(base)
+	copy(patch[24:], sum[:]) // MD5 before patching
+
+	if got, err := ApplyPatch(base, patch); err != nil || !bytes.Equal(got, base) {
+		t.Errorf("Got: %q, %v, want: %q, nil", got, err, base)
+	}
+	if _, err := ApplyPatch([]byte("Hello, Gopher"), patch); err != ErrPatchBaseMismatch {
+		t.Errorf("Expected: %v, got: %v", ErrPatchBaseMismatch, err)
+	}
+
+	// COPY patches don't depend on the base:
+	patch = buildPatch(patchTypeCopy, len(base), len(base), len(base), base)
+	copy(patch[24:], sum[:])
+	if got, err := ApplyPatch(nil, patch); err != nil || !bytes.Equal(got, base) {
+		t.Errorf("Got: %q, %v, want: %q, nil", got, err, base)
+	}
+}
+
+// buildPatchArchive builds a format version 1 patch archive holding a single patch file with the given name
+// and PTCH content, laid out like in real patch archives: the patch info followed by the sector offset table
+// and the compressed sectors.
+func buildPatchArchive(t *testing.T, name string, ptch []byte) []byte {
+	le := binary.LittleEndian
+	const sectorSize = 512 // Sector size shift 0
+
+	// Sectors and their offset table, relative to the end of the patch info:
+	sectors := (len(ptch) + sectorSize - 1) / sectorSize
+	offsets := make([]byte, (sectors+1)*4)
+	le.PutUint32(offsets, uint32(len(offsets)))
+	var sectorData []byte
+	for k := 0; k < sectors; k++ {
+		sector := ptch[k*sectorSize:]
+		if len(sector) > sectorSize {
+			sector = sector[:sectorSize]
+		}
+		compressed, err := compressMulti(sector, CompressionZlib, 9)
+		if err != nil {
+			t.Fatalf("Failed to compress sector: %v", err)
+		}
+		sectorData = append(sectorData, compressed...)
+		le.PutUint32(offsets[(k+1)*4:], uint32(len(offsets)+len(sectorData)))
+	}
+
+	info := make([]byte, patchInfoSize)
+	le.PutUint32(info, patchInfoSize)
+	le.PutUint32(info[4:], 0x80000000)
+	le.PutUint32(info[8:], uint32(len(ptch)))
+	sum := md5.Sum(ptch)
+	copy(info[12:], sum[:])
+	data := append(append(info, offsets...), sectorData...)
+
+	hashTable := bytes.Repeat([]byte{0xff}, 4*16)
+	h1, h2, h3 := FileNameHash(name)
+	e := hashTable[(h1&3)*16:]
+	le.PutUint32(e, h2)
+	le.PutUint32(e[4:], h3)
+	le.PutUint32(e[8:], 0) // Locale and platform
+	le.PutUint32(e[12:], 0)
+	encrypt(hashTable, hashTableKey)
+
+	blockTable := make([]byte, 16)
+	le.PutUint32(blockTable, headerSizeV1)
+	le.PutUint32(blockTable[4:], uint32(len(data)))
+	le.PutUint32(blockTable[8:], uint32(len(ptch)+100)) // Not the size of the PTCH file
+	le.PutUint32(blockTable[12:], beFlagFile|beFlagCompressedMulti|uint32(FlagPatchFile))
+	encrypt(blockTable, blockTableKey)
+
+	header := make([]byte, headerSizeV1)
+	copy(header, headerMagic[:])
+	le.PutUint32(header[0x04:], headerSizeV1)
+	le.PutUint32(header[0x08:], uint32(headerSizeV1+len(data)+len(hashTable)+len(blockTable)))
+	le.PutUint32(header[0x10:], uint32(headerSizeV1+len(data)))
+	le.PutUint32(header[0x14:], uint32(headerSizeV1+len(data)+len(hashTable)))
+	le.PutUint32(header[0x18:], 4)
+	le.PutUint32(header[0x1c:], 1)
+
+	return append(append(append(header, data...), hashTable...), blockTable...)
+}
+
+func TestPatchArchive(t *testing.T) {
+	base := []byte("Hello, World!")
+	big := bytes.Repeat([]byte("Hello, Gophers! "), 100) // Spans multiple sectors
+
+	// Replace the base, then increment the first byte of the result:
+	copyPatch := buildPatch(patchTypeCopy, len(base), len(big), len(big), big)
+	diff := make([]byte, len(big))
+	diff[0] = 1
+	bsdiff := buildBsdiff([]uint32{uint32(len(big)), 0, 0}, diff, nil, len(big))
+	bsdPatch := buildPatch(patchTypeBSD0, len(big), len(big), len(bsdiff), bsdiff)
+	want := append([]byte{'I'}, big[1:]...)
+
+	patch1, err := New(bytes.NewReader(buildPatchArchive(t, "a.txt", copyPatch)))
+	if err != nil {
+		t.Fatalf("Failed to open patch archive: %v", err)
+	}
+	patch2, err := New(bytes.NewReader(buildPatchArchive(t, "a.txt", bsdPatch)))
+	if err != nil {
+		t.Fatalf("Failed to open patch archive: %v", err)
+	}
+
+	// The content of a patch file is the PTCH file:
+	if got, err := patch1.FileByName("a.txt"); err != nil || !bytes.Equal(got, copyPatch) {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(got), err, len(copyPatch))
+	}
+	r, err := patch2.Open("a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, bsdPatch) {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(got), err, len(bsdPatch))
+	}
+	r.Close()
+
+	// Patches are applied in the chain:
+	c := NewChain(buildArchive(t, "a.txt", string(base)), patch1, patch2)
+	defer c.Close()
+	if got, err := c.FileByName("a.txt"); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Got: %.20q, %v, want: %.20q", got, err, want)
+	}
+	if got, err := c.FileByHash(FileNameHash("a.txt")); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Got: %.20q, %v, want: %.20q", got, err, want)
+	}
+
+	// The BSD0 patch alone has the wrong base:
+	c = NewChain(buildArchive(t, "a.txt", string(base)), patch2)
+	if got, err := c.FileByName("a.txt"); err != nil || bytes.Equal(got, want) {
+		t.Errorf("Got: %.20q, %v, want: different content", got, err)
+	}
+}
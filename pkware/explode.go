@@ -0,0 +1,198 @@
+// Package pkware implements the PKWARE Data Compression Library (DCL) "implode" format, used by MPQ archives
+// and several other Blizzard formats.
+//
+// Imploded data starts with 2 bytes: the literal mode (0: literals are stored as raw bytes,
+// 1: literals are Huffman coded) and the dictionary size in bits (4, 5 or 6 for a 1, 2 or 4 KB dictionary).
+// It is followed by a bit stream (least significant bit first) of literals and length-distance pairs,
+// terminated by a special length (519). The Huffman codes are fixed, and are stored bit-inverted.
+//
+// The decompression is based on Mark Adler's blast.c.
+package pkware
+
+import "errors"
+
+// ErrCorrupt is returned if the imploded data is invalid or truncated
+var ErrCorrupt = errors.New("Corrupt PKWARE DCL data")
+
+// Code lengths of the fixed Huffman codes, in compact form: each byte holds a code length in its lower 4 bits,
+// and the number of symbols having that code length minus 1 in its higher 4 bits.
+var (
+	explodeLitLen = []byte{
+		11, 124, 8, 7, 28, 7, 188, 13, 76, 4, 10, 8, 12, 10, 12, 10, 8, 23, 8,
+		9, 7, 6, 7, 8, 7, 6, 55, 8, 23, 24, 12, 11, 7, 9, 11, 12, 6, 7, 22, 5,
+		7, 24, 6, 11, 9, 6, 7, 22, 7, 11, 38, 7, 9, 8, 25, 11, 8, 11, 9, 12,
+		8, 12, 5, 38, 5, 38, 5, 11, 7, 5, 6, 21, 6, 10, 53, 8, 7, 24, 10, 27,
+		44, 253, 253, 253, 252, 252, 252, 13, 12, 45, 12, 45, 12, 61, 12, 45,
+		44, 173}
+	explodeLenLen  = []byte{2, 35, 36, 53, 38, 23}
+	explodeDistLen = []byte{2, 20, 53, 230, 247, 151, 248}
+)
+
+// Base values and extra bits of the length symbols.
+var (
+	explodeLenBase  = [16]int{3, 2, 4, 5, 6, 7, 8, 9, 10, 12, 16, 24, 40, 72, 136, 264}
+	explodeLenExtra = [16]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}
+)
+
+// Length marking the end of the stream.
+const explodeEndLen = 519
+
+// Maximum code length of the Huffman codes.
+const explodeMaxBits = 13
+
+// huffman is a canonical Huffman code used for decoding.
+type huffman struct {
+	count  [explodeMaxBits + 1]int // Number of symbols of each code length
+	symbol []int                   // Symbols ordered by code length, then by value
+}
+
+// Huffman codes of the literals, lengths and distances.
+var (
+	explodeLitCode  = newHuffman(explodeLitLen)
+	explodeLenCode  = newHuffman(explodeLenLen)
+	explodeDistCode = newHuffman(explodeDistLen)
+)
+
+// newHuffman builds a Huffman code from its code lengths in compact form.
+func newHuffman(compact []byte) *huffman {
+	var lengths []int
+	for _, b := range compact {
+		for i := 0; i <= int(b>>4); i++ {
+			lengths = append(lengths, int(b&15))
+		}
+	}
+
+	h := &huffman{symbol: make([]int, len(lengths))}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	var offs [explodeMaxBits + 1]int
+	for l := 1; l < explodeMaxBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for sym, l := range lengths {
+		h.symbol[offs[l]] = sym
+		offs[l]++
+	}
+	return h
+}
+
+// bitReader reads a bit stream, least significant bit first.
+type bitReader struct {
+	src    []byte
+	pos    int  // Position of the next byte in src
+	buf    uint // Bit buffer
+	bitCnt uint // Number of bits in buf
+}
+
+// bits returns the next n bits (n <= 16).
+func (br *bitReader) bits(n uint) (int, error) {
+	for br.bitCnt < n {
+		if br.pos >= len(br.src) {
+			return 0, ErrCorrupt
+		}
+		br.buf |= uint(br.src[br.pos]) << br.bitCnt
+		br.pos++
+		br.bitCnt += 8
+	}
+	v := int(br.buf & (1<<n - 1))
+	br.buf >>= n
+	br.bitCnt -= n
+	return v, nil
+}
+
+// decode decodes the next symbol using the Huffman code h.
+func (br *bitReader) decode(h *huffman) (int, error) {
+	var code, first, index int
+	for l := 1; l <= explodeMaxBits; l++ {
+		bit, err := br.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= bit ^ 1 // Codes are stored inverted
+		count := h.count[l]
+		if code < first+count {
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, ErrCorrupt
+}
+
+// Explode decompresses the imploded src into dst, and returns the decompressed size.
+// Decompression stops when dst is full or at the end of the stream, so the size of dst may be
+// the exact decompressed size (if known) or an upper limit of it.
+// ErrCorrupt is returned if src is invalid or truncated.
+func Explode(dst, src []byte) (int, error) {
+	if len(src) < 2 {
+		return 0, ErrCorrupt
+	}
+	lit, dict := src[0], uint(src[1])
+	if lit > 1 || dict < 4 || dict > 6 {
+		return 0, ErrCorrupt
+	}
+
+	br := &bitReader{src: src[2:]}
+	n := 0
+	for n < len(dst) {
+		flag, err := br.bits(1)
+		if err != nil {
+			return 0, err
+		}
+
+		if flag == 0 {
+			// Literal
+			var sym int
+			if lit == 1 {
+				sym, err = br.decode(explodeLitCode)
+			} else {
+				sym, err = br.bits(8)
+			}
+			if err != nil {
+				return 0, err
+			}
+			dst[n] = byte(sym)
+			n++
+			continue
+		}
+
+		// Length-distance pair
+		sym, err := br.decode(explodeLenCode)
+		if err != nil {
+			return 0, err
+		}
+		extra, err := br.bits(explodeLenExtra[sym])
+		if err != nil {
+			return 0, err
+		}
+		length := explodeLenBase[sym] + extra
+		if length == explodeEndLen {
+			break
+		}
+
+		distBits := dict
+		if length == 2 {
+			distBits = 2
+		}
+		if sym, err = br.decode(explodeDistCode); err != nil {
+			return 0, err
+		}
+		if extra, err = br.bits(distBits); err != nil {
+			return 0, err
+		}
+		dist := sym<<distBits + extra + 1
+		if dist > n {
+			return 0, ErrCorrupt
+		}
+
+		// Copy (the source and destination may overlap)
+		for ; length > 0 && n < len(dst); length-- {
+			dst[n] = dst[n-dist]
+			n++
+		}
+	}
+
+	return n, nil
+}
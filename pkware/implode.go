@@ -0,0 +1,193 @@
+// Compression to the PKWARE Data Compression Library (DCL) "implode" format.
+
+package pkware
+
+import "errors"
+
+// Mode is the literal mode of imploded data.
+type Mode byte
+
+// Literal modes.
+const (
+	// Binary stores literals as raw bytes, suitable for arbitrary data.
+	Binary Mode = 0
+
+	// ASCII stores literals Huffman coded, optimized for text.
+	ASCII Mode = 1
+)
+
+// ErrDictSize is returned by Implode if the dictionary size is invalid
+var ErrDictSize = errors.New("Invalid PKWARE DCL dictionary size")
+
+// Parameters of the match finder.
+const (
+	implodeMinLen     = 3                 // Minimum length of the matches (repetitions) used
+	implodeMaxLen     = explodeEndLen - 1 // Maximum length of the matches
+	implodeHashBits   = 12                // Number of bits of the hash of the match prefixes
+	implodeChainDepth = 64                // Maximum number of candidates examined for a match
+)
+
+// huffmanCode is the code of a symbol prepared for writing: its bits are inverted and reversed,
+// so it can be written to the bit stream (least significant bit first) as a single value.
+type huffmanCode struct {
+	code uint32 // Bits of the code
+	len  uint   // Length of the code in bits
+}
+
+// Huffman codes of the literals, lengths and distances, indexed by the symbols.
+var (
+	implodeLitCode  = explodeLitCode.codes()
+	implodeLenCode  = explodeLenCode.codes()
+	implodeDistCode = explodeDistCode.codes()
+)
+
+// implodeLenSym holds the length symbols of the match lengths.
+var implodeLenSym = func() (syms [explodeEndLen]byte) {
+	for sym, base := range explodeLenBase {
+		for i := 0; i < 1<<explodeLenExtra[sym] && base+i < explodeEndLen; i++ {
+			syms[base+i] = byte(sym)
+		}
+	}
+	return
+}()
+
+// codes returns the codes of the symbols of the canonical Huffman code h, indexed by the symbols.
+func (h *huffman) codes() []huffmanCode {
+	codes := make([]huffmanCode, len(h.symbol))
+	code, index := 0, 0
+	for l := 1; l <= explodeMaxBits; l++ {
+		for i := 0; i < h.count[l]; i++ {
+			// Codes are read most significant bit first, inverted
+			var c uint32
+			for b := 0; b < l; b++ {
+				c |= uint32((code+i)>>uint(l-1-b)&1^1) << uint(b)
+			}
+			codes[h.symbol[index+i]] = huffmanCode{code: c, len: uint(l)}
+		}
+		index += h.count[l]
+		code = (code + h.count[l]) << 1
+	}
+	return codes
+}
+
+// bitWriter writes a bit stream, least significant bit first.
+type bitWriter struct {
+	out    []byte
+	buf    uint32 // Bit buffer
+	bitCnt uint   // Number of bits in buf
+}
+
+// bits writes the lower n bits of v (n <= 16).
+func (bw *bitWriter) bits(v uint32, n uint) {
+	bw.buf |= v << bw.bitCnt
+	for bw.bitCnt += n; bw.bitCnt >= 8; bw.bitCnt -= 8 {
+		bw.out = append(bw.out, byte(bw.buf))
+		bw.buf >>= 8
+	}
+}
+
+// code writes a Huffman code.
+func (bw *bitWriter) code(hc huffmanCode) {
+	bw.bits(hc.code, hc.len)
+}
+
+// flush writes the remaining bits, padded to a byte.
+func (bw *bitWriter) flush() {
+	if bw.bitCnt > 0 {
+		bw.out = append(bw.out, byte(bw.buf))
+		bw.buf, bw.bitCnt = 0, 0
+	}
+}
+
+// Implode compresses src using the given literal mode and dictionary size (1024, 2048 or 4096 bytes),
+// and returns the imploded data which can be decompressed with Explode().
+// Bigger dictionaries usually result in better compression.
+// ErrDictSize is returned if the dictionary size is invalid.
+func Implode(src []byte, mode Mode, dictSize int) ([]byte, error) {
+	var dictBits uint
+	switch dictSize {
+	case 1024:
+		dictBits = 4
+	case 2048:
+		dictBits = 5
+	case 4096:
+		dictBits = 6
+	default:
+		return nil, ErrDictSize
+	}
+
+	bw := &bitWriter{out: make([]byte, 2, 2+len(src)/2)}
+	bw.out[0], bw.out[1] = byte(mode), byte(dictBits)
+
+	var head [1 << implodeHashBits]int32
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(src))
+	hash := func(i int) int {
+		return int((uint32(src[i])<<16 | uint32(src[i+1])<<8 | uint32(src[i+2])) * 2654435761 >> (32 - implodeHashBits))
+	}
+	insert := func(i int) {
+		if i+implodeMinLen <= len(src) {
+			h := hash(i)
+			prev[i], head[h] = head[h], int32(i)
+		}
+	}
+
+	for i := 0; i < len(src); {
+		// Find the longest match
+		bestLen, bestDist := 0, 0
+		if i+implodeMinLen <= len(src) {
+			maxLen := len(src) - i
+			if maxLen > implodeMaxLen {
+				maxLen = implodeMaxLen
+			}
+			for j, depth := int(head[hash(i)]), 0; j >= 0 && i-j <= dictSize && depth < implodeChainDepth; j, depth = int(prev[j]), depth+1 {
+				l := 0
+				for l < maxLen && src[j+l] == src[i+l] {
+					l++
+				}
+				if l > bestLen {
+					bestLen, bestDist = l, i-j
+					if l == maxLen {
+						break
+					}
+				}
+			}
+		}
+
+		if bestLen < implodeMinLen {
+			// Literal
+			bw.bits(0, 1)
+			if mode == ASCII {
+				bw.code(implodeLitCode[src[i]])
+			} else {
+				bw.bits(uint32(src[i]), 8)
+			}
+			insert(i)
+			i++
+			continue
+		}
+
+		// Length-distance pair
+		bw.bits(1, 1)
+		sym := implodeLenSym[bestLen]
+		bw.code(implodeLenCode[sym])
+		bw.bits(uint32(bestLen-explodeLenBase[sym]), explodeLenExtra[sym])
+		d := uint32(bestDist - 1)
+		bw.code(implodeDistCode[d>>dictBits])
+		bw.bits(d&(1<<dictBits-1), dictBits)
+
+		for end := i + bestLen; i < end; i++ {
+			insert(i)
+		}
+	}
+
+	// End of the stream
+	bw.bits(1, 1)
+	bw.code(implodeLenCode[len(explodeLenBase)-1])
+	bw.bits(uint32(explodeEndLen-explodeLenBase[len(explodeLenBase)-1]), explodeLenExtra[len(explodeLenBase)-1])
+	bw.flush()
+
+	return bw.out, nil
+}
@@ -0,0 +1,71 @@
+package pkware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExplode(t *testing.T) {
+	// Test vector of blast.c
+	src := []byte{0x00, 0x04, 0x82, 0x24, 0x25, 0x8f, 0x80, 0x7f}
+	exp := "AIAIAIAIAIAIA"
+
+	dst := make([]byte, len(exp))
+	if n, err := Explode(dst, src); err != nil || n != len(exp) || string(dst) != exp {
+		t.Errorf("Explode() = %q, %d (err: %v), expected: %q", dst, n, err, exp)
+	}
+
+	// Output longer than the stream
+	if n, err := Explode(make([]byte, len(exp)+1), src); err != nil || n != len(exp) {
+		t.Errorf("Got: %d, %v, want: %d, nil", n, err, len(exp))
+	}
+	// Truncated and invalid input
+	for _, in := range [][]byte{src[:5], {0x02, 0x04, 0x82}, {0x00, 0x07, 0x82}} {
+		if _, err := Explode(make([]byte, len(exp)), in); err != ErrCorrupt {
+			t.Errorf("Expected ErrCorrupt for % x, got: %v", in, err)
+		}
+	}
+}
+
+// testContent returns test data of the given size.
+func testContent(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/13)
+	}
+	return data
+}
+
+func TestImplode(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":      {},
+		"short":      []byte("ab"),
+		"text":       []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)),
+		"binary":     testContent(10000),
+		"zeros":      make([]byte, 5000),
+		"long match": append(testContent(3000), testContent(3000)...),
+	}
+	for name, data := range inputs {
+		for _, mode := range []Mode{Binary, ASCII} {
+			for _, dictSize := range []int{1024, 2048, 4096} {
+				src, err := Implode(data, mode, dictSize)
+				if err != nil {
+					t.Fatalf("[%s] Unexpected error: %v", name, err)
+				}
+				dst := make([]byte, len(data))
+				if n, err := Explode(dst, src); err != nil || n != len(data) || !bytes.Equal(dst, data) {
+					t.Errorf("[%s, mode: %d, dict: %d] Mismatch (n: %d, err: %v)", name, mode, dictSize, n, err)
+				}
+			}
+		}
+	}
+
+	if src, _ := Implode(inputs["text"], ASCII, 4096); len(src) > len(inputs["text"])/10 {
+		t.Errorf("Poor compression: %d bytes of %d", len(src), len(inputs["text"]))
+	}
+
+	if _, err := Implode(nil, Binary, 512); err != ErrDictSize {
+		t.Errorf("Got: %v, want: %v", err, ErrDictSize)
+	}
+}
@@ -0,0 +1,52 @@
+// Pooling of the buffers used to read the sectors.
+
+package mpq
+
+import "sync"
+
+// Maximum capacity of the buffers put into the pools, bigger buffers (e.g. of big files stored as a single unit)
+// are left to the garbage collector instead of being retained.
+const maxPooledSize = 1 << 20
+
+// Pools of the buffers used to read the sectors, shared by all MPQs.
+// Pointers to slices are pooled, so putting them doesn't allocate.
+var (
+	bytesPool   sync.Pool
+	uint32sPool sync.Pool
+)
+
+// getBytes returns a byte slice of the given length from the pool, or a new one
+// if the pooled slice is too small. Its content is undefined.
+func getBytes(size int) *[]byte {
+	if p, _ := bytesPool.Get().(*[]byte); p != nil && cap(*p) >= size {
+		*p = (*p)[:size]
+		return p
+	}
+	b := make([]byte, size)
+	return &b
+}
+
+// putBytes puts a byte slice obtained from getBytes() into the pool. p may be nil.
+func putBytes(p *[]byte) {
+	if p != nil && cap(*p) <= maxPooledSize {
+		bytesPool.Put(p)
+	}
+}
+
+// getUint32s returns a uint32 slice of the given length from the pool, or a new one
+// if the pooled slice is too small. Its content is undefined.
+func getUint32s(size int) *[]uint32 {
+	if p, _ := uint32sPool.Get().(*[]uint32); p != nil && cap(*p) >= size {
+		*p = (*p)[:size]
+		return p
+	}
+	s := make([]uint32, size)
+	return &s
+}
+
+// putUint32s puts a uint32 slice obtained from getUint32s() into the pool. p may be nil.
+func putUint32s(p *[]uint32) {
+	if p != nil && cap(*p)*4 <= maxPooledSize {
+		uint32sPool.Put(p)
+	}
+}
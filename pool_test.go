@@ -0,0 +1,39 @@
+package mpq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPooledSectorBuffers(t *testing.T) {
+	content := bytes.Repeat([]byte("pooled "), 3000) // Multiple sectors
+	m, err := New(bytes.NewReader(buildArchiveBytes(t, "a.txt", string(content))))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	// Buffers returned to the pools must not affect the returned content:
+	first, err := m.FileByName("a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		data, err := m.FileByName("a.txt")
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("Got: %d bytes, %v, want: %d bytes", len(data), err, len(content))
+		}
+	}
+	if !bytes.Equal(first, content) {
+		t.Errorf("Content changed by subsequent reads")
+	}
+
+	// Pooled buffers are resized:
+	p := getBytes(10)
+	putBytes(p)
+	if p = getBytes(100); len(*p) != 100 {
+		t.Errorf("Got: %d length, want: 100", len(*p))
+	}
+	if s := getUint32s(5); len(*s) != 5 {
+		t.Errorf("Got: %d length, want: 5", len(*s))
+	}
+}
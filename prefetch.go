@@ -0,0 +1,104 @@
+// Prefetching the data of the archives in the background.
+
+package mpq
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Size of the chunks the archive is read in when prefetching.
+const prefetchChunkSize = 1 << 20
+
+// prefetcher holds the state of prefetching.
+type prefetcher struct {
+	names []string // Names of the files to prefetch, the whole archive is prefetched if empty
+
+	stopped int32         // Set to 1 (atomically) to stop prefetching
+	done    chan struct{} // Closed when prefetching is finished, nil if not started
+
+	mu       sync.Mutex        // Guards contents (not MPQ.cacheMu, which is held while reading files internally)
+	contents map[string][]byte // Prefetched contents of the files by their names
+}
+
+// WithPrefetch returns an Option which starts reading data of the archive in the background after
+// the archive is opened, so subsequent reads of the files hit warm caches, e.g. when opening the archive
+// and extracting its files are separated by user interaction.
+//
+// If names are given, the content of the named files is read, and handed over by the first FileByName() call
+// of each file (prefetched contents are held until then). Else the whole archive is read (and discarded)
+// to warm the caches of the input, e.g. the page cache of the operating system or the cache of a network file system.
+//
+// Prefetching stops when the MPQ is closed. Errors of prefetching are ignored, files failing to be
+// prefetched are read as usual.
+func WithPrefetch(names ...string) Option {
+	return func(m *MPQ) {
+		m.prefetch = &prefetcher{names: names}
+	}
+}
+
+// startPrefetch starts prefetching in a new goroutine if enabled.
+func (m *MPQ) startPrefetch() {
+	p := m.prefetch
+	if p == nil {
+		return
+	}
+
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+
+		if len(p.names) == 0 {
+			r := io.NewSectionReader(m.inputAt(), m.archiveOffset, int64(m.ArchiveSize()))
+			for atomic.LoadInt32(&p.stopped) == 0 {
+				if _, err := io.CopyN(io.Discard, r, prefetchChunkSize); err != nil {
+					return
+				}
+			}
+			return
+		}
+
+		for _, name := range p.names {
+			if atomic.LoadInt32(&p.stopped) != 0 {
+				return
+			}
+			idx := m.blockIndexByName(name)
+			if idx < 0 {
+				continue
+			}
+			if data, err := m.readFile(idx, name); err == nil {
+				p.mu.Lock()
+				if p.contents == nil {
+					p.contents = map[string][]byte{}
+				}
+				p.contents[name] = data
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// takePrefetched returns the prefetched content of the named file, and removes it from the prefetched contents
+// (the caller owns it). nil is returned if the file is not prefetched (yet).
+func (m *MPQ) takePrefetched(name string) []byte {
+	p := m.prefetch
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data := p.contents[name]
+	delete(p.contents, name)
+	return data
+}
+
+// stopPrefetch stops prefetching, and waits until it is stopped.
+func (m *MPQ) stopPrefetch() {
+	if p := m.prefetch; p != nil && p.done != nil {
+		atomic.StoreInt32(&p.stopped, 1)
+		<-p.done
+	}
+}
@@ -0,0 +1,75 @@
+package mpq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWithPrefetch(t *testing.T) {
+	exp, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer exp.Close()
+	expDetails, _ := exp.FileByName("replay.details")
+
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithPrefetch("replay.details", "missing"), WithIOStats())
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	<-m.prefetch.done
+
+	// The prefetched content is handed over, then read as usual:
+	prefetched := m.prefetch.contents["replay.details"]
+	for i := 0; i < 2; i++ {
+		details, err := m.FileByName("replay.details")
+		if err != nil || !bytes.Equal(details, expDetails) {
+			t.Errorf("[%d] Got: %d bytes, %v, want: %d bytes", i, len(details), err, len(expDetails))
+		}
+		if handedOver := &details[0] == &prefetched[0]; handedOver != (i == 0) {
+			t.Errorf("[%d] Got: %t handed over, want: %t", i, handedOver, i == 0)
+		}
+	}
+	if _, err := m.FileByName("missing"); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Prefetching the whole archive, stopped by Close:
+	m, err = NewFromFile("reps/lotv.SC2Replay", WithPrefetch(), WithIOStats())
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	m, err = NewFromFile("reps/lotv.SC2Replay", WithPrefetch(), WithIOStats())
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	<-m.prefetch.done
+	if got := m.IOStats().BytesRead; got < int64(m.ArchiveSize()) {
+		t.Errorf("Got: %d bytes read, want: at least %d", got, m.ArchiveSize())
+	}
+}
+
+func TestWithPrefetchInternalReads(t *testing.T) {
+	// Internal reads of files (e.g. the "(listfile)" and the "(attributes)") must not contend with prefetching:
+	m, err := NewFromFile("reps/lotv.SC2Replay", WithPrefetch(listfileName, attributesName))
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	<-m.prefetch.done
+
+	h1, h2, h3 := FileNameHash("replay.details")
+	if got := m.NameForHash(h1, h2, h3); got != "replay.details" {
+		t.Errorf("Got: %q, want: %q", got, "replay.details")
+	}
+	if _, err := m.Attributes(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
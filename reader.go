@@ -0,0 +1,272 @@
+// Streaming reading of the files of the archives.
+
+package mpq
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// FileReader reads the content of a file of an archive sector by sector, on demand, so only a few
+// sectors are held in memory at a time (except for files stored as a single unit). See MPQ.Open().
+//
+// Besides sequential reading, FileReader implements io.ReaderAt and io.Seeker: only the sectors covering
+// the requested range are read and decompressed, and the most recently used sectors are cached,
+// so random access into large files does not require extracting them.
+//
+// Reading interleaved with other reads of the same MPQ is allowed. ReadAt may be called concurrently,
+// but Read and Seek (just like MPQ) must not be used concurrently from multiple goroutines.
+type FileReader struct {
+	sr *sectorReader // Sector reader of the file, nil if closed
+
+	size   int64 // Uncompressed size of the file
+	offset int64 // Offset of the next Read
+
+	mu    sync.Mutex     // Serializes reading the sectors and accessing the cache
+	cache []cachedSector // Recently read sectors, the most recently used first
+}
+
+// cachedSector is a decompressed sector cached by FileReader.
+type cachedSector struct {
+	index uint32 // Index of the sector
+	data  []byte // Content of the sector
+}
+
+// Maximum number of sectors cached by FileReader.
+const fileReaderCacheSize = 4
+
+// Open opens a file specified by its name for streaming reading: the file is decrypted and decompressed
+// sector by sector as it is read, instead of materializing its whole content like FileByName().
+// The returned FileReader should be closed when no longer needed.
+//
+// ErrFileNotFound is returned if the file cannot be found.
+// ErrFileDeleted is returned if the file is marked as deleted (by a deletion marker).
+// ErrCorruptSectorTable or ErrInvalidArchive is returned if reading the sector offset table of the file fails.
+// Errors of reading the tables are returned as-is (see WithLazyTables).
+// Reading the returned FileReader may return the errors documented at FileByName().
+func (m *MPQ) Open(name string) (*FileReader, error) {
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	idx := m.blockIndexByName(name)
+	if idx < 0 {
+		return nil, ErrFileNotFound
+	}
+	return m.openFile(idx, name)
+}
+
+// openFile opens the file stored in the block specified by its block table index for streaming reading.
+// name is the file name, used to decrypt encrypted files; it may be empty if unknown.
+func (m *MPQ) openFile(blockEntryIndex int, name string) (*FileReader, error) {
+	sr, err := m.newSectorReader(m.fileInput(), blockEntryIndex, name)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReader{sr: sr, size: int64(sr.blockEntry.fileSize)}, nil
+}
+
+// ExtractTo writes the content of a file specified by its name to w, sector by sector,
+// without materializing the whole content like FileByName(). Useful to pipe files into hashers,
+// HTTP responses or files on disk. It returns the number of bytes written.
+//
+// ErrFileNotFound is returned if the file cannot be found.
+// Errors of w are returned as-is, other return values are the same as those of FileByName().
+func (m *MPQ) ExtractTo(name string, w io.Writer) (n int64, err error) {
+	if err = m.loadTables(); err != nil {
+		return 0, err
+	}
+	idx := m.blockIndexByName(name)
+	if idx < 0 {
+		return 0, ErrFileNotFound
+	}
+	sr, err := m.newSectorReader(m.fileInput(), idx, name)
+	if err != nil {
+		return 0, err
+	}
+	defer sr.release()
+	return sr.writeTo(w, 0)
+}
+
+// writeTo writes the content of the sectors starting at the given sector index to w.
+func (sr *sectorReader) writeTo(w io.Writer, sector uint32) (n int64, err error) {
+	var buf []byte
+	for k := sector; k < sr.sectorsCount; k++ {
+		size := int(sr.sectorSize(k))
+		if cap(buf) >= size {
+			buf = buf[:size]
+		} else {
+			if err = sr.m.checkAlloc(uint64(size)); err != nil {
+				return
+			}
+			buf = make([]byte, size)
+		}
+		if err = sr.readSector(k, buf); err != nil {
+			return
+		}
+		var c int
+		c, err = w.Write(buf)
+		n += int64(c)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Size returns the (uncompressed) size of the file.
+func (r *FileReader) Size() int64 {
+	return r.size
+}
+
+// Read implements io.Reader, it reads the next sectors of the file as needed.
+func (r *FileReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil // Reported by the next Read
+	}
+	return
+}
+
+// ReadAt implements io.ReaderAt, it reads the sectors covering the requested range as needed.
+func (r *FileReader) ReadAt(p []byte, off int64) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sr := r.sr
+	if sr == nil {
+		return 0, fs.ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("Negative offset")
+	}
+
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+
+		k, start := sr.sectorAt(off)
+		data, err := r.sector(k)
+		if err != nil {
+			return n, err
+		}
+
+		c := copy(p[n:], data[off-start:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo, it writes the rest of the file to w sector by sector,
+// without copying the content into intermediate buffers like io.Copy() would.
+func (r *FileReader) WriteTo(w io.Writer) (n int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sr := r.sr
+	if sr == nil {
+		return 0, fs.ErrClosed
+	}
+	if r.offset >= r.size {
+		return 0, nil
+	}
+
+	// Rest of the sector containing the offset
+	k, start := sr.sectorAt(r.offset)
+	if r.offset > start {
+		data, err := r.sector(k)
+		if err != nil {
+			return 0, err
+		}
+		c, err := w.Write(data[r.offset-start:])
+		n += int64(c)
+		r.offset += int64(c)
+		if err != nil {
+			return n, err
+		}
+		k++
+	}
+
+	c, err := sr.writeTo(w, k)
+	n += c
+	r.offset += c
+	return n, err
+}
+
+// sectorAt returns the index and the offset of the sector containing the given offset of the file.
+func (sr *sectorReader) sectorAt(offset int64) (k uint32, start int64) {
+	if sr.sectorsCount > 1 {
+		k = uint32(offset / int64(sr.m.blockSize))
+		start = int64(k) * int64(sr.m.blockSize)
+	}
+	return
+}
+
+// sector returns the content of the sector specified by its index, from the cache if it is cached.
+// The returned slice is only valid until the next call.
+func (r *FileReader) sector(k uint32) ([]byte, error) {
+	for i, cs := range r.cache {
+		if cs.index == k {
+			copy(r.cache[1:i+1], r.cache[:i])
+			r.cache[0] = cs
+			return cs.data, nil
+		}
+	}
+
+	// Reuse the buffer of the least recently used sector if the cache is full
+	var buf []byte
+	if len(r.cache) == fileReaderCacheSize {
+		buf = r.cache[len(r.cache)-1].data
+		r.cache = r.cache[:len(r.cache)-1]
+	}
+	size := int(r.sr.sectorSize(k))
+	if cap(buf) >= size {
+		buf = buf[:size]
+	} else {
+		if err := r.sr.m.checkAlloc(uint64(size)); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, size)
+	}
+	if err := r.sr.readSector(k, buf); err != nil {
+		return nil, err
+	}
+
+	r.cache = append(r.cache, cachedSector{})
+	copy(r.cache[1:], r.cache)
+	r.cache[0] = cachedSector{index: k, data: buf}
+	return buf, nil
+}
+
+// Seek implements io.Seeker, it sets the offset of the next Read.
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	if r.sr == nil {
+		return 0, fs.ErrClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.Size()
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close implements io.Closer. Reading a closed FileReader returns fs.ErrClosed.
+func (r *FileReader) Close() error {
+	r.mu.Lock()
+	if r.sr != nil {
+		r.sr.release()
+	}
+	r.sr, r.cache = nil, nil
+	r.mu.Unlock()
+	return nil
+}
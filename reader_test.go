@@ -0,0 +1,202 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+func TestOpen(t *testing.T) {
+	content := strings.Repeat("streaming content ", 1000) // Multiple sectors
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []struct {
+		name string
+		opts []FileOption
+	}{
+		{"stored.txt", nil},
+		{"zlib.txt", []FileOption{FileCompression(CompressionZlib)}},
+		{"encrypted.txt", []FileOption{FileCompression(CompressionZlib), FileEncrypted(true)}},
+		{"empty.txt", nil},
+	}
+	for _, f := range files {
+		data := content
+		if f.name == "empty.txt" {
+			data = ""
+		}
+		if err := w.AddFile(f.name, []byte(data), f.opts...); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	for _, f := range files {
+		exp := content
+		if f.name == "empty.txt" {
+			exp = ""
+		}
+		r, err := m.Open(f.name)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %v", f.name, err)
+		}
+		if r.Size() != int64(len(exp)) {
+			t.Errorf("[%s] Got: %d size, want: %d", f.name, r.Size(), len(exp))
+		}
+		if err := iotest.TestReader(r, []byte(exp)); err != nil {
+			t.Errorf("[%s] %v", f.name, err)
+		}
+		r.Close()
+		if _, err := r.Read(make([]byte, 1)); err != fs.ErrClosed {
+			t.Errorf("[%s] Got: %v, want: %v", f.name, err, fs.ErrClosed)
+		}
+		if r.Size() != int64(len(exp)) {
+			t.Errorf("[%s] Got: %d size after Close, want: %d", f.name, r.Size(), len(exp))
+		}
+	}
+
+	// Small reads:
+	r, _ := m.Open("zlib.txt")
+	data, err := io.ReadAll(iotest.OneByteReader(r))
+	if err != nil || string(data) != content {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(data), err, len(content))
+	}
+
+	if _, err := m.Open("missing.txt"); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+}
+
+func TestExtractTo(t *testing.T) {
+	content := strings.Repeat("extracted content ", 1000)
+	m := buildArchive(t, "a.txt", content, "empty.txt", "")
+
+	h := md5.New()
+	n, err := m.ExtractTo("a.txt", h)
+	if err != nil || n != int64(len(content)) {
+		t.Errorf("Got: %d, %v, want: %d, nil", n, err, len(content))
+	}
+	if got, exp := h.Sum(nil), md5.Sum([]byte(content)); !bytes.Equal(got, exp[:]) {
+		t.Errorf("Got: %x, want: %x", got, exp)
+	}
+
+	var buf bytes.Buffer
+	if n, err = m.ExtractTo("empty.txt", &buf); err != nil || n != 0 {
+		t.Errorf("Got: %d, %v, want: 0, nil", n, err)
+	}
+
+	if _, err = m.ExtractTo("missing.txt", &buf); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+
+	// Write errors are returned:
+	if _, err = m.ExtractTo("a.txt", errWriter{}); err != io.ErrShortWrite {
+		t.Errorf("Got: %v, want: %v", err, io.ErrShortWrite)
+	}
+}
+
+func TestFileReaderReadAt(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; sb.Len() < 100000; i++ {
+		sb.WriteString(strconv.Itoa(i))
+		sb.WriteByte(' ')
+	}
+	content := sb.String() // Multiple sectors
+	m, err := New(bytes.NewReader(buildArchiveBytes(t, "a.txt", content)), WithIOStats())
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	r, err := m.Open("a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer r.Close()
+
+	// Ranges within and across sectors, read concurrently:
+	ranges := [][2]int64{{90000, 100}, {0, 10}, {4090, 20}, {50000, 9000}, {int64(len(content)) - 5, 5}}
+	var wg sync.WaitGroup
+	for _, rg := range ranges {
+		wg.Add(1)
+		go func(off, size int64) {
+			defer wg.Done()
+			p := make([]byte, size)
+			if n, err := r.ReadAt(p, off); n != len(p) || err != nil || string(p) != content[off:off+size] {
+				t.Errorf("[%d, %d] Got: %d, %v, %q", off, size, n, err, p)
+			}
+		}(rg[0], rg[1])
+	}
+	wg.Wait()
+
+	// Reading a cached sector again doesn't read the input:
+	p := make([]byte, 100)
+	r.ReadAt(p, 90000)
+	before := m.IOStats()
+	if _, err := r.ReadAt(p, 90000); err != nil || string(p) != content[90000:90100] {
+		t.Errorf("Got: %v, %q", err, p)
+	}
+	if after := m.IOStats(); after != before {
+		t.Errorf("Cached sector read again, before: %+v, after: %+v", before, after)
+	}
+
+	// Reading beyond the end:
+	if n, err := r.ReadAt(p, int64(len(content))-10); n != 10 || err != io.EOF {
+		t.Errorf("Got: %d, %v, want: 10, %v", n, err, io.EOF)
+	}
+
+	// Seeking:
+	if pos, err := r.Seek(-6, io.SeekEnd); err != nil || pos != int64(len(content))-6 {
+		t.Errorf("Got: %d, %v", pos, err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != content[len(content)-6:] {
+		t.Errorf("Got: %q, %v", data, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Expected error")
+	}
+}
+
+func TestFileReaderWriteTo(t *testing.T) {
+	content := strings.Repeat("written content ", 1000) // Multiple sectors
+	m := buildArchive(t, "a.txt", content)
+	r, err := m.Open("a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer r.Close()
+
+	for _, off := range []int64{0, 10, 4096, int64(len(content)) - 1, int64(len(content))} {
+		r.Seek(off, io.SeekStart)
+		var buf bytes.Buffer
+		if n, err := r.WriteTo(&buf); err != nil || n != int64(len(content))-off || buf.String() != content[off:] {
+			t.Errorf("[%d] Got: %d, %v, want: %d, nil", off, n, err, int64(len(content))-off)
+		}
+		if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("[%d] Got: %d, %v, want: 0, %v", off, n, err, io.EOF)
+		}
+	}
+
+	// Write errors are returned:
+	r.Seek(10, io.SeekStart)
+	if _, err = r.WriteTo(errWriter{}); err != io.ErrShortWrite {
+		t.Errorf("Got: %v, want: %v", err, io.ErrShortWrite)
+	}
+}
+
+// errWriter is an io.Writer which always fails with io.ErrShortWrite.
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) { return 0, io.ErrShortWrite }
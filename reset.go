@@ -0,0 +1,68 @@
+// Reusing MPQs to parse multiple archives.
+
+package mpq
+
+import "io"
+
+// tableBuffers holds the buffers of the tables of a previously parsed archive, to be reused by Reset().
+type tableBuffers struct {
+	hashTable         []hashEntry
+	blockTable        []blockEntry
+	blockEntryIndices []int
+	fileIndices       []uint32
+}
+
+// Reset closes m (see Close()), and reuses it to parse the archive of input with the same options,
+// like New(input, opts...) would with the options m was created with. Registered names (see AddNames()
+// and WithListfile) are kept. Useful in pipelines processing many similar archives (e.g. replays):
+// the buffers of the tables of the previous archive are reused, avoiding allocations for each archive.
+//
+// Since the buffers are reused, clones of m (see Clone()) must not be used after Reset.
+// If an error is returned (the same errors as those of New()), m must only be reset or closed.
+func (m *MPQ) Reset(input io.ReadSeeker) error {
+	m.Close()
+
+	spare := m.spare
+	if cap(m.hashTable) > cap(spare.hashTable) {
+		spare.hashTable = m.hashTable
+	}
+	if cap(m.blockTable) > cap(spare.blockTable) {
+		spare.blockTable = m.blockTable
+	}
+	if cap(m.blockEntryIndices) > cap(spare.blockEntryIndices) {
+		spare.blockEntryIndices = m.blockEntryIndices
+	}
+	if cap(m.fileIndices) > cap(spare.fileIndices) {
+		spare.fileIndices = m.fileIndices
+	}
+
+	var prefetch *prefetcher
+	if m.prefetch != nil {
+		prefetch = &prefetcher{names: m.prefetch.names}
+	}
+	var ioStats *IOStats
+	if m.ioStats != nil {
+		ioStats = &IOStats{}
+	}
+
+	*m = MPQ{
+		input:          input,
+		scanHeader:     m.scanHeader,
+		names:          m.names,
+		strict:         m.strict,
+		lazyTables:     m.lazyTables,
+		headerOnly:     m.headerOnly,
+		maxMemory:      m.maxMemory,
+		locale:         m.locale,
+		hasLocale:      m.hasLocale,
+		noUserDataCopy: m.noUserDataCopy,
+		logger:         m.logger,
+		inMemoryLimit:  m.inMemoryLimit,
+		prefetch:       prefetch,
+		ioStats:        ioStats,
+		spare:          spare,
+	}
+
+	_, err := m.diveIn()
+	return err
+}
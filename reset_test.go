@@ -0,0 +1,59 @@
+package mpq
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	var inputs [][]byte
+	for _, name := range []string{"reps/lotv.SC2Replay", "reps/wol.SC2Replay", "reps/automm.SC2Replay"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read replay: %v", err)
+		}
+		inputs = append(inputs, data)
+	}
+
+	m, err := New(bytes.NewReader(inputs[0]), WithStrictValidation())
+	if err != nil {
+		t.Fatalf("Failed to parse replay: %v", err)
+	}
+	m.AddNames("extra.txt")
+
+	for i, data := range inputs {
+		prevHashTable := m.hashTable
+		if err := m.Reset(bytes.NewReader(data)); err != nil {
+			t.Fatalf("[%d] Unexpected error: %v", i, err)
+		}
+		if !m.strict || len(m.names) != 1 {
+			t.Errorf("[%d] Options or names are not kept", i)
+		}
+		if cap(prevHashTable) >= len(m.hashTable) && &prevHashTable[0] != &m.hashTable[0] {
+			t.Errorf("[%d] Hash table is not reused", i)
+		}
+
+		exp, err := New(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("[%d] Failed to parse replay: %v", i, err)
+		}
+		if m.FilesCount() != exp.FilesCount() {
+			t.Errorf("[%d] Got: %d files, want: %d", i, m.FilesCount(), exp.FilesCount())
+		}
+		for _, name := range []string{"replay.details", "replay.initData", "replay.tracker.events"} {
+			got, err := m.FileByName(name)
+			want, err2 := exp.FileByName(name)
+			if err != err2 || !bytes.Equal(got, want) {
+				t.Errorf("[%d][%s] Got: %d bytes, %v, want: %d bytes, %v", i, name, len(got), err, len(want), err2)
+			}
+		}
+	}
+
+	if err := m.Reset(bytes.NewReader([]byte("invalid"))); err == nil {
+		t.Errorf("Expected error")
+	}
+	if err := m.Reset(bytes.NewReader(inputs[0])); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Decoding the header of StarCraft II replays stored in the user data.
+
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// ErrInvalidSC2ReplayHeader indicates that the user data is not a valid StarCraft II replay header
+var ErrInvalidSC2ReplayHeader = errors.New("Invalid StarCraft II replay header")
+
+// SC2Version is the version of the game which recorded a StarCraft II replay.
+type SC2Version struct {
+	Flags, Major, Minor, Revision int
+
+	// Build number of the game.
+	Build int
+
+	// Base build number of the game, which determines the protocol of the replay.
+	BaseBuild int
+}
+
+// String returns the version in the form of "Major.Minor.Revision.Build", e.g. "3.2.2.42253".
+func (v SC2Version) String() string {
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." + strconv.Itoa(v.Revision) + "." + strconv.Itoa(v.Build)
+}
+
+// SC2ReplayHeader is the header of a StarCraft II replay, stored in the user data of the archive.
+type SC2ReplayHeader struct {
+	// Signature of the replay, e.g. "StarCraft II replay\x1b11".
+	Signature string
+
+	// Version of the game which recorded the replay.
+	Version SC2Version
+
+	// Type of the replay.
+	Type int
+
+	// Length of the game in game loops (16 game loops is 1 second at "normal" game speed).
+	ElapsedGameLoops int64
+
+	// Tells if the game used scaled time.
+	UseScaledTime bool
+
+	// Build number of the game data.
+	DataBuildNum int
+}
+
+// SC2ReplayHeader decodes the header of a StarCraft II replay from the user data of the archive,
+// without reading any files of the archive. Useful to triage many replays by version.
+//
+// ErrInvalidSC2ReplayHeader is returned if the archive has no user data or it is not a valid replay header.
+func (m *MPQ) SC2ReplayHeader() (*SC2ReplayHeader, error) {
+	data := m.UserData()
+	if data == nil {
+		return nil, ErrInvalidSC2ReplayHeader
+	}
+	return ParseSC2ReplayHeader(data)
+}
+
+// ParseSC2ReplayHeader decodes the header of a StarCraft II replay from the user data of an archive
+// (as returned by MPQ.UserData()).
+//
+// ErrInvalidSC2ReplayHeader is returned if data is not a valid replay header.
+func ParseSC2ReplayHeader(data []byte) (*SC2ReplayHeader, error) {
+	// The user data starts with the size of the header
+	if len(data) < 4 {
+		return nil, ErrInvalidSC2ReplayHeader
+	}
+	size := binary.LittleEndian.Uint32(data)
+	if uint64(size) > uint64(len(data)-4) {
+		return nil, ErrInvalidSC2ReplayHeader
+	}
+
+	d := &versionedDecoder{data: data[4 : 4+size]}
+	fields, ok := d.value(0).(map[int64]interface{})
+	if !ok || d.err != nil {
+		return nil, ErrInvalidSC2ReplayHeader
+	}
+
+	signature, ok := fields[0].([]byte)
+	if !ok {
+		return nil, ErrInvalidSC2ReplayHeader
+	}
+	h := &SC2ReplayHeader{Signature: string(signature)}
+
+	if version, ok := fields[1].(map[int64]interface{}); ok {
+		h.Version = SC2Version{
+			Flags:     int(intField(version, 0)),
+			Major:     int(intField(version, 1)),
+			Minor:     int(intField(version, 2)),
+			Revision:  int(intField(version, 3)),
+			Build:     int(intField(version, 4)),
+			BaseBuild: int(intField(version, 5)),
+		}
+	}
+	h.Type = int(intField(fields, 2))
+	h.ElapsedGameLoops = intField(fields, 3)
+	h.UseScaledTime = intField(fields, 4) != 0
+	h.DataBuildNum = int(intField(fields, 6))
+
+	return h, nil
+}
+
+// intField returns the integer field of a decoded struct specified by its tag, 0 if it is missing.
+func intField(fields map[int64]interface{}, tag int64) int64 {
+	v, _ := fields[tag].(int64)
+	return v
+}
+
+// Types of the values of the "versioned" serialization format of StarCraft II.
+const (
+	vtArray    = 0x00
+	vtBitArray = 0x01
+	vtBlob     = 0x02
+	vtChoice   = 0x03
+	vtOptional = 0x04
+	vtStruct   = 0x05
+	vtU8       = 0x06
+	vtU32      = 0x07
+	vtU64      = 0x08
+	vtVInt     = 0x09
+)
+
+// Maximum nesting depth of the decoded values.
+const versionedMaxDepth = 32
+
+// versionedDecoder decodes values of the "versioned" serialization format of StarCraft II.
+// Values are decoded to the following types: structs to map[int64]interface{} (keyed by the field tags),
+// arrays to []interface{}, blobs and bit arrays to []byte, integers to int64, missing optionals to nil,
+// and choices to their value.
+type versionedDecoder struct {
+	data []byte
+	pos  int
+	err  error // First error of decoding, decoding stops on error
+}
+
+// bytes returns the next n bytes.
+func (d *versionedDecoder) bytes(n int64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > int64(len(d.data)-d.pos) {
+		d.err = ErrInvalidSC2ReplayHeader
+		return nil
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b
+}
+
+// byte returns the next byte.
+func (d *versionedDecoder) byte() byte {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// vint decodes a variable-length integer: the lowest bit of the first byte is the sign,
+// the highest bits of the bytes tell if more bytes follow.
+func (d *versionedDecoder) vint() int64 {
+	b := d.byte()
+	negative := b&1 != 0
+	v := int64(b>>1) & 0x3f
+	for shift := uint(6); b&0x80 != 0 && d.err == nil; shift += 7 {
+		if shift > 63 {
+			d.err = ErrInvalidSC2ReplayHeader
+			return 0
+		}
+		b = d.byte()
+		v |= int64(b&0x7f) << shift
+	}
+	if negative {
+		return -v
+	}
+	return v
+}
+
+// value decodes the next value. depth is the nesting depth of the value.
+func (d *versionedDecoder) value(depth int) interface{} {
+	if depth > versionedMaxDepth {
+		d.err = ErrInvalidSC2ReplayHeader
+	}
+	typ := d.byte()
+	if d.err != nil {
+		return nil
+	}
+
+	switch typ {
+	case vtArray:
+		n := d.vint()
+		var arr []interface{}
+		for i := int64(0); i < n && d.err == nil; i++ {
+			arr = append(arr, d.value(depth+1))
+		}
+		return arr
+	case vtBitArray:
+		return d.bytes((d.vint() + 7) / 8)
+	case vtBlob:
+		return d.bytes(d.vint())
+	case vtChoice:
+		d.vint() // Tag
+		return d.value(depth + 1)
+	case vtOptional:
+		if d.byte() == 0 {
+			return nil
+		}
+		return d.value(depth + 1)
+	case vtStruct:
+		n := d.vint()
+		fields := map[int64]interface{}{}
+		for i := int64(0); i < n && d.err == nil; i++ {
+			tag := d.vint()
+			fields[tag] = d.value(depth + 1)
+		}
+		return fields
+	case vtU8:
+		return int64(d.byte())
+	case vtU32:
+		if b := d.bytes(4); b != nil {
+			return int64(binary.BigEndian.Uint32(b))
+		}
+		return nil
+	case vtU64:
+		if b := d.bytes(8); b != nil {
+			return int64(binary.BigEndian.Uint64(b))
+		}
+		return nil
+	case vtVInt:
+		return d.vint()
+	}
+
+	d.err = ErrInvalidSC2ReplayHeader
+	return nil
+}
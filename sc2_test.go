@@ -0,0 +1,48 @@
+package mpq
+
+import (
+	"testing"
+)
+
+func TestSC2ReplayHeader(t *testing.T) {
+	cases := []struct {
+		name    string
+		version string
+		base    int
+		loops   int64
+	}{
+		{"reps/lotv.SC2Replay", "3.2.2.42253", 42253, 13804},
+		{"reps/wol.SC2Replay", "2.1.9.34644", 32283, 0},
+	}
+	for _, c := range cases {
+		m, err := NewFromFile(c.name)
+		if err != nil {
+			t.Fatalf("Failed to open replay: %v", err)
+		}
+		h, err := m.SC2ReplayHeader()
+		m.Close()
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %v", c.name, err)
+			continue
+		}
+		if h.Signature != "StarCraft II replay\x1b11" {
+			t.Errorf("[%s] Got: %q signature", c.name, h.Signature)
+		}
+		if h.Version.String() != c.version || h.Version.BaseBuild != c.base {
+			t.Errorf("[%s] Got: %s (base: %d), want: %s (base: %d)", c.name, h.Version, h.Version.BaseBuild, c.version, c.base)
+		}
+		if c.loops != 0 && h.ElapsedGameLoops != c.loops {
+			t.Errorf("[%s] Got: %d game loops, want: %d", c.name, h.ElapsedGameLoops, c.loops)
+		}
+	}
+
+	m := buildArchive(t, "a.txt", "a")
+	if _, err := m.SC2ReplayHeader(); err != ErrInvalidSC2ReplayHeader {
+		t.Errorf("Got: %v, want: %v", err, ErrInvalidSC2ReplayHeader)
+	}
+	for _, data := range [][]byte{nil, {5, 0, 0, 0, 5, 2, 0, 2, 0x7f}, {3, 0, 0, 0, 0x0a, 0, 0}} {
+		if _, err := ParseSC2ReplayHeader(data); err != ErrInvalidSC2ReplayHeader {
+			t.Errorf("[% x] Got: %v, want: %v", data, err, ErrInvalidSC2ReplayHeader)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Processing directories of archives concurrently.
+
+package mpq
+
+import (
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// ScanDir walks the directory dir (recursively), opens the archives in it with the given options,
+// and calls fn with each archive, closing the archive after fn returns. Only files whose base name matches
+// pattern (see filepath.Match(), e.g. "*.SC2Replay") are opened; an empty pattern matches all files.
+//
+// Archives are opened and processed concurrently by the given number of workers
+// (GOMAXPROCS workers if workers <= 0), so fn is called concurrently from multiple goroutines.
+// All archives are processed, errors do not stop the scan.
+//
+// Errors of opening the archives, the errors returned by fn and the errors of walking the directory
+// are returned in errs, mapped by the paths (errs is nil if there were no errors).
+// If pattern is malformed, errs maps dir to filepath.ErrBadPattern, and no archives are processed.
+func ScanDir(dir, pattern string, workers int, fn func(path string, m *MPQ) error, opts ...Option) (errs map[string]error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return map[string]error{dir: err}
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var mu sync.Mutex // Guards errs
+	setErr := func(path string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if errs == nil {
+			errs = map[string]error{}
+		}
+		errs[path] = err
+	}
+
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if err := scanFile(path, fn, opts); err != nil {
+					setErr(path, err)
+				}
+			}
+		}()
+	}
+
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			setErr(path, err)
+			return nil // Continue with the rest
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if pattern != "" {
+			if ok, _ := filepath.Match(pattern, d.Name()); !ok {
+				return nil
+			}
+		}
+		paths <- path
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+
+	return
+}
+
+// scanFile opens the archive of the named file, and calls fn with it.
+func scanFile(path string, fn func(path string, m *MPQ) error, opts []Option) error {
+	m, err := NewFromFile(path, opts...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return fn(path, m)
+}
@@ -0,0 +1,60 @@
+package mpq
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestScanDir(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to read replay: %v", err)
+	}
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	files := map[string][]byte{
+		"a.SC2Replay":        data,
+		"sub/b.SC2Replay":    data,
+		"sub/c.SC2Replay":    data,
+		"invalid.SC2Replay":  []byte("invalid"),
+		"ignored.txt":        []byte("ignored"),
+		"sub/ignored.SC2Map": []byte("ignored"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), content, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var processed []string
+	errs := ScanDir(dir, "*.SC2Replay", 2, func(path string, m *MPQ) error {
+		if _, err := m.FileByName("replay.details"); err != nil {
+			return err
+		}
+		mu.Lock()
+		processed = append(processed, path)
+		mu.Unlock()
+		if filepath.Base(path) == "c.SC2Replay" {
+			return ErrFileNotFound
+		}
+		return nil
+	}, WithLazyTables())
+
+	if len(processed) != 3 {
+		t.Errorf("Got: %d processed, want: 3", len(processed))
+	}
+	if len(errs) != 2 || errs[filepath.Join(dir, "invalid.SC2Replay")] == nil ||
+		errs[filepath.Join(dir, "sub", "c.SC2Replay")] != ErrFileNotFound {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+
+	if errs := ScanDir(dir, "[", 0, nil); errs[dir] != filepath.ErrBadPattern {
+		t.Errorf("Got: %v, want: %v", errs, filepath.ErrBadPattern)
+	}
+	if errs := ScanDir(filepath.Join(dir, "missing"), "", 0, nil); len(errs) != 1 {
+		t.Errorf("Got: %v, want: 1 error", errs)
+	}
+}
@@ -0,0 +1,197 @@
+// Digital signatures of MPQ archives.
+//
+// The weak digital signature is stored in the "(signature)" file of the archive, which is 72 bytes long:
+// 8 zero bytes followed by a 512-bit RSA signature (PKCS #1 v1.5) of the MD5 digest of the archive,
+// in little endian byte order. The digest is computed over the whole archive,
+// treating the content of the "(signature)" file as zeros.
+//
+// The strong digital signature is appended after the archive (it is not part of the archive):
+// the "NGIS" magic followed by a 2048-bit RSA signature of the SHA-1 digest of the archive,
+// in little endian byte order. The signed data (in big endian byte order) is a proprietary padding:
+// 0x0B, followed by 0xBB bytes, followed by the digest in reversed byte order.
+
+package mpq
+
+import (
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"errors"
+	"hash"
+	"io"
+	"math/big"
+)
+
+var (
+	// ErrInvalidSignatureKey indicates that the key supplied for signing has an invalid size.
+	ErrInvalidSignatureKey = errors.New("Invalid MPQ signature key size")
+
+	// ErrUnreadableOutput indicates that the output of a Writer must be readable
+	// (implement io.ReaderAt) for signing the archive.
+	ErrUnreadableOutput = errors.New("MPQ Writer output must implement io.ReaderAt for signing")
+)
+
+// Name of the internal file holding the weak digital signature.
+const signatureFileName = "(signature)"
+
+// Sizes of the weak signature and the "(signature)" file.
+const (
+	weakSignatureSize     = 64
+	weakSignatureFileSize = 8 + weakSignatureSize
+)
+
+// Size of the strong signature.
+const strongSignatureSize = 256
+
+// Magic bytes preceding the strong signature.
+var strongSignatureMagic = [4]byte{'N', 'G', 'I', 'S'}
+
+// DER encoded DigestInfo prefix of MD5 digests in PKCS #1 v1.5 signatures.
+var md5DigestInfoPrefix = []byte{0x30, 0x20, 0x30, 0x0c, 0x06, 0x08, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x02, 0x05, 0x05, 0x00, 0x04, 0x10}
+
+// WithWeakSignature returns a WriterOption which signs the archive with the weak digital signature
+// using the given 512-bit RSA private key. The signature is stored in the "(signature)" file.
+//
+// Signing requires reading back the written archive, so the output must implement io.ReaderAt.
+func WithWeakSignature(key *rsa.PrivateKey) WriterOption {
+	return func(w *Writer) {
+		w.weakSigKey = key
+	}
+}
+
+// WithStrongSignature returns a WriterOption which signs the archive with the strong digital signature
+// using the given 2048-bit RSA private key. The signature is appended after the archive.
+//
+// Signing requires reading back the written archive, so the output must implement io.ReaderAt.
+func WithStrongSignature(key *rsa.PrivateKey) WriterOption {
+	return func(w *Writer) {
+		w.strongSigKey = key
+	}
+}
+
+// checkSigning checks the prerequisites of the requested signatures.
+func (w *Writer) checkSigning() error {
+	if w.weakSigKey == nil && w.strongSigKey == nil {
+		return nil
+	}
+	if w.weakSigKey != nil && w.weakSigKey.N.BitLen() != weakSignatureSize*8 {
+		return ErrInvalidSignatureKey
+	}
+	if w.strongSigKey != nil && w.strongSigKey.N.BitLen() != strongSignatureSize*8 {
+		return ErrInvalidSignatureKey
+	}
+	if _, ok := w.out.(io.ReaderAt); !ok {
+		return ErrUnreadableOutput
+	}
+	return nil
+}
+
+// signWeak computes the weak signature of the archive, and writes it into the "(signature)" file.
+// The archive must be complete (including the header).
+func (w *Writer) signWeak() error {
+	_, h2, h3 := FileNameHash(signatureFileName)
+	f := w.files[w.fileIndices[[2]uint32{h2, h3}]]
+	sigOffset := f.offset
+
+	h := md5.New()
+	if err := w.hashArchive(h, sigOffset, sigOffset+weakSignatureFileSize); err != nil {
+		return err
+	}
+
+	sig, err := signPKCS1v15(w.weakSigKey, md5DigestInfoPrefix, h.Sum(nil))
+	if err != nil {
+		return err
+	}
+	reverse(sig) // Stored in little endian byte order
+
+	return w.writeAt(sig, sigOffset+8)
+}
+
+// signStrong computes the strong signature of the archive, and writes it after the archive.
+// The archive must be complete (including the header and the weak signature).
+func (w *Writer) signStrong() error {
+	h := sha1.New()
+	if err := w.hashArchive(h, 0, 0); err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+	reverse(digest)
+
+	padded := make([]byte, strongSignatureSize)
+	padded[0] = 0x0b
+	for i := 1; i < len(padded)-len(digest); i++ {
+		padded[i] = 0xbb
+	}
+	copy(padded[len(padded)-len(digest):], digest)
+
+	sig := rsaDecrypt(w.strongSigKey, padded)
+	reverse(sig) // Stored in little endian byte order
+
+	if _, err := w.out.Write(strongSignatureMagic[:]); err != nil {
+		return err
+	}
+	_, err := w.out.Write(sig)
+	return err
+}
+
+// hashArchive writes the archive content into h, treating the bytes in the range [excludeFrom, excludeTo)
+// (relative to the beginning of the archive) as zeros.
+func (w *Writer) hashArchive(h hash.Hash, excludeFrom, excludeTo int64) error {
+	r := io.NewSectionReader(w.out.(io.ReaderAt), w.base, w.pos)
+
+	buf := make([]byte, 64*1024)
+	for pos := int64(0); ; {
+		n, err := r.Read(buf)
+		chunk := buf[:n]
+		for i := range chunk {
+			if p := pos + int64(i); p >= excludeFrom && p < excludeTo {
+				chunk[i] = 0
+			}
+		}
+		h.Write(chunk)
+		pos += int64(n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// signPKCS1v15 computes the PKCS #1 v1.5 signature of the digest.
+//
+// This is implemented here (and rsa.SignPKCS1v15 is not used) because MPQ signatures
+// use key sizes which are rejected by the crypto/rsa package as insecure.
+func signPKCS1v15(key *rsa.PrivateKey, prefix, digest []byte) ([]byte, error) {
+	k := (key.N.BitLen() + 7) / 8
+	tLen := len(prefix) + len(digest)
+	if k < tLen+11 {
+		return nil, ErrInvalidSignatureKey
+	}
+
+	// EM = 0x00 || 0x01 || PS || 0x00 || T
+	em := make([]byte, k)
+	em[1] = 1
+	for i := 2; i < k-tLen-1; i++ {
+		em[i] = 0xff
+	}
+	copy(em[k-tLen:], prefix)
+	copy(em[k-len(digest):], digest)
+
+	return rsaDecrypt(key, em), nil
+}
+
+// rsaDecrypt performs the raw RSA private key operation on the (big endian) input.
+// The result has the same size as the modulus.
+func rsaDecrypt(key *rsa.PrivateKey, in []byte) []byte {
+	c := new(big.Int).Exp(new(big.Int).SetBytes(in), key.D, key.N)
+	return c.FillBytes(make([]byte, (key.N.BitLen()+7)/8))
+}
+
+// reverse reverses the bytes of b in place.
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
@@ -0,0 +1,141 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRSAKey returns an RSA private key made of the given (hex) primes.
+func testRSAKey(p, q string) *rsa.PrivateKey {
+	bp, _ := new(big.Int).SetString(p, 16)
+	bq, _ := new(big.Int).SetString(q, 16)
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: new(big.Int).Mul(bp, bq), E: 65537},
+		Primes:    []*big.Int{bp, bq},
+	}
+	one := big.NewInt(1)
+	phi := new(big.Int).Mul(new(big.Int).Sub(bp, one), new(big.Int).Sub(bq, one))
+	key.D = new(big.Int).ModInverse(big.NewInt(int64(key.E)), phi)
+	return key
+}
+
+// Test keys
+var (
+	testWeakKey = testRSAKey(
+		"fcf50513ab8efe7718a57b95c3100708bd06390e7c87fa21b8b181cca0eac7cb",
+		"f75abd90d4782b2ae0146b03c8308425539cb9ecf1f236e0fe22c33adb03f721",
+	)
+	testStrongKey = testRSAKey(
+		"ddbf7d019eff53603f3582c71719ec77494b456e3a44c8b6cbd3a727735ac271329eb5d2363902210cf4852022567352df37684e9b377581dfe6f4b7a1c131854d19396635add16235788ecadb579edf0fc923d6c41c82a37f550dd8e9886de92be247ad26c53a67663b1519228c36a23fd937f42a6ecaab05bd9dbb55d32d1f",
+		"c66be16f5bb6f093804ff88590324f816070fbd142a0376ac2acef368c411ca58bc0964cf79244a0c5302575a8bd8e967086dafbcfbd84d2e31720526b7fe2335a4820e3a97e62843b8c142fbcb94bd210960c3c7ad428f5f82cbf59f6249b6c2e6d341de04ebc565c76812251ac219081c6c9fd1f065d25cdc7b7f07ab6bf5b",
+	)
+)
+
+// rsaEncrypt performs the raw RSA public key operation on the (big endian) input.
+func rsaEncrypt(key *rsa.PublicKey, in []byte) []byte {
+	c := new(big.Int).Exp(new(big.Int).SetBytes(in), big.NewInt(int64(key.E)), key.N)
+	return c.FillBytes(make([]byte, (key.N.BitLen()+7)/8))
+}
+
+func TestWeakSignature(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	if _, err := NewWriter(nil, WithWeakSignature(testStrongKey)); err != ErrInvalidSignatureKey {
+		t.Errorf("Expected ErrInvalidSignatureKey, got: %v", err)
+	}
+
+	w, err := NewFileWriter(name, WithWeakSignature(testWeakKey))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("a.txt", testContent(5000), FileCompression(CompressionZlib)); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	m, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	defer m.Close()
+
+	sigFile, err := m.FileByName(signatureFileName)
+	if err != nil || len(sigFile) != weakSignatureFileSize {
+		t.Fatalf("Invalid (signature) file: %v, error: %v", sigFile, err)
+	}
+
+	// Compute the expected digest
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	archive := content[:m.header.archiveSize]
+	idx := bytes.Index(archive, sigFile)
+	for i := idx; i < idx+weakSignatureFileSize; i++ {
+		archive[i] = 0
+	}
+	digest := md5.Sum(archive)
+
+	sig := append([]byte{}, sigFile[8:]...)
+	reverse(sig)
+	em := rsaEncrypt(&testWeakKey.PublicKey, sig)
+	if !bytes.Equal(em[len(em)-md5.Size:], digest[:]) || em[0] != 0 || em[1] != 1 {
+		t.Errorf("Invalid weak signature")
+	}
+}
+
+func TestStrongSignature(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	if _, err := NewWriter(nil, WithStrongSignature(testWeakKey)); err != ErrInvalidSignatureKey {
+		t.Errorf("Expected ErrInvalidSignatureKey, got: %v", err)
+	}
+
+	w, err := NewFileWriter(name, WithWeakSignature(testWeakKey), WithStrongSignature(testStrongKey))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("a.txt", testContent(5000)); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	if got, err := m.FileByName("a.txt"); err != nil || !bytes.Equal(got, testContent(5000)) {
+		t.Errorf("File mismatch (err: %v)", err)
+	}
+
+	archiveSize := int(m.header.archiveSize)
+	if len(content) != archiveSize+4+strongSignatureSize {
+		t.Fatalf("Unexpected size: %d", len(content))
+	}
+	if !bytes.Equal(content[archiveSize:archiveSize+4], strongSignatureMagic[:]) {
+		t.Errorf("Missing strong signature magic")
+	}
+
+	digest := sha1.Sum(content[:archiveSize])
+	reverse(digest[:])
+	sig := append([]byte{}, content[archiveSize+4:]...)
+	reverse(sig)
+	padded := rsaEncrypt(&testStrongKey.PublicKey, sig)
+	if padded[0] != 0x0b || padded[1] != 0xbb || !bytes.Equal(padded[len(padded)-sha1.Size:], digest[:]) {
+		t.Errorf("Invalid strong signature")
+	}
+}
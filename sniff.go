@@ -0,0 +1,113 @@
+// Content type sniffing of file data, useful for files with unknown names.
+
+package mpq
+
+import (
+	"bytes"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// contentSignature is a magic byte sequence at the start of the data of a content type.
+type contentSignature struct {
+	offset int    // Offset of the magic bytes
+	magic  string // Magic bytes
+	kind   string // Description of the content type
+	ext    string // File name extension of the content type
+}
+
+// Known content signatures, checked in order.
+var contentSignatures = []contentSignature{
+	{0, "\x89PNG\r\n\x1a\n", "PNG image", ".png"},
+	{0, "\xff\xd8\xff", "JPEG image", ".jpg"},
+	{0, "GIF8", "GIF image", ".gif"},
+	{0, "BM", "BMP image", ".bmp"},
+	{0, "BLP1", "BLP texture", ".blp"},
+	{0, "BLP2", "BLP texture", ".blp"},
+	{0, "DDS ", "DDS texture", ".dds"},
+	{8, "WAVE", "WAV audio", ".wav"},
+	{0, "OggS", "Ogg audio", ".ogg"},
+	{0, "ID3", "MP3 audio", ".mp3"},
+	{0, "MDLX", "Warcraft III model", ".mdx"},
+	{0, "43DM", "StarCraft II model", ".m3"},
+	{0, "W3E!", "Warcraft III environment", ".w3e"},
+	{0, "MPQ\x1a", "MPQ archive", ".mpq"},
+	{0, "MPQ\x1b", "MPQ archive", ".mpq"},
+	{0, "PK\x03\x04", "ZIP archive", ".zip"},
+	{0, "<?xml", "XML document", ".xml"},
+}
+
+// SniffContent guesses the type of file data by its magic bytes, e.g. to name files
+// whose names are unknown (see UnnamedFiles()) when extracting them.
+//
+// kind is a short description of the content type (e.g. "PNG image"), ext is the usual file name
+// extension (e.g. ".png"). Besides well-known formats, text and JSON are recognized,
+// and so are the serialized structures of StarCraft II (replays), reported with the ".s2v" extension.
+// Empty strings are returned if the type cannot be guessed.
+func SniffContent(data []byte) (kind, ext string) {
+	for _, cs := range contentSignatures {
+		if len(data) >= cs.offset+len(cs.magic) && string(data[cs.offset:cs.offset+len(cs.magic)]) == cs.magic {
+			if cs.ext == ".wav" && !bytes.HasPrefix(data, []byte("RIFF")) {
+				continue
+			}
+			return cs.kind, cs.ext
+		}
+	}
+
+	if len(data) == 0 {
+		return "", ""
+	}
+
+	if isText(data) {
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+			return "JSON document", ".json"
+		}
+		return "Text", ".txt"
+	}
+
+	if isSC2Serialized(data) {
+		return "StarCraft II serialized data", ".s2v"
+	}
+
+	return "", ""
+}
+
+// isText tells if data looks like text: valid UTF-8 without control characters other than whitespace.
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// isSC2Serialized tells if data looks like a "versioned" serialized StarCraft II structure:
+// a struct (type 0x05) with a non-negative field count, or a choice (type 0x03) with a non-negative tag
+// followed by a value of variable-length integer type (0x09).
+// Counts and tags are zigzag encoded variable-length integers.
+func isSC2Serialized(data []byte) bool {
+	if len(data) < 3 || data[1]&0x01 != 0 {
+		return false
+	}
+	// Skip the count / tag
+	i := 1
+	for i < len(data) && data[i]&0x80 != 0 {
+		i++
+	}
+	i++
+	if i >= len(data) {
+		return false
+	}
+
+	switch data[0] {
+	case 0x05:
+		return true
+	case 0x03:
+		return data[i] == 0x09
+	}
+	return false
+}
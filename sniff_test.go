@@ -0,0 +1,50 @@
+package mpq
+
+import (
+	"testing"
+)
+
+func TestSniffContent(t *testing.T) {
+	cases := []struct {
+		data string
+		ext  string
+	}{
+		{"\x89PNG\r\n\x1a\n\x00\x00", ".png"},
+		{"RIFF\x24\x00\x00\x00WAVEfmt ", ".wav"},
+		{"RIFX\x24\x00\x00\x00WAVEfmt ", ""},
+		{"BLP2\x01\x00", ".blp"},
+		{"MPQ\x1a\x2c\x00\x00\x00", ".mpq"},
+		{"hello\r\nworld\r\n", ".txt"},
+		{` {"a": [1, 2]} `, ".json"},
+		{`{"a": [1, 2`, ".txt"},
+		{"\x05\x04\x00\x06\x01", ".s2v"},
+		{"\x03\x00\x09\x00", ".s2v"},
+		{"\x03\x00\x06\x00", ""},
+		{"\x00\x01\x02\x03", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if _, ext := SniffContent([]byte(c.data)); ext != c.ext {
+			t.Errorf("[%q] Got: %q, want: %q", c.data, ext, c.ext)
+		}
+	}
+
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+	for _, name := range []string{"replay.details", "replay.tracker.events"} {
+		data, err := m.FileByName(name)
+		if err != nil {
+			t.Fatalf("[%s] Unexpected error: %v", name, err)
+		}
+		if _, ext := SniffContent(data); ext != ".s2v" {
+			t.Errorf("[%s] Got: %q, want: %q", name, ext, ".s2v")
+		}
+	}
+	data, _ := m.FileByName(listfileName)
+	if _, ext := SniffContent(data); ext != ".txt" {
+		t.Errorf("[%s] Got: %q, want: %q", listfileName, ext, ".txt")
+	}
+}
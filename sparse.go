@@ -0,0 +1,77 @@
+// The sparse compression, a simple run-length encoding of zero bytes used by Storm.
+//
+// The compressed data starts with the size of the uncompressed data (big endian uint32),
+// followed by chunks. Each chunk starts with a control byte:
+//     0x80 | (n-1): n (1..128) literal bytes follow
+//     n-3:          n (3..130) zero bytes
+
+package mpq
+
+import "encoding/binary"
+
+// compressSparse compresses src using the sparse compression.
+func compressSparse(src []byte) []byte {
+	dst := make([]byte, 4, len(src)+len(src)/128+5)
+	binary.BigEndian.PutUint32(dst, uint32(len(src)))
+
+	// zeros returns the number of zeros starting at i, up to max.
+	zeros := func(i, max int) int {
+		n := 0
+		for i+n < len(src) && n < max && src[i+n] == 0 {
+			n++
+		}
+		return n
+	}
+
+	for i := 0; i < len(src); {
+		if n := zeros(i, 130); n >= 3 {
+			dst = append(dst, byte(n-3))
+			i += n
+			continue
+		}
+
+		// Literal chunk: lasts until the next run of at least 3 zeros
+		j := i + 1
+		for j < len(src) && j-i < 128 && zeros(j, 3) < 3 {
+			j++
+		}
+		dst = append(dst, 0x80|byte(j-i-1))
+		dst = append(dst, src[i:j]...)
+		i = j
+	}
+
+	return dst
+}
+
+// decompressSparse decompresses src which was compressed using the sparse compression.
+func decompressSparse(dst, src []byte) error {
+	if len(src) < 4 || binary.BigEndian.Uint32(src) > uint32(len(dst)) {
+		return ErrInvalidArchive
+	}
+	src = src[4:]
+
+	var i int
+	for len(src) > 0 && i < len(dst) {
+		ctrl := src[0]
+		src = src[1:]
+		if ctrl&0x80 != 0 {
+			n := int(ctrl&0x7f) + 1
+			if n > len(src) {
+				return ErrInvalidArchive
+			}
+			i += copy(dst[i:], src[:n])
+			src = src[n:]
+		} else {
+			n := int(ctrl) + 3
+			for end := i + n; i < end && i < len(dst); i++ {
+				dst[i] = 0
+			}
+		}
+	}
+	// Unspecified bytes are zeros:
+	for ; i < len(dst); i++ {
+		dst[i] = 0
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+// Statistics of the archives.
+
+package mpq
+
+// Stats holds statistics of an archive, see MPQ.Stats().
+type Stats struct {
+	// Number of files (blocks flagged as file), including deletion markers.
+	Files int
+
+	// Number of deletion markers.
+	DeletionMarkers int
+
+	// Total uncompressed size of the files.
+	UncompressedSize uint64
+
+	// Total stored (compressed) size of the files.
+	CompressedSize uint64
+
+	// Number of compressed (not imploded) files.
+	Compressed int
+
+	// Number of imploded (PKWARE DCL compressed) files.
+	Imploded int
+
+	// Number of encrypted files.
+	Encrypted int
+
+	// Number of files stored as a single unit.
+	SingleUnit int
+
+	// Number of files having sector checksums.
+	SectorCRC int
+
+	// Number of free space blocks (not files, but having stored data).
+	FreeBlocks int
+
+	// Total size of the free space blocks: space wasted by deleted or replaced files.
+	FreeSpace uint64
+
+	// Number of unused block table entries (not files, and having no stored data).
+	UnusedBlocks int
+
+	// Number of entries in the hash table, and the number of them referencing a file.
+	HashTableEntries, HashTableUsed int
+
+	// Number of entries in the block table.
+	BlockTableEntries int
+
+	// Size of the hash table and the block table in bytes (uncompressed).
+	HashTableSize, BlockTableSize int
+
+	// Tells if the archive has HET and BET tables.
+	HetBet bool
+}
+
+// Stats returns statistics of the archive, computed from its tables (no file data is read).
+func (m *MPQ) Stats() *Stats {
+	m.loadTables()
+	s := &Stats{
+		HashTableEntries:  len(m.hashTable),
+		BlockTableEntries: len(m.blockTable),
+		HashTableSize:     len(m.hashTable) * 16,
+		BlockTableSize:    len(m.blockTable) * 16,
+		HetBet:            m.het != nil,
+	}
+
+	for _, be := range m.blockTable {
+		if be.flags&beFlagFile == 0 {
+			if be.blockSize > 0 {
+				s.FreeBlocks++
+				s.FreeSpace += uint64(be.blockSize)
+			} else {
+				s.UnusedBlocks++
+			}
+			continue
+		}
+
+		f := BlockFlags(be.flags)
+		s.Files++
+		s.UncompressedSize += uint64(be.fileSize)
+		s.CompressedSize += uint64(be.blockSize)
+		if f.IsDeleted() {
+			s.DeletionMarkers++
+		}
+		if f&FlagCompress != 0 {
+			s.Compressed++
+		}
+		if f&FlagImplode != 0 {
+			s.Imploded++
+		}
+		if f.IsEncrypted() {
+			s.Encrypted++
+		}
+		if f.IsSingleUnit() {
+			s.SingleUnit++
+		}
+		if f.HasSectorCRC() {
+			s.SectorCRC++
+		}
+	}
+
+	for _, he := range m.hashTable {
+		if m.hashEntryBlockIndex(he) >= 0 {
+			s.HashTableUsed++
+		}
+	}
+
+	return s
+}
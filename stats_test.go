@@ -0,0 +1,51 @@
+package mpq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	s := m.Stats()
+	if s.Files != int(m.FilesCount()) || s.BlockTableEntries != len(m.blockTable) ||
+		s.HashTableEntries != len(m.hashTable) || s.HashTableSize != 16*len(m.hashTable) {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+	if s.HashTableUsed != s.Files {
+		t.Errorf("Got: %d used hash table entries, want: %d", s.HashTableUsed, s.Files)
+	}
+	if s.CompressedSize == 0 || s.CompressedSize > s.UncompressedSize || s.Compressed == 0 {
+		t.Errorf("Unexpected sizes: %+v", s)
+	}
+
+	var uncompressed uint64
+	files, _ := m.Files()
+	for _, f := range append(files, ListedFile{Name: listfileName}, ListedFile{Name: attributesName}) {
+		size, _ := m.FileSize(f.Name)
+		uncompressed += uint64(size)
+	}
+	if s.UncompressedSize != uncompressed {
+		t.Errorf("Got: %d, want: %d", s.UncompressedSize, uncompressed)
+	}
+
+	// Free space left by a file whose block is no longer a file:
+	content := buildArchiveBytes(t, "a.txt", "aaaa", "b.txt", "bb")
+	m2, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	setBlockFlags(content, m2.header, 0, 0)
+	if m2, err = New(bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	s = m2.Stats()
+	if s.Files != 2 || s.FreeBlocks != 1 || s.FreeSpace != 4 || s.UnusedBlocks != 0 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,70 @@
+// Zero-copy access to the files stored as is.
+
+package mpq
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrFileNotStored indicates that the content of a file is not stored as is in the MPQ archive
+// (it is compressed, encrypted or it is a patch file)
+var ErrFileNotStored = errors.New("File not stored as is in MPQ Archive")
+
+// StoredFile returns a view of the content of a file stored as is (not compressed and not encrypted)
+// in the archive, without copying it: the content is read from the input when the returned reader is read.
+// Large stored files can be processed this way without allocating their whole content.
+//
+// ErrFileNotFound is returned if the file cannot be found.
+// ErrFileDeleted is returned if the file is marked as deleted (by a deletion marker).
+// ErrFileNotStored is returned if the file is not stored as is; such files can be read with FileByName() or Open().
+func (m *MPQ) StoredFile(name string) (*io.SectionReader, error) {
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	offset, size, err := m.storedRange(m.blockIndexByName(name))
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(m.inputAt(), offset, size), nil
+}
+
+// FileByNameNoCopy returns the content of a file specified by its name from the archive, like FileByName().
+// If the archive is in memory (see WithInMemory and NewFromReader) and the file is stored as is (not compressed and not encrypted),
+// the returned slice is a subslice of the archive data (the content is not copied), so it must not be modified!
+// Else the content is read like with FileByName(). Errors are the same as those of FileByName().
+func (m *MPQ) FileByNameNoCopy(name string) ([]byte, error) {
+	if m.memory == nil {
+		return m.FileByName(name)
+	}
+	if err := m.loadTables(); err != nil {
+		return nil, err
+	}
+	idx := m.blockIndexByName(name)
+	offset, size, err := m.storedRange(idx)
+	switch {
+	case err == ErrFileNotStored:
+		return m.readFile(idx, name)
+	case err != nil:
+		return nil, err
+	case offset+size > int64(len(m.memory)):
+		return nil, parseErr("sector", offset, io.ErrUnexpectedEOF)
+	}
+	return m.memory[offset : offset+size : offset+size], nil
+}
+
+// storedRange returns the offset and the size of the content of the file stored in the block
+// specified by its block table index, if the file is stored as is.
+func (m *MPQ) storedRange(blockEntryIndex int) (offset, size int64, err error) {
+	if blockEntryIndex < 0 {
+		return 0, 0, ErrFileNotFound
+	}
+	be := m.blockTable[blockEntryIndex]
+	if be.flags&beFlagDeleteMarker != 0 {
+		return 0, 0, ErrFileDeleted
+	}
+	if be.flags&(beFlagCompressed|beFlagEncrypted|uint32(FlagPatchFile)) != 0 || be.blockSize < be.fileSize {
+		return 0, 0, ErrFileNotStored
+	}
+	return m.archiveOffset + m.blockOffset(blockEntryIndex), int64(be.fileSize), nil
+}
@@ -0,0 +1,68 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStoredFile(t *testing.T) {
+	content := strings.Repeat("stored content ", 1000) // Multiple sectors
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []struct {
+		name string
+		opts []FileOption
+	}{
+		{"stored.txt", []FileOption{FileCompression(CompressionNone)}},
+		{"zlib.txt", []FileOption{FileCompression(CompressionZlib)}},
+		{"encrypted.txt", []FileOption{FileCompression(CompressionNone), FileEncrypted(false)}},
+	}
+	for _, f := range files {
+		if err := w.AddFile(f.name, []byte(content), f.opts...); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	m, err := NewFromReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	r, err := m.StoredFile("stored.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != content {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(data), err, len(content))
+	}
+	for _, name := range []string{"zlib.txt", "encrypted.txt"} {
+		if _, err := m.StoredFile(name); err != ErrFileNotStored {
+			t.Errorf("[%s] Got: %v, want: %v", name, err, ErrFileNotStored)
+		}
+	}
+	if _, err := m.StoredFile("missing.txt"); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+
+	// Stored files are not copied from archives in memory, others are read as usual:
+	for _, f := range files {
+		data, err := m.FileByNameNoCopy(f.name)
+		if err != nil || string(data) != content {
+			t.Errorf("[%s] Got: %d bytes, %v, want: %d bytes", f.name, len(data), err, len(content))
+		}
+		offset, _, _ := m.storedRange(m.blockIndexByName(f.name))
+		if aliased := err == nil && &data[0] == &m.memory[offset]; aliased != (f.name == "stored.txt") {
+			t.Errorf("[%s] Got: %t aliased, want: %t", f.name, aliased, !aliased)
+		}
+	}
+	if _, err := m.FileByNameNoCopy("missing.txt"); err != ErrFileNotFound {
+		t.Errorf("Got: %v, want: %v", err, ErrFileNotFound)
+	}
+}
@@ -0,0 +1,76 @@
+// Opening archives from non-seekable inputs.
+
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Maximum size of the content of non-seekable inputs buffered in memory, see NewFromReader().
+const readerMemoryLimit = 32 << 20
+
+// NewFromReader returns a new MPQ using a non-seekable reader (e.g. a pipe or a network stream) as the input.
+// Since the tables of archives are usually at their end, the content of r is read until EOF first:
+// up to 32 MB it is buffered in memory, bigger content is spilled to a temporary file.
+// The returned MPQ must be closed with the Close method (which removes the temporary file)!
+//
+// Errors of reading r or creating the temporary file are returned as-is.
+// ErrInvalidArchive is returned if the content is not a valid MPQ archive, see ParseError for details.
+// Options may be specified to tune how the archive is opened, see Option.
+func NewFromReader(r io.Reader, opts ...Option) (*MPQ, error) {
+	data, err := io.ReadAll(io.LimitReader(r, readerMemoryLimit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MPQ{}
+	m.applyOptions(opts)
+	if len(data) <= readerMemoryLimit {
+		m.input, m.memory = bytes.NewReader(data), data
+	} else {
+		tf, err := spillToTempFile(data, r)
+		if err != nil {
+			return nil, err
+		}
+		m.closer, m.input = tf, tf.f
+	}
+
+	if _, err := m.diveIn(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// tempFile is a temporary file which is removed when closed.
+type tempFile struct {
+	f *os.File
+}
+
+// Close implements io.Closer, it closes and removes the file.
+func (tf tempFile) Close() error {
+	err := tf.f.Close()
+	if err2 := os.Remove(tf.f.Name()); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// spillToTempFile writes data and the rest of r to a temporary file, positioned at its start.
+func spillToTempFile(data []byte, r io.Reader) (tf tempFile, err error) {
+	if tf.f, err = os.CreateTemp("", "mpq-*"); err != nil {
+		return
+	}
+	if _, err = tf.f.Write(data); err == nil {
+		_, err = io.Copy(tf.f, r)
+	}
+	if err == nil {
+		_, err = tf.f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tf.Close()
+	}
+	return
+}
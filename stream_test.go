@@ -0,0 +1,65 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNewFromReader(t *testing.T) {
+	data, err := os.ReadFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to read replay: %v", err)
+	}
+	exp, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to parse replay: %v", err)
+	}
+	expDetails, _ := exp.FileByName("replay.details")
+
+	m, err := NewFromReader(iotest.HalfReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m.Close()
+	if details, err := m.FileByName("replay.details"); err != nil || !bytes.Equal(details, expDetails) {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(details), err, len(expDetails))
+	}
+
+	// Read errors are returned:
+	if _, err := NewFromReader(iotest.ErrReader(io.ErrUnexpectedEOF)); err != io.ErrUnexpectedEOF {
+		t.Errorf("Got: %v, want: %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := NewFromReader(strings.NewReader("not an archive")); err == nil {
+		t.Errorf("Expected error")
+	}
+}
+
+func TestNewFromReaderSpill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping spilling big input in short mode")
+	}
+
+	// Big content spills to a temporary file, which is removed when closed:
+	data := buildArchiveBytes(t, "big.bin", strings.Repeat("x", readerMemoryLimit+1))
+	m, err := NewFromReader(iotest.HalfReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tf, ok := m.closer.(tempFile)
+	if !ok {
+		t.Fatalf("Expected temporary file")
+	}
+	if content, err := m.FileByName("big.bin"); err != nil || len(content) != readerMemoryLimit+1 {
+		t.Errorf("Got: %d bytes, %v, want: %d bytes", len(content), err, readerMemoryLimit+1)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(tf.f.Name()); !os.IsNotExist(err) {
+		t.Errorf("Temporary file not removed: %v", err)
+	}
+}
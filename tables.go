@@ -0,0 +1,79 @@
+// Raw access to the hash and block tables.
+
+package mpq
+
+// Special values of HashEntry.BlockIndex.
+const (
+	// HashEntryEmpty marks a hash table entry that is empty, and has always been empty.
+	HashEntryEmpty = 0xffffffff
+
+	// HashEntryDeleted marks a hash table entry that is empty, but was valid at some point (the file was deleted).
+	HashEntryDeleted = 0xfffffffe
+)
+
+// HashEntry is a decrypted entry of the hash table.
+type HashEntry struct {
+	// Hashes of the file name, see FileNameHash() (h2 and h3).
+	HashA, HashB uint32
+
+	// Locale of the file (Windows LANGID), 0 is the default (language-neutral) locale.
+	Locale uint16
+
+	// Platform of the file, 0 is the default platform.
+	Platform uint16
+
+	// Index of the file's entry in the block table, or HashEntryEmpty or HashEntryDeleted.
+	BlockIndex uint32
+}
+
+// BlockEntry is a decrypted entry of the block table.
+type BlockEntry struct {
+	// Offset of the block relative to the beginning of the archive
+	// (including the upper bits from the extended block table).
+	Offset uint64
+
+	// Size of the stored data of the block.
+	CompressedSize uint32
+
+	// Uncompressed size of the file.
+	Size uint32
+
+	// Flags of the block.
+	Flags BlockFlags
+}
+
+// HashTable returns a copy of the decrypted entries of the hash table.
+// nil is returned if the archive has no usable hash table (only HET and BET tables).
+func (m *MPQ) HashTable() []HashEntry {
+	m.loadTables()
+	if m.hashTable == nil {
+		return nil
+	}
+	entries := make([]HashEntry, len(m.hashTable))
+	for i, he := range m.hashTable {
+		entries[i] = HashEntry{
+			HashA:      he.filePathHashA,
+			HashB:      he.filePathHashB,
+			Locale:     he.language,
+			Platform:   he.platform,
+			BlockIndex: he.fileBlockIndex,
+		}
+	}
+	return entries
+}
+
+// BlockTable returns a copy of the decrypted entries of the block table
+// (or the entries of the BET table if the archive has no usable block table).
+func (m *MPQ) BlockTable() []BlockEntry {
+	m.loadTables()
+	entries := make([]BlockEntry, len(m.blockTable))
+	for i, be := range m.blockTable {
+		entries[i] = BlockEntry{
+			Offset:         uint64(m.blockOffset(i)),
+			CompressedSize: be.blockSize,
+			Size:           be.fileSize,
+			Flags:          BlockFlags(be.flags),
+		}
+	}
+	return entries
+}
@@ -0,0 +1,43 @@
+package mpq
+
+import (
+	"testing"
+)
+
+func TestTables(t *testing.T) {
+	m, err := NewFromFile("reps/lotv.SC2Replay")
+	if err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	defer m.Close()
+
+	hashTable, blockTable := m.HashTable(), m.BlockTable()
+	if len(hashTable) != int(m.HashTableEntries()) || len(blockTable) != int(m.BlockTableEntries()) {
+		t.Fatalf("Got: %d, %d entries, want: %d, %d", len(hashTable), len(blockTable), m.HashTableEntries(), m.BlockTableEntries())
+	}
+
+	_, h2, h3 := FileNameHash("replay.details")
+	fi := m.FileInfo("replay.details")
+	found := false
+	for _, he := range hashTable {
+		if he.HashA == h2 && he.HashB == h3 {
+			found = true
+			if int(he.BlockIndex) != fi.BlockIndex {
+				t.Errorf("Got: %d block index, want: %d", he.BlockIndex, fi.BlockIndex)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Hash table entry not found")
+	}
+	if be := blockTable[fi.BlockIndex]; be.Size != fi.Size || be.CompressedSize != fi.CompressedSize ||
+		be.Flags != fi.Flags || be.Offset == 0 {
+		t.Errorf("Unexpected block entry: %+v, file info: %+v", be, fi)
+	}
+
+	// Copies are returned:
+	hashTable[0].BlockIndex, blockTable[0].Size = 12345, 12345
+	if m.HashTable()[0].BlockIndex == 12345 || m.BlockTable()[0].Size == 12345 {
+		t.Errorf("Tables are not copied")
+	}
+}
@@ -0,0 +1,58 @@
+// Warcraft III map (.w3m and .w3x) header.
+//
+// Warcraft III maps start with a 512-byte header preceding the MPQ archive. The header starts with
+// the "HM3W" magic and an unused 32-bit value, followed by the zero-terminated map name,
+// the map flags and the maximum number of players (both 32-bit), padded with zeros to 512 bytes.
+
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Magic bytes of the Warcraft III map header
+var mapHeaderMagic = [4]byte{'H', 'M', '3', 'W'}
+
+// Size of the Warcraft III map header, the MPQ archive follows it.
+const mapHeaderSize = 512
+
+// MapHeader is the header of Warcraft III maps preceding the MPQ archive.
+type MapHeader struct {
+	// Name of the map.
+	Name string
+
+	// Map flags, e.g. 0x0001: hide minimap in preview screens, 0x0004: melee map.
+	Flags uint32
+
+	// Maximum number of players.
+	MaxPlayers uint32
+}
+
+// readMapHeader reads the Warcraft III map header whose magic has already been read from in.
+// in is positioned at the end of the header when this function returns without an error.
+func readMapHeader(in io.Reader) (*MapHeader, error) {
+	buf := make([]byte, mapHeaderSize-len(mapHeaderMagic))
+	if _, err := io.ReadFull(in, buf); err != nil {
+		return nil, err
+	}
+
+	buf = buf[4:] // Unused
+	i := bytes.IndexByte(buf, 0)
+	if i < 0 || i+1+8 > len(buf) {
+		return nil, ErrInvalidArchive
+	}
+
+	return &MapHeader{
+		Name:       string(buf[:i]),
+		Flags:      binary.LittleEndian.Uint32(buf[i+1:]),
+		MaxPlayers: binary.LittleEndian.Uint32(buf[i+5:]),
+	}, nil
+}
+
+// MapHeader returns the header of Warcraft III maps preceding the MPQ archive.
+// nil is returned if the input does not start with a Warcraft III map header.
+func (m *MPQ) MapHeader() *MapHeader {
+	return m.mapHeader
+}
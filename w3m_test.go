@@ -0,0 +1,51 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMapHeader(t *testing.T) {
+	w, err := NewBufferedWriter()
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("war3map.j", []byte("function main takes nothing returns nothing")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var archive bytes.Buffer
+	if _, err := w.WriteTo(&archive); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	content := make([]byte, mapHeaderSize)
+	copy(content, mapHeaderMagic[:])
+	i := 8 + copy(content[8:], "Lost Temple") + 1
+	binary.LittleEndian.PutUint32(content[i:], 0x0004)
+	binary.LittleEndian.PutUint32(content[i+4:], 4)
+	content = append(content, archive.Bytes()...)
+
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open map: %v", err)
+	}
+	exp := MapHeader{Name: "Lost Temple", Flags: 0x0004, MaxPlayers: 4}
+	if mh := m.MapHeader(); mh == nil || *mh != exp {
+		t.Errorf("Got: %+v, want: %+v", mh, exp)
+	}
+	if data, err := m.FileByName("war3map.j"); err != nil || !bytes.HasPrefix(data, []byte("function main")) {
+		t.Errorf("Failed to read file: %q, %v", data, err)
+	}
+	if err := m.VerifyMD5(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Archives without map header:
+	if m, err = New(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if mh := m.MapHeader(); mh != nil {
+		t.Errorf("Got: %+v, want: nil", mh)
+	}
+}
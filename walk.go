@@ -0,0 +1,30 @@
+// Iteration over the files of the archives.
+
+package mpq
+
+// Walk calls fn for each existing file of the archive whose name is known (see Files()),
+// in the order the files are listed. Files marked as deleted (by a deletion marker) are skipped.
+// The content of a file may be read in fn, e.g. with FileByName(fi.Name) or Open(fi.Name).
+//
+// If fn returns a non-nil error, the walk stops and the error is returned.
+// Errors of Files() are returned as-is.
+func (m *MPQ) Walk(fn func(fi *FileInfo) error) error {
+	files, err := m.Files()
+	if err != nil {
+		return err
+	}
+
+	for _, lf := range files {
+		if !lf.Found {
+			continue
+		}
+		hashIndex, blockIndex := m.lookupName(lf.Name)
+		if !m.exists(blockIndex) {
+			continue
+		}
+		if err := fn(m.fileInfo(lf.Name, hashIndex, blockIndex)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package mpq
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	m := buildArchive(t, "a.txt", "a", `dir\b.txt`, "bb")
+	var names []string
+	err := m.Walk(func(fi *FileInfo) error {
+		if data, err := m.FileByName(fi.Name); err != nil || len(data) != int(fi.Size) {
+			t.Errorf("[%s] Got: %q, %v", fi.Name, data, err)
+		}
+		names = append(names, fi.Name)
+		return nil
+	})
+	if err != nil || len(names) != 2 || names[0] != "a.txt" || names[1] != `dir\b.txt` {
+		t.Errorf("Got: %v, %v", names, err)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	if err := m.Walk(func(fi *FileInfo) error { count++; return stop }); err != stop || count != 1 {
+		t.Errorf("Got: %v after %d files, want: %v after 1 file", err, count, stop)
+	}
+}
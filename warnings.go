@@ -0,0 +1,90 @@
+// Non-fatal anomalies of the archives.
+
+package mpq
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Warning describes a recoverable anomaly encountered while parsing an archive, which did not prevent
+// opening it, e.g. an unexpected header size, unknown flag bits or unused space between the tables.
+// See MPQ.Warnings().
+type Warning struct {
+	// Structure the anomaly was found in, e.g. "header" or "block table".
+	Struct string
+
+	// Offset of the structure in the input.
+	Offset int64
+
+	// Description of the anomaly.
+	Msg string
+}
+
+// String returns a human-readable description of the warning.
+func (w Warning) String() string {
+	return w.Struct + " at offset " + strconv.FormatInt(w.Offset, 10) + ": " + w.Msg
+}
+
+// knownBlockFlags is the mask of all the known block flags.
+const knownBlockFlags = FlagImplode | FlagCompress | FlagEncrypted | FlagFixKey | FlagPatchFile |
+	FlagSingleUnit | FlagDeleteMarker | FlagSectorCRC | FlagExists
+
+// Warnings returns the recoverable anomalies encountered while parsing the archive, in the order
+// they were encountered. Anomalies do not prevent using the archive, but they may be of interest
+// when analyzing archives, e.g. to find archives created by unusual tools.
+func (m *MPQ) Warnings() []Warning {
+	m.loadTables()
+	return m.warnings
+}
+
+// warn records a warning about the structure at the given offset.
+func (m *MPQ) warn(structure string, offset int64, format string, a ...interface{}) {
+	m.warnings = append(m.warnings, Warning{Struct: structure, Offset: offset, Msg: fmt.Sprintf(format, a...)})
+}
+
+// checkHeader records the anomalies of the header.
+func (m *MPQ) checkHeader() {
+	h := &m.header
+
+	var size uint32
+	switch h.formatVersion {
+	case 0:
+		size = headerSizeV1
+	case 1:
+		size = headerSizeV2
+	case 2:
+		size = headerSizeV3
+	case 3:
+		size = headerSizeV4
+	default:
+		m.warn("header", m.archiveOffset, "unknown format version: %d", h.formatVersion)
+	}
+	if size > 0 && h.size != size {
+		m.warn("header", m.archiveOffset, "header size %#x, expected %#x for format version %d", h.size, size, h.formatVersion)
+	}
+}
+
+// checkTables records the anomalies of the hash and block tables.
+func (m *MPQ) checkTables() {
+	h := &m.header
+
+	hashTableOffset := int64(h.hashTableOffsetHigh)<<32 + int64(h.hashTableOffset)
+	blockTableOffset := int64(h.blockTableOffsetHigh)<<32 + int64(h.blockTableOffset)
+	// Stored tables may be smaller (compressed), but not larger than their entries
+	if m.hashTable != nil && blockTableOffset > hashTableOffset {
+		if slack := blockTableOffset - hashTableOffset - int64(h.hashTableEntries)*16; slack > 0 {
+			m.warn("hash table", m.archiveOffset+hashTableOffset, "%d unused bytes between the hash table and the block table", slack)
+		}
+	}
+
+	structure, offset := "block table", m.archiveOffset+blockTableOffset
+	if m.hashTable == nil { // Blocks of the BET table
+		structure, offset = "BET table", m.archiveOffset+int64(h.betTableOffset)
+	}
+	for i, be := range m.blockTable {
+		if unknown := BlockFlags(be.flags) &^ knownBlockFlags; unknown != 0 {
+			m.warn(structure, offset, "unknown flags %#08x in block %d", uint32(unknown), i)
+		}
+	}
+}
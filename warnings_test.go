@@ -0,0 +1,36 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	data := buildArchiveBytes(t, "a.txt", "a")
+	m, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if ws := m.Warnings(); len(ws) != 0 {
+		t.Errorf("Unexpected warnings: %v", ws)
+	}
+
+	binary.LittleEndian.PutUint32(data[4:], m.header.size+4) // Header size
+	if m, err = New(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	ws := m.Warnings()
+	if len(ws) != 1 || ws[0].Struct != "header" || ws[0].Offset != 0 || !strings.Contains(ws[0].String(), "header size") {
+		t.Errorf("Unexpected warnings: %v", ws)
+	}
+
+	m.warnings = nil
+	m.blockTable[0].flags |= 0x08
+	m.checkTables()
+	ws = m.Warnings()
+	if len(ws) != 1 || ws[0].Struct != "block table" || !strings.Contains(ws[0].Msg, "unknown flags 0x00000008 in block 0") {
+		t.Errorf("Unexpected warnings: %v", ws)
+	}
+}
@@ -0,0 +1,906 @@
+// Creation of new MPQ archives.
+
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrWriterClosed indicates that a Writer (or an Editor) has already been closed.
+	ErrWriterClosed = errors.New("MPQ Writer already closed")
+
+	// ErrDuplicateFile indicates an attempt to add a file whose name is already present in the archive.
+	ErrDuplicateFile = errors.New("Duplicate file in MPQ archive")
+
+	// ErrInvalidCompressionLevel indicates an invalid compression level.
+	ErrInvalidCompressionLevel = errors.New("Invalid compression level")
+
+	// ErrFileTooLarge indicates an attempt to add a file whose size does not fit into 32 bits.
+	ErrFileTooLarge = errors.New("File too large for MPQ archive")
+
+	// ErrArchiveTooLarge indicates that the archive size would exceed the supported maximum (2^48 bytes).
+	ErrArchiveTooLarge = errors.New("MPQ archive too large")
+
+	// ErrInvalidFormatVersion indicates an unknown format version.
+	ErrInvalidFormatVersion = errors.New("Invalid MPQ format version")
+
+	// ErrNotBuffered indicates an attempt to use Writer.WriteTo on a Writer not created with NewBufferedWriter.
+	ErrNotBuffered = errors.New("MPQ Writer is not buffered")
+)
+
+// Name of the internal file that lists the names of the files in the archive.
+const listfileName = "(listfile)"
+
+// Header sizes of the different format versions.
+const (
+	headerSizeV1 = 0x20 // Original format
+	headerSizeV2 = 0x2c // Burning Crusade format
+	headerSizeV3 = 0x44 // Format version 3
+	headerSizeV4 = 0xd0 // Format version 4
+)
+
+// FormatVersion is the version of the MoPaQ format.
+type FormatVersion uint16
+
+// Format versions.
+const (
+	// FormatVersion1 is the original format. Archives are limited to 4 GB.
+	FormatVersion1 FormatVersion = iota
+
+	// FormatVersion2 is the Burning Crusade format, supporting archives larger than 4 GB.
+	FormatVersion2
+
+	// FormatVersion3 is the format introduced in the Cataclysm beta, with a 64-bit archive size.
+	FormatVersion3
+
+	// FormatVersion4 is the Cataclysm format, with the sizes and MD5 digests of the tables in the header.
+	FormatVersion4
+)
+
+// headerSize returns the size of the header of the format version.
+func (v FormatVersion) headerSize() uint32 {
+	return [...]uint32{headerSizeV1, headerSizeV2, headerSizeV3, headerSizeV4}[v]
+}
+
+// Default sector size shift used by the Writer (4096 byte sectors).
+const defaultSectorSizeShift = 3
+
+// Minimum number of entries of the hash table created by the Writer.
+const minHashTableEntries = 4
+
+// Special values of hashEntry.fileBlockIndex.
+const (
+	hashEntryEmpty   = 0xffffffff // The entry is empty, and has always been empty (terminates searches)
+	hashEntryDeleted = 0xfffffffe // The entry is empty, but was in use (does not terminate searches)
+)
+
+// emptyHashEntry is an empty hash table entry (all its bytes are 0xff).
+var emptyHashEntry = hashEntry{
+	filePathHashA: 0xffffffff, filePathHashB: 0xffffffff,
+	language: 0xffff, platform: 0xffff,
+	fileBlockIndex: hashEntryEmpty,
+}
+
+// Writer creates a new MPQ archive.
+//
+// Files can be added with AddFile. The header, the hash table and the block table
+// are written when the Writer is closed, so the returned Writer must be closed with the Close method!
+//
+// Unless a file named "(listfile)" is added explicitly, a "(listfile)" containing
+// the names of all added files is also written to the archive on Close.
+//
+// By default archives are written in the original format, unless they exceed 4 GB, in which case
+// the Burning Crusade format is used with an extended block table.
+// A specific format version can be requested with WithFormatVersion.
+//
+// The output is reproducible: adding the same files (with the same options) in the same order
+// produces byte-identical archives. The layout of the data and the tables only depends on the order
+// of the added files, unused areas are always zeroed, and no timestamps are recorded.
+type Writer struct {
+	file *os.File       // Optional target file
+	out  io.WriteSeeker // Output of the archive
+
+	userData []byte // Optional user data, written before the header
+
+	base int64 // Position of the archive (the header) in the output
+	pos  int64 // Current write position, relative to the beginning of the archive
+
+	header header // Header of the archive, completed on Close
+
+	autoVersion bool // Tells if the format version is chosen automatically (based on the archive size)
+
+	blockSize uint32 // Size of the blocks (sectors).
+
+	compressionLevel int // Default compression level of the files
+
+	weakSigKey   *rsa.PrivateKey // Optional key of the weak digital signature
+	strongSigKey *rsa.PrivateKey // Optional key of the strong digital signature
+
+	files []*writerFile // Files added to the archive, in the order of their data
+
+	fileIndices map[[2]uint32]int // Indices of the files, mapped from their name hashes
+
+	err error // First error encountered, returned by all subsequent calls
+}
+
+// writerFile describes a file added to a Writer.
+type writerFile struct {
+	name string // Name of the file
+
+	h1, h2, h3 uint32 // Hashes of the name, see FileNameHash()
+
+	offset int64 // Offset of the file's block, relative to the beginning of the archive
+
+	block blockEntry // Block table entry of the file (blockOffset is only set when the tables are written)
+}
+
+// WriterOption configures a Writer, see NewWriter.
+type WriterOption func(*Writer)
+
+// WithCompressionLevel returns a WriterOption which sets the default compression level of the files.
+// Valid levels and their meaning are the same as in the compress/zlib package
+// (e.g. zlib.BestSpeed or zlib.BestCompression); the default is zlib.DefaultCompression.
+//
+// For bzip2 the level specifies the block size in 100 KB units (zlib.DefaultCompression meaning 9),
+// which only matters if the sector size exceeds 100 KB.
+// PKWARE DCL implosion has no levels, it always uses a 4 KB dictionary.
+//
+// The level can be overridden for individual files with FileCompressionLevel.
+func WithCompressionLevel(level int) WriterOption {
+	return func(w *Writer) {
+		w.compressionLevel = level
+	}
+}
+
+// WithFormatVersion returns a WriterOption which sets the format version of the archive.
+//
+// Archives larger than 4 GB can only be written in FormatVersion2 and later;
+// in FormatVersion1 ErrArchiveTooLarge is returned on Close in that case.
+// In FormatVersion3 and later the HET and BET tables are also written (besides the hash and block tables).
+func WithFormatVersion(v FormatVersion) WriterOption {
+	return func(w *Writer) {
+		w.header.formatVersion = uint16(v)
+		w.autoVersion = false
+	}
+}
+
+// WithUserData returns a WriterOption which writes a user data section (shunt block) with the given content
+// before the archive. The archive itself (the header) begins at the next 512-byte boundary after the user data.
+//
+// The content is stored right after the size and header offset fields of the user data section,
+// and it is what MPQ.UserData() returns when the archive is read
+// (in case of StarCraft II replays this starts with the size of the user data header).
+func WithUserData(data []byte) WriterOption {
+	return func(w *Writer) {
+		w.userData = data
+	}
+}
+
+// validCompressionLevel tells if level is a valid compression level.
+func validCompressionLevel(level int) bool {
+	return level >= zlib.HuffmanOnly && level <= zlib.BestCompression
+}
+
+// NewFileWriter creates (or truncates) the named file and returns a Writer writing into it.
+// The returned Writer must be closed with the Close method, which also closes the file!
+func NewFileWriter(name string, opts ...WriterOption) (*Writer, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f, opts...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.file = f
+
+	return w, nil
+}
+
+// NewBufferedWriter returns a new Writer that builds the archive in memory.
+// The archive can be written to any io.Writer (which does not need to support seeking) with Writer.WriteTo,
+// after all files have been added.
+func NewBufferedWriter(opts ...WriterOption) (*Writer, error) {
+	return NewWriter(&memBuffer{}, opts...)
+}
+
+// NewWriter returns a new Writer that writes an MPQ archive to the specified io.WriteSeeker.
+// The archive (or the user data section, see WithUserData) starts at the current position of out.
+// The returned Writer must be closed with the Close method!
+func NewWriter(out io.WriteSeeker, opts ...WriterOption) (*Writer, error) {
+	w := &Writer{
+		out:              out,
+		autoVersion:      true,
+		compressionLevel: zlib.DefaultCompression,
+		fileIndices:      map[[2]uint32]int{},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.header.formatVersion > uint16(FormatVersion4) {
+		return nil, ErrInvalidFormatVersion
+	}
+	if !validCompressionLevel(w.compressionLevel) {
+		return nil, ErrInvalidCompressionLevel
+	}
+	if err := w.checkSigning(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if w.base, err = out.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	if w.userData != nil {
+		if err = w.writeUserData(); err != nil {
+			return nil, err
+		}
+	}
+
+	w.header.size = FormatVersion(w.header.formatVersion).headerSize()
+	w.header.sectorSizeShift = defaultSectorSizeShift
+	w.blockSize = 512 << w.header.sectorSizeShift
+
+	// Reserve space for the header, it is written on Close when all its fields are known.
+	// In automatic mode space for the Burning Crusade header is reserved in case the archive exceeds 4 GB.
+	reserved := w.header.size
+	if w.autoVersion {
+		reserved = headerSizeV2
+	}
+	if err = w.write(make([]byte, reserved)); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// writeUserData writes the user data section, and moves the base of the archive after it
+// (to the next 512-byte boundary).
+func (w *Writer) writeUserData() error {
+	headerOffset := (12 + len(w.userData) + 511) &^ 511
+
+	buf := make([]byte, headerOffset)
+	copy(buf, userDataMagic[:])
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(w.userData)))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(headerOffset))
+	copy(buf[12:], w.userData)
+	if _, err := w.out.Write(buf); err != nil {
+		return err
+	}
+
+	w.base += int64(headerOffset)
+	return nil
+}
+
+// write writes p to the output, advancing the write position.
+func (w *Writer) write(p []byte) error {
+	n, err := w.out.Write(p)
+	w.pos += int64(n)
+	return err
+}
+
+// FileOption configures how a file is stored in the archive, see Writer.AddFile.
+type FileOption func(*fileConfig)
+
+// fileConfig holds the parameters of storing a file.
+type fileConfig struct {
+	compression Compression // Compression of the file's sectors
+	level       int         // Compression level
+	encrypted   bool        // Tells if the file is encrypted
+	fixKey      bool        // Tells if the encryption key is adjusted by the file's position and size
+}
+
+// FileCompression returns a FileOption which sets the compression of the file.
+// The default is CompressionNone.
+func FileCompression(c Compression) FileOption {
+	return func(fc *fileConfig) {
+		fc.compression = c
+	}
+}
+
+// FileCompressionLevel returns a FileOption which sets the compression level of the file,
+// overriding the default level of the Writer. See WithCompressionLevel for valid levels.
+func FileCompressionLevel(level int) FileOption {
+	return func(fc *fileConfig) {
+		fc.level = level
+	}
+}
+
+// FileEncrypted returns a FileOption which encrypts the file's data.
+// The encryption key is derived from the file name (without the path), so the name is required to read the file.
+//
+// If fixKey is true, the key is also adjusted by the position and the size of the file's data
+// (the FIX_KEY variant), so identical files are encrypted differently.
+func FileEncrypted(fixKey bool) FileOption {
+	return func(fc *fileConfig) {
+		fc.encrypted = true
+		fc.fixKey = fixKey
+	}
+}
+
+// AddFile adds a file with the specified name and content to the archive.
+//
+// ErrDuplicateFile is returned if a file with the same name has already been added.
+// ErrCompressionUnsupported is returned if the specified compression is not supported.
+// ErrInvalidCompressionLevel is returned if the specified compression level is invalid.
+func (w *Writer) AddFile(name string, data []byte, opts ...FileOption) error {
+	return w.AddFileReader(name, bytes.NewReader(data), opts...)
+}
+
+// AddFileReader adds a file with the specified name to the archive whose content is read from r until EOF.
+// The content is streamed into the output sector by sector, so it is never held in memory as a whole.
+//
+// The size of compressed and encrypted files must be known in advance
+// (compressed files start with a table of their sector offsets, and the size may be part of the encryption key).
+// The size is acquired from r if it has a Len() method (like bytes.Reader) or if it implements io.Seeker;
+// else the content is first copied into a temporary file.
+//
+// ErrDuplicateFile is returned if a file with the same name has already been added.
+// ErrCompressionUnsupported is returned if the specified compression is not supported.
+// ErrInvalidCompressionLevel is returned if the specified compression level is invalid.
+// ErrFileTooLarge is returned if the content is larger than 4 GB.
+// If reading from r fails, the partially written content is discarded and the error is returned;
+// the Writer remains usable.
+func (w *Writer) AddFileReader(name string, r io.Reader, opts ...FileOption) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	fc, err := w.fileConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	h1, h2, h3 := FileNameHash(name)
+	key := [2]uint32{h2, h3}
+	if _, ok := w.fileIndices[key]; ok {
+		return ErrDuplicateFile
+	}
+
+	f := &writerFile{
+		name: name,
+		h1:   h1, h2: h2, h3: h3,
+	}
+	if err := w.writeFile(f, r, fc); err != nil {
+		return err
+	}
+
+	w.fileIndices[key] = len(w.files)
+	w.files = append(w.files, f)
+
+	return nil
+}
+
+// fileConfig applies the file options, and validates the resulting configuration.
+func (w *Writer) fileConfig(opts []FileOption) (*fileConfig, error) {
+	fc := &fileConfig{level: w.compressionLevel}
+	for _, opt := range opts {
+		opt(fc)
+	}
+	if !fc.compression.valid() {
+		return nil, ErrCompressionUnsupported
+	}
+	if !validCompressionLevel(fc.level) {
+		return nil, ErrInvalidCompressionLevel
+	}
+	return fc, nil
+}
+
+// writeFile writes the content of the file read from r at the current write position, and fills its block entry
+// (except the block offset, which is only set when the tables are written).
+// If writing fails, the partially written content is discarded.
+func (w *Writer) writeFile(f *writerFile, r io.Reader, fc *fileConfig) error {
+	f.offset = w.pos
+	f.block = blockEntry{flags: beFlagFile}
+
+	var err error
+	if fc.compression == CompressionNone && !fc.encrypted {
+		err = w.writeStored(f, r)
+	} else {
+		err = w.writeSectors(f, r, fc)
+	}
+	if w.err != nil {
+		return w.err // Output error
+	}
+	if err != nil {
+		return w.discard(f.offset, err)
+	}
+	return nil
+}
+
+// writeStored writes the content of an uncompressed file, read from r.
+// Errors of the output are recorded in w.err.
+func (w *Writer) writeStored(f *writerFile, r io.Reader) error {
+	var size int64
+
+	buf := make([]byte, w.blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if size += int64(n); size > math.MaxUint32 {
+				return ErrFileTooLarge
+			}
+			if w.err = w.write(buf[:n]); w.err != nil {
+				return w.err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	f.block.blockSize = uint32(size)
+	f.block.fileSize = uint32(size)
+
+	return nil
+}
+
+// writeSectors writes the content of a compressed and / or encrypted file, read from r.
+// Errors of the output are recorded in w.err.
+func (w *Writer) writeSectors(f *writerFile, r io.Reader, fc *fileConfig) error {
+	size, ok, err := contentSize(r)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		tmp, err := spill(r)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+		if size, err = tmp.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = tmp
+	}
+
+	if size > math.MaxUint32 {
+		return ErrFileTooLarge
+	}
+	if size == 0 {
+		return nil // Empty files have no data (and are not flagged compressed or encrypted)
+	}
+
+	compressed := fc.compression != CompressionNone
+	if compressed {
+		f.block.flags |= beFlagCompressedMulti
+	}
+
+	var key uint32
+	if fc.encrypted {
+		f.block.flags |= beFlagEncrypted
+		if fc.fixKey {
+			f.block.flags |= beFlagFixKey
+		}
+		key = fileKey(f.name, uint32(f.offset), uint32(size), f.block.flags)
+	}
+
+	sectors := (size + int64(w.blockSize) - 1) / int64(w.blockSize)
+	var offsets []byte
+	if compressed {
+		// Reserve space for the sector offset table (1 entry for each sector + 1 for the end of the last sector):
+		offsets = make([]byte, (sectors+1)*4)
+		if w.err = w.write(offsets); w.err != nil {
+			return w.err
+		}
+		binary.LittleEndian.PutUint32(offsets, uint32(len(offsets)))
+	}
+
+	pos := int64(len(offsets))
+	buf := make([]byte, w.blockSize)
+	for k := int64(0); k < sectors; k++ {
+		sector := buf
+		if remaining := size - k*int64(w.blockSize); remaining < int64(len(sector)) {
+			sector = sector[:remaining]
+		}
+		if _, err = io.ReadFull(r, sector); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		if compressed {
+			if sector, err = compressMulti(sector, fc.compression, fc.level); err != nil {
+				return err
+			}
+		}
+		if fc.encrypted {
+			encrypt(sector, key+uint32(k)) // Sectors are encrypted with the key incremented by their index
+		}
+		if pos += int64(len(sector)); pos > math.MaxUint32 {
+			return ErrFileTooLarge
+		}
+		if w.err = w.write(sector); w.err != nil {
+			return w.err
+		}
+		if compressed {
+			binary.LittleEndian.PutUint32(offsets[(k+1)*4:], uint32(pos))
+		}
+	}
+
+	if compressed {
+		if fc.encrypted {
+			encrypt(offsets, key-1) // The sector offset table is encrypted with the key decremented by 1
+		}
+		if w.err = w.writeAt(offsets, f.offset); w.err != nil {
+			return w.err
+		}
+	}
+
+	f.block.blockSize = uint32(pos)
+	f.block.fileSize = uint32(size)
+
+	return nil
+}
+
+// writeAt writes p at the given offset (relative to the beginning of the archive),
+// and then restores the write position.
+func (w *Writer) writeAt(p []byte, offset int64) error {
+	if _, err := w.out.Seek(w.base+offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(p); err != nil {
+		return err
+	}
+	_, err := w.out.Seek(w.base+w.pos, io.SeekStart)
+	return err
+}
+
+// contentSize returns the size of the remaining content of r if it can be determined without reading it.
+func contentSize(r io.Reader) (size int64, ok bool, err error) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), true, nil
+	case io.Seeker:
+		cur, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false, nil // Not seekable after all (e.g. a pipe)
+		}
+		end, err := v.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false, nil
+		}
+		if _, err = v.Seek(cur, io.SeekStart); err != nil {
+			return 0, false, err
+		}
+		return end - cur, true, nil
+	}
+	return 0, false, nil
+}
+
+// spill copies the content of r into a new temporary file.
+// It's the caller's responsibility to close and remove the file.
+func spill(r io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "mpq-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// discard discards the data written after offset (by moving the write position back to offset),
+// and returns err.
+func (w *Writer) discard(offset int64, err error) error {
+	if _, w.err = w.out.Seek(w.base+offset, io.SeekStart); w.err != nil {
+		return w.err
+	}
+	w.pos = offset
+	return err
+}
+
+// Close writes the "(listfile)", the hash table, the block table and the header of the archive,
+// and closes the target file if the Writer was created with NewFileWriter.
+//
+// Closing the Writer does not close the io.WriteSeeker passed to NewWriter.
+func (w *Writer) Close() error {
+	if w.err == ErrWriterClosed {
+		return w.err
+	}
+
+	err := w.finish()
+	if w.file != nil {
+		if err == nil {
+			// Cut data of discarded files that may remain after the end of the archive
+			var end int64
+			if end, err = w.file.Seek(0, io.SeekCurrent); err == nil {
+				err = w.file.Truncate(end)
+			}
+		}
+		if err2 := w.file.Close(); err == nil {
+			err = err2
+		}
+	}
+
+	w.err = ErrWriterClosed
+
+	return err
+}
+
+// WriteTo completes the archive (just like Close), and writes it to dst.
+// It implements io.WriterTo, and may only be called once.
+//
+// WriteTo can only be used with Writers created with NewBufferedWriter, else ErrNotBuffered is returned.
+func (w *Writer) WriteTo(dst io.Writer) (int64, error) {
+	mb, ok := w.out.(*memBuffer)
+	if !ok {
+		return 0, ErrNotBuffered
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	// The output is positioned at the end of the archive (and the strong signature, if any)
+	n, err := dst.Write(mb.data[:mb.pos])
+	return int64(n), err
+}
+
+// finish writes the data that can only be written after all files have been added.
+func (w *Writer) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	// Add the "(listfile)" unless it was added explicitly:
+	_, h2, h3 := FileNameHash(listfileName)
+	if _, ok := w.fileIndices[[2]uint32{h2, h3}]; !ok {
+		sb := strings.Builder{}
+		for _, f := range w.files {
+			sb.WriteString(f.name)
+			sb.WriteString("\r\n")
+		}
+		if err := w.AddFile(listfileName, []byte(sb.String())); err != nil {
+			return err
+		}
+	}
+
+	// Reserve the "(signature)" file, its content is written when the rest of the archive is complete:
+	if w.weakSigKey != nil {
+		if err := w.AddFile(signatureFileName, make([]byte, weakSignatureFileSize)); err != nil {
+			return err
+		}
+	}
+
+	if err := w.writeTables(); err != nil {
+		return err
+	}
+
+	// Finally go back and write the header
+	if _, err := w.out.Seek(w.base, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(w.headerBytes()); err != nil {
+		return err
+	}
+
+	// Leave the output positioned at the end of the archive
+	if _, err := w.out.Seek(w.base+w.pos, io.SeekStart); err != nil {
+		return err
+	}
+
+	if w.weakSigKey != nil {
+		if err := w.signWeak(); err != nil {
+			return err
+		}
+	}
+	if w.strongSigKey != nil {
+		if err := w.signStrong(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeTables writes the HET and BET tables (in format version 3 and later), the hash table,
+// the block table and the extended block table (if needed), and fills the related fields of the header.
+//
+// If the archive exceeds 4 GB and the format version is chosen automatically, the Burning Crusade format is used.
+func (w *Writer) writeTables() error {
+	h := &w.header
+
+	// Hash table size must be a power of 2, and we keep at least 1 empty entry to terminate searches.
+	h.hashTableEntries = minHashTableEntries
+	for h.hashTableEntries <= uint32(len(w.files)) {
+		h.hashTableEntries <<= 1
+	}
+	h.blockTableEntries = uint32(len(w.files))
+
+	// HET and BET tables, they do not depend on the table positions:
+	var het, bet []byte
+	if h.formatVersion >= uint16(FormatVersion3) {
+		hashes := make([]uint64, len(w.files))
+		for i, f := range w.files {
+			hashes[i] = hetHash(f.name)
+		}
+		het, bet = buildHetTable(hashes), buildBetTable(w.files, hashes)
+	}
+
+	// Size of the tables, needed to decide if the archive is large:
+	tablesSize := int64(len(het)+len(bet)) + int64(h.hashTableEntries+h.blockTableEntries)*16
+	large := w.pos+tablesSize > math.MaxUint32
+	if large {
+		if w.autoVersion {
+			h.formatVersion = uint16(FormatVersion2)
+			h.size = headerSizeV2
+		}
+		if h.formatVersion == uint16(FormatVersion1) {
+			return ErrArchiveTooLarge
+		}
+		tablesSize += int64(h.blockTableEntries) * 2
+	}
+	if w.pos+tablesSize > 1<<48 {
+		return ErrArchiveTooLarge
+	}
+
+	if het != nil {
+		h.hetTableOffset = uint64(w.pos)
+		h.hetTableSize64, h.hetTableMD5 = uint64(len(het)), md5.Sum(het)
+		if err := w.write(het); err != nil {
+			return err
+		}
+		h.betTableOffset = uint64(w.pos)
+		h.betTableSize64, h.betTableMD5 = uint64(len(bet)), md5.Sum(bet)
+		if err := w.write(bet); err != nil {
+			return err
+		}
+	}
+
+	// Build the hash table, the block table and the extended block table
+	hashTable := make([]hashEntry, h.hashTableEntries)
+	for i := range hashTable {
+		hashTable[i] = emptyHashEntry
+	}
+	blockTable := make([]blockEntry, len(w.files))
+	var highOffsets []uint16
+	if large {
+		highOffsets = make([]uint16, len(w.files))
+	}
+	for i, f := range w.files {
+		j := f.h1 & (h.hashTableEntries - 1)
+		for hashTable[j].fileBlockIndex != hashEntryEmpty {
+			j = (j + 1) & (h.hashTableEntries - 1)
+		}
+		hashTable[j] = hashEntry{filePathHashA: f.h2, filePathHashB: f.h3, fileBlockIndex: uint32(i)}
+
+		f.block.blockOffset = uint32(f.offset)
+		blockTable[i] = f.block
+		if large {
+			highOffsets[i] = uint16(f.offset >> 32)
+		}
+	}
+
+	return w.writeClassicTables(hashTable, blockTable, highOffsets)
+}
+
+// writeClassicTables writes the hash table, the block table and the extended block table (if highOffsets is not nil),
+// and fills the related fields of the header, including the archive size.
+func (w *Writer) writeClassicTables(hashTable []hashEntry, blockTable []blockEntry, highOffsets []uint16) error {
+	h := &w.header
+
+	buf := make([]byte, len(hashTable)*16)
+	for i, he := range hashTable {
+		e := buf[i*16:]
+		binary.LittleEndian.PutUint32(e, he.filePathHashA)
+		binary.LittleEndian.PutUint32(e[4:], he.filePathHashB)
+		binary.LittleEndian.PutUint16(e[8:], he.language)
+		binary.LittleEndian.PutUint16(e[10:], he.platform)
+		binary.LittleEndian.PutUint32(e[12:], he.fileBlockIndex)
+	}
+	encrypt(buf, hashTableKey)
+	h.hashTableEntries = uint32(len(hashTable))
+	h.hashTableOffset, h.hashTableOffsetHigh = uint32(w.pos), uint16(w.pos>>32)
+	h.hashTableSize64, h.hashTableMD5 = uint64(len(buf)), md5.Sum(buf)
+	if err := w.write(buf); err != nil {
+		return err
+	}
+
+	buf = make([]byte, len(blockTable)*16)
+	for i, be := range blockTable {
+		e := buf[i*16:]
+		binary.LittleEndian.PutUint32(e, be.blockOffset)
+		binary.LittleEndian.PutUint32(e[4:], be.blockSize)
+		binary.LittleEndian.PutUint32(e[8:], be.fileSize)
+		binary.LittleEndian.PutUint32(e[12:], be.flags)
+	}
+	encrypt(buf, blockTableKey)
+	h.blockTableEntries = uint32(len(blockTable))
+	h.blockTableOffset, h.blockTableOffsetHigh = uint32(w.pos), uint16(w.pos>>32)
+	h.blockTableSize64, h.blockTableMD5 = uint64(len(buf)), md5.Sum(buf)
+	if err := w.write(buf); err != nil {
+		return err
+	}
+
+	h.extendedBlockTableOffset = 0
+	h.extendedBlockTableSize64, h.extendedBlockTableMD5 = 0, [16]byte{}
+	if highOffsets != nil {
+		// Extended block table with the upper 16 bits of the block offsets, not encrypted
+		buf = buf[:len(highOffsets)*2]
+		for i, high := range highOffsets {
+			binary.LittleEndian.PutUint16(buf[i*2:], high)
+		}
+		h.extendedBlockTableOffset = uint64(w.pos)
+		h.extendedBlockTableSize64, h.extendedBlockTableMD5 = uint64(len(buf)), md5.Sum(buf)
+		if err := w.write(buf); err != nil {
+			return err
+		}
+	}
+
+	// Deprecated in the Burning Crusade format, only the lower 32 bits are stored
+	h.archiveSize = uint32(w.pos)
+	h.archiveSize64 = uint64(w.pos)
+
+	return nil
+}
+
+// headerBytes returns the binary representation of the header (including its magic).
+func (w *Writer) headerBytes() []byte {
+	h := &w.header
+
+	buf := make([]byte, h.size)
+	copy(buf, headerMagic[:])
+	binary.LittleEndian.PutUint32(buf[4:], h.size)
+	binary.LittleEndian.PutUint32(buf[8:], h.archiveSize)
+	binary.LittleEndian.PutUint16(buf[12:], h.formatVersion)
+	binary.LittleEndian.PutUint16(buf[14:], h.sectorSizeShift)
+	binary.LittleEndian.PutUint32(buf[16:], h.hashTableOffset)
+	binary.LittleEndian.PutUint32(buf[20:], h.blockTableOffset)
+	binary.LittleEndian.PutUint32(buf[24:], h.hashTableEntries)
+	binary.LittleEndian.PutUint32(buf[28:], h.blockTableEntries)
+
+	if h.formatVersion > 0 {
+		binary.LittleEndian.PutUint64(buf[32:], h.extendedBlockTableOffset)
+		binary.LittleEndian.PutUint16(buf[40:], h.hashTableOffsetHigh)
+		binary.LittleEndian.PutUint16(buf[42:], h.blockTableOffsetHigh)
+	}
+
+	if h.formatVersion > 1 {
+		binary.LittleEndian.PutUint64(buf[0x2c:], h.archiveSize64)
+		binary.LittleEndian.PutUint64(buf[0x34:], h.betTableOffset)
+		binary.LittleEndian.PutUint64(buf[0x3c:], h.hetTableOffset)
+	}
+
+	if h.formatVersion > 2 {
+		binary.LittleEndian.PutUint64(buf[0x44:], h.hashTableSize64)
+		binary.LittleEndian.PutUint64(buf[0x4c:], h.blockTableSize64)
+		binary.LittleEndian.PutUint64(buf[0x54:], h.extendedBlockTableSize64)
+		binary.LittleEndian.PutUint64(buf[0x5c:], h.hetTableSize64)
+		binary.LittleEndian.PutUint64(buf[0x64:], h.betTableSize64)
+		binary.LittleEndian.PutUint32(buf[0x6c:], h.rawChunkSize)
+		copy(buf[0x70:], h.blockTableMD5[:])
+		copy(buf[0x80:], h.hashTableMD5[:])
+		copy(buf[0x90:], h.extendedBlockTableMD5[:])
+		copy(buf[0xa0:], h.betTableMD5[:])
+		copy(buf[0xb0:], h.hetTableMD5[:])
+		h.headerMD5 = md5.Sum(buf[:0xc0])
+		copy(buf[0xc0:], h.headerMD5[:])
+	}
+
+	return buf
+}
@@ -0,0 +1,640 @@
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testContent returns deterministic test content of the given size.
+func testContent(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/13)
+	}
+	return data
+}
+
+func TestWriter(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	files := map[string][]byte{
+		"empty.txt":     {},
+		"small.txt":     []byte("Hello, MPQ!"),
+		"dir\\big.bin":  testContent(10000),
+		"exact.bin":     testContent(4096),
+		"replay.detail": testContent(777),
+	}
+
+	w, err := NewFileWriter(name)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for fname, data := range files {
+		if err := w.AddFile(fname, data); err != nil {
+			t.Errorf("Failed to add file %s: %v", fname, err)
+		}
+	}
+	if err := w.AddFile("SMALL.TXT", nil); err != ErrDuplicateFile {
+		t.Errorf("Expected ErrDuplicateFile, got: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	if err := w.AddFile("late.txt", nil); err != ErrWriterClosed {
+		t.Errorf("Expected ErrWriterClosed, got: %v", err)
+	}
+
+	m, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	defer m.Close()
+
+	if exp := uint32(len(files) + 1); m.FilesCount() != exp { // +1: (listfile)
+		t.Errorf("Expected %d files, got: %d", exp, m.FilesCount())
+	}
+	for fname, data := range files {
+		got, err := m.FileByName(fname)
+		if err != nil || got == nil || !bytes.Equal(got, data) {
+			t.Errorf("File %s mismatch (err: %v)", fname, err)
+		}
+	}
+
+	listfile, err := m.FileByName("(listfile)")
+	if err != nil {
+		t.Fatalf("Failed to read (listfile): %v", err)
+	}
+	for fname := range files {
+		if !strings.Contains(string(listfile), fname+"\r\n") {
+			t.Errorf("(listfile) does not contain: %s", fname)
+		}
+	}
+
+	if data, err := m.FileByName("missing.txt"); data != nil || err != ErrFileNotFound {
+		t.Errorf("Expected nil, ErrFileNotFound for missing file, got: %v, %v", data, err)
+	}
+}
+
+// failingReader returns n bytes of content and then fails.
+type failingReader struct {
+	n int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errors.New("read failure")
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	copy(p, testContent(len(p)))
+	r.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriterAddFileReader(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	w, err := NewFileWriter(name)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	big := testContent(1 << 20)
+	if err := w.AddFileReader("big.bin", iotest.OneByteReader(bytes.NewReader(big))); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.AddFileReader("failing.bin", &failingReader{n: 10000}); err == nil {
+		t.Errorf("Expected error from failing reader")
+	}
+	if err := w.AddFileReader("after.txt", strings.NewReader("after")); err != nil {
+		t.Errorf("Failed to add file after failed one: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	m, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	defer m.Close()
+
+	if got, err := m.FileByName("big.bin"); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("File big.bin mismatch (err: %v)", err)
+	}
+	if got, err := m.FileByName("after.txt"); err != nil || string(got) != "after" {
+		t.Errorf("File after.txt mismatch: %q (err: %v)", got, err)
+	}
+	if got, err := m.FileByName("failing.bin"); got != nil || err != ErrFileNotFound {
+		t.Errorf("Failed file should not be present, got: %v, %v", got, err)
+	}
+}
+
+func TestWriterCompression(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	sparse := make([]byte, 20000)
+	copy(sparse[1000:], "some data between zeros")
+	sparse[5000], sparse[5002] = 1, 1
+	contents := map[string][]byte{
+		"random.bin": testContent(10000),
+		"sparse.bin": sparse,
+		"text.txt":   bytes.Repeat([]byte("Lorem ipsum dolor sit amet. "), 1000),
+		"small.txt":  []byte("x"),
+		"empty.txt":  {},
+	}
+	compressions := []Compression{
+		CompressionNone, CompressionZlib, CompressionBzip2, CompressionPKWare, CompressionSparse,
+		CompressionSparse | CompressionZlib, CompressionSparse | CompressionBzip2, CompressionSparse | CompressionPKWare,
+	}
+
+	w, err := NewFileWriter(name)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for _, c := range compressions {
+		for fname, data := range contents {
+			fname = fmt.Sprintf("%x\\%s", c, fname)
+			if err := w.AddFile(fname, data, FileCompression(c)); err != nil {
+				t.Errorf("Failed to add file %s: %v", fname, err)
+			}
+			// Also through a reader whose size is unknown:
+			r := iotest.OneByteReader(bytes.NewReader(data))
+			if err := w.AddFileReader(fname+".r", r, FileCompression(c)); err != nil {
+				t.Errorf("Failed to add file %s: %v", fname, err)
+			}
+		}
+	}
+	if err := w.AddFile("invalid", nil, FileCompression(CompressionZlib|CompressionBzip2)); err != ErrCompressionUnsupported {
+		t.Errorf("Expected ErrCompressionUnsupported, got: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	m, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	defer m.Close()
+
+	for _, c := range compressions {
+		for fname, data := range contents {
+			fname = fmt.Sprintf("%x\\%s", c, fname)
+			for _, fname := range []string{fname, fname + ".r"} {
+				if got, err := m.FileByName(fname); err != nil || !bytes.Equal(got, data) {
+					t.Errorf("File %s mismatch (err: %v)", fname, err)
+				}
+			}
+		}
+	}
+}
+
+func TestWriterCompressionLevel(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	if _, err := NewWriter(nil, WithCompressionLevel(10)); err != ErrInvalidCompressionLevel {
+		t.Errorf("Expected ErrInvalidCompressionLevel, got: %v", err)
+	}
+
+	w, err := NewFileWriter(name, WithCompressionLevel(zlib.BestSpeed))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	data := bytes.Repeat([]byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit. "), 500)
+	opts := map[string][]FileOption{
+		"default.txt": {FileCompression(CompressionZlib)},
+		"best.txt":    {FileCompression(CompressionZlib), FileCompressionLevel(zlib.BestCompression)},
+		"none.txt":    {FileCompression(CompressionZlib), FileCompressionLevel(zlib.NoCompression)},
+		"bzip2-1.txt": {FileCompression(CompressionBzip2), FileCompressionLevel(1)},
+	}
+	for fname, opts := range opts {
+		if err := w.AddFile(fname, data, opts...); err != nil {
+			t.Errorf("Failed to add file %s: %v", fname, err)
+		}
+	}
+	if err := w.AddFile("invalid", data, FileCompressionLevel(-3)); err != ErrInvalidCompressionLevel {
+		t.Errorf("Expected ErrInvalidCompressionLevel, got: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	m, err := NewFromFile(name)
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	defer m.Close()
+
+	for fname := range opts {
+		if got, err := m.FileByName(fname); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("File %s mismatch (err: %v)", fname, err)
+		}
+	}
+}
+
+func TestWriterFormatVersion(t *testing.T) {
+	if _, err := NewWriter(nil, WithFormatVersion(4)); err != ErrInvalidFormatVersion {
+		t.Errorf("Expected ErrInvalidFormatVersion, got: %v", err)
+	}
+
+	for _, v := range []FormatVersion{FormatVersion1, FormatVersion2, FormatVersion3, FormatVersion4} {
+		name := filepath.Join(t.TempDir(), "test.mpq")
+		w, err := NewFileWriter(name, WithFormatVersion(v))
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		if err := w.AddFile("a.txt", testContent(5000), FileCompression(CompressionZlib)); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to close writer: %v", err)
+		}
+
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		m, err := New(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("[v%d] Failed to open written archive: %v", v+1, err)
+		}
+		if m.header.formatVersion != uint16(v) || m.header.size != v.headerSize() {
+			t.Errorf("[v%d] Unexpected header: %+v", v+1, m.header)
+		}
+		if got, err := m.FileByName("a.txt"); err != nil || !bytes.Equal(got, testContent(5000)) {
+			t.Errorf("[v%d] File mismatch (err: %v)", v+1, err)
+		}
+
+		switch v {
+		case FormatVersion3:
+			if got := binary.LittleEndian.Uint64(content[0x2c:]); got != uint64(len(content)) {
+				t.Errorf("[v%d] Unexpected 64-bit archive size: %d", v+1, got)
+			}
+		case FormatVersion4:
+			hashTable := content[m.header.hashTableOffset : m.header.hashTableOffset+m.header.hashTableEntries*16]
+			blockTable := content[m.header.blockTableOffset : m.header.blockTableOffset+m.header.blockTableEntries*16]
+			hashMD5, blockMD5, headerMD5 := md5.Sum(hashTable), md5.Sum(blockTable), md5.Sum(content[:0xc0])
+			if !bytes.Equal(content[0x80:0x90], hashMD5[:]) || !bytes.Equal(content[0x70:0x80], blockMD5[:]) ||
+				!bytes.Equal(content[0xc0:0xd0], headerMD5[:]) {
+				t.Errorf("[v%d] MD5 mismatch", v+1)
+			}
+			if got := binary.LittleEndian.Uint64(content[0x44:]); got != uint64(len(hashTable)) {
+				t.Errorf("[v%d] Unexpected hash table size: %d", v+1, got)
+			}
+		}
+	}
+}
+
+func TestWriterUserData(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	userData := testContent(512)
+	w, err := NewFileWriter(name, WithUserData(userData))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("a.txt", testContent(5000), FileCompression(CompressionZlib)); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if !bytes.Equal(content[1024:1028], headerMagic[:]) {
+		t.Errorf("Header is not at the next 512-byte boundary")
+	}
+
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	if !bytes.Equal(m.UserData(), userData) {
+		t.Errorf("User data mismatch")
+	}
+	if got, err := m.FileByName("a.txt"); err != nil || !bytes.Equal(got, testContent(5000)) {
+		t.Errorf("File mismatch (err: %v)", err)
+	}
+}
+
+func TestWriterDeterministic(t *testing.T) {
+	write := func() []byte {
+		name := filepath.Join(t.TempDir(), "test.mpq")
+		w, err := NewFileWriter(name, WithFormatVersion(FormatVersion4), WithUserData([]byte("user data")),
+			WithWeakSignature(testWeakKey))
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		for i, c := range []Compression{CompressionNone, CompressionZlib, CompressionBzip2, CompressionSparse} {
+			fname := fmt.Sprintf("file%d.bin", i)
+			if err := w.AddFile(fname, testContent(9000), FileCompression(c), FileEncrypted(i%2 == 0)); err != nil {
+				t.Errorf("Failed to add file %s: %v", fname, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to close writer: %v", err)
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		return content
+	}
+
+	if !bytes.Equal(write(), write()) {
+		t.Errorf("Output is not reproducible")
+	}
+}
+
+func TestWriterWriteTo(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	opts := []WriterOption{WithWeakSignature(testWeakKey), WithStrongSignature(testStrongKey)}
+	fw, err := NewFileWriter(name, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	bw, err := NewBufferedWriter(opts...)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for _, w := range []*Writer{fw, bw} {
+		if err := w.AddFile("a.txt", testContent(5000), FileCompression(CompressionZlib)); err != nil {
+			t.Errorf("Failed to add file: %v", err)
+		}
+		if err := w.AddFileReader("failing.bin", &failingReader{n: 20000}); err == nil {
+			t.Errorf("Expected error from failing reader")
+		}
+	}
+
+	if _, err := fw.WriteTo(io.Discard); err != ErrNotBuffered {
+		t.Errorf("Expected ErrNotBuffered, got: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := bw.WriteTo(struct{ io.Writer }{buf}) // Hide everything but Write
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("Failed to write archive: %d, %v", n, err)
+	}
+	if _, err := bw.WriteTo(buf); err != ErrWriterClosed {
+		t.Errorf("Expected ErrWriterClosed, got: %v", err)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Buffered output differs from file output")
+	}
+
+	m, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	if got, err := m.FileByName("a.txt"); err != nil || !bytes.Equal(got, testContent(5000)) {
+		t.Errorf("File mismatch (err: %v)", err)
+	}
+}
+
+// decodeEncrypted decrypts and decompresses the content of an encrypted file.
+func decodeEncrypted(content []byte, name string, be blockEntry, blockSize uint32) ([]byte, error) {
+	key := fileKey(name, be.blockOffset, be.fileSize, be.flags)
+	data := append([]byte{}, content[be.blockOffset:be.blockOffset+be.blockSize]...)
+	sectors := (be.fileSize + blockSize - 1) / blockSize
+
+	offsets := make([]uint32, sectors+1)
+	if be.flags&beFlagCompressedMulti != 0 {
+		decrypt(data[:len(offsets)*4], key-1)
+		for i := range offsets {
+			offsets[i] = binary.LittleEndian.Uint32(data[i*4:])
+		}
+	} else {
+		for i := range offsets {
+			offsets[i] = uint32(i) * blockSize
+		}
+		offsets[sectors] = be.fileSize
+	}
+
+	result := make([]byte, be.fileSize)
+	for i := uint32(0); i < sectors; i++ {
+		sector := data[offsets[i]:offsets[i+1]]
+		decrypt(sector, key+i)
+		dst := result[i*blockSize:]
+		if len(dst) > int(blockSize) {
+			dst = dst[:blockSize]
+		}
+		if be.flags&beFlagCompressedMulti != 0 {
+			if err := decompressMulti(dst, sector); err != nil {
+				return nil, err
+			}
+		} else {
+			copy(dst, sector)
+		}
+	}
+	return result, nil
+}
+
+func TestWriterEncryption(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mpq")
+
+	if key := fileKey("dir/(hash table)", 0, 0, 0); key != hashTableKey {
+		t.Errorf("Unexpected file key: %x", key)
+	}
+
+	w, err := NewFileWriter(name)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		opts []FileOption
+	}{
+		{"dir\\stored.bin", testContent(10001), []FileOption{FileEncrypted(false)}},
+		{"dir\\fixkey.bin", testContent(10001), []FileOption{FileEncrypted(true)}},
+		{"compressed.txt", bytes.Repeat([]byte("Lorem ipsum. "), 1000),
+			[]FileOption{FileEncrypted(false), FileCompression(CompressionZlib)}},
+		{"compressed-fixkey.txt", bytes.Repeat([]byte("Lorem ipsum. "), 1000),
+			[]FileOption{FileEncrypted(true), FileCompression(CompressionBzip2)}},
+	}
+	for _, f := range files {
+		if err := w.AddFile(f.name, f.data, f.opts...); err != nil {
+			t.Errorf("Failed to add file %s: %v", f.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	m, err := New(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+
+	if bytes.Contains(content, files[0].data[:100]) {
+		t.Errorf("Found unencrypted content")
+	}
+	for i, f := range files {
+		be := m.blockTable[i]
+		if be.flags&beFlagEncrypted == 0 || (be.flags&beFlagFixKey != 0) != strings.Contains(f.name, "fixkey") {
+			t.Errorf("Unexpected flags of %s: %x", f.name, be.flags)
+		}
+		if got, err := decodeEncrypted(content, f.name, be, m.blockSize); err != nil || !bytes.Equal(got, f.data) {
+			t.Errorf("File %s mismatch (err: %v)", f.name, err)
+		}
+	}
+}
+
+// sparseBuffer is an in-memory io.ReadWriteSeeker and io.ReaderAt which only stores pages containing non-zero bytes,
+// to allow testing huge archives.
+type sparseBuffer struct {
+	pages map[int64][]byte
+	size  int64
+	pos   int64
+}
+
+const sparsePageSize = 64 * 1024
+
+func (sb *sparseBuffer) Write(p []byte) (int, error) {
+	if sb.pages == nil {
+		sb.pages = map[int64][]byte{}
+	}
+	for written := 0; written < len(p); {
+		page, off := sb.pos/sparsePageSize, sb.pos%sparsePageSize
+		chunk := p[written:]
+		if len(chunk) > int(sparsePageSize-off) {
+			chunk = chunk[:sparsePageSize-off]
+		}
+		data := sb.pages[page]
+		if data == nil && bytes.Count(chunk, []byte{0}) != len(chunk) {
+			data = make([]byte, sparsePageSize)
+			sb.pages[page] = data
+		}
+		if data != nil {
+			copy(data[off:], chunk)
+		}
+		written += len(chunk)
+		sb.pos += int64(len(chunk))
+	}
+	if sb.pos > sb.size {
+		sb.size = sb.pos
+	}
+	return len(p), nil
+}
+
+func (sb *sparseBuffer) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && off < sb.size {
+		page, poff := off/sparsePageSize, off%sparsePageSize
+		chunk := p[n:]
+		if len(chunk) > int(sparsePageSize-poff) {
+			chunk = chunk[:sparsePageSize-poff]
+		}
+		if rem := sb.size - off; int64(len(chunk)) > rem {
+			chunk = chunk[:rem]
+		}
+		if data := sb.pages[page]; data != nil {
+			copy(chunk, data[poff:])
+		} else {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (sb *sparseBuffer) Read(p []byte) (int, error) {
+	n, err := sb.ReadAt(p, sb.pos)
+	sb.pos += int64(n)
+	return n, err
+}
+
+func (sb *sparseBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += sb.pos
+	case io.SeekEnd:
+		offset += sb.size
+	}
+	sb.pos = offset
+	return offset, nil
+}
+
+// zeroReader is an infinite source of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestWriterLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping large archive test in short mode")
+	}
+
+	sb := &sparseBuffer{}
+	w, err := NewWriter(sb)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.AddFile("first.txt", []byte("first")); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.AddFileReader("huge.bin", io.LimitReader(zeroReader{}, math.MaxUint32)); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.AddFile("last.txt", []byte("last")); err != nil {
+		t.Errorf("Failed to add file: %v", err)
+	}
+	if err := w.AddFileReader("toobig.bin", io.LimitReader(zeroReader{}, math.MaxUint32+1)); err != ErrFileTooLarge {
+		t.Errorf("Expected ErrFileTooLarge, got: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	m, err := New(io.NewSectionReader(sb, 0, sb.size))
+	if err != nil {
+		t.Fatalf("Failed to open written archive: %v", err)
+	}
+	if m.header.formatVersion != 1 || m.extBlockEntryHighOffsets == nil || m.header.hashTableOffsetHigh != 1 {
+		t.Errorf("Expected Burning Crusade format with extended block table, got: %+v", m.header)
+	}
+	for fname, exp := range map[string]string{"first.txt": "first", "last.txt": "last"} {
+		if got, err := m.FileByName(fname); err != nil || string(got) != exp {
+			t.Errorf("File %s mismatch: %q (err: %v)", fname, got, err)
+		}
+	}
+}